@@ -0,0 +1,58 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package lru
+
+import "github.com/ethereum/go-ethereum/metrics"
+
+// MetricsLRU is a LRU cache reporting its hits, misses and evictions as meters.
+// This type is safe for concurrent use.
+type MetricsLRU[K comparable, V any] struct {
+	*Cache[K, V]
+
+	hits      *metrics.Meter
+	misses    *metrics.Meter
+	evictions *metrics.Meter
+}
+
+// NewMetricsLRU creates a LRU cache with its meters registered under the given
+// name, suffixed with /hits, /misses and /evictions.
+func NewMetricsLRU[K comparable, V any](capacity int, name string) *MetricsLRU[K, V] {
+	return &MetricsLRU[K, V]{
+		Cache:     NewCache[K, V](capacity),
+		hits:      metrics.GetOrRegisterMeter(name+"/hits", nil),
+		misses:    metrics.GetOrRegisterMeter(name+"/misses", nil),
+		evictions: metrics.GetOrRegisterMeter(name+"/evictions", nil),
+	}
+}
+
+// Add adds a value to the cache. Returns true if an item was evicted to store the new item.
+func (c *MetricsLRU[K, V]) Add(key K, value V) (evicted bool) {
+	if evicted = c.Cache.Add(key, value); evicted {
+		c.evictions.Mark(1)
+	}
+	return evicted
+}
+
+// Get retrieves a value from the cache. This marks the key as recently used.
+func (c *MetricsLRU[K, V]) Get(key K) (value V, ok bool) {
+	if value, ok = c.Cache.Get(key); ok {
+		c.hits.Mark(1)
+	} else {
+		c.misses.Mark(1)
+	}
+	return value, ok
+}
@@ -0,0 +1,42 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package lru
+
+import "testing"
+
+// Tests that the cache meters track the hits, misses and evictions.
+func TestMetricsLRU(t *testing.T) {
+	cache := NewMetricsLRU[int, int](2, "lru/test")
+
+	cache.Add(1, 1)
+	cache.Add(2, 2)
+	cache.Get(1)    // hit
+	cache.Get(3)    // miss
+	cache.Add(3, 3) // evicts 2
+	cache.Get(2)    // miss
+	cache.Peek(1)   // not metered
+
+	if have := cache.hits.Snapshot().Count(); have != 1 {
+		t.Errorf("hits mismatch: have %d, want 1", have)
+	}
+	if have := cache.misses.Snapshot().Count(); have != 2 {
+		t.Errorf("misses mismatch: have %d, want 2", have)
+	}
+	if have := cache.evictions.Snapshot().Count(); have != 1 {
+		t.Errorf("evictions mismatch: have %d, want 1", have)
+	}
+}
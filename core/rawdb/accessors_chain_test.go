@@ -999,6 +999,48 @@ func BenchmarkDecodeRLPLogs(b *testing.B) {
 	})
 }
 
+// benchHeaderHeights are the block numbers the header accessors are measured at,
+// spanning from a young chain up to the height of BSC mainnet.
+var benchHeaderHeights = []uint64{1000, 1_000_000, 40_000_000}
+
+// This measures the read speed of the ReadHeader operation at various heights.
+// Block numbers are encoded as fixed size keys, so the speed should not depend
+// on the height.
+func BenchmarkReadHeaderAtHeight(b *testing.B) {
+	for _, height := range benchHeaderHeights {
+		b.Run(fmt.Sprintf("height-%d", height), func(b *testing.B) {
+			db := NewMemoryDatabase()
+			header := &types.Header{Number: new(big.Int).SetUint64(height), Extra: []byte("benchmark header")}
+			WriteHeader(db, header)
+			hash := header.Hash()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if ReadHeader(db, hash, height) == nil {
+					b.Fatal("header not found")
+				}
+			}
+		})
+	}
+}
+
+// This measures the write speed of the WriteHeader operation at various heights.
+func BenchmarkWriteHeaderAtHeight(b *testing.B) {
+	for _, height := range benchHeaderHeights {
+		b.Run(fmt.Sprintf("height-%d", height), func(b *testing.B) {
+			db := NewMemoryDatabase()
+			header := &types.Header{Number: new(big.Int).SetUint64(height), Extra: []byte("benchmark header")}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				WriteHeader(db, header)
+			}
+		})
+	}
+}
+
 func TestHeadersRLPStorage(t *testing.T) {
 	// Have N headers in the freezer
 	frdir := t.TempDir()
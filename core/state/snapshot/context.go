@@ -93,15 +93,17 @@ type generatorContext struct {
 	storage *holdableIterator   // Iterator of storage snapshot data
 	batch   ethdb.Batch         // Database batch for writing batch data atomically
 	logged  time.Time           // The timestamp when last generation progress was displayed
+	flushed time.Time           // The timestamp when the last batch was flushed
 }
 
 // newGeneratorContext initializes the context for generation.
 func newGeneratorContext(stats *generatorStats, db ethdb.KeyValueStore, accMarker []byte, storageMarker []byte) *generatorContext {
 	ctx := &generatorContext{
-		stats:  stats,
-		db:     db,
-		batch:  db.NewBatch(),
-		logged: time.Now(),
+		stats:   stats,
+		db:      db,
+		batch:   db.NewBatch(),
+		logged:  time.Now(),
+		flushed: time.Now(),
 	}
 	ctx.openIterator(snapAccount, accMarker)
 	ctx.openIterator(snapStorage, storageMarker)
@@ -124,16 +126,29 @@ func (ctx *generatorContext) openIterator(kind string, start []byte) {
 // reopenIterator releases the specified snapshot iterator and re-open it
 // in the next position. It's aimed for not blocking leveldb compaction.
 func (ctx *generatorContext) reopenIterator(kind string) {
+	ctx.resumeIterator(kind, ctx.releaseIterator(kind))
+}
+
+// releaseIterator releases the specified snapshot iterator, returning the
+// position to resume it from, or nil if the iterator is exhausted.
+func (ctx *generatorContext) releaseIterator(kind string) []byte {
 	// Shift iterator one more step, so that we can reopen
 	// the iterator at the right position.
-	var iter = ctx.account
-	if kind == snapStorage {
-		iter = ctx.storage
-	}
-	hasNext := iter.Next()
-	if !hasNext {
-		// Iterator exhausted, release forever and create an already exhausted virtual iterator
+	iter := ctx.iterator(kind)
+	if !iter.Next() {
 		iter.Release()
+		return nil
+	}
+	next := common.CopyBytes(iter.Key()[1:])
+	iter.Release()
+	return next
+}
+
+// resumeIterator re-opens a released snapshot iterator at the position returned
+// by releaseIterator.
+func (ctx *generatorContext) resumeIterator(kind string, next []byte) {
+	if next == nil {
+		// Iterator exhausted, create an already exhausted virtual iterator
 		if kind == snapAccount {
 			ctx.account = newHoldableIterator(memorydb.New().NewIterator(nil, nil))
 			return
@@ -141,9 +156,7 @@ func (ctx *generatorContext) reopenIterator(kind string) {
 		ctx.storage = newHoldableIterator(memorydb.New().NewIterator(nil, nil))
 		return
 	}
-	next := iter.Key()
-	iter.Release()
-	ctx.openIterator(kind, next[1:])
+	ctx.openIterator(kind, next)
 }
 
 // close releases all the held resources.
@@ -19,6 +19,7 @@ package snapshot
 import (
 	"bytes"
 	"sync"
+	"sync/atomic"
 
 	"github.com/VictoriaMetrics/fastcache"
 	"github.com/ethereum/go-ethereum/common"
@@ -42,9 +43,19 @@ type diskLayer struct {
 	genPending chan struct{}             // Notification channel when generation is done (test synchronicity)
 	genAbort   chan chan *generatorStats // Notification channel to abort generating the snapshot in this layer
 
+	genPriority *atomic.Int32 // Priority of the generation, shared with the tree (nil means normal)
+
 	lock sync.RWMutex
 }
 
+// generationPriority returns the current priority of the snapshot generation.
+func (dl *diskLayer) generationPriority() GenerationPriority {
+	if dl.genPriority == nil {
+		return GenerationNormal
+	}
+	return GenerationPriority(dl.genPriority.Load())
+}
+
 // Release releases underlying resources; specifically the fastcache requires
 // Reset() in order to not leak memory.
 // OBS: It does not invoke Close on the diskdb
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/fastcache"
@@ -52,10 +53,31 @@ var (
 	errMissingTrie = errors.New("missing trie")
 )
 
+// GenerationPriority defines how aggressively the snapshot generator may use the
+// resources of the node.
+type GenerationPriority int32
+
+const (
+	// GenerationBackground pauses the generator after every flushed batch for
+	// as long as the batch took to generate, halving its resource usage.
+	GenerationBackground GenerationPriority = iota
+
+	// GenerationNormal runs the generator unthrottled.
+	GenerationNormal
+
+	// GenerationUrgent runs the generator unthrottled, flushing larger batches
+	// to spend less time on database writes and iterator reopening.
+	GenerationUrgent
+)
+
+// urgentBatchFactor is the multiple of the ideal batch size the generator flushes
+// at when generating urgently.
+const urgentBatchFactor = 4
+
 // generateSnapshot regenerates a brand new snapshot based on an existing state
 // database and head block asynchronously. The snapshot is returned immediately
 // and generation is continued in the background until done.
-func generateSnapshot(diskdb ethdb.KeyValueStore, triedb *triedb.Database, cache int, root common.Hash) *diskLayer {
+func generateSnapshot(diskdb ethdb.KeyValueStore, triedb *triedb.Database, cache int, root common.Hash, priority *atomic.Int32) *diskLayer {
 	// Create a new disk layer with an initialized state marker at zero
 	var (
 		stats     = &generatorStats{start: time.Now()}
@@ -75,6 +97,8 @@ func generateSnapshot(diskdb ethdb.KeyValueStore, triedb *triedb.Database, cache
 		genMarker:  genMarker,
 		genPending: make(chan struct{}),
 		genAbort:   make(chan chan *generatorStats),
+
+		genPriority: priority,
 	}
 	go base.generate(stats)
 	log.Debug("Start snapshot generation", "root", root)
@@ -472,7 +496,13 @@ func (dl *diskLayer) checkAndFlush(ctx *generatorContext, current []byte) error
 	case abort = <-dl.genAbort:
 	default:
 	}
-	if ctx.batch.ValueSize() > ethdb.IdealBatchSize || abort != nil {
+	priority := dl.generationPriority()
+
+	limit := ethdb.IdealBatchSize
+	if priority == GenerationUrgent {
+		limit *= urgentBatchFactor
+	}
+	if ctx.batch.ValueSize() > limit || abort != nil {
 		if bytes.Compare(current, dl.genMarker) < 0 {
 			log.Error("Snapshot generator went backwards", "current", fmt.Sprintf("%x", current), "genMarker", fmt.Sprintf("%x", dl.genMarker))
 		}
@@ -490,13 +520,25 @@ func (dl *diskLayer) checkAndFlush(ctx *generatorContext, current []byte) error
 		dl.genMarker = current
 		dl.lock.Unlock()
 
+		// Don't hold the iterators too long, release them to let compactor works
+		account, storage := ctx.releaseIterator(snapAccount), ctx.releaseIterator(snapStorage)
+
+		// In the background, rest for as long as the batch took to generate
+		// before reopening them, but stay responsive to interruptions meanwhile
+		if abort == nil && priority == GenerationBackground {
+			select {
+			case abort = <-dl.genAbort:
+			case <-time.After(time.Since(ctx.flushed)):
+			}
+		}
+		ctx.resumeIterator(snapAccount, account)
+		ctx.resumeIterator(snapStorage, storage)
+
 		if abort != nil {
 			ctx.stats.Log("Aborting state snapshot generation", dl.root, current)
 			return newAbortErr(abort) // bubble up an error for interruption
 		}
-		// Don't hold the iterators too long, release them to let compactor works
-		ctx.reopenIterator(snapAccount)
-		ctx.reopenIterator(snapStorage)
+		ctx.flushed = time.Now()
 	}
 	if time.Since(ctx.logged) > 8*time.Second {
 		ctx.stats.Log("Generating state snapshot", dl.root, current)
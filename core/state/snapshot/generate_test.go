@@ -19,6 +19,7 @@ package snapshot
 import (
 	"fmt"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -85,6 +86,40 @@ func testGeneration(t *testing.T, scheme string) {
 	<-stop
 }
 
+// Tests that snapshot generation completes regardless of the priority it runs at.
+func TestGenerationPriority(t *testing.T) {
+	for _, priority := range []GenerationPriority{GenerationBackground, GenerationNormal, GenerationUrgent} {
+		testGenerationPriority(t, rawdb.HashScheme, priority)
+		testGenerationPriority(t, rawdb.PathScheme, priority)
+	}
+}
+
+func testGenerationPriority(t *testing.T, scheme string, priority GenerationPriority) {
+	var helper = newHelper(scheme)
+	for i := 0; i < 100; i++ {
+		stRoot := helper.makeStorageTrie(fmt.Sprintf("acc-%d", i), []string{"key-1", "key-2", "key-3"}, []string{"val-1", "val-2", "val-3"}, true)
+		helper.addTrieAccount(fmt.Sprintf("acc-%d", i), &types.StateAccount{Balance: uint256.NewInt(uint64(i)), Root: stRoot, CodeHash: types.EmptyCodeHash.Bytes()})
+	}
+	var gen atomic.Int32
+	gen.Store(int32(priority))
+
+	root := helper.Commit()
+	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root, &gen)
+	select {
+	case <-snap.genPending:
+		// Snapshot generation succeeded
+
+	case <-time.After(3 * time.Second):
+		t.Errorf("Snapshot generation failed at priority %d", priority)
+	}
+	checkSnapRoot(t, snap, root)
+
+	// Signal abortion to the generator and wait for it to tear down
+	stop := make(chan *generatorStats)
+	snap.genAbort <- stop
+	<-stop
+}
+
 // Tests that snapshot generation with existent flat state.
 func TestGenerateExistentState(t *testing.T) {
 	testGenerateExistentState(t, rawdb.HashScheme)
@@ -245,7 +280,7 @@ func (t *testHelper) Commit() common.Hash {
 
 func (t *testHelper) CommitAndGenerate() (common.Hash, *diskLayer) {
 	root := t.Commit()
-	snap := generateSnapshot(t.diskdb, t.triedb, 16, root)
+	snap := generateSnapshot(t.diskdb, t.triedb, 16, root, nil)
 	return root, snap
 }
 
@@ -447,7 +482,7 @@ func testGenerateCorruptAccountTrie(t *testing.T, scheme string) {
 
 	rawdb.DeleteTrieNode(helper.diskdb, common.Hash{}, targetPath, targetHash, scheme)
 
-	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root)
+	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root, nil)
 	select {
 	case <-snap.genPending:
 		// Snapshot generation succeeded
@@ -491,7 +526,7 @@ func testGenerateMissingStorageTrie(t *testing.T, scheme string) {
 	rawdb.DeleteTrieNode(helper.diskdb, acc1, nil, stRoot, scheme)
 	rawdb.DeleteTrieNode(helper.diskdb, acc3, nil, stRoot, scheme)
 
-	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root)
+	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root, nil)
 	select {
 	case <-snap.genPending:
 		// Snapshot generation succeeded
@@ -533,7 +568,7 @@ func testGenerateCorruptStorageTrie(t *testing.T, scheme string) {
 	rawdb.DeleteTrieNode(helper.diskdb, hashData([]byte("acc-1")), targetPath, targetHash, scheme)
 	rawdb.DeleteTrieNode(helper.diskdb, hashData([]byte("acc-3")), targetPath, targetHash, scheme)
 
-	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root)
+	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root, nil)
 	select {
 	case <-snap.genPending:
 		// Snapshot generation succeeded
@@ -597,7 +632,7 @@ func testGenerateWithExtraAccounts(t *testing.T, scheme string) {
 	if data := rawdb.ReadStorageSnapshot(helper.diskdb, hashData([]byte("acc-2")), hashData([]byte("b-key-1"))); data == nil {
 		t.Fatalf("expected snap storage to exist")
 	}
-	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root)
+	snap := generateSnapshot(helper.diskdb, helper.triedb, 16, root, nil)
 	select {
 	case <-snap.genPending:
 		// Snapshot generation succeeded
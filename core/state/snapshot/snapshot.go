@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -173,6 +174,8 @@ type Tree struct {
 	lock     sync.RWMutex
 	capLimit int
 
+	genPriority atomic.Int32 // Priority of the snapshot generation, shared with the disk layers
+
 	// Test hooks
 	onFlatten func() // Hook invoked when the bottom most diff layers are flattened
 }
@@ -201,8 +204,10 @@ func New(config Config, diskdb ethdb.KeyValueStore, triedb *triedb.Database, roo
 		capLimit: cap,
 		layers:   make(map[common.Hash]snapshot),
 	}
+	snap.genPriority.Store(int32(GenerationNormal))
+
 	// Attempt to load a previously persisted snapshot and rebuild one if failed
-	head, disabled, err := loadSnapshot(diskdb, triedb, root, config.CacheSize, config.Recovery, config.NoBuild, withoutTrie)
+	head, disabled, err := loadSnapshot(diskdb, triedb, root, config.CacheSize, config.Recovery, config.NoBuild, withoutTrie, &snap.genPriority)
 	if disabled {
 		log.Warn("Snapshot maintenance disabled (syncing)")
 		return snap, nil
@@ -635,6 +640,8 @@ func diffToDisk(bottom *diffLayer) *diskLayer {
 		triedb:     base.triedb,
 		genMarker:  base.genMarker,
 		genPending: base.genPending,
+
+		genPriority: base.genPriority,
 	}
 	// If snapshot generation hasn't finished yet, port over all the starts and
 	// continue where the previous round left off.
@@ -699,6 +706,12 @@ func (t *Tree) Journal(root common.Hash) (common.Hash, error) {
 	return base, nil
 }
 
+// SetGenerationPriority changes the priority of the snapshot generation, taking
+// effect on running and future generations alike.
+func (t *Tree) SetGenerationPriority(priority GenerationPriority) {
+	t.genPriority.Store(int32(priority))
+}
+
 // Rebuild wipes all available snapshot data from the persistent database and
 // discard all caches and diff layers. Afterwards, it starts a new snapshot
 // generator with the given root hash.
@@ -735,7 +748,7 @@ func (t *Tree) Rebuild(root common.Hash) {
 	// generator will run a wiper first if there's not one running right now.
 	log.Info("Rebuilding state snapshot")
 	t.layers = map[common.Hash]snapshot{
-		root: generateSnapshot(t.diskdb, t.triedb, t.config.CacheSize, root),
+		root: generateSnapshot(t.diskdb, t.triedb, t.config.CacheSize, root, &t.genPriority),
 	}
 }
 
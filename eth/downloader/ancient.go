@@ -0,0 +1,117 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// freezeBatchSize is the number of blocks written into the ancient store at
+// once by FreezeAncient.
+const freezeBatchSize = 1024
+
+var errAncientGap = errors.New("ancient chain not contiguous")
+
+// FreezeAncient writes a contiguous chain of blocks and their receipts directly
+// into the ancient store, bypassing the synchronisation state machine. It is
+// meant to bootstrap a node from an offline block archive. The blocks must
+// continue exactly where the ancient store currently ends, and the receipts of
+// each block must match the receipt root of its header.
+//
+// The blocks are written in batches, the batch being written is rolled back by
+// the ancient store on failure, previously written batches are retained.
+//
+// The method fails with errBusy if a sync is in progress, and blocks any sync
+// from starting until it returns.
+func (d *Downloader) FreezeAncient(blocks []*types.Block, receipts []types.Receipts) error {
+	if len(blocks) != len(receipts) {
+		return fmt.Errorf("%w: %d blocks, %d receipt sets", errInvalidReceipt, len(blocks), len(receipts))
+	}
+	if !d.synchronising.CompareAndSwap(false, true) {
+		return errBusy
+	}
+	defer d.synchronising.Store(false)
+
+	for len(blocks) > 0 {
+		batch, batchReceipts := blocks[:min(len(blocks), freezeBatchSize)], receipts[:min(len(blocks), freezeBatchSize)]
+		blocks, receipts = blocks[len(batch):], receipts[len(batch):]
+
+		frozen, err := d.stateDB.Ancients()
+		if err != nil {
+			return err
+		}
+		// Ensure the batch continues the ancient chain and is internally linked
+		td, err := d.verifyAncientBatch(frozen, batch, batchReceipts)
+		if err != nil {
+			return err
+		}
+		if _, err := rawdb.WriteAncientBlocks(d.stateDB, batch, batchReceipts, td); err != nil {
+			return err
+		}
+		last := batch[len(batch)-1].NumberU64()
+
+		d.syncStatsLock.Lock()
+		if d.syncStatsChainHeight < last {
+			d.syncStatsChainHeight = last
+		}
+		d.syncStatsLock.Unlock()
+
+		log.Debug("Froze ancient blocks", "count", len(batch), "first", batch[0].NumberU64(), "last", last)
+	}
+	return nil
+}
+
+// verifyAncientBatch checks that a batch of blocks links up with the head of the
+// ancient store and within itself, and that their receipts match the headers,
+// returning the total difficulty of the first block of the batch.
+func (d *Downloader) verifyAncientBatch(frozen uint64, batch []*types.Block, receipts []types.Receipts) (*big.Int, error) {
+	first := batch[0]
+	if first.NumberU64() != frozen {
+		return nil, fmt.Errorf("%w: have #%d, want #%d", errAncientGap, first.NumberU64(), frozen)
+	}
+	for i := 1; i < len(batch); i++ {
+		if batch[i].NumberU64() != batch[i-1].NumberU64()+1 || batch[i].ParentHash() != batch[i-1].Hash() {
+			return nil, fmt.Errorf("%w: #%d [%x] not child of #%d [%x]", errAncientGap,
+				batch[i].NumberU64(), batch[i].Hash().Bytes()[:4], batch[i-1].NumberU64(), batch[i-1].Hash().Bytes()[:4])
+		}
+	}
+	hasher := trie.NewStackTrie(nil)
+	for i, block := range batch {
+		if hash := types.DeriveSha(receipts[i], hasher); hash != block.ReceiptHash() {
+			return nil, fmt.Errorf("%w: #%d receipt root [%x], want [%x]", errInvalidReceipt, block.NumberU64(), hash.Bytes()[:4], block.ReceiptHash().Bytes()[:4])
+		}
+	}
+	if frozen == 0 {
+		return first.Difficulty(), nil
+	}
+	parent := rawdb.ReadCanonicalHash(d.stateDB, frozen-1)
+	if first.ParentHash() != parent {
+		return nil, fmt.Errorf("%w: #%d parent [%x], ancient head [%x]", errAncientGap, frozen, first.ParentHash().Bytes()[:4], parent.Bytes()[:4])
+	}
+	td := rawdb.ReadTd(d.stateDB, parent, frozen-1)
+	if td == nil {
+		return nil, fmt.Errorf("missing total difficulty of ancient head #%d [%x]", frozen-1, parent.Bytes()[:4])
+	}
+	return new(big.Int).Add(td, first.Difficulty()), nil
+}
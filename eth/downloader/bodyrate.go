@@ -0,0 +1,88 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	bodyRateWindow = 10 // Number of seconds over which the body fetch rate is measured
+
+	// bodySizeWeight is the weight of a newly delivered body in the moving
+	// average of the body sizes.
+	bodySizeWeight = 0.1
+)
+
+// bodyRateBucket is the number of body bytes delivered within one second.
+type bodyRateBucket struct {
+	second int64  // Unix time of the second the bucket accounts for
+	bytes  uint64 // Number of body bytes delivered within the second
+}
+
+// bodyRate tracks the rate at which block bodies are delivered, keeping a ring
+// buffer of the bytes delivered in each second of the measurement window.
+type bodyRate struct {
+	buckets [bodyRateWindow]bodyRateBucket
+	avgSize float64 // Moving average of the size of a delivered body
+	lock    sync.Mutex
+}
+
+// add accounts for a batch of delivered bodies totalling the given size.
+func (r *bodyRate) add(now time.Time, bodies int, bytes uint64) {
+	if bodies == 0 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	second := now.Unix()
+	bucket := &r.buckets[second%bodyRateWindow]
+	if bucket.second != second {
+		*bucket = bodyRateBucket{second: second}
+	}
+	bucket.bytes += bytes
+
+	size := float64(bytes) / float64(bodies)
+	if r.avgSize == 0 {
+		r.avgSize = size
+	} else {
+		r.avgSize = bodySizeWeight*size + (1-bodySizeWeight)*r.avgSize
+	}
+}
+
+// rate returns the number of bodies of average size delivered per second over
+// the measurement window, or 0 if none were delivered in it.
+func (r *bodyRate) rate(now time.Time) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var (
+		second = now.Unix()
+		bytes  uint64
+	)
+	for _, bucket := range r.buckets {
+		if age := second - bucket.second; age >= 0 && age < bodyRateWindow {
+			bytes += bucket.bytes
+		}
+	}
+	if bytes == 0 || r.avgSize == 0 {
+		return 0
+	}
+	return float64(bytes) / bodyRateWindow / r.avgSize
+}
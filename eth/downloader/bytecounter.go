@@ -0,0 +1,125 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// byteSampleCount is the number of seconds over which the cumulative downloaded
+// bytes are sampled, limiting how far back the bytes downloaded can be measured.
+const byteSampleCount = 3600
+
+// byteSample is the number of bytes downloaded in total before a given second.
+type byteSample struct {
+	second int64  // Unix time of the second the sample was taken in
+	total  uint64 // Bytes downloaded before the first delivery in the second
+}
+
+// byteCounter tracks the total number of bytes downloaded, keeping a ring buffer
+// of the totals sampled every second something was delivered.
+type byteCounter struct {
+	total atomic.Uint64 // Bytes downloaded since the downloader was created
+
+	samples [byteSampleCount]byteSample
+	next    int  // Index of the slot to store the next sample in
+	wrapped bool // Whether the oldest samples were already overwritten
+	lock    sync.Mutex
+}
+
+// add accounts for a delivery of the given size.
+func (c *byteCounter) add(now time.Time, bytes uint64) {
+	if bytes == 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	second := now.Unix()
+	if last := (c.next + byteSampleCount - 1) % byteSampleCount; (c.next == 0 && !c.wrapped) || c.samples[last].second != second {
+		c.samples[c.next] = byteSample{second: second, total: c.total.Load()}
+		if c.next = (c.next + 1) % byteSampleCount; c.next == 0 {
+			c.wrapped = true
+		}
+	}
+	c.total.Add(bytes)
+}
+
+// since returns the number of bytes downloaded from the second of the given time
+// onwards. Times before the sampled window are clamped to its start.
+func (c *byteCounter) since(t time.Time) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	total, second := c.total.Load(), t.Unix()
+
+	// Iterate the samples from the oldest one and stop at the first one taken
+	// at or after the requested second
+	count, start := c.next, 0
+	if c.wrapped {
+		count, start = byteSampleCount, c.next
+	}
+	for i := 0; i < count; i++ {
+		if sample := c.samples[(start+i)%byteSampleCount]; sample.second >= second {
+			return total - sample.total
+		}
+	}
+	return 0
+}
+
+// headersSize returns the approximate number of bytes taken by delivered headers.
+func headersSize(headers []*types.Header) common.StorageSize {
+	var size common.StorageSize
+	for _, header := range headers {
+		size += header.Size()
+	}
+	return size
+}
+
+// bodiesSize returns the approximate number of bytes taken by delivered block
+// bodies, including their blob sidecars.
+func bodiesSize(txs [][]*types.Transaction, uncles [][]*types.Header, withdrawals [][]*types.Withdrawal, sidecars []types.BlobSidecars) common.StorageSize {
+	var size common.StorageSize
+	for i := range txs {
+		for _, tx := range txs[i] {
+			size += common.StorageSize(tx.Size())
+		}
+		for _, uncle := range uncles[i] {
+			size += uncle.Size()
+		}
+		if withdrawals[i] != nil {
+			size += common.StorageSize(types.Withdrawals(withdrawals[i]).Size())
+		}
+		for _, sidecar := range sidecars[i] {
+			for j := range sidecar.Blobs {
+				size += common.StorageSize(len(sidecar.Blobs[j]))
+			}
+			for j := range sidecar.Commitments {
+				size += common.StorageSize(len(sidecar.Commitments[j]))
+			}
+			for j := range sidecar.Proofs {
+				size += common.StorageSize(len(sidecar.Proofs[j]))
+			}
+		}
+	}
+	return size
+}
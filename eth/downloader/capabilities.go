@@ -0,0 +1,78 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import "maps"
+
+// preferredFetchCapabilities are the capabilities, on top of the eth protocol,
+// of the peers preferred to serve header and body retrievals.
+var preferredFetchCapabilities = []string{"bsc/1"}
+
+// RegisterPeerCapabilities sets the capabilities declared by a peer, in the form
+// of protocol name and version (e.g. "bsc/1"). Retrievals preferring some given
+// capabilities are assigned to peers declaring all of them first, falling back
+// to the rest of the peers if those are busy.
+//
+// The capabilities are cleared when the peer is unregistered, each call replaces
+// the previously registered ones.
+func (d *Downloader) RegisterPeerCapabilities(id string, caps map[string]bool) {
+	d.peerCapsLock.Lock()
+	defer d.peerCapsLock.Unlock()
+
+	if d.peerCaps == nil {
+		d.peerCaps = make(map[string]map[string]bool)
+	}
+	d.peerCaps[id] = maps.Clone(caps)
+}
+
+// hasCapabilities reports whether a peer declared all the given capabilities.
+func (d *Downloader) hasCapabilities(id string, want []string) bool {
+	d.peerCapsLock.RLock()
+	defer d.peerCapsLock.RUnlock()
+
+	caps := d.peerCaps[id]
+	for _, name := range want {
+		if !caps[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// preferCapable reorders a list of idle peers and their capacities, moving the
+// peers declaring all the wanted capabilities to the front. The relative order,
+// by capacity, of the peers is retained within both groups.
+func (d *Downloader) preferCapable(idles []*peerConnection, caps []int, want []string) {
+	if len(want) == 0 {
+		return
+	}
+	var (
+		peers = make([]*peerConnection, 0, len(idles))
+		rest  []*peerConnection
+		pcaps = make([]int, 0, len(caps))
+		rcaps []int
+	)
+	for i, peer := range idles {
+		if d.hasCapabilities(peer.id, want) {
+			peers, pcaps = append(peers, peer), append(pcaps, caps[i])
+		} else {
+			rest, rcaps = append(rest, peer), append(rcaps, caps[i])
+		}
+	}
+	copy(idles, append(peers, rest...))
+	copy(caps, append(pcaps, rcaps...))
+}
@@ -18,9 +18,12 @@
 package downloader
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"runtime/trace"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -32,6 +35,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/eth/protocols/eth"
 	"github.com/ethereum/go-ethereum/eth/protocols/snap"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
@@ -47,9 +51,13 @@ var (
 	MaxReceiptFetch = 256 // Number of transaction receipts to allow fetching per request
 	MaxStateFetch   = 384 // Number of node state values to allow fetching per request
 
+	maxHeadersPerRequestLimit = 256 // Upper bound of the configurable header request size
+	maxSnapAccountWorkers     = 256 // Upper bound of the configurable snap account range chunks
+
 	maxQueuedHeaders           = 32 * 1024                        // [eth/62] Maximum number of headers to queue for import (DOS protection)
 	maxHeadersProcess          = 2048                             // Number of header download results to import at once into the chain
 	maxResultsProcess          = 2048                             // Number of content download results to import at once into the chain
+	maxBodyTransactions        = 10000                            // Maximum number of transactions accepted in a single block body (gas limit / 21000)
 	FullMaxForkAncestry uint64 = params.FullImmutabilityThreshold // Maximum chain reorganisation (locally redeclared so tests can reduce it)
 
 	reorgProtThreshold   = 48 // Threshold number of recent blocks to disable mini reorg protection
@@ -58,6 +66,15 @@ var (
 	fsHeaderSafetyNet = 2048            // Number of headers to discard in case a chain violation is detected
 	fsHeaderContCheck = 3 * time.Second // Time interval to check for header continuations during state download
 	fsMinFullBlocks   = 64              // Number of blocks to retrieve fully even in snap sync
+
+	minFetchTimeout = time.Second // Lowest fetch timeout allowed to be configured
+	maxFetchTimeout = time.Minute // Highest fetch timeout allowed to be configured
+
+	maxLagScore = 3 // Number of lagging syncs to the same head after which a peer is rejected outright
+
+	rollbackPollInterval = 10 * time.Millisecond // Interval to check whether a cancelled sync exited during a rollback
+
+	headerPoolCheck = 100 * time.Millisecond // Interval to check whether the body fetchers caught up with the headers
 )
 
 var (
@@ -79,7 +96,14 @@ var (
 	errCancelContentProcessing = errors.New("content processing canceled (requested)")
 	errCanceled                = errors.New("syncing canceled (requested)")
 	errTooOld                  = errors.New("peer's protocol version too old")
+	errTooOldProtocol          = fmt.Errorf("%w: eth/%d required", errTooOld, eth.ETH68)
 	errNoAncestorFound         = errors.New("no common ancestor found")
+	errInvalidQueueCapacity    = errors.New("invalid queue capacity")
+	errInvalidHeaderFetch      = errors.New("invalid header request size")
+	errInvalidSnapPriority     = errors.New("invalid snapshot generation priority")
+	errInvalidSnapWorkers      = errors.New("invalid snap account worker count")
+	errOutOfRange              = errors.New("block range out of bounds")
+	errUnsupportedRangeMode    = errors.New("range sync only supports full sync")
 )
 
 // SyncMode defines the sync method of the downloader.
@@ -93,6 +117,18 @@ const (
 	SnapSync = ethconfig.SnapSync
 )
 
+// NoSync is the sync mode reported by CurrentMode when no sync is running.
+const NoSync = ^SyncMode(0) // SyncMode(-1)
+
+// BodyVerificationMode defines when delivered block bodies are matched against
+// the transaction, uncle and withdrawal hashes of their headers.
+type BodyVerificationMode uint32
+
+const (
+	EagerVerification BodyVerificationMode = iota // Bodies are verified as soon as they are delivered
+	LazyVerification                              // Bodies are verified when their blocks are imported
+)
+
 // peerDropFn is a callback type for dropping a peer detected as malicious.
 type peerDropFn func(id string)
 
@@ -101,6 +137,7 @@ type peerDropFn func(id string)
 type headerTask struct {
 	headers []*types.Header
 	hashes  []common.Hash
+	peers   []string // Peers that delivered the headers
 }
 
 type Downloader struct {
@@ -110,7 +147,8 @@ type Downloader struct {
 	queue *queue   // Scheduler for selecting the hashes to download
 	peers *peerSet // Set of active peers from which download can proceed
 
-	stateDB ethdb.Database // Database to state sync into (and deduplicate via)
+	stateDB ethdb.Database   // Database to state sync into (and deduplicate via)
+	config  DownloaderConfig // Tunable timeouts of the data retrievals
 
 	// Statistics
 	syncStatsChainOrigin uint64       // Origin block number where syncing started at
@@ -120,15 +158,49 @@ type Downloader struct {
 	blockchain BlockChain
 
 	// Callbacks
-	dropPeer peerDropFn // Drops a peer for misbehaving
+	dropPeer   peerDropFn            // Drops a peer for misbehaving
+	extensions []DownloaderExtension // Extensions notified of the sync events
+
+	sidecarStore BlobSidecarStore // External store for the blob sidecars of the downloaded blocks
 
 	// Status
 	synchroniseMock func(id string, hash common.Hash) error // Replacement for synchronise during testing
 	synchronising   atomic.Bool
+	currentSyncPeer atomic.Value // Identifier of the peer currently being synced from (string)
+	currentMode     atomic.Int32 // Sync mode of the running sync cycle, -1 if idle
+	syncCycleStart  atomic.Int64 // Unix time in nanoseconds the running sync cycle started at, 0 if idle
+	peerCount       atomic.Int32 // Number of registered peers, tracked to avoid locking the peer set
 	notified        atomic.Bool
 	committed       atomic.Bool
 	ancientLimit    uint64 // The maximum block number which can be regarded as ancient data.
 
+	// Sync source preferences
+	preferredPeers map[string]struct{} // Peers to synchronise with whenever suitable
+	avoidedPeers   map[string]struct{} // Peers to only synchronise with if there's no other option
+	syncSourceLock sync.RWMutex        // Lock protecting the sync source preferences
+
+	// Peer capabilities
+	peerCaps     map[string]map[string]bool // Capabilities declared by the peers, used for request routing
+	peerCapsLock sync.RWMutex               // Lock protecting the peer capabilities
+
+	peerLagScores map[string]*lagScore // Lagging sync attempts per peer, to reject hopeless syncs early
+	peerLagLock   sync.Mutex           // Lock protecting the peer lag scores
+
+	peerRegisterHook atomic.Pointer[func(id string, version uint) error] // Custom check to run before registering a peer
+
+	// Queue capacities, only updated while no sync is running
+	headerCap  int // Maximum number of headers queued for content retrieval
+	bodyCap    int // Maximum number of blocks held in the result cache
+	receiptCap int // Maximum number of blocks ahead of the import to fetch receipts for
+
+	maxHeadersPerRequest int // Number of headers to fetch per request, only updated while no sync is running
+
+	snapGenPriority atomic.Int32  // Priority of the snapshot generation started after snap sync
+	freezeThreshold atomic.Uint64 // Number of recent blocks snap sync keeps out of the ancient store
+
+	bodyRate        bodyRate    // Rate at which block bodies are delivered, for telemetry
+	bytesDownloaded byteCounter // Bytes of headers, bodies and receipts delivered by peers
+
 	// Channels
 	headerProcCh chan *headerTask // Channel to feed the header processor new tasks
 
@@ -139,12 +211,17 @@ type Downloader struct {
 	SnapSyncer     *snap.Syncer // TODO(karalabe): make private! hack for now
 	stateSyncStart chan *stateSync
 
+	snapDone     chan struct{} // Channel closed once a snap sync session committed its pivot state
+	snapDoneLock sync.Mutex    // Lock protecting the snap sync completion channel from replacements
+
 	// Cancellation and termination
 	cancelPeer string         // Identifier of the peer currently being used as the master (cancel on drop)
 	cancelCh   chan struct{}  // Channel to cancel mid-flight syncs
 	cancelLock sync.RWMutex   // Lock to protect the cancel channel and peer in delivers
 	cancelWg   sync.WaitGroup // Make sure all fetcher goroutines have exited.
 
+	rollingBack atomic.Bool // Whether a rollback is cancelling the sync or rewinding the chain
+
 	quitCh   chan struct{} // Quit channel to signal termination
 	quitLock sync.Mutex    // Lock to prevent double closes
 
@@ -220,9 +297,56 @@ type BlockChain interface {
 
 type DownloadOption func(downloader *Downloader) *Downloader
 
-// New creates a new downloader to fetch hashes and blocks from remote peers.
-func New(stateDb ethdb.Database, mux *event.TypeMux, chain BlockChain, dropPeer peerDropFn, _ func()) *Downloader {
+// DownloaderConfig contains the tunable timeouts of the data retrievals. Each
+// timeout is the minimum time a request is given before being considered timed
+// out, the actual allowance may be higher based on the measured peer latencies.
+type DownloaderConfig struct {
+	HeaderFetchTimeout  time.Duration // Minimum time allowed for a header request to be fulfilled
+	BodyFetchTimeout    time.Duration // Minimum time allowed for a block body request to be fulfilled
+	ReceiptFetchTimeout time.Duration // Minimum time allowed for a receipt request to be fulfilled
+}
+
+// DefaultConfig contains the default downloader timeouts.
+var DefaultConfig = DownloaderConfig{
+	HeaderFetchTimeout:  5 * time.Second,
+	BodyFetchTimeout:    5 * time.Second,
+	ReceiptFetchTimeout: 5 * time.Second,
+}
+
+// sanitize replaces any unset timeouts with their defaults and ensures the rest
+// are within the allowed range.
+func (c DownloaderConfig) sanitize() (DownloaderConfig, error) {
+	for _, field := range []struct {
+		name  string
+		value *time.Duration
+		def   time.Duration
+	}{
+		{"header", &c.HeaderFetchTimeout, DefaultConfig.HeaderFetchTimeout},
+		{"body", &c.BodyFetchTimeout, DefaultConfig.BodyFetchTimeout},
+		{"receipt", &c.ReceiptFetchTimeout, DefaultConfig.ReceiptFetchTimeout},
+	} {
+		if *field.value == 0 {
+			*field.value = field.def
+		}
+		if *field.value < minFetchTimeout || *field.value > maxFetchTimeout {
+			return c, fmt.Errorf("%s fetch timeout %v out of range [%v, %v]", field.name, *field.value, minFetchTimeout, maxFetchTimeout)
+		}
+	}
+	return c, nil
+}
+
+// New creates a new downloader to fetch hashes and blocks from remote peers. If
+// no config is given, the default timeouts are used.
+func New(stateDb ethdb.Database, mux *event.TypeMux, chain BlockChain, dropPeer peerDropFn, _ func(), config *DownloaderConfig) (*Downloader, error) {
+	if config == nil {
+		config = &DefaultConfig
+	}
+	cfg, err := config.sanitize()
+	if err != nil {
+		return nil, err
+	}
 	dl := &Downloader{
+		config:         cfg,
 		stateDB:        stateDb,
 		mux:            mux,
 		queue:          newQueue(blockCacheMaxItems, blockCacheInitialItems),
@@ -233,11 +357,20 @@ func New(stateDb ethdb.Database, mux *event.TypeMux, chain BlockChain, dropPeer
 		quitCh:         make(chan struct{}),
 		SnapSyncer:     snap.NewSyncer(stateDb, chain.TrieDB().Scheme()),
 		stateSyncStart: make(chan *stateSync),
+		snapDone:       make(chan struct{}),
 		syncStartBlock: chain.CurrentSnapBlock().Number.Uint64(),
+		headerCap:      maxQueuedHeaders,
+		bodyCap:        blockCacheMaxItems,
+		receiptCap:     blockCacheMaxItems,
+
+		maxHeadersPerRequest: MaxHeaderFetch,
 	}
+	dl.currentMode.Store(-1)
+	dl.snapGenPriority.Store(int32(snapshot.GenerationNormal))
+	dl.freezeThreshold.Store(FullMaxForkAncestry)
 
 	go dl.stateFetcher()
-	return dl
+	return dl, nil
 }
 
 // Progress retrieves the synchronisation boundaries, specifically the origin
@@ -264,10 +397,23 @@ func (d *Downloader) Progress() ethereum.SyncProgress {
 	}
 	progress, pending := d.SnapSyncer.Progress()
 
+	// During an active snap sync, fill the legacy state counters from the live
+	// snap metrics: every processed state item is pulled, the pending healing
+	// tasks are known on top of those.
+	var pulled, known uint64
+	if d.CurrentMode() == ethconfig.SnapSync {
+		stat := d.SnapSyncer.Stats()
+		pulled = stat.AccountsProcessed + stat.StorageSlotsProcessed + stat.BytecodesProcessed + stat.TrieNodesHealed
+		known = pulled + pending.TrienodeHeal + pending.BytecodeHeal
+	}
 	return ethereum.SyncProgress{
 		StartingBlock:       d.syncStatsChainOrigin,
 		CurrentBlock:        current,
 		HighestBlock:        d.syncStatsChainHeight,
+		BodyQueueDepth:      uint64(d.queue.BodyQueueDepth()),
+		ReceiptQueueDepth:   uint64(d.queue.ReceiptQueueDepth()),
+		PulledStates:        pulled,
+		KnownStates:         known,
 		SyncedAccounts:      progress.AccountSynced,
 		SyncedAccountBytes:  uint64(progress.AccountBytes),
 		SyncedBytecodes:     progress.BytecodeSynced,
@@ -283,6 +429,24 @@ func (d *Downloader) Progress() ethereum.SyncProgress {
 	}
 }
 
+// BehindBy returns the number of blocks the local chain is behind the highest
+// block known by the downloader, or zero if it caught up. It is a cheap variant
+// of Progress meant for frequent polling, skipping the state sync statistics.
+func (d *Downloader) BehindBy() uint64 {
+	d.syncStatsLock.RLock()
+	highest := d.syncStatsChainHeight
+	d.syncStatsLock.RUnlock()
+
+	current := d.blockchain.CurrentBlock().Number.Uint64()
+	if d.getMode() == ethconfig.SnapSync {
+		current = d.blockchain.CurrentSnapBlock().Number.Uint64()
+	}
+	if current >= highest {
+		return 0
+	}
+	return highest - current
+}
+
 // RegisterPeer injects a new download peer into the set of block source to be
 // used for fetching hashes and blocks from.
 func (d *Downloader) RegisterPeer(id string, version uint, peer Peer) error {
@@ -293,14 +457,172 @@ func (d *Downloader) RegisterPeer(id string, version uint, peer Peer) error {
 	} else {
 		logger = log.New("peer", id[:8])
 	}
+	if version < eth.ETH68 {
+		logger.Warn("Rejecting sync peer with deprecated protocol", "version", version)
+		return errTooOldProtocol
+	}
+	if hook := d.peerRegisterHook.Load(); hook != nil {
+		if err := (*hook)(id, version); err != nil {
+			logger.Debug("Sync peer rejected by register hook", "err", err)
+			return err
+		}
+	}
 	logger.Trace("Registering sync peer")
 	if err := d.peers.Register(newPeerConnection(id, version, peer, logger)); err != nil {
 		logger.Error("Failed to register sync peer", "err", err)
 		return err
 	}
+	d.peerCount.Add(1)
 	return nil
 }
 
+// SetPeerRegisterHook installs a callback to run before a peer is registered,
+// allowing custom checks such as authentication on private networks. If the hook
+// returns an error, RegisterPeer fails with it and the peer is not added to the
+// sync pool. Peers already registered are not affected. A nil hook disables the
+// check.
+func (d *Downloader) SetPeerRegisterHook(hook func(id string, version uint) error) {
+	if hook == nil {
+		d.peerRegisterHook.Store(nil)
+		return
+	}
+	d.peerRegisterHook.Store(&hook)
+}
+
+// SetBodyVerificationMode sets when delivered block bodies are matched against
+// their headers. In lazy mode the hashes derived on delivery are kept and only
+// compared on chain insertion, and the peer that delivered a mismatching body
+// is dropped then.
+func (d *Downloader) SetBodyVerificationMode(mode BodyVerificationMode) {
+	d.queue.SetBodyVerification(mode)
+}
+
+// SetQueueCapacity adjusts the sizes of the download queue, taking effect from
+// the next sync run. The headerCap limits the headers queued for their content
+// to be retrieved, bodyCap the blocks held in memory until imported, receiptCap
+// how far ahead of the import receipts are fetched. The capacities must satisfy
+// headerCap >= bodyCap >= receiptCap >= 1. The method fails with errBusy if a
+// sync is in progress.
+func (d *Downloader) SetQueueCapacity(headerCap, bodyCap, receiptCap int) error {
+	if receiptCap < 1 || bodyCap < receiptCap || headerCap < bodyCap {
+		return fmt.Errorf("%w: headers %d, bodies %d, receipts %d", errInvalidQueueCapacity, headerCap, bodyCap, receiptCap)
+	}
+	if !d.synchronising.CompareAndSwap(false, true) {
+		return errBusy
+	}
+	defer d.synchronising.Store(false)
+
+	d.headerCap, d.bodyCap, d.receiptCap = headerCap, bodyCap, receiptCap
+	return nil
+}
+
+// SetMaxHeadersPerRequest overrides MaxHeaderFetch for this downloader, taking
+// effect from the next sync run. Chains with large headers can lower it to keep
+// header responses well below the message size limit. The number must be within
+// [1, 256]. The method fails with errBusy if a sync is in progress.
+func (d *Downloader) SetMaxHeadersPerRequest(n int) error {
+	if n < 1 || n > maxHeadersPerRequestLimit {
+		return fmt.Errorf("%w: %d, want 1-%d", errInvalidHeaderFetch, n, maxHeadersPerRequestLimit)
+	}
+	if !d.synchronising.CompareAndSwap(false, true) {
+		return errBusy
+	}
+	defer d.synchronising.Store(false)
+
+	d.maxHeadersPerRequest = n
+	return nil
+}
+
+// ConcurrentSnapAccountWorkers sets the number of chunks the account range is
+// split into by snap sync, each retrieved in parallel with its own origin, limit
+// and peer. It takes effect from the next snap sync started from scratch, syncs
+// resumed from a saved progress keep their chunks. The number must be within
+// [1, 256], the default being 16.
+func (d *Downloader) ConcurrentSnapAccountWorkers(n int) error {
+	if n < 1 || n > maxSnapAccountWorkers {
+		return fmt.Errorf("%w: %d, want 1-%d", errInvalidSnapWorkers, n, maxSnapAccountWorkers)
+	}
+	d.SnapSyncer.SetAccountConcurrency(n)
+	return nil
+}
+
+// FreezeThreshold returns the number of most recent blocks snap sync keeps in the
+// active database, older blocks are written directly into the ancient store.
+func (d *Downloader) FreezeThreshold() uint64 {
+	return d.freezeThreshold.Load()
+}
+
+// SetFreezeThreshold sets the number of most recent blocks snap sync keeps in the
+// active database, taking effect from the next sync run. It defaults to the full
+// immutability threshold the chain freezer migrates blocks at, which is also the
+// deepest reorg the chain accepts. Ancient blocks can't be reorged out, so lower
+// thresholds are raised to it, and only more blocks can be kept in the active
+// database.
+func (d *Downloader) SetFreezeThreshold(n uint64) {
+	d.freezeThreshold.Store(max(n, FullMaxForkAncestry))
+}
+
+// SetSnapshotGenerationPriority sets how aggressively the state snapshot is
+// regenerated after a snap sync completes: 0 in the background, 1 normally and
+// 2 urgently. Validators wanting to start validating soon after the sync can
+// raise it, while archive nodes can lower it to keep serving requests. The
+// priority is applied when the sync pivot is committed.
+func (d *Downloader) SetSnapshotGenerationPriority(priority int) error {
+	if priority < int(snapshot.GenerationBackground) || priority > int(snapshot.GenerationUrgent) {
+		return fmt.Errorf("%w: %d, want %d-%d", errInvalidSnapPriority, priority, snapshot.GenerationBackground, snapshot.GenerationUrgent)
+	}
+	d.snapGenPriority.Store(int32(priority))
+	return nil
+}
+
+// SnapSyncComplete returns a channel which is closed once a snap sync session has
+// retrieved the entire state of its pivot block and committed it as the new head.
+// Every session started after that hands out a fresh channel.
+func (d *Downloader) SnapSyncComplete() <-chan struct{} {
+	d.snapDoneLock.Lock()
+	defer d.snapDoneLock.Unlock()
+
+	return d.snapDone
+}
+
+// resetSnapSyncComplete replaces the snap sync completion channel with a fresh
+// one if the previous session already closed it. Otherwise listeners of a failed
+// session keep waiting for the retry.
+func (d *Downloader) resetSnapSyncComplete() {
+	d.snapDoneLock.Lock()
+	defer d.snapDoneLock.Unlock()
+
+	select {
+	case <-d.snapDone:
+		d.snapDone = make(chan struct{})
+	default:
+	}
+}
+
+// markSnapSyncComplete closes the snap sync completion channel, notifying any
+// listeners that the pivot state has been committed.
+func (d *Downloader) markSnapSyncComplete() {
+	d.snapDoneLock.Lock()
+	defer d.snapDoneLock.Unlock()
+
+	select {
+	case <-d.snapDone:
+	default:
+		close(d.snapDone)
+	}
+}
+
+// Peers retrieves the identifiers of the currently registered peers.
+func (d *Downloader) Peers() []string {
+	return d.peers.IDs()
+}
+
+// PeerCount returns the number of currently registered peers. Contrary to Peers,
+// it does not need to lock the peer set.
+func (d *Downloader) PeerCount() int {
+	return int(d.peerCount.Load())
+}
+
 // UnregisterPeer remove a peer from the known list, preventing any action from
 // the specified peer. An effort is also made to return any pending fetches into
 // the queue.
@@ -314,18 +636,76 @@ func (d *Downloader) UnregisterPeer(id string) error {
 		logger = log.New("peer", id[:8])
 	}
 	logger.Trace("Unregistering sync peer")
+
+	// Forget the declared capabilities even if the peer is unknown, they may
+	// have been declared without the peer ever being registered
+	d.peerCapsLock.Lock()
+	delete(d.peerCaps, id)
+	d.peerCapsLock.Unlock()
+
 	if err := d.peers.Unregister(id); err != nil {
 		logger.Error("Failed to unregister sync peer", "err", err)
 		return err
 	}
+	d.peerCount.Add(-1)
 	d.queue.Revoke(id)
 
+	d.peerLagLock.Lock()
+	delete(d.peerLagScores, id)
+	d.peerLagLock.Unlock()
+
 	return nil
 }
 
+// lagScore tracks the sync attempts to a peer that turned out to be lagging
+// behind the local chain.
+type lagScore struct {
+	head  common.Hash // Head the peer advertised when it was found lagging
+	score int         // Number of consecutive lagging syncs to that head
+}
+
+// peerLagScore returns the number of consecutive syncs to the peer found to be
+// lagging while it advertised the given head.
+func (d *Downloader) peerLagScore(id string, head common.Hash) int {
+	d.peerLagLock.Lock()
+	defer d.peerLagLock.Unlock()
+
+	if lag := d.peerLagScores[id]; lag != nil && lag.head == head {
+		return lag.score
+	}
+	return 0
+}
+
+// updatePeerLagScore raises the lag score of a peer if the sync to the given
+// head found it lagging, or clears the score otherwise. Announcing a new head
+// starts counting from scratch.
+func (d *Downloader) updatePeerLagScore(id string, head common.Hash, lagging bool) {
+	d.peerLagLock.Lock()
+	defer d.peerLagLock.Unlock()
+
+	if !lagging {
+		delete(d.peerLagScores, id)
+		return
+	}
+	if d.peerLagScores == nil {
+		d.peerLagScores = make(map[string]*lagScore)
+	}
+	lag := d.peerLagScores[id]
+	if lag == nil || lag.head != head {
+		lag = &lagScore{head: head}
+		d.peerLagScores[id] = lag
+	}
+	lag.score++
+}
+
 // LegacySync tries to sync up our local blockchain with a remote peer, both
 // adding various sanity checks and wrapping it with various log entries.
 func (d *Downloader) LegacySync(id string, head common.Hash, name string, td *big.Int, ttd *big.Int, mode SyncMode) error {
+	// Apply any operator preferences on the peer to synchronise with
+	if selected, shead, std := d.selectSyncPeer(id, head, td, mode); selected != id {
+		id, head, td = selected, shead, std
+		name = ""
+	}
 	err := d.synchronise(id, head, td, ttd, mode, false, nil)
 
 	switch err {
@@ -349,6 +729,65 @@ func (d *Downloader) LegacySync(id string, head common.Hash, name string, td *bi
 	return err
 }
 
+// SyncTo synchronises the local chain up to the given target header, using a
+// registered peer advertising it as its head. The sync is cancelled if the
+// context is done before it finishes, in which case the context error is
+// returned instead of the sync error.
+func (d *Downloader) SyncTo(ctx context.Context, target *types.Header, mode SyncMode) error {
+	hash := target.Hash()
+	for _, peer := range d.peers.AllPeers() {
+		if head, td := peer.peer.Head(); head == hash {
+			return d.synchroniseContext(ctx, peer.id, hash, td, mode)
+		}
+	}
+	return fmt.Errorf("%w: none at #%d [%x]", errNoPeers, target.Number, hash.Bytes()[:4])
+}
+
+// synchroniseContext runs a sync cycle with the given peer, cancelling it when
+// the context is done.
+func (d *Downloader) synchroniseContext(ctx context.Context, id string, hash common.Hash, td *big.Int, mode SyncMode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var (
+		done   = make(chan struct{})
+		exited = make(chan struct{})
+	)
+	go func() {
+		defer close(exited)
+
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		// The sync might not have created its cancel channel yet, in which case
+		// cancelling is a noop, so keep at it until the sync returns. There's no
+		// need to wait for the fetchers to exit, the sync does that on return.
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			d.cancel()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	err := d.synchronise(id, hash, td, nil, mode, false, nil)
+
+	// Wait for the canceller to exit so it doesn't interfere with later syncs
+	close(done)
+	<-exited
+
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
 // synchronise will select the peer and use it for synchronising. If an empty string is given
 // it will use the best peer possible and synchronize if its TD is higher than our own. If any of the
 // checks fail an error will be returned. This method is synchronous
@@ -371,12 +810,68 @@ func (d *Downloader) synchronise(id string, hash common.Hash, td, ttd *big.Int,
 	if d.synchroniseMock != nil {
 		return d.synchroniseMock(id, hash)
 	}
+	return d.runSync(id, mode, beaconMode, func(p *peerConnection) error {
+		// Don't bother the peer if it was repeatedly found lagging on this head
+		if p != nil {
+			if score := d.peerLagScore(id, hash); score >= maxLagScore {
+				p.log.Debug("Rejecting sync to lagging peer", "head", hash, "score", score)
+				return errLaggingPeer
+			}
+		}
+		if beaconPing != nil {
+			close(beaconPing)
+		}
+		err := d.syncWithPeer(p, hash, td, ttd, beaconMode)
+		if p != nil {
+			d.updatePeerLagScore(id, hash, errors.Is(err, errLaggingPeer))
+		}
+		return err
+	})
+}
+
+// DebugSync downloads and imports the blocks in [from, to] from the given peer,
+// overwriting the local chain from block from onwards, e.g. to recover from a
+// state corruption discovered at a known block. The local chain is rewound to
+// the parent of from first, so blocks above to are dropped too, and need to be
+// resynced by the regular sync afterwards. The ancestor lookup is skipped,
+// headers are fetched straight from the range start.
+//
+// Only full sync is supported, since snap sync needs a pivot to download the
+// state for. The sync can be cancelled like any other, and fails with errBusy if
+// another sync is running. It fails with errOutOfRange if from is above to, or
+// to is above the head of the peer.
+func (d *Downloader) DebugSync(peer string, from, to uint64, mode SyncMode) error {
+	if mode != ethconfig.FullSync {
+		return fmt.Errorf("%w: have %v", errUnsupportedRangeMode, mode)
+	}
+	if from == 0 || from > to {
+		return fmt.Errorf("%w: [%d, %d]", errOutOfRange, from, to)
+	}
+	return d.runSync(peer, mode, false, func(p *peerConnection) error {
+		return d.syncRange(p, from, to)
+	})
+}
+
+// runSync sets up a sync cycle with the given peer and runs the given sync
+// function against it, cleaning up after it returns. The peer is nil in beacon
+// mode.
+func (d *Downloader) runSync(id string, mode SyncMode, beaconMode bool, sync func(p *peerConnection) error) error {
 	// Make sure only one goroutine is ever allowed past this point at once
 	if !d.synchronising.CompareAndSwap(false, true) {
 		return errBusy
 	}
 	defer d.synchronising.Store(false)
 
+	// Track the peer being synced from for the duration of the sync cycle
+	d.currentSyncPeer.Store(id)
+	defer d.currentSyncPeer.Store("")
+
+	d.currentMode.Store(int32(mode))
+	defer d.currentMode.Store(-1)
+
+	d.syncCycleStart.Store(time.Now().UnixNano())
+	defer d.syncCycleStart.Store(0)
+
 	// Post a user notification of the sync (only once per session)
 	if d.notified.CompareAndSwap(false, true) {
 		log.Info("Block synchronisation started")
@@ -399,7 +894,9 @@ func (d *Downloader) synchronise(id string, hash common.Hash, td, ttd *big.Int,
 		}
 	}
 	// Reset the queue, peer set and wake channels to clean any internal leftover state
-	d.queue.Reset(blockCacheMaxItems, blockCacheInitialItems)
+	d.queue.Reset(d.bodyCap, min(blockCacheInitialItems, d.bodyCap))
+	d.queue.SetReceiptLimit(d.receiptCap)
+	d.queue.SetHeaderFetch(d.maxHeadersPerRequest)
 	d.peers.Reset()
 
 	for _, ch := range []chan bool{d.queue.blockWakeCh, d.queue.receiptWakeCh} {
@@ -434,10 +931,136 @@ func (d *Downloader) synchronise(id string, hash common.Hash, td, ttd *big.Int,
 			return errUnknownPeer
 		}
 	}
-	if beaconPing != nil {
-		close(beaconPing)
+	return sync(p)
+}
+
+// SyncPeer retrieves the identifier of the peer the downloader is currently
+// synchronising with, or an empty string if no sync is running.
+func (d *Downloader) SyncPeer() string {
+	id, _ := d.currentSyncPeer.Load().(string)
+	return id
+}
+
+// SnapSyncETA estimates the time needed by the running snap sync to download
+// the remaining accounts, based on the throughput of the last 30 seconds. -1 is
+// returned if no snap sync is running or no accounts are being downloaded.
+func (d *Downloader) SnapSyncETA() time.Duration {
+	if d.CurrentMode() != ethconfig.SnapSync {
+		return -1
+	}
+	return d.SnapSyncer.AccountETA()
+}
+
+// SnapThroughput returns the number of accounts downloaded per second by snap
+// sync, averaged over the last 30 seconds.
+func (d *Downloader) SnapThroughput() float64 {
+	return d.SnapSyncer.AccountThroughput()
+}
+
+// ExpectedBodiesPerSecond returns the number of block bodies of average size
+// delivered per second over the last 10 seconds, or 0 if no bodies arrived in
+// that time. Compared to the header progress, it shows whether a sync is held
+// back by the body retrieval.
+func (d *Downloader) ExpectedBodiesPerSecond() float64 {
+	return d.bodyRate.rate(time.Now())
+}
+
+// PipelineLatency returns the moving averages of the time blocks spend between
+// the stages of the download pipeline: from the header being scheduled to its
+// body arriving ("header_to_body"), from the body arriving to the block being
+// handed over for insertion ("body_to_insert") and from that to the insertion
+// completing ("insert_to_commit").
+func (d *Downloader) PipelineLatency() map[string]time.Duration {
+	return d.queue.latency.latencies()
+}
+
+// TotalBytesDownloaded returns the approximate number of bytes of headers, block
+// bodies and receipts delivered by peers since the downloader was created.
+func (d *Downloader) TotalBytesDownloaded() uint64 {
+	return d.bytesDownloaded.total.Load()
+}
+
+// TotalBytesDownloadedSince returns the approximate number of bytes of headers,
+// block bodies and receipts delivered by peers from the given time onwards. The
+// deliveries are tracked with a resolution of a second for at least the last
+// hour, older times count from the start of the tracked window.
+func (d *Downloader) TotalBytesDownloadedSince(t time.Time) uint64 {
+	return d.bytesDownloaded.since(t)
+}
+
+// QueueIdle reports whether the download queue has no block bodies or receipts
+// scheduled or in flight.
+func (d *Downloader) QueueIdle() bool {
+	return d.queue.Idle()
+}
+
+// QueueResultsPending returns the number of downloaded blocks waiting in the
+// queue to be imported.
+func (d *Downloader) QueueResultsPending() int {
+	return d.queue.ResultsPending()
+}
+
+// HeaderPool returns the number of headers downloaded, but whose bodies were not
+// requested yet. Header retrieval is held back while it exceeds twice the header
+// request size, to give the body fetchers time to catch up.
+func (d *Downloader) HeaderPool() int {
+	return d.queue.HeaderPool()
+}
+
+// BodyQueueDepth returns the share of the download queue open to body retrievals
+// which is in use, as a percentage. At 100, body retrievals are held back until
+// the downloaded blocks are imported.
+func (d *Downloader) BodyQueueDepth() int {
+	return d.queue.BodyQueueDepth()
+}
+
+// ReceiptQueueDepth returns the share of the download queue open to receipt
+// retrievals which is in use, as a percentage. At 100, receipt retrievals are
+// held back until the downloaded blocks are imported.
+func (d *Downloader) ReceiptQueueDepth() int {
+	return d.queue.ReceiptQueueDepth()
+}
+
+// PeerHeaderFetchRate returns the moving average of the headers delivered per
+// second by the given peer, or 0 if the peer is unknown.
+func (d *Downloader) PeerHeaderFetchRate(id string) float64 {
+	p := d.peers.Peer(id)
+	if p == nil {
+		return 0
+	}
+	return p.HeaderFetchRate()
+}
+
+// ReportPeerSpeed feeds the bandwidth of a peer, as measured by the transport
+// layer, into the downloader. Reported speeds are preferred over the downloader's
+// own estimates when sizing requests, and lose 10% of their weight for every 30
+// seconds passing without a new report.
+func (d *Downloader) ReportPeerSpeed(id string, bytesPerSec uint64) {
+	if p := d.peers.Peer(id); p != nil {
+		p.ReportSpeed(bytesPerSec, time.Now())
 	}
-	return d.syncWithPeer(p, hash, td, ttd, beaconMode)
+}
+
+// BestHeaderPeer returns the id of the peer currently delivering headers the
+// fastest, or an empty string if no peer delivered any headers yet.
+func (d *Downloader) BestHeaderPeer() string {
+	var (
+		best string
+		rate float64
+	)
+	for _, p := range d.peers.AllPeers() {
+		if have := p.HeaderFetchRate(); have > rate {
+			best, rate = p.id, have
+		}
+	}
+	return best
+}
+
+// CurrentMode retrieves the sync mode of the running sync cycle, or NoSync if
+// no sync is running. Contrary to the configured mode, this is the mode that
+// is actually used, after any downgrade made when the sync was started.
+func (d *Downloader) CurrentMode() SyncMode {
+	return SyncMode(d.currentMode.Load())
 }
 
 func (d *Downloader) getMode() SyncMode {
@@ -459,6 +1082,13 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td, ttd *
 	}()
 	mode := d.getMode()
 
+	var peer string
+	if p != nil {
+		peer = p.id
+	}
+	d.notifySyncStart(peer, mode)
+	defer func() { d.notifySyncComplete(peer, err) }()
+
 	if !beaconMode {
 		log.Debug("Synchronising with the network", "peer", p.id, "eth", p.version, "head", hash, "td", td, "mode", mode)
 	} else {
@@ -552,8 +1182,8 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td, ttd *
 
 		// Legacy sync, use the best announcement we have from the remote peer.
 		// TODO(karalabe): Drop this pathway.
-		if remoteHeight > FullMaxForkAncestry+1 {
-			d.ancientLimit = remoteHeight - FullMaxForkAncestry - 1
+		if threshold := d.freezeThreshold.Load(); remoteHeight > threshold+1 {
+			d.ancientLimit = remoteHeight - threshold - 1
 		} else {
 			d.ancientLimit = 0
 		}
@@ -582,9 +1212,9 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td, ttd *
 	}
 
 	fetchers := []func() error{
-		func() error { return d.fetchHeaders(p, origin+1, remoteHeader.Number.Uint64()) }, // Headers are always retrieved
-		func() error { return d.fetchBodies(origin+1, beaconMode) },                       // Bodies are retrieved during normal and snap sync
-		func() error { return d.fetchReceipts(origin+1, beaconMode) },                     // Receipts are retrieved during snap sync
+		func() error { return d.fetchHeaders(p, origin+1, remoteHeader.Number.Uint64(), 0) }, // Headers are always retrieved
+		func() error { return d.fetchBodies(origin+1, beaconMode) },                          // Bodies are retrieved during normal and snap sync
+		func() error { return d.fetchReceipts(origin+1, beaconMode) },                        // Receipts are retrieved during snap sync
 		func() error { return d.processHeaders(origin+1, td, ttd, beaconMode) },
 	}
 	if mode == ethconfig.SnapSync {
@@ -592,6 +1222,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td, ttd *
 		d.pivotHeader = pivot
 		d.pivotLock.Unlock()
 
+		d.resetSnapSyncComplete()
 		fetchers = append(fetchers, func() error { return d.processSnapSyncContent() })
 	} else if mode == ethconfig.FullSync {
 		fetchers = append(fetchers, func() error { return d.processFullSyncContent(ttd, beaconMode) })
@@ -601,6 +1232,69 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td, ttd *
 	return d.spawnSync(fetchers)
 }
 
+// syncRange full syncs the blocks in [from, to] from the specified peer, after
+// rewinding the local chain below the range.
+func (d *Downloader) syncRange(p *peerConnection, from, to uint64) (err error) {
+	d.mux.Post(StartEvent{})
+	defer func() {
+		// reset on error
+		if err != nil {
+			d.mux.Post(FailedEvent{err})
+		} else {
+			latest := d.blockchain.CurrentHeader()
+			d.mux.Post(DoneEvent{latest})
+		}
+	}()
+	d.notifySyncStart(p.id, ethconfig.FullSync)
+	defer func() { d.notifySyncComplete(p.id, err) }()
+
+	log.Debug("Synchronising block range with the network", "peer", p.id, "eth", p.version, "from", from, "to", to)
+	defer func(start time.Time) {
+		log.Debug("Range synchronisation terminated", "elapsed", common.PrettyDuration(time.Since(start)))
+	}(time.Now())
+
+	// Make sure the peer has the entire range before dropping anything locally
+	headers, _, err := d.fetchHeadersByNumber(p, to, 1, 0, false)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return fmt.Errorf("%w: block #%d above head of peer %s", errOutOfRange, to, p.id)
+	}
+	// Rewind the chain to the parent of the range, the state of which might not
+	// be available, in which case the chain will go deeper and so does the sync
+	if d.blockchain.CurrentBlock().Number.Uint64() >= from {
+		log.Warn("Rewinding chain for range sync", "target", from-1)
+		if err := d.blockchain.SetHead(from - 1); err != nil {
+			return err
+		}
+	}
+	origin := d.blockchain.CurrentBlock().Number.Uint64()
+
+	d.syncStatsLock.Lock()
+	d.syncStatsChainOrigin = origin
+	d.syncStatsChainHeight = to
+	d.syncStatsLock.Unlock()
+
+	d.committed.Store(true)
+
+	d.queue.Prepare(origin+1, ethconfig.FullSync)
+	if d.syncInitHook != nil {
+		d.syncInitHook(origin, to)
+	}
+	// The range makes no difficulty promises, so don't hold the peer to any
+	td := new(big.Int)
+
+	fetchers := []func() error{
+		func() error { return d.fetchHeaders(p, origin+1, to, to) },
+		func() error { return d.fetchBodies(origin+1, false) },
+		func() error { return d.fetchReceipts(origin+1, false) },
+		func() error { return d.processHeaders(origin+1, td, nil, false) },
+		func() error { return d.processFullSyncContent(nil, false) },
+	}
+	return d.spawnSync(fetchers)
+}
+
 // spawnSync runs d.process and all given fetcher functions to completion in
 // separate goroutines, returning the first error that appears.
 func (d *Downloader) spawnSync(fetchers []func() error) error {
@@ -655,6 +1349,63 @@ func (d *Downloader) Cancel() {
 	d.cancelWg.Wait()
 }
 
+// CancelAndWait aborts all of the operations similarly to Cancel, but waits at
+// most timeout for the download goroutines to finish. It reports whether all of
+// them exited in time.
+func (d *Downloader) CancelAndWait(timeout time.Duration) bool {
+	d.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.cancelWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Rollback rewinds the local chain to the target block without shutting down the
+// downloader, e.g. when the state turns out to be inconsistent after an import.
+// Any running sync is cancelled and the queue is cleared; syncing is possible
+// again once the method returns. It fails with errBusy if another rollback is
+// already in progress.
+func (d *Downloader) Rollback(target uint64) error {
+	if !d.rollingBack.CompareAndSwap(false, true) {
+		return errBusy
+	}
+	defer d.rollingBack.Store(false)
+
+	// Cancel any running sync and wait until it exits, keeping new ones out
+	for {
+		d.Cancel()
+		if d.synchronising.CompareAndSwap(false, true) {
+			break
+		}
+		select {
+		case <-time.After(rollbackPollInterval):
+		case <-d.quitCh:
+			return errCanceled
+		}
+	}
+	defer d.synchronising.Store(false)
+
+	log.Warn("Rolling back chain", "target", target)
+	if err := d.blockchain.SetHead(target); err != nil {
+		return err
+	}
+	d.queue.Reset(d.bodyCap, min(blockCacheInitialItems, d.bodyCap))
+
+	d.syncStatsLock.Lock()
+	d.syncStatsChainHeight = target
+	d.syncStatsChainOrigin = min(d.syncStatsChainOrigin, target)
+	d.syncStatsLock.Unlock()
+	return nil
+}
+
 // Terminate interrupts the downloader, canceling all pending operations.
 // The downloader cannot be reused after calling Terminate.
 func (d *Downloader) Terminate() {
@@ -731,15 +1482,18 @@ func calculateRequestSpan(remoteHeight, localHeight uint64) (int64, int, int, ui
 	// the highest block that we will get is 16 blocks back from head, which means we
 	// will fetch 14 or 15 blocks unnecessarily in the case the height difference
 	// between us and the peer is 1-2 blocks, which is most common
-	requestHead := int(remoteHeight) - 1
-	if requestHead < 0 {
-		requestHead = 0
+	//
+	// Note, the heights are clamped in the unsigned domain to avoid wrapping around
+	// when converting absurdly high values into signed integers.
+	var requestHead int
+	if remoteHeight > 0 {
+		requestHead = int(min(remoteHeight-1, math.MaxInt))
 	}
 	// requestBottom is the lowest block we want included in the query
 	// Ideally, we want to include the one just below our own head
-	requestBottom := int(localHeight - 1)
-	if requestBottom < 0 {
-		requestBottom = 0
+	var requestBottom int
+	if localHeight > 0 {
+		requestBottom = int(min(localHeight-1, math.MaxInt))
 	}
 	totalSpan := requestHead - requestBottom
 	span := 1 + totalSpan/MaxCount
@@ -889,9 +1643,14 @@ func (d *Downloader) findAncestorBinarySearch(p *peerConnection, mode SyncMode,
 	}
 	p.log.Trace("Binary searching for common ancestor", "start", start, "end", end)
 
+	// Trace the search iterations to allow debugging long running lookups
+	ctx := context.Background()
+	defer trace.StartRegion(ctx, "findAncestor").End()
+
 	for start+1 < end {
 		// Split our chain interval in two, and request the hash to cross check
 		check := (start + end) / 2
+		ancestorSearchIterationsCounter.Inc(1)
 
 		headers, hashes, err := d.fetchHeadersByNumber(p, check, 1, 0, false)
 		if err != nil {
@@ -915,6 +1674,7 @@ func (d *Downloader) findAncestorBinarySearch(p *peerConnection, mode SyncMode,
 		default:
 			known = d.blockchain.HasHeader(h, n)
 		}
+		trace.Logf(ctx, "findAncestor", "low=%d high=%d pivot=%d found=%t", start, end, check, known)
 		if !known {
 			end = check
 			continue
@@ -948,17 +1708,29 @@ func (d *Downloader) findAncestorBinarySearch(p *peerConnection, mode SyncMode,
 // other peers are only accepted if they map cleanly to the skeleton. If no one
 // can fill in the skeleton - not even the origin peer - it's assumed invalid and
 // the origin is dropped.
-func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, head uint64) error {
-	p.log.Debug("Directing header downloads", "origin", from)
+//
+// If limit is non-zero, no headers above it are retrieved. Such bounded fetches
+// skip the skeleton and retrieve the headers directly from the origin peer.
+func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, head uint64, limit uint64) error {
+	p.log.Debug("Directing header downloads", "origin", from, "limit", limit)
 	defer p.log.Debug("Header download terminated")
 
 	// Start pulling the header chain skeleton until all is done
 	var (
-		skeleton = true  // Skeleton assembly phase or finishing up
-		pivoting = false // Whether the next request is pivot verification
+		skeleton = limit == 0 // Skeleton assembly phase or finishing up
+		pivoting = false      // Whether the next request is pivot verification
 		ancestor = from
+		fetch    = d.maxHeadersPerRequest
 	)
 	for {
+		// Give the body fetchers time to catch up if a lot of headers are pending
+		for !pivoting && d.queue.HeaderPool() > 2*fetch {
+			select {
+			case <-time.After(headerPoolCheck):
+			case <-d.cancelCh:
+				return errCanceled
+			}
+		}
 		// Pull the next batch of headers, it either:
 		//   - Pivot check to see if the chain moved too far
 		//   - Skeleton retrieval to permit concurrent header fetches
@@ -978,12 +1750,19 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, head uint64) e
 			headers, hashes, err = d.fetchHeadersByNumber(p, pivot+uint64(fsMinFullBlocks), 2, fsMinFullBlocks-9, false) // move +64 when it's 2x64-8 deep
 
 		case skeleton:
-			p.log.Trace("Fetching skeleton headers", "count", MaxHeaderFetch, "from", from)
-			headers, hashes, err = d.fetchHeadersByNumber(p, from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
+			p.log.Trace("Fetching skeleton headers", "count", fetch, "from", from)
+			headers, hashes, err = d.fetchHeadersByNumber(p, from+uint64(fetch)-1, MaxSkeletonSize, fetch-1, false)
+
+		case limit > 0 && from > limit:
+			// Bounded fetch reached its limit, finish as if the chain ended
 
 		default:
-			p.log.Trace("Fetching full headers", "count", MaxHeaderFetch, "from", from)
-			headers, hashes, err = d.fetchHeadersByNumber(p, from, MaxHeaderFetch, 0, false)
+			count := fetch
+			if limit > 0 {
+				count = int(min(uint64(fetch), limit-from+1))
+			}
+			p.log.Trace("Fetching full headers", "count", count, "from", from)
+			headers, hashes, err = d.fetchHeadersByNumber(p, from, count, 0, false)
 		}
 		switch err {
 		case nil:
@@ -1049,9 +1828,9 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, head uint64) e
 		// If the skeleton's finished, pull any remaining head headers directly from the origin
 		if skeleton && len(headers) == 0 {
 			// A malicious node might withhold advertised headers indefinitely
-			if from+uint64(MaxHeaderFetch)-1 <= head {
-				p.log.Warn("Peer withheld skeleton headers", "advertised", head, "withheld", from+uint64(MaxHeaderFetch)-1)
-				return fmt.Errorf("%w: withheld skeleton headers: advertised %d, withheld #%d", errStallingPeer, head, from+uint64(MaxHeaderFetch)-1)
+			if from+uint64(fetch)-1 <= head {
+				p.log.Warn("Peer withheld skeleton headers", "advertised", head, "withheld", from+uint64(fetch)-1)
+				return fmt.Errorf("%w: withheld skeleton headers: advertised %d, withheld #%d", errStallingPeer, head, from+uint64(fetch)-1)
 			}
 			p.log.Debug("No skeleton, fetching headers directly")
 			skeleton = false
@@ -1079,28 +1858,32 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, head uint64) e
 			}
 		}
 		// If we received a skeleton batch, resolve internals concurrently
-		var progressed bool
+		var (
+			progressed bool
+			peers      = []string{p.id}
+		)
 		if skeleton {
-			filled, hashset, proced, err := d.fillHeaderSkeleton(from, headers)
+			filled, hashset, fillers, proced, err := d.fillHeaderSkeleton(from, headers)
 			if err != nil {
 				p.log.Debug("Skeleton chain invalid", "err", err)
 				return fmt.Errorf("%w: %v", errInvalidChain, err)
 			}
 			headers = filled[proced:]
 			hashes = hashset[proced:]
+			peers = fillers
 
 			progressed = proced > 0
 			from += uint64(proced)
 		} else {
 			// A malicious node might withhold advertised headers indefinitely
-			if n := len(headers); n < MaxHeaderFetch && headers[n-1].Number.Uint64() < head {
+			if n := len(headers); n < fetch && headers[n-1].Number.Uint64() < head {
 				p.log.Warn("Peer withheld headers", "advertised", head, "delivered", headers[n-1].Number.Uint64())
 				return fmt.Errorf("%w: withheld headers: advertised %d, delivered %d", errStallingPeer, head, headers[n-1].Number.Uint64())
 			}
 			// If we're closing in on the chain head, but haven't yet reached it, delay
 			// the last few headers so mini reorgs on the head don't cause invalid hash
-			// chain errors.
-			if n := len(headers); n > 0 {
+			// chain errors. Bounded fetches don't need to, they don't go to the head.
+			if n := len(headers); n > 0 && limit == 0 {
 				// Retrieve the current head we're at
 				var head uint64
 				head = d.blockchain.CurrentSnapBlock().Number.Uint64()
@@ -1139,10 +1922,12 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, head uint64) e
 		// Insert any remaining new headers and fetch the next batch
 		if len(headers) > 0 {
 			p.log.Trace("Scheduling new headers", "count", len(headers), "from", from)
+			d.queue.headerPooled.Add(int64(len(headers)))
 			select {
 			case d.headerProcCh <- &headerTask{
 				headers: headers,
 				hashes:  hashes,
+				peers:   peers,
 			}:
 			case <-d.cancelCh:
 				return errCanceled
@@ -1166,19 +1951,19 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, head uint64) e
 //
 // The method returns the entire filled skeleton and also the number of headers
 // already forwarded for processing.
-func (d *Downloader) fillHeaderSkeleton(from uint64, skeleton []*types.Header) ([]*types.Header, []common.Hash, int, error) {
+func (d *Downloader) fillHeaderSkeleton(from uint64, skeleton []*types.Header) ([]*types.Header, []common.Hash, []string, int, error) {
 	log.Debug("Filling up skeleton", "from", from)
 	d.queue.ScheduleSkeleton(from, skeleton)
 
-	err := d.concurrentFetch((*headerQueue)(d), false)
+	err := d.concurrentFetch((*headerQueue)(d), d.config.HeaderFetchTimeout, false)
 	if err != nil {
 		log.Debug("Skeleton fill failed", "err", err)
 	}
-	filled, hashes, proced := d.queue.RetrieveHeaders()
+	filled, hashes, peers, proced := d.queue.RetrieveHeaders()
 	if err == nil {
 		log.Debug("Skeleton fill succeeded", "filled", len(filled), "processed", proced)
 	}
-	return filled, hashes, proced, err
+	return filled, hashes, peers, proced, err
 }
 
 // fetchBodies iteratively downloads the scheduled block bodies, taking any
@@ -1186,7 +1971,7 @@ func (d *Downloader) fillHeaderSkeleton(from uint64, skeleton []*types.Header) (
 // and also periodically checking for timeouts.
 func (d *Downloader) fetchBodies(from uint64, beaconMode bool) error {
 	log.Debug("Downloading block bodies", "origin", from)
-	err := d.concurrentFetch((*bodyQueue)(d), beaconMode)
+	err := d.concurrentFetch((*bodyQueue)(d), d.config.BodyFetchTimeout, beaconMode)
 
 	log.Debug("Block body download terminated", "err", err)
 	return err
@@ -1197,7 +1982,7 @@ func (d *Downloader) fetchBodies(from uint64, beaconMode bool) error {
 // and also periodically checking for timeouts.
 func (d *Downloader) fetchReceipts(from uint64, beaconMode bool) error {
 	log.Debug("Downloading receipts", "origin", from)
-	err := d.concurrentFetch((*receiptQueue)(d), beaconMode)
+	err := d.concurrentFetch((*receiptQueue)(d), d.config.ReceiptFetchTimeout, beaconMode)
 
 	log.Debug("Receipt download terminated", "err", err)
 	return err
@@ -1294,7 +2079,7 @@ func (d *Downloader) processHeaders(origin uint64, td, ttd *big.Int, beaconMode
 					}
 				}
 				// If we've reached the allowed number of pending headers, stall a bit
-				for d.queue.PendingBodies() >= maxQueuedHeaders || d.queue.PendingReceipts() >= maxQueuedHeaders {
+				for d.queue.PendingBodies() >= d.headerCap || d.queue.PendingReceipts() >= d.headerCap {
 					timer.Reset(time.Second)
 					select {
 					case <-d.cancelCh:
@@ -1305,9 +2090,20 @@ func (d *Downloader) processHeaders(origin uint64, td, ttd *big.Int, beaconMode
 				// Otherwise insert the headers for content retrieval
 				inserts := d.queue.Schedule(chunkHeaders, chunkHashes, origin)
 				if len(inserts) != len(chunkHeaders) {
+					// The headers may have been filled in by other peers than the
+					// master. If the master peer has a clean history, drop the
+					// worst of them instead and fail with a retryable error, so
+					// the master peer is kept.
+					if id, worst := d.staleHeadersCulprit(task.peers); worst != nil {
+						log.Warn("Stale headers delivered, dropping worst deliverer", "peer", id, "failures", worst.failures())
+						d.dropPeer(id)
+						return fmt.Errorf("%w: stale headers from %s", errStaleDelivery, id)
+					}
 					return fmt.Errorf("%w: stale headers", errBadPeer)
 				}
 
+				d.queue.headerPooled.Add(-int64(limit))
+
 				headers = headers[limit:]
 				hashes = hashes[limit:]
 				origin += uint64(limit)
@@ -1330,6 +2126,31 @@ func (d *Downloader) processHeaders(origin uint64, td, ttd *big.Int, beaconMode
 	}
 }
 
+// staleHeadersCulprit returns the peer to penalise instead of the master peer for
+// out of order headers delivered by the given peers, or nil if the master peer
+// is to be blamed. That is the case unless the master peer has no failures
+// recorded while another peer that delivered the headers does.
+func (d *Downloader) staleHeadersCulprit(delivered []string) (string, *peerConnection) {
+	if d.dropPeer == nil {
+		return "", nil
+	}
+	d.cancelLock.RLock()
+	master := d.peers.Peer(d.cancelPeer)
+	d.cancelLock.RUnlock()
+
+	if master == nil || master.failures() > 0 {
+		return "", nil
+	}
+	if len(delivered) == 0 {
+		return "", nil
+	}
+	id, worst := d.peers.worstPeer(delivered...)
+	if worst == nil || worst == master || worst.failures() == 0 {
+		return "", nil
+	}
+	return id, worst
+}
+
 // processFullSyncContent takes fetch results from the queue and imports them into the chain.
 func (d *Downloader) processFullSyncContent(ttd *big.Int, beaconMode bool) error {
 	for {
@@ -1365,13 +2186,20 @@ func (d *Downloader) importBlockResults(results []*fetchResult) error {
 		"firstnum", first.Number, "firsthash", first.Hash(),
 		"lastnum", last.Number, "lasthash", last.Hash(),
 	)
+	if err := d.verifyBodies(results); err != nil {
+		return err
+	}
 	blocks := make([]*types.Block, len(results))
 	for i, result := range results {
 		blocks[i] = types.NewBlockWithHeader(result.Header).WithBody(result.body()).WithSidecars(result.Sidecars)
 	}
+	if err := d.storeSidecars(results); err != nil {
+		return err
+	}
 	// Downloaded blocks are always regarded as trusted after the
 	// transition. Because the downloaded chain is guided by the
 	// consensus-layer.
+	head := d.blockchain.CurrentBlock()
 	if index, err := d.blockchain.InsertChain(blocks); err != nil {
 		if index < len(results) {
 			log.Debug("Downloaded item processing failed", "number", results[index].Header.Number, "hash", results[index].Header.Hash(), "err", err)
@@ -1387,9 +2215,28 @@ func (d *Downloader) importBlockResults(results []*fetchResult) error {
 		}
 		return fmt.Errorf("%w: %v", errInvalidChain, err)
 	}
+	d.queue.latency.commit(time.Now(), results)
+	d.notifyBlocksImported(blocks)
+	d.notifyReorg(head, d.blockchain.CurrentBlock())
 	return nil
 }
 
+// verifyBodies checks the lazily verified bodies of a batch of results before
+// they are imported, dropping the peer that delivered a mismatching one.
+func (d *Downloader) verifyBodies(results []*fetchResult) error {
+	peer, err := d.queue.verifyBodies(results)
+	if err == nil {
+		return nil
+	}
+	log.Warn("Delivered block body mismatches header, dropping peer", "peer", peer, "err", err)
+	if d.dropPeer == nil {
+		log.Warn("Downloader wants to drop peer, but peerdrop-function is not set", "peer", peer)
+	} else {
+		d.dropPeer(peer)
+	}
+	return err
+}
+
 // processSnapSyncContent takes fetch results from the queue and writes them to the
 // database. It also controls the synchronisation of state nodes of the pivot block.
 func (d *Downloader) processSnapSyncContent() error {
@@ -1522,6 +2369,7 @@ func (d *Downloader) processSnapSyncContent() error {
 				if err := d.commitPivotBlock(P); err != nil {
 					return err
 				}
+				d.markSnapSyncComplete()
 				oldPivot = nil
 
 			case <-timer.C:
@@ -1579,27 +2427,44 @@ func (d *Downloader) commitSnapSyncData(results []*fetchResult, stateSync *state
 		"firstnum", first.Number, "firsthash", first.Hash(),
 		"lastnumn", last.Number, "lasthash", last.Hash(),
 	)
+	if err := d.verifyBodies(results); err != nil {
+		return err
+	}
 	blocks := make([]*types.Block, len(results))
 	receipts := make([]types.Receipts, len(results))
 	for i, result := range results {
 		blocks[i] = types.NewBlockWithHeader(result.Header).WithBody(result.body()).WithSidecars(result.Sidecars)
 		receipts[i] = result.Receipts
 	}
+	if err := d.storeSidecars(results); err != nil {
+		return err
+	}
 	if index, err := d.blockchain.InsertReceiptChain(blocks, receipts, d.ancientLimit); err != nil {
 		log.Debug("Downloaded item processing failed", "number", results[index].Header.Number, "hash", results[index].Header.Hash(), "err", err)
 		return fmt.Errorf("%w: %v", errInvalidChain, err)
 	}
+	d.queue.latency.commit(time.Now(), results)
 	return nil
 }
 
 func (d *Downloader) commitPivotBlock(result *fetchResult) error {
+	if err := d.verifyBodies([]*fetchResult{result}); err != nil {
+		return err
+	}
 	block := types.NewBlockWithHeader(result.Header).WithBody(result.body()).WithSidecars(result.Sidecars)
 	log.Debug("Committing snap sync pivot as new head", "number", block.Number(), "hash", block.Hash())
 
 	// Commit the pivot block as the new head, will require full sync from here on
+	if err := d.storeSidecars([]*fetchResult{result}); err != nil {
+		return err
+	}
 	if _, err := d.blockchain.InsertReceiptChain([]*types.Block{block}, []types.Receipts{result.Receipts}, d.ancientLimit); err != nil {
 		return err
 	}
+	d.queue.latency.commit(time.Now(), []*fetchResult{result})
+	if snapshots := d.blockchain.Snapshots(); snapshots != nil { // Only nil in tests and with path scheme
+		snapshots.SetGenerationPriority(snapshot.GenerationPriority(d.snapGenPriority.Load()))
+	}
 	if err := d.blockchain.SnapSyncCommitHead(block.Hash()); err != nil {
 		return err
 	}
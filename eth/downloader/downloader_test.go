@@ -17,9 +17,13 @@
 package downloader
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -31,10 +35,12 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/eth/protocols/eth"
 	"github.com/ethereum/go-ethereum/eth/protocols/snap"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
@@ -42,8 +48,13 @@ import (
 	"github.com/ethereum/go-ethereum/trie"
 )
 
+// testSyncTimeout is the time after which a sync cycle run by the tester is
+// cancelled, failing the test instead of letting it hang.
+const testSyncTimeout = time.Minute
+
 // downloadTester is a test simulator for mocking out local block chain.
 type downloadTester struct {
+	t          *testing.T
 	freezer    string
 	chain      *core.BlockChain
 	downloader *Downloader
@@ -67,6 +78,29 @@ func newTesterWithNotification(t *testing.T, success func()) *downloadTester {
 	t.Cleanup(func() {
 		db.Close()
 	})
+	tester := newTesterWithDB(t, db, success)
+	tester.freezer = freezer
+	return tester
+}
+
+// newTesterWithFreezer creates a new downloader test mocker whose ancient store
+// is located at the given path. The freezer is left intact on termination, so
+// callers may prepare its content beforehand and inspect it afterwards.
+func newTesterWithFreezer(t *testing.T, freezerPath string) *downloadTester {
+	db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), freezerPath, "", false, false, false)
+	if err != nil {
+		panic(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return newTesterWithDB(t, db, nil)
+}
+
+// newTesterWithDB creates a new downloader test mocker on top of an existing
+// database. The database is not closed or wiped on termination, allowing tests
+// to simulate a node restart by creating a new tester over the same database.
+func newTesterWithDB(t *testing.T, db ethdb.Database, success func()) *downloadTester {
 	gspec := &core.Genesis{
 		Config:  params.TestChainConfig,
 		Alloc:   types.GenesisAlloc{testAddress: {Balance: big.NewInt(1000000000000000)}},
@@ -77,17 +111,23 @@ func newTesterWithNotification(t *testing.T, success func()) *downloadTester {
 		panic(err)
 	}
 	tester := &downloadTester{
-		freezer: freezer,
-		chain:   chain,
-		peers:   make(map[string]*downloadTesterPeer),
+		t:     t,
+		chain: chain,
+		peers: make(map[string]*downloadTesterPeer),
+	}
+	tester.downloader, err = New(db, new(event.TypeMux), tester.chain, tester.dropPeer, success, nil)
+	if err != nil {
+		panic(err)
 	}
-	tester.downloader = New(db, new(event.TypeMux), tester.chain, tester.dropPeer, success)
 	return tester
 }
 
 // terminate aborts any operations on the embedded downloader and releases all
 // held resources.
 func (dl *downloadTester) terminate() {
+	if !dl.downloader.CancelAndWait(5 * time.Second) {
+		dl.t.Errorf("downloader goroutines still running after cancellation")
+	}
 	dl.downloader.Terminate()
 	dl.chain.Stop()
 
@@ -102,7 +142,10 @@ func (dl *downloadTester) sync(id string, td *big.Int, mode SyncMode) error {
 		td = dl.peers[id].chain.GetTd(head.Hash(), head.Number.Uint64())
 	}
 	// Synchronise with the chosen peer and ensure proper cleanup afterwards
-	err := dl.downloader.synchronise(id, head.Hash(), td, nil, mode, false, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), testSyncTimeout)
+	defer cancel()
+
+	err := dl.downloader.synchroniseContext(ctx, id, head.Hash(), td, mode)
 	select {
 	case <-dl.downloader.cancelCh:
 		// Ok, downloader fully cancelled after sync cycle
@@ -113,6 +156,24 @@ func (dl *downloadTester) sync(id string, td *big.Int, mode SyncMode) error {
 	return err
 }
 
+// simulateNetworkPartition makes all the peers fail their hash based header
+// requests as if they timed out, until the given duration passes.
+func (dl *downloadTester) simulateNetworkPartition(duration time.Duration) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	peers := make([]*downloadTesterPeer, 0, len(dl.peers))
+	for _, peer := range dl.peers {
+		peer.partitioned.Store(true)
+		peers = append(peers, peer)
+	}
+	time.AfterFunc(duration, func() {
+		for _, peer := range peers {
+			peer.partitioned.Store(false)
+		}
+	})
+}
+
 // newPeer registers a new block download source into the downloader.
 func (dl *downloadTester) newPeer(id string, version uint, blocks []*types.Block) *downloadTesterPeer {
 	dl.lock.Lock()
@@ -151,6 +212,50 @@ type downloadTesterPeer struct {
 	chain *core.BlockChain
 
 	withholdHeaders map[common.Hash]struct{}
+	bloatBodies     bool          // Pad served block bodies with junk transactions
+	corruptBodies   bool          // Add a junk transaction to served block bodies, breaking their hashes
+	latency         time.Duration // Simulated network latency of the responses
+	served          atomic.Int32  // Number of header, body and receipt requests served
+	partitioned     atomic.Bool   // Whether hash based header requests fail as if unreachable
+	contiguous      atomic.Int32  // Largest contiguous (skipless) header request served
+
+	reorgAt    uint64           // Block above which header requests trigger the simulated reorg
+	reorgChain *core.BlockChain // Chain the peer switches over to upon the simulated reorg
+	reorged    atomic.Bool      // Whether the peer already switched over to the reorged chain
+}
+
+// SimulateReorg makes the peer switch over to serving newChain once the downloader
+// requests headers starting above block at, as if the remote chain reorganised
+// mid-sync. The head reported by the peer changes along. It must be called before
+// syncing.
+func (dlp *downloadTesterPeer) SimulateReorg(at uint64, newChain *core.BlockChain) {
+	dlp.reorgAt, dlp.reorgChain = at, newChain
+}
+
+// serving returns the chain the peer currently serves its data from.
+func (dlp *downloadTesterPeer) serving() *core.BlockChain {
+	if dlp.reorged.Load() {
+		return dlp.reorgChain
+	}
+	return dlp.chain
+}
+
+// SimulateLatency sets the delay after which the peer delivers its responses,
+// to mimic a remote peer on the network. It must be called before syncing.
+func (dlp *downloadTesterPeer) SimulateLatency(d time.Duration) {
+	dlp.latency = d
+}
+
+// deliver sends a response to the downloader after the simulated latency.
+func (dlp *downloadTesterPeer) deliver(sink chan *eth.Response, res *eth.Response) {
+	dlp.served.Add(1)
+	if dlp.latency > 0 {
+		res.Time = dlp.latency
+	}
+	go func() {
+		time.Sleep(dlp.latency)
+		sink <- res
+	}()
 }
 
 func (dlp *downloadTesterPeer) MarkLagging() {
@@ -159,8 +264,9 @@ func (dlp *downloadTesterPeer) MarkLagging() {
 // Head constructs a function to retrieve a peer's current head hash
 // and total difficulty.
 func (dlp *downloadTesterPeer) Head() (common.Hash, *big.Int) {
-	head := dlp.chain.CurrentBlock()
-	return head.Hash(), dlp.chain.GetTd(head.Hash(), head.Number.Uint64())
+	chain := dlp.serving()
+	head := chain.CurrentBlock()
+	return head.Hash(), chain.GetTd(head.Hash(), head.Number.Uint64())
 }
 
 func unmarshalRlpHeaders(rlpdata []rlp.RawValue) []*types.Header {
@@ -179,8 +285,11 @@ func unmarshalRlpHeaders(rlpdata []rlp.RawValue) []*types.Header {
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
 func (dlp *downloadTesterPeer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool, sink chan *eth.Response) (*eth.Request, error) {
+	if dlp.partitioned.Load() {
+		return nil, context.DeadlineExceeded
+	}
 	// Service the header query via the live handler code
-	rlpHeaders := eth.ServiceGetBlockHeadersQuery(dlp.chain, &eth.GetBlockHeadersRequest{
+	rlpHeaders := eth.ServiceGetBlockHeadersQuery(dlp.serving(), &eth.GetBlockHeadersRequest{
 		Origin: eth.HashOrNumber{
 			Hash: origin,
 		},
@@ -213,9 +322,7 @@ func (dlp *downloadTesterPeer) RequestHeadersByHash(origin common.Hash, amount i
 		Time: 1,
 		Done: make(chan error, 1), // Ignore the returned status
 	}
-	go func() {
-		sink <- res
-	}()
+	dlp.deliver(sink, res)
 	return req, nil
 }
 
@@ -223,8 +330,19 @@ func (dlp *downloadTesterPeer) RequestHeadersByHash(origin common.Hash, amount i
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
 func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool, sink chan *eth.Response) (*eth.Request, error) {
+	if skip == 0 {
+		for {
+			have := dlp.contiguous.Load()
+			if int32(amount) <= have || dlp.contiguous.CompareAndSwap(have, int32(amount)) {
+				break
+			}
+		}
+	}
+	if dlp.reorgChain != nil && origin > dlp.reorgAt {
+		dlp.reorged.Store(true)
+	}
 	// Service the header query via the live handler code
-	rlpHeaders := eth.ServiceGetBlockHeadersQuery(dlp.chain, &eth.GetBlockHeadersRequest{
+	rlpHeaders := eth.ServiceGetBlockHeadersQuery(dlp.serving(), &eth.GetBlockHeadersRequest{
 		Origin: eth.HashOrNumber{
 			Number: origin,
 		},
@@ -257,9 +375,7 @@ func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int,
 		Time: 1,
 		Done: make(chan error, 1), // Ignore the returned status
 	}
-	go func() {
-		sink <- res
-	}()
+	dlp.deliver(sink, res)
 	return req, nil
 }
 
@@ -267,13 +383,21 @@ func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int,
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block bodies from the particularly requested peer.
 func (dlp *downloadTesterPeer) RequestBodies(hashes []common.Hash, sink chan *eth.Response) (*eth.Request, error) {
-	blobs := eth.ServiceGetBlockBodiesQuery(dlp.chain, hashes)
+	blobs := eth.ServiceGetBlockBodiesQuery(dlp.serving(), hashes)
 
 	bodies := make([]*eth.BlockBody, len(blobs))
 	for i, blob := range blobs {
 		bodies[i] = new(eth.BlockBody)
 		rlp.DecodeBytes(blob, bodies[i])
 	}
+	if dlp.bloatBodies && len(bodies) > 0 {
+		for nonce := uint64(0); len(bodies[0].Transactions) <= maxBodyTransactions; nonce++ {
+			bodies[0].Transactions = append(bodies[0].Transactions, types.NewTx(&types.LegacyTx{Nonce: nonce}))
+		}
+	}
+	if dlp.corruptBodies && len(bodies) > 0 {
+		bodies[0].Transactions = append(bodies[0].Transactions, types.NewTx(&types.LegacyTx{}))
+	}
 	var (
 		txsHashes        = make([]common.Hash, len(bodies))
 		uncleHashes      = make([]common.Hash, len(bodies))
@@ -294,9 +418,7 @@ func (dlp *downloadTesterPeer) RequestBodies(hashes []common.Hash, sink chan *et
 		Time: 1,
 		Done: make(chan error, 1), // Ignore the returned status
 	}
-	go func() {
-		sink <- res
-	}()
+	dlp.deliver(sink, res)
 	return req, nil
 }
 
@@ -304,7 +426,7 @@ func (dlp *downloadTesterPeer) RequestBodies(hashes []common.Hash, sink chan *et
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block receipts from the particularly requested peer.
 func (dlp *downloadTesterPeer) RequestReceipts(hashes []common.Hash, sink chan *eth.Response) (*eth.Request, error) {
-	blobs := eth.ServiceGetReceiptsQuery(dlp.chain, hashes)
+	blobs, _ := eth.ServiceGetReceiptsQuery(dlp.serving(), hashes)
 
 	receipts := make([][]*types.Receipt, len(blobs))
 	for i, blob := range blobs {
@@ -325,9 +447,7 @@ func (dlp *downloadTesterPeer) RequestReceipts(hashes []common.Hash, sink chan *
 		Time: 1,
 		Done: make(chan error, 1), // Ignore the returned status
 	}
-	go func() {
-		sink <- res
-	}()
+	dlp.deliver(sink, res)
 	return req, nil
 }
 
@@ -347,7 +467,7 @@ func (dlp *downloadTesterPeer) RequestAccountRange(id uint64, root, origin, limi
 		Limit:  limit,
 		Bytes:  bytes,
 	}
-	slimaccs, proofs := snap.ServiceGetAccountRangeQuery(dlp.chain, req)
+	slimaccs, proofs := snap.ServiceGetAccountRangeQuery(dlp.serving(), req)
 
 	// We need to convert to non-slim format, delegate to the packet code
 	res := &snap.AccountRangePacket{
@@ -374,7 +494,7 @@ func (dlp *downloadTesterPeer) RequestStorageRanges(id uint64, root common.Hash,
 		Limit:    limit,
 		Bytes:    bytes,
 	}
-	storage, proofs := snap.ServiceGetStorageRangesQuery(dlp.chain, req)
+	storage, proofs := snap.ServiceGetStorageRangesQuery(dlp.serving(), req)
 
 	// We need to convert to demultiplex, delegate to the packet code
 	res := &snap.StorageRangesPacket{
@@ -395,7 +515,7 @@ func (dlp *downloadTesterPeer) RequestByteCodes(id uint64, hashes []common.Hash,
 		Hashes: hashes,
 		Bytes:  bytes,
 	}
-	codes := snap.ServiceGetByteCodesQuery(dlp.chain, req)
+	codes := snap.ServiceGetByteCodesQuery(dlp.serving(), req)
 	go dlp.dl.downloader.SnapSyncer.OnByteCodes(dlp, id, codes)
 	return nil
 }
@@ -409,7 +529,7 @@ func (dlp *downloadTesterPeer) RequestTrieNodes(id uint64, root common.Hash, pat
 		Paths: paths,
 		Bytes: bytes,
 	}
-	nodes, _ := snap.ServiceGetTrieNodesQuery(dlp.chain, req, time.Now())
+	nodes, _ := snap.ServiceGetTrieNodesQuery(dlp.serving(), req, time.Now())
 	go dlp.dl.downloader.SnapSyncer.OnTrieNodes(dlp, id, nodes)
 	return nil
 }
@@ -437,7 +557,11 @@ func assertOwnChain(t *testing.T, tester *downloadTester, length int) {
 	}
 }
 
-func TestCanonicalSynchronisation68Full(t *testing.T) { testCanonSync(t, eth.ETH68, FullSync) }
+func TestCanonicalSynchronisation68Full(t *testing.T) {
+	t.Run("fresh", func(t *testing.T) { testCanonSync(t, eth.ETH68, FullSync) })
+	t.Run("ancients", func(t *testing.T) { testCanonSyncWithAncients(t, eth.ETH68, FullSync) })
+	t.Run("small-header-requests", func(t *testing.T) { testCanonSyncHeadersPerRequest(t, eth.ETH68, FullSync, 32) })
+}
 func TestCanonicalSynchronisation68Snap(t *testing.T) { testCanonSync(t, eth.ETH68, SnapSync) }
 
 func testCanonSync(t *testing.T, protocol uint, mode SyncMode) {
@@ -455,18 +579,113 @@ func testCanonSync(t *testing.T, protocol uint, mode SyncMode) {
 	assertOwnChain(t, tester, len(chain.blocks))
 }
 
+// testCanonSyncHeadersPerRequest tests that a simple synchronization against a
+// canonical chain works correctly with a header request size lower than the
+// default MaxHeaderFetch.
+func testCanonSyncHeadersPerRequest(t *testing.T, protocol uint, mode SyncMode, n int) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	if err := tester.downloader.SetMaxHeadersPerRequest(n); err != nil {
+		t.Fatalf("failed to set header request size: %v", err)
+	}
+	for _, invalid := range []int{0, -1, maxHeadersPerRequestLimit + 1} {
+		if err := tester.downloader.SetMaxHeadersPerRequest(invalid); !errors.Is(err, errInvalidHeaderFetch) {
+			t.Fatalf("header request size %d: error mismatch: have %v, want %v", invalid, err, errInvalidHeaderFetch)
+		}
+	}
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	peer := tester.newPeer("peer", protocol, chain.blocks[1:])
+
+	if err := tester.sync("peer", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	// Make sure no header request exceeded the configured size
+	if have := int(peer.contiguous.Load()); have != n {
+		t.Fatalf("largest header request mismatch: have %d, want %d", have, n)
+	}
+}
+
+// testCanonSyncWithAncients tests that a simple synchronization against a canonical
+// chain works correctly if the first blocks are already present in the ancient
+// store, without the downloader fetching them again.
+func testCanonSyncWithAncients(t *testing.T, protocol uint, mode SyncMode) {
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	frozen := 64
+
+	// Import the first blocks into a local chain and migrate them to the ancients
+	freezer := t.TempDir()
+	db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), freezer, "", false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	tester := newTesterWithDB(t, db, nil)
+	if _, err := tester.chain.InsertChain(chain.blocks[1 : frozen+1]); err != nil {
+		t.Fatalf("failed to import blocks: %v", err)
+	}
+	receipts := make([]types.Receipts, frozen+1)
+	for i, block := range chain.blocks[:frozen+1] {
+		receipts[i] = tester.chain.GetReceiptsByHash(block.Hash())
+	}
+	genesis := chain.blocks[0]
+	if _, err := rawdb.WriteAncientBlocks(db, chain.blocks[:frozen+1], receipts, tester.chain.GetTd(genesis.Hash(), 0)); err != nil {
+		t.Fatalf("failed to write ancient blocks: %v", err)
+	}
+	if n, err := db.Ancients(); err != nil || n != uint64(frozen+1) {
+		t.Fatalf("ancient item count mismatch: have %d, want %d (err %v)", n, frozen+1, err)
+	}
+	tester.terminate()
+	db.Close()
+
+	// Reopen the chain on top of the prepopulated freezer and sync the rest
+	tester = newTesterWithFreezer(t, freezer)
+	defer tester.terminate()
+
+	var refetched atomic.Int64
+	tester.downloader.bodyFetchHook = func(headers []*types.Header) {
+		for _, header := range headers {
+			if header.Number.Uint64() <= uint64(frozen) {
+				refetched.Add(1)
+			}
+		}
+	}
+	tester.newPeer("peer", protocol, chain.blocks[1:])
+	if err := tester.sync("peer", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	if n := refetched.Load(); n != 0 {
+		t.Fatalf("ancient blocks refetched: %d", n)
+	}
+}
+
 // Tests that if a large batch of blocks are being downloaded, it is throttled
 // until the cached blocks are retrieved.
 func TestThrottling68Full(t *testing.T) { testThrottling(t, eth.ETH68, FullSync) }
 func TestThrottling68Snap(t *testing.T) { testThrottling(t, eth.ETH68, SnapSync) }
 
 func testThrottling(t *testing.T, protocol uint, mode SyncMode) {
+	t.Run("long", func(t *testing.T) { testThrottlingChain(t, protocol, mode, testChainBase) })
+
+	// Ensure chains around the length of the reorg protection delay aren't off
+	// by one in the throttling accounting
+	for _, length := range []int{reorgProtHeaderDelay - 1, reorgProtHeaderDelay, reorgProtHeaderDelay + 1} {
+		t.Run(fmt.Sprintf("length-%d", length), func(t *testing.T) {
+			testThrottlingChain(t, protocol, mode, testChainBase.shorten(length+1))
+		})
+	}
+}
+
+func testThrottlingChain(t *testing.T, protocol uint, mode SyncMode, chain *testChain) {
 	tester := newTester(t)
 	defer tester.terminate()
 
-	// Create a long block chain to download and the tester
-	targetBlocks := len(testChainBase.blocks) - 1
-	tester.newPeer("peer", protocol, testChainBase.blocks[1:])
+	// Create a block chain to download and the tester
+	targetBlocks := len(chain.blocks) - 1
+	tester.newPeer("peer", protocol, chain.blocks[1:])
 
 	// Wrap the importer to allow stepping
 	var blocked atomic.Uint32
@@ -588,6 +807,76 @@ func testHeavyForkedSync(t *testing.T, protocol uint, mode SyncMode) {
 	assertOwnChain(t, tester, len(chainB.blocks))
 }
 
+// testExtension is a downloader extension recording the events it's notified of.
+type testExtension struct {
+	NoopExtension // Ensure the no-op implementation satisfies the interface
+
+	starts    []string
+	completes []error
+	imported  []common.Hash
+	reorgs    [][2]common.Hash
+}
+
+func (ext *testExtension) OnSyncStart(peer string, mode SyncMode) {
+	ext.starts = append(ext.starts, peer)
+}
+
+func (ext *testExtension) OnSyncComplete(peer string, err error) {
+	ext.completes = append(ext.completes, err)
+}
+
+func (ext *testExtension) OnBlockImported(block *types.Block) {
+	ext.imported = append(ext.imported, block.Hash())
+}
+
+func (ext *testExtension) OnReorg(oldHead, newHead *types.Header) {
+	ext.reorgs = append(ext.reorgs, [2]common.Hash{oldHead.Hash(), newHead.Hash()})
+}
+
+// Tests that registered downloader extensions are notified of the sync cycles,
+// the imported blocks and the chain reorganisations.
+func TestDownloaderExtensions68Full(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	exts := []*testExtension{new(testExtension), new(testExtension)}
+	for _, ext := range exts {
+		tester.downloader.RegisterExtension(ext)
+	}
+	chainA := testChainForkLightA.shorten(len(testChainBase.blocks) + 80)
+	chainB := testChainForkLightB.shorten(len(testChainBase.blocks) + 81)
+	tester.newPeer("fork A", eth.ETH68, chainA.blocks[1:])
+	tester.newPeer("fork B", eth.ETH68, chainB.blocks[1:])
+
+	if err := tester.sync("fork A", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if err := tester.sync("fork B", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	for i, ext := range exts {
+		if want := []string{"fork A", "fork B"}; !slices.Equal(ext.starts, want) {
+			t.Errorf("extension %d: sync starts mismatch: have %v, want %v", i, ext.starts, want)
+		}
+		if want := []error{nil, nil}; !slices.Equal(ext.completes, want) {
+			t.Errorf("extension %d: sync completions mismatch: have %v, want %v", i, ext.completes, want)
+		}
+		// All blocks of the first fork are new, the second one shares the base
+		for j, block := range chainA.blocks[1:] {
+			if j >= len(ext.imported) || ext.imported[j] != block.Hash() {
+				t.Fatalf("extension %d: imported block %d mismatch", i, j+1)
+			}
+		}
+		if last := ext.imported[len(ext.imported)-1]; last != chainB.blocks[len(chainB.blocks)-1].Hash() {
+			t.Errorf("extension %d: last imported block mismatch: have %x, want %x", i, last, chainB.blocks[len(chainB.blocks)-1].Hash())
+		}
+		want := [][2]common.Hash{{chainA.blocks[len(chainA.blocks)-1].Hash(), chainB.blocks[len(chainB.blocks)-1].Hash()}}
+		if !slices.Equal(ext.reorgs, want) {
+			t.Errorf("extension %d: reorgs mismatch: have %x, want %x", i, ext.reorgs, want)
+		}
+	}
+}
+
 // Tests that chain forks are contained within a certain interval of the current
 // chain head, ensuring that malicious peers cannot waste resources by feeding
 // long dead chains.
@@ -615,6 +904,39 @@ func testBoundedForkedSync(t *testing.T, protocol uint, mode SyncMode) {
 	}
 }
 
+// Tests that the binary ancestor search on a fork at the maximum allowed depth
+// takes exactly log2(FullMaxForkAncestry) iterations.
+func TestAncestorSearchIterations68Full(t *testing.T) {
+	testAncestorSearchIterations(t, eth.ETH68, FullSync)
+}
+func TestAncestorSearchIterations68Snap(t *testing.T) {
+	testAncestorSearchIterations(t, eth.ETH68, SnapSync)
+}
+
+func testAncestorSearchIterations(t *testing.T, protocol uint, mode SyncMode) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chainA := testChainForkLightA
+	chainB := testChainForkLightB
+	tester.newPeer("original", protocol, chainA.blocks[1:])
+	tester.newPeer("rewriter", protocol, chainB.blocks[1:])
+
+	if err := tester.sync("original", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	// Both forks are of equal length, so the binary search spans exactly the
+	// maximum fork ancestry, halving it until the lowest allowed block
+	before := ancestorSearchIterationsCounter.Snapshot().Count()
+	if err := tester.sync("rewriter", nil, mode); err != errInvalidAncestor {
+		t.Fatalf("sync failure mismatch: have %v, want %v", err, errInvalidAncestor)
+	}
+	have := ancestorSearchIterationsCounter.Snapshot().Count() - before
+	if want := int64(math.Log2(float64(FullMaxForkAncestry))); have != want {
+		t.Fatalf("binary search iterations mismatch: have %d, want %d", have, want)
+	}
+}
+
 // Tests that chain forks are contained within a certain interval of the current
 // chain head for short but heavy forks too. These are a bit special because they
 // take different ancestor lookup paths.
@@ -660,7 +982,7 @@ func testCancel(t *testing.T, protocol uint, mode SyncMode) {
 
 	// Make sure canceling works with a pristine downloader
 	tester.downloader.Cancel()
-	if !tester.downloader.queue.Idle() {
+	if !tester.downloader.QueueIdle() {
 		t.Errorf("download queue not idle")
 	}
 	// Synchronise with the peer, but cancel afterwards
@@ -668,9 +990,12 @@ func testCancel(t *testing.T, protocol uint, mode SyncMode) {
 		t.Fatalf("failed to synchronise blocks: %v", err)
 	}
 	tester.downloader.Cancel()
-	if !tester.downloader.queue.Idle() {
+	if !tester.downloader.QueueIdle() {
 		t.Errorf("download queue not idle")
 	}
+	if pending := tester.downloader.QueueResultsPending(); pending != 0 {
+		t.Errorf("download queue results pending: have %d, want 0", pending)
+	}
 }
 
 // Tests that synchronisation from multiple peers works as intended (multi thread sanity test).
@@ -693,6 +1018,20 @@ func testMultiSynchronisation(t *testing.T, protocol uint, mode SyncMode) {
 		t.Fatalf("failed to synchronise blocks: %v", err)
 	}
 	assertOwnChain(t, tester, len(chain.blocks))
+
+	// Ensure none of the peers got dropped during the sync
+	if have := tester.downloader.PeerCount(); have != targetPeers {
+		t.Errorf("registered peer count mismatch: have %d, want %d", have, targetPeers)
+	}
+	peers := tester.downloader.Peers()
+	if len(peers) != targetPeers {
+		t.Errorf("registered peers mismatch: have %v, want %d peers", peers, targetPeers)
+	}
+	for i := 0; i < targetPeers; i++ {
+		if id := fmt.Sprintf("peer #%d", i); !slices.Contains(peers, id) {
+			t.Errorf("peer %q not registered", id)
+		}
+	}
 }
 
 // Tests that synchronisations behave well in multi-version protocol environments
@@ -710,6 +1049,14 @@ func testMultiProtoSync(t *testing.T, protocol uint, mode SyncMode) {
 	// Create peers of every type
 	tester.newPeer("peer 68", eth.ETH68, chain.blocks[1:])
 
+	// Ensure peers on deprecated protocols are rejected straight away
+	if err := tester.downloader.RegisterPeer("peer 67", eth.ETH68-1, &downloadTesterPeer{dl: tester, id: "peer 67"}); !errors.Is(err, errTooOldProtocol) {
+		t.Fatalf("deprecated peer registration error mismatch: have %v, want %v", err, errTooOldProtocol)
+	}
+	if peers := tester.downloader.PeerCount(); peers != 1 {
+		t.Fatalf("registered peer count mismatch: have %d, want 1", peers)
+	}
+
 	// Synchronise with the requested peer and make sure all blocks were retrieved
 	if err := tester.sync(fmt.Sprintf("peer %d", protocol), nil, mode); err != nil {
 		t.Fatalf("failed to synchronise blocks: %v", err)
@@ -778,13 +1125,33 @@ func TestMissingHeaderAttack68Full(t *testing.T) { testMissingHeaderAttack(t, et
 func TestMissingHeaderAttack68Snap(t *testing.T) { testMissingHeaderAttack(t, eth.ETH68, SnapSync) }
 
 func testMissingHeaderAttack(t *testing.T, protocol uint, mode SyncMode) {
+	testMissingHeaderAttackAt(t, protocol, mode, (blockCacheMaxItems-15)/2-1)
+}
+
+// FuzzMissingHeaderAttack checks that a peer withholding a header at arbitrary
+// positions of the chain is detected, without the sync panicking, hanging or
+// leaking goroutines.
+func FuzzMissingHeaderAttack(f *testing.F) {
+	length := uint(blockCacheMaxItems - 15)
+	for _, position := range []uint{1, length / 4, length / 2, length - 1} {
+		f.Add(position - 1) // Undo the genesis offset below
+	}
+	f.Fuzz(func(t *testing.T, offset uint) {
+		// Genesis is never requested from peers, withhold any other header
+		testMissingHeaderAttackAt(t, eth.ETH68, FullSync, 1+int(offset%(length-1)))
+	})
+}
+
+// testMissingHeaderAttackAt runs a sync against a peer withholding the header
+// at the given position of the chain, followed by a sync against a valid one.
+func testMissingHeaderAttackAt(t *testing.T, protocol uint, mode SyncMode, position int) {
 	tester := newTester(t)
 	defer tester.terminate()
 
 	chain := testChainBase.shorten(blockCacheMaxItems - 15)
 
 	attacker := tester.newPeer("attack", protocol, chain.blocks[1:])
-	attacker.withholdHeaders[chain.blocks[len(chain.blocks)/2-1].Hash()] = struct{}{}
+	attacker.withholdHeaders[chain.blocks[position].Hash()] = struct{}{}
 
 	if err := tester.sync("attack", nil, mode); err == nil {
 		t.Fatalf("succeeded attacker synchronisation")
@@ -797,6 +1164,96 @@ func testMissingHeaderAttack(t *testing.T, protocol uint, mode SyncMode) {
 	assertOwnChain(t, tester, len(chain.blocks))
 }
 
+// Tests that a peer delivering block bodies with more transactions than what can
+// fit into a block gets dropped, and sync proceeds with the remaining peers.
+func TestOversizedBodyAttack68Full(t *testing.T) { testOversizedBodyAttack(t, eth.ETH68, FullSync) }
+func TestOversizedBodyAttack68Snap(t *testing.T) { testOversizedBodyAttack(t, eth.ETH68, SnapSync) }
+
+func testOversizedBodyAttack(t *testing.T, protocol uint, mode SyncMode) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+
+	tester.newPeer("valid", protocol, chain.blocks[1:])
+	attacker := tester.newPeer("attack", protocol, chain.blocks[1:])
+	attacker.bloatBodies = true
+
+	if err := tester.sync("valid", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	tester.lock.RLock()
+	_, ok := tester.peers["attack"]
+	tester.lock.RUnlock()
+	if ok {
+		t.Fatalf("peer delivering oversized bodies not dropped")
+	}
+}
+
+// Tests that when synchronising with peers of different latencies, the faster
+// peer ends up serving more of the data retrievals.
+func TestLatencyAwarePeerSelection(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+
+	fast := tester.newPeer("fast", eth.ETH68, chain.blocks[1:])
+	fast.SimulateLatency(time.Millisecond)
+	slow := tester.newPeer("slow", eth.ETH68, chain.blocks[1:])
+	slow.SimulateLatency(50 * time.Millisecond)
+
+	if err := tester.sync("fast", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	if f, s := fast.served.Load(), slow.served.Load(); f <= s {
+		t.Fatalf("fast peer served less requests than slow one: fast %d, slow %d", f, s)
+	}
+}
+
+// Tests that body retrievals are assigned to peers declaring the preferred extra
+// capabilities ahead of peers which don't, and that the declared capabilities
+// are forgotten when a peer is unregistered.
+func TestCapabilityAwarePeerSelection(t *testing.T) {
+	// The chain requires a single body to be retrieved, which is assigned to a
+	// random peer without preferences, so repeat the sync a few times
+	chain := testChainBase.shorten(reorgProtHeaderDelay + 1)
+
+	for i := 0; i < 8; i++ {
+		tester := newTester(t)
+
+		plain := tester.newPeer("plain", eth.ETH68, chain.blocks[1:])
+		capable := tester.newPeer("capable", eth.ETH68, chain.blocks[1:])
+		tester.downloader.RegisterPeerCapabilities("capable", map[string]bool{"eth/68": true, "bsc/1": true})
+
+		if err := tester.sync("plain", nil, FullSync); err != nil {
+			t.Fatalf("run %d: failed to synchronise blocks: %v", i, err)
+		}
+		assertOwnChain(t, tester, len(chain.blocks))
+
+		if served := capable.served.Load(); served == 0 {
+			t.Fatalf("run %d: capable peer not preferred: plain served %d, capable %d", i, plain.served.Load(), served)
+		}
+		tester.downloader.UnregisterPeer("capable")
+		if tester.downloader.hasCapabilities("capable", preferredFetchCapabilities) {
+			t.Fatalf("run %d: capabilities retained after unregistering", i)
+		}
+		// Capabilities of peers never registered must be forgotten too
+		tester.downloader.RegisterPeerCapabilities("unknown", map[string]bool{"bsc/1": true})
+		if err := tester.downloader.UnregisterPeer("unknown"); err == nil {
+			t.Fatalf("run %d: unknown peer unregistered", i)
+		}
+		if tester.downloader.hasCapabilities("unknown", preferredFetchCapabilities) {
+			t.Fatalf("run %d: capabilities retained for unknown peer", i)
+		}
+		tester.terminate()
+	}
+}
+
 // Tests that if requested headers are shifted (i.e. first is missing), the queue
 // detects the invalid numbering.
 func TestShiftedHeaderAttack68Full(t *testing.T) { testShiftedHeaderAttack(t, eth.ETH68, FullSync) }
@@ -837,9 +1294,23 @@ func testHighTDStarvationAttack(t *testing.T, protocol uint, mode SyncMode) {
 	defer tester.terminate()
 
 	chain := testChainBase.shorten(1)
-	tester.newPeer("attack", protocol, chain.blocks[1:])
+	peer := tester.newPeer("attack", protocol, chain.blocks[1:])
+	for i := 0; i < maxLagScore; i++ {
+		if err := tester.sync("attack", big.NewInt(1000000), mode); err != errLaggingPeer {
+			t.Fatalf("attempt %d: synchronisation error mismatch: have %v, want %v", i, err, errLaggingPeer)
+		}
+	}
+	head := chain.blocks[len(chain.blocks)-1].Hash()
+	if score := tester.downloader.peerLagScore("attack", head); score < maxLagScore {
+		t.Fatalf("lag score mismatch: have %d, want >= %d", score, maxLagScore)
+	}
+	// Any further attempt should be rejected without contacting the peer
+	served := peer.served.Load()
 	if err := tester.sync("attack", big.NewInt(1000000), mode); err != errLaggingPeer {
-		t.Fatalf("synchronisation error mismatch: have %v, want %v", err, errLaggingPeer)
+		t.Fatalf("rejected attempt: synchronisation error mismatch: have %v, want %v", err, errLaggingPeer)
+	}
+	if have := peer.served.Load(); have != served {
+		t.Fatalf("rejected attempt contacted the peer: served %d requests", have-served)
 	}
 }
 
@@ -926,6 +1397,7 @@ func testSyncProgress(t *testing.T, protocol uint, mode SyncMode) {
 	checkProgress(t, tester.downloader, "initial", ethereum.SyncProgress{
 		HighestBlock: uint64(len(chain.blocks)/2 - 1),
 	})
+	checkBehindBy(t, tester.downloader, "initial", uint64(len(chain.blocks)/2-1))
 	progress <- struct{}{}
 	pending.Wait()
 
@@ -944,6 +1416,7 @@ func testSyncProgress(t *testing.T, protocol uint, mode SyncMode) {
 		CurrentBlock:  uint64(len(chain.blocks)/2 - 1),
 		HighestBlock:  uint64(len(chain.blocks) - 1),
 	})
+	checkBehindBy(t, tester.downloader, "completing", uint64(len(chain.blocks)-len(chain.blocks)/2))
 
 	// Check final progress after successful sync
 	progress <- struct{}{}
@@ -953,6 +1426,16 @@ func testSyncProgress(t *testing.T, protocol uint, mode SyncMode) {
 		CurrentBlock:  uint64(len(chain.blocks) - 1),
 		HighestBlock:  uint64(len(chain.blocks) - 1),
 	})
+	checkBehindBy(t, tester.downloader, "final", 0)
+}
+
+func checkBehindBy(t *testing.T, d *Downloader, stage string, want uint64) {
+	// Mark this method as a helper to report errors at callsite, not in here
+	t.Helper()
+
+	if have := d.BehindBy(); have != want {
+		t.Errorf("%s blocks behind mismatch: have %d, want %d", stage, have, want)
+	}
 }
 
 func checkProgress(t *testing.T, d *Downloader, stage string, want ethereum.SyncProgress) {
@@ -1186,39 +1669,417 @@ func testFakedSyncProgress(t *testing.T, protocol uint, mode SyncMode) {
 	})
 }
 
-func TestRemoteHeaderRequestSpan(t *testing.T) {
-	testCases := []struct {
-		remoteHeight uint64
-		localHeight  uint64
-		expected     []int
-	}{
-		// Remote is way higher. We should ask for the remote head and go backwards
-		{1500, 1000,
-			[]int{1323, 1339, 1355, 1371, 1387, 1403, 1419, 1435, 1451, 1467, 1483, 1499},
-		},
-		{15000, 13006,
-			[]int{14823, 14839, 14855, 14871, 14887, 14903, 14919, 14935, 14951, 14967, 14983, 14999},
-		},
-		// Remote is pretty close to us. We don't have to fetch as many
-		{1200, 1150,
-			[]int{1149, 1154, 1159, 1164, 1169, 1174, 1179, 1184, 1189, 1194, 1199},
-		},
-		// Remote is equal to us (so on a fork with higher td)
-		// We should get the closest couple of ancestors
-		{1500, 1500,
-			[]int{1497, 1499},
-		},
-		// We're higher than the remote! Odd
-		{1000, 1500,
-			[]int{997, 999},
-		},
-		// Check some weird edgecases that it behaves somewhat rationally
-		{0, 1500,
-			[]int{0, 2},
-		},
-		{6000000, 0,
-			[]int{5999823, 5999839, 5999855, 5999871, 5999887, 5999903, 5999919, 5999935, 5999951, 5999967, 5999983, 5999999},
-		},
+// Tests that the peer currently being synced from and the mode of the sync are
+// exposed while a sync cycle is running and cleared once it terminates.
+func TestSyncPeer68Full(t *testing.T) { testSyncPeer(t, eth.ETH68, FullSync) }
+func TestSyncPeer68Snap(t *testing.T) { testSyncPeer(t, eth.ETH68, SnapSync) }
+
+func testSyncPeer(t *testing.T, protocol uint, mode SyncMode) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(MaxHeaderFetch)
+	tester.newPeer("peer", protocol, chain.blocks[1:])
+
+	// Set a sync init hook to pause the sync mid-flight
+	starting := make(chan struct{})
+	progress := make(chan struct{})
+
+	tester.downloader.syncInitHook = func(origin, latest uint64) {
+		starting <- struct{}{}
+		<-progress
+	}
+	if id := tester.downloader.SyncPeer(); id != "" {
+		t.Fatalf("pristine sync peer mismatch: have %q, want %q", id, "")
+	}
+	if have := tester.downloader.CurrentMode(); have != NoSync {
+		t.Fatalf("pristine sync mode mismatch: have %v, want %v", have, NoSync)
+	}
+	if eta := tester.downloader.SnapSyncETA(); eta != -1 {
+		t.Fatalf("pristine snap sync ETA mismatch: have %v, want -1", eta)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tester.sync("peer", nil, mode)
+	}()
+	<-starting
+	if id := tester.downloader.SyncPeer(); id != "peer" {
+		t.Fatalf("active sync peer mismatch: have %q, want %q", id, "peer")
+	}
+	if have := tester.downloader.CurrentMode(); have != mode {
+		t.Fatalf("active sync mode mismatch: have %v, want %v", have, mode)
+	}
+	progress <- struct{}{}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if id := tester.downloader.SyncPeer(); id != "" {
+		t.Fatalf("final sync peer mismatch: have %q, want %q", id, "")
+	}
+	if have := tester.downloader.CurrentMode(); have != NoSync {
+		t.Fatalf("final sync mode mismatch: have %v, want %v", have, NoSync)
+	}
+}
+
+// Tests that syncing to a target header finds the peer advertising it, and that
+// the sync is aborted with the context error when the context gets cancelled.
+func TestSyncTo68Full(t *testing.T) { testSyncTo(t, eth.ETH68, FullSync) }
+func TestSyncTo68Snap(t *testing.T) { testSyncTo(t, eth.ETH68, SnapSync) }
+
+func testSyncTo(t *testing.T, protocol uint, mode SyncMode) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800)
+	tester.newPeer("short", protocol, testChainBase.shorten(800 / 2).blocks[1:])
+	tester.newPeer("long", protocol, chain.blocks[1:])
+
+	// Unknown targets should be rejected straight away
+	unknown := testChainForkLightA.blocks[len(testChainForkLightA.blocks)-1].Header()
+	if err := tester.downloader.SyncTo(context.Background(), unknown, mode); !errors.Is(err, errNoPeers) {
+		t.Fatalf("unknown target error mismatch: have %v, want %v", err, errNoPeers)
+	}
+	// Pause the sync mid-flight and cancel its context
+	starting := make(chan struct{})
+	progress := make(chan struct{})
+
+	tester.downloader.syncInitHook = func(origin, latest uint64) {
+		starting <- struct{}{}
+		<-progress
+	}
+	target := chain.blocks[len(chain.blocks)-1].Header()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tester.downloader.SyncTo(ctx, target, mode)
+	}()
+	<-starting
+	cancel()
+	progress <- struct{}{}
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("cancelled sync error mismatch: have %v, want %v", err, context.Canceled)
+	}
+	// Sync again without interruption and ensure the target is reached
+	tester.downloader.syncInitHook = nil
+	if err := tester.downloader.SyncTo(context.Background(), target, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+}
+
+// Tests that a node restarted midway through a snap sync resumes from the data
+// already persisted to its database instead of starting over from genesis.
+func TestSnapSyncRestart68(t *testing.T) { testSnapSyncRestart(t, eth.ETH68) }
+
+func testSnapSyncRestart(t *testing.T, protocol uint) {
+	db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	half := chain.shorten(len(chain.blocks) / 2)
+
+	// Snap sync the first half of the chain, then shut the node down
+	tester := newTesterWithDB(t, db, nil)
+	tester.newPeer("peer", protocol, half.blocks[1:])
+	if err := tester.sync("peer", nil, SnapSync); err != nil {
+		t.Fatalf("failed to synchronise first half: %v", err)
+	}
+	assertOwnChain(t, tester, len(half.blocks))
+	tester.terminate()
+
+	// Restart the node on top of the same database and sync the remainder
+	tester = newTesterWithDB(t, db, nil)
+	defer tester.terminate()
+
+	var lowest atomic.Uint64
+	lowest.Store(math.MaxUint64)
+	track := func(headers []*types.Header) {
+		for _, header := range headers {
+			for {
+				have := lowest.Load()
+				if header.Number.Uint64() >= have || lowest.CompareAndSwap(have, header.Number.Uint64()) {
+					break
+				}
+			}
+		}
+	}
+	tester.downloader.bodyFetchHook = track
+	tester.downloader.receiptFetchHook = track
+
+	tester.newPeer("peer", protocol, chain.blocks[1:])
+	if err := tester.sync("peer", nil, SnapSync); err != nil {
+		t.Fatalf("failed to synchronise second half: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	if have, want := tester.chain.CurrentBlock().Hash(), chain.blocks[len(chain.blocks)-1].Hash(); have != want {
+		t.Fatalf("head block mismatch: have %x, want %x", have, want)
+	}
+	if root := tester.chain.CurrentBlock().Root; !tester.chain.HasState(root) {
+		t.Fatalf("head state %x missing after restart", root)
+	}
+	if have, limit := lowest.Load(), uint64(len(half.blocks)-1); have <= limit {
+		t.Fatalf("restarted sync refetched persisted block #%d, local head #%d", have, limit)
+	}
+}
+
+// Tests that the downloader config defaults unset timeouts and rejects any out
+// of the allowed range.
+func TestDownloaderConfig(t *testing.T) {
+	tests := []struct {
+		config DownloaderConfig
+		want   DownloaderConfig
+		fail   bool
+	}{
+		{config: DownloaderConfig{}, want: DefaultConfig},
+		{
+			config: DownloaderConfig{HeaderFetchTimeout: 30 * time.Second},
+			want:   DownloaderConfig{HeaderFetchTimeout: 30 * time.Second, BodyFetchTimeout: DefaultConfig.BodyFetchTimeout, ReceiptFetchTimeout: DefaultConfig.ReceiptFetchTimeout},
+		},
+		{
+			config: DownloaderConfig{HeaderFetchTimeout: time.Second, BodyFetchTimeout: time.Minute, ReceiptFetchTimeout: time.Minute},
+			want:   DownloaderConfig{HeaderFetchTimeout: time.Second, BodyFetchTimeout: time.Minute, ReceiptFetchTimeout: time.Minute},
+		},
+		{config: DownloaderConfig{HeaderFetchTimeout: 500 * time.Millisecond}, fail: true},
+		{config: DownloaderConfig{BodyFetchTimeout: time.Minute + 1}, fail: true},
+		{config: DownloaderConfig{ReceiptFetchTimeout: -time.Second}, fail: true},
+	}
+	for i, tt := range tests {
+		have, err := tt.config.sanitize()
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure for %+v", i, tt.config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected failure: %v", i, err)
+			continue
+		}
+		if have != tt.want {
+			t.Errorf("test %d: config mismatch: have %+v, want %+v", i, have, tt.want)
+		}
+	}
+	// Ensure an invalid config is also rejected by the constructor
+	tester := newTester(t)
+	defer tester.terminate()
+
+	if _, err := New(rawdb.NewMemoryDatabase(), new(event.TypeMux), tester.chain, tester.dropPeer, nil, &DownloaderConfig{HeaderFetchTimeout: time.Hour}); err == nil {
+		t.Fatalf("downloader created with invalid config")
+	}
+}
+
+// Tests that the sync source preferences redirect synchronisations to preferred
+// peers and away from avoided ones, unless there's no other option.
+func TestSyncSource(t *testing.T) {
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	short := testChainBase.shorten(800)
+
+	tests := []struct {
+		name    string
+		prefer  []string
+		avoid   []string
+		suggest string
+		want    string
+	}{
+		{name: "no preferences", suggest: "a", want: "a"},
+		{name: "preferred", prefer: []string{"b"}, suggest: "a", want: "b"},
+		{name: "preferred behind", prefer: []string{"short"}, suggest: "a", want: "a"},
+		{name: "avoided", avoid: []string{"a"}, suggest: "a", want: "b"},
+		{name: "avoided preferred", prefer: []string{"b"}, avoid: []string{"b"}, suggest: "a", want: "a"},
+		{name: "all avoided", avoid: []string{"a", "b", "short"}, suggest: "a", want: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := newTester(t)
+			defer tester.terminate()
+
+			ext := new(testExtension)
+			tester.downloader.RegisterExtension(ext)
+
+			tester.newPeer("a", eth.ETH68, chain.blocks[1:])
+			tester.newPeer("b", eth.ETH68, chain.blocks[1:])
+			tester.newPeer("short", eth.ETH68, short.blocks[1:])
+			tester.downloader.SetSyncSource(tt.prefer, tt.avoid)
+
+			head := tester.peers[tt.suggest].chain.CurrentBlock()
+			td := tester.peers[tt.suggest].chain.GetTd(head.Hash(), head.Number.Uint64())
+			if err := tester.downloader.LegacySync(tt.suggest, head.Hash(), tt.suggest, td, nil, FullSync); err != nil {
+				t.Fatalf("failed to synchronise blocks: %v", err)
+			}
+			if want := []string{tt.want}; !slices.Equal(ext.starts, want) {
+				t.Fatalf("sync peer mismatch: have %v, want %v", ext.starts, want)
+			}
+			assertOwnChain(t, tester, len(chain.blocks))
+		})
+	}
+}
+
+// Tests that blocks can be imported directly into the ancient store in batches,
+// and that non-contiguous chains or mismatching receipts are rejected without
+// committing the batch.
+func TestFreezeAncient(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	db := tester.downloader.stateDB
+	chain := testChainForkLightA
+	count := 2*freezeBatchSize + 100
+
+	source := newTestBlockchain(chain.blocks[1:])
+	receipts := make([]types.Receipts, len(chain.blocks))
+	for i, block := range chain.blocks {
+		receipts[i] = source.GetReceiptsByHash(block.Hash())
+	}
+	// Ensure blocks with transactions are rejected without their receipts
+	if len(chain.blocks[1].Transactions()) == 0 {
+		t.Fatalf("test block #1 has no transactions")
+	}
+	if err := tester.downloader.FreezeAncient(chain.blocks[:2], []types.Receipts{receipts[0], nil}); !errors.Is(err, errInvalidReceipt) {
+		t.Fatalf("empty receipts error mismatch: have %v, want %v", err, errInvalidReceipt)
+	}
+	if frozen, _ := db.Ancients(); frozen != 0 {
+		t.Fatalf("frozen block count mismatch after failure: have %d, want 0", frozen)
+	}
+	if err := tester.downloader.FreezeAncient(chain.blocks[:count], receipts[:count]); err != nil {
+		t.Fatalf("failed to freeze blocks: %v", err)
+	}
+	if frozen, _ := db.Ancients(); frozen != uint64(count) {
+		t.Fatalf("frozen block count mismatch: have %d, want %d", frozen, count)
+	}
+	for _, number := range []int{0, freezeBatchSize, count - 1} {
+		if hash := rawdb.ReadCanonicalHash(db, uint64(number)); hash != chain.blocks[number].Hash() {
+			t.Errorf("frozen block #%d hash mismatch: have %x, want %x", number, hash, chain.blocks[number].Hash())
+		}
+	}
+	for number := 0; number < count; number++ {
+		if len(chain.blocks[number].Transactions()) == 0 {
+			continue
+		}
+		stored := rawdb.ReadRawReceipts(db, chain.blocks[number].Hash(), uint64(number))
+		if len(stored) != len(chain.blocks[number].Transactions()) {
+			t.Errorf("frozen block #%d receipt count mismatch: have %d, want %d", number, len(stored), len(chain.blocks[number].Transactions()))
+		}
+	}
+	if height := tester.downloader.Progress().HighestBlock; height != uint64(count-1) {
+		t.Errorf("highest block mismatch: have %d, want %d", height, count-1)
+	}
+	// Ensure gapped batches and missing receipts are rejected in their entirety
+	gapped := append(append([]*types.Block{}, chain.blocks[count:count+10]...), chain.blocks[count+11:count+20]...)
+	gappedReceipts := append(append([]types.Receipts{}, receipts[count:count+10]...), receipts[count+11:count+20]...)
+	if err := tester.downloader.FreezeAncient(gapped, gappedReceipts); !errors.Is(err, errAncientGap) {
+		t.Fatalf("gapped chain error mismatch: have %v, want %v", err, errAncientGap)
+	}
+	if err := tester.downloader.FreezeAncient(chain.blocks[count+1:count+10], receipts[count+1:count+10]); !errors.Is(err, errAncientGap) {
+		t.Fatalf("detached chain error mismatch: have %v, want %v", err, errAncientGap)
+	}
+	if err := tester.downloader.FreezeAncient(chain.blocks[count:count+10], receipts[count:count+9]); !errors.Is(err, errInvalidReceipt) {
+		t.Fatalf("missing receipt sets error mismatch: have %v, want %v", err, errInvalidReceipt)
+	}
+	if frozen, _ := db.Ancients(); frozen != uint64(count) {
+		t.Fatalf("frozen block count mismatch after failures: have %d, want %d", frozen, count)
+	}
+	// Ensure the ancient chain can be continued
+	if err := tester.downloader.FreezeAncient(chain.blocks[count:count+10], receipts[count:count+10]); err != nil {
+		t.Fatalf("failed to continue frozen chain: %v", err)
+	}
+	if frozen, _ := db.Ancients(); frozen != uint64(count+10) {
+		t.Fatalf("frozen block count mismatch: have %d, want %d", frozen, count+10)
+	}
+}
+
+// Tests that headers can be injected directly into the local chain, as long as
+// they are continuous, attach to a known header and don't reorg too deep.
+func TestInjectHeaders(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	headers := func(blocks []*types.Block) []*types.Header {
+		headers := make([]*types.Header, len(blocks))
+		for i, block := range blocks {
+			headers[i] = block.Header()
+		}
+		return headers
+	}
+	base := len(testChainBase.blocks)
+	chainA := testChainForkLightA.shorten(base + 80)
+	chainB := testChainForkLightB.shorten(base + 81)
+
+	// Gapped and detached header chains must be rejected
+	gapped := append(headers(chainA.blocks[1:10]), headers(chainA.blocks[11:20])...)
+	if err := tester.downloader.InjectHeaders(gapped); !errors.Is(err, errInvalidChain) {
+		t.Fatalf("gapped chain error mismatch: have %v, want %v", err, errInvalidChain)
+	}
+	if err := tester.downloader.InjectHeaders(headers(chainA.blocks[2:10])); !errors.Is(err, errUnknownParent) {
+		t.Fatalf("detached chain error mismatch: have %v, want %v", err, errUnknownParent)
+	}
+	// Injection must be refused while synchronising
+	tester.downloader.synchronising.Store(true)
+	if err := tester.downloader.InjectHeaders(headers(chainA.blocks[1:])); err != errBusy {
+		t.Fatalf("concurrent injection error mismatch: have %v, want %v", err, errBusy)
+	}
+	tester.downloader.synchronising.Store(false)
+
+	// Inject a chain, and reorg it to a slightly heavier one
+	if err := tester.downloader.InjectHeaders(headers(chainA.blocks[1:])); err != nil {
+		t.Fatalf("failed to inject headers: %v", err)
+	}
+	if head := tester.chain.CurrentHeader(); head.Hash() != chainA.blocks[len(chainA.blocks)-1].Hash() {
+		t.Fatalf("head header mismatch: have #%d [%x], want #%d", head.Number, head.Hash().Bytes()[:4], len(chainA.blocks)-1)
+	}
+	if err := tester.downloader.InjectHeaders(headers(chainB.blocks[1:])); err != nil {
+		t.Fatalf("failed to inject reorging headers: %v", err)
+	}
+	if head := tester.chain.CurrentHeader(); head.Hash() != chainB.blocks[len(chainB.blocks)-1].Hash() {
+		t.Fatalf("head header mismatch: have #%d [%x], want #%d", head.Number, head.Hash().Bytes()[:4], len(chainB.blocks)-1)
+	}
+	// Extend the chain beyond the maximum reorg depth and ensure forks from
+	// the common ancestor are rejected
+	if err := tester.downloader.InjectHeaders(headers(testChainForkLightB.blocks[len(chainB.blocks):])); err != nil {
+		t.Fatalf("failed to extend headers: %v", err)
+	}
+	if err := tester.downloader.InjectHeaders(headers(testChainForkLightA.blocks[base:])); !errors.Is(err, errReorgTooDeep) {
+		t.Fatalf("deep reorg error mismatch: have %v, want %v", err, errReorgTooDeep)
+	}
+}
+
+func TestRemoteHeaderRequestSpan(t *testing.T) {
+	testCases := []struct {
+		remoteHeight uint64
+		localHeight  uint64
+		expected     []int
+	}{
+		// Remote is way higher. We should ask for the remote head and go backwards
+		{1500, 1000,
+			[]int{1323, 1339, 1355, 1371, 1387, 1403, 1419, 1435, 1451, 1467, 1483, 1499},
+		},
+		{15000, 13006,
+			[]int{14823, 14839, 14855, 14871, 14887, 14903, 14919, 14935, 14951, 14967, 14983, 14999},
+		},
+		// Remote is pretty close to us. We don't have to fetch as many
+		{1200, 1150,
+			[]int{1149, 1154, 1159, 1164, 1169, 1174, 1179, 1184, 1189, 1194, 1199},
+		},
+		// Remote is equal to us (so on a fork with higher td)
+		// We should get the closest couple of ancestors
+		{1500, 1500,
+			[]int{1497, 1499},
+		},
+		// We're higher than the remote! Odd
+		{1000, 1500,
+			[]int{997, 999},
+		},
+		// Check some weird edgecases that it behaves somewhat rationally
+		{0, 1500,
+			[]int{0, 2},
+		},
+		{6000000, 0,
+			[]int{5999823, 5999839, 5999855, 5999871, 5999887, 5999903, 5999919, 5999935, 5999951, 5999967, 5999983, 5999999},
+		},
 		{0, 0,
 			[]int{0, 2},
 		},
@@ -1260,3 +2121,921 @@ func TestRemoteHeaderRequestSpan(t *testing.T) {
 		}
 	}
 }
+
+// FuzzCalculateRequestSpan checks that the ancestor lookup request spans stay
+// internally consistent for arbitrary local and remote chain heights.
+func FuzzCalculateRequestSpan(f *testing.F) {
+	// Seed the corpus with the cases from TestRemoteHeaderRequestSpan
+	for _, seed := range [][2]uint64{
+		{1500, 1000}, {15000, 13006}, {1200, 1150}, {1500, 1500},
+		{1000, 1500}, {0, 1500}, {6000000, 0}, {0, 0},
+		{math.MaxInt64 + 1, 0}, {0, math.MaxInt64 + 1}, {math.MaxUint64, math.MaxUint64},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, remoteHeight, localHeight uint64) {
+		from, count, span, max := calculateRequestSpan(remoteHeight, localHeight)
+		if from < 0 {
+			t.Fatalf("negative origin: remote %d, local %d, from %d", remoteHeight, localHeight, from)
+		}
+		if count < 1 {
+			t.Fatalf("empty request: remote %d, local %d, count %d", remoteHeight, localHeight, count)
+		}
+		if span < 0 {
+			t.Fatalf("negative skip: remote %d, local %d, span %d", remoteHeight, localHeight, span)
+		}
+		if want := uint64(from) + uint64(count-1)*(uint64(span)+1); max != want {
+			t.Fatalf("last header mismatch: remote %d, local %d, have %d, want %d", remoteHeight, localHeight, max, want)
+		}
+		// The requested headers should never exceed the remote head, unless the
+		// 2-header minimum forces the request past a nearly empty remote chain
+		if remoteHeight > 2 && max >= remoteHeight {
+			t.Fatalf("request past remote head: remote %d, local %d, max %d", remoteHeight, localHeight, max)
+		}
+	})
+}
+
+// Tests that CancelAndWait reports download goroutines outliving the timeout.
+func TestCancelAndWait(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	// Simulate a fetcher goroutine that is slow to react to the cancellation
+	tester.downloader.cancelWg.Add(1)
+	if tester.downloader.CancelAndWait(10 * time.Millisecond) {
+		t.Fatalf("cancellation reported complete with a goroutine still running")
+	}
+	tester.downloader.cancelWg.Done()
+	if !tester.downloader.CancelAndWait(time.Second) {
+		t.Fatalf("cancellation reported incomplete with no goroutines running")
+	}
+}
+
+// Tests that the chain can be rolled back to an earlier block, both idle and
+// in the middle of a sync, and that syncing works again afterwards.
+func TestRollback(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800 / 4)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	if err := tester.downloader.Rollback(100); err != nil {
+		t.Fatalf("failed to roll back: %v", err)
+	}
+	if head := tester.chain.CurrentBlock().Number.Uint64(); head != 100 {
+		t.Fatalf("head block mismatch after rollback: have %d, want %d", head, 100)
+	}
+	if highest := tester.downloader.Progress().HighestBlock; highest != 100 {
+		t.Fatalf("highest block mismatch after rollback: have %d, want %d", highest, 100)
+	}
+	// Concurrent rollbacks should be rejected
+	tester.downloader.rollingBack.Store(true)
+	if err := tester.downloader.Rollback(50); !errors.Is(err, errBusy) {
+		t.Fatalf("concurrent rollback error mismatch: have %v, want %v", err, errBusy)
+	}
+	tester.downloader.rollingBack.Store(false)
+
+	// Roll back in the middle of a slowed down sync
+	tester.peers["peer"].SimulateLatency(50 * time.Millisecond)
+	errc := make(chan error, 1)
+	go func() { errc <- tester.sync("peer", nil, FullSync) }()
+
+	for tester.downloader.SyncPeer() == "" {
+		time.Sleep(time.Millisecond)
+	}
+	if err := tester.downloader.Rollback(100); err != nil {
+		t.Fatalf("failed to roll back mid-sync: %v", err)
+	}
+	if err := <-errc; err == nil {
+		t.Fatalf("sync succeeded despite the rollback")
+	}
+	if head := tester.chain.CurrentBlock().Number.Uint64(); head > 100 {
+		t.Fatalf("head block mismatch after mid-sync rollback: have %d, want <= %d", head, 100)
+	}
+	// Syncing should work again after the rollback
+	tester.peers["peer"].SimulateLatency(0)
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to resynchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+}
+
+// Tests that the header fetch rates of the peers are tracked and that the one
+// delivering the fastest is reported as the best header peer.
+func TestPeerHeaderFetchRate(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800 / 4)
+	tester.newPeer("fast", eth.ETH68, chain.blocks[1:])
+	tester.newPeer("slow", eth.ETH68, chain.blocks[1:])
+	tester.peers["slow"].SimulateLatency(20 * time.Millisecond)
+
+	if best := tester.downloader.BestHeaderPeer(); best != "" {
+		t.Fatalf("best header peer mismatch before fetching: have %q, want none", best)
+	}
+	// Fetch the same headers from both peers and compare their rates
+	for _, id := range []string{"fast", "slow"} {
+		for i := 0; i < 3; i++ {
+			if _, _, err := tester.downloader.fetchHeadersByNumber(tester.downloader.peers.Peer(id), 1, 64, 0, false); err != nil {
+				t.Fatalf("failed to fetch headers from %s: %v", id, err)
+			}
+		}
+	}
+	fast, slow := tester.downloader.PeerHeaderFetchRate("fast"), tester.downloader.PeerHeaderFetchRate("slow")
+	if slow <= 0 {
+		t.Fatalf("slow peer rate mismatch: have %v, want > 0", slow)
+	}
+	if fast <= slow {
+		t.Fatalf("peer rate mismatch: fast %v, slow %v", fast, slow)
+	}
+	if best := tester.downloader.BestHeaderPeer(); best != "fast" {
+		t.Fatalf("best header peer mismatch: have %q, want %q", best, "fast")
+	}
+	if rate := tester.downloader.PeerHeaderFetchRate("unknown"); rate != 0 {
+		t.Fatalf("unknown peer rate mismatch: have %v, want 0", rate)
+	}
+	// A failed first request must seed the average, not be overwritten by the
+	// next delivery
+	peer := newPeerConnection("failing", eth.ETH68, tester.peers["fast"], log.New())
+	peer.updateHeaderFetchRate(0, time.Second)
+	peer.updateHeaderFetchRate(100, time.Second)
+	if have, want := peer.HeaderFetchRate(), headerRateWeight*100; math.Abs(have-want) > 1e-9 {
+		t.Fatalf("rate mismatch after failed first request: have %v, want %v", have, want)
+	}
+}
+
+// Tests that the worst peer is the one with the most timeouts and stalls, and
+// that it is only blamed for stale headers if it delivered them and the master
+// peer has a clean record.
+func TestWorstPeer(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800 / 4)
+	for _, id := range []string{"good", "flaky", "bad"} {
+		tester.newPeer(id, eth.ETH68, chain.blocks[1:])
+	}
+	peers := tester.downloader.peers
+	if id, _ := peers.worstPeer(); id != "bad" {
+		t.Fatalf("worst peer mismatch with clean records: have %q, want %q", id, "bad")
+	}
+	// Timeouts and stalls both count as failures: the bad peer only outranks
+	// the flaky one on their sum
+	peers.Peer("flaky").markTimeout()
+	peers.Peer("flaky").markTimeout()
+	peers.Peer("bad").markTimeout()
+	if id, _ := peers.worstPeer(); id != "flaky" {
+		t.Fatalf("worst peer mismatch on timeouts: have %q, want %q", id, "flaky")
+	}
+	peers.Peer("bad").markStall()
+	peers.Peer("bad").markStall()
+
+	id, worst := peers.worstPeer()
+	if id != "bad" || worst != peers.Peer("bad") {
+		t.Fatalf("worst peer mismatch: have %q, want %q", id, "bad")
+	}
+	if fails := worst.failures(); fails != 3 {
+		t.Fatalf("worst peer failures mismatch: have %d, want 3", fails)
+	}
+	if id, _ := peers.worstPeer("good", "flaky", "unknown"); id != "flaky" {
+		t.Fatalf("worst peer mismatch among candidates: have %q, want %q", id, "flaky")
+	}
+	if id, _ := newPeerSet().worstPeer(); id != "" {
+		t.Fatalf("worst peer mismatch in empty set: have %q, want none", id)
+	}
+	// Stale headers are blamed on the worst peer that delivered them, unless the
+	// master peer is a bad one itself
+	tester.downloader.cancelPeer = "good"
+	for _, tt := range []struct {
+		delivered []string
+		want      string
+	}{
+		{[]string{"good", "bad"}, "bad"},
+		{[]string{"good", "flaky"}, "flaky"},
+		{[]string{"good"}, ""},
+		{nil, ""},
+	} {
+		if id, _ := tester.downloader.staleHeadersCulprit(tt.delivered); id != tt.want {
+			t.Fatalf("stale headers culprit mismatch for %v: have %q, want %q", tt.delivered, id, tt.want)
+		}
+	}
+	tester.downloader.cancelPeer = "flaky"
+	if id, culprit := tester.downloader.staleHeadersCulprit([]string{"bad"}); culprit != nil {
+		t.Fatalf("stale headers culprit mismatch with flaky master: have %q, want none", id)
+	}
+}
+
+// Tests that externally reported peer speeds decay over time and take precedence
+// over the measured throughput when sizing requests.
+func TestReportPeerSpeed(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800 / 4)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+
+	peer := tester.downloader.peers.Peer("peer")
+	headers := (*headerQueue)(tester.downloader)
+	if have, want := headers.capacity(peer, time.Second), peer.HeaderCapacity(time.Second); have != want {
+		t.Fatalf("capacity mismatch without report: have %d, want %d", have, want)
+	}
+	now := time.Now()
+	peer.ReportSpeed(1000, now)
+	for i, want := range []float64{1000, 900, 810} {
+		if have := peer.ReportedSpeed(now.Add(time.Duration(i) * speedReportDecayPeriod)); math.Abs(have-want) > 1e-9 {
+			t.Fatalf("speed mismatch after %d periods: have %v, want %v", i, have, want)
+		}
+	}
+	if have := peer.ReportedSpeed(now.Add(speedReportMaxAge + time.Second)); have != 0 {
+		t.Fatalf("speed mismatch after expiry: have %v, want 0", have)
+	}
+	// Reports too slow for a single header must not pin the capacity
+	tester.downloader.ReportPeerSpeed("peer", uint64(estHeaderSize)/2)
+	if have, want := headers.capacity(peer, time.Second), peer.HeaderCapacity(time.Second); have != want {
+		t.Fatalf("capacity mismatch with slow report: have %d, want %d", have, want)
+	}
+	// 100 headers per second are reported, a second's worth must be requested
+	// (minus the decay since the report)
+	tester.downloader.ReportPeerSpeed("peer", uint64(100*estHeaderSize))
+	if have := headers.capacity(peer, time.Second); have < 99 || have > 100 {
+		t.Fatalf("capacity mismatch with report: have %d, want 100", have)
+	}
+	tester.downloader.ReportPeerSpeed("peer", uint64(100*MaxHeaderFetch)*uint64(estHeaderSize))
+	if have := headers.capacity(peer, time.Second); have != MaxHeaderFetch {
+		t.Fatalf("capacity mismatch with fast report: have %d, want %d", have, MaxHeaderFetch)
+	}
+	// Bodies can't be sized until the block sizes are known
+	bodies := (*bodyQueue)(tester.downloader)
+	if have, want := bodies.capacity(peer, time.Second), peer.BodyCapacity(time.Second); have != want {
+		t.Fatalf("body capacity mismatch with unknown block size: have %d, want %d", have, want)
+	}
+	tester.downloader.ReportPeerSpeed("unknown", 1000) // must not panic
+}
+
+// Tests that the queue capacities are validated, cannot be changed mid-sync,
+// and that syncing works with capacities much smaller than the defaults.
+func TestSetQueueCapacity(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	for _, caps := range [][3]int{{0, 0, 0}, {128, 128, 0}, {128, 256, 64}, {256, 64, 128}} {
+		if err := tester.downloader.SetQueueCapacity(caps[0], caps[1], caps[2]); !errors.Is(err, errInvalidQueueCapacity) {
+			t.Errorf("capacities %v: error mismatch: have %v, want %v", caps, err, errInvalidQueueCapacity)
+		}
+	}
+	tester.downloader.synchronising.Store(true)
+	if err := tester.downloader.SetQueueCapacity(256, 128, 64); !errors.Is(err, errBusy) {
+		t.Errorf("mid-sync error mismatch: have %v, want %v", err, errBusy)
+	}
+	tester.downloader.synchronising.Store(false)
+
+	if err := tester.downloader.SetQueueCapacity(256, 128, 64); err != nil {
+		t.Fatalf("failed to set queue capacities: %v", err)
+	}
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+	if err := tester.sync("peer", nil, SnapSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	if have := len(tester.downloader.queue.resultCache.items); have != 128 {
+		t.Errorf("result cache size mismatch: have %d, want %d", have, 128)
+	}
+}
+
+// Tests that the body delivery rate is measured over a sliding window, in
+// bodies of average size, and that it is reported by a sync.
+// Tests that the downloaded bytes are accumulated, and that the bytes downloaded
+// since a given time are measured from the per second samples.
+func TestTotalBytesDownloaded(t *testing.T) {
+	var (
+		counter byteCounter
+		start   = time.Unix(1000, 0)
+	)
+	if have := counter.since(start); have != 0 {
+		t.Fatalf("bytes mismatch with no deliveries: have %d, want 0", have)
+	}
+	// Deliver 100 bytes twice a second for 10 seconds
+	for i := 0; i < 10; i++ {
+		counter.add(start.Add(time.Duration(i)*time.Second), 100)
+		counter.add(start.Add(time.Duration(i)*time.Second+time.Second/2), 100)
+	}
+	if have := counter.total.Load(); have != 2000 {
+		t.Fatalf("total bytes mismatch: have %d, want 2000", have)
+	}
+	for _, tt := range []struct {
+		since time.Time
+		want  uint64
+	}{
+		{start.Add(-time.Hour), 2000},
+		{start, 2000},
+		{start.Add(5 * time.Second), 1000},
+		{start.Add(9*time.Second + time.Second/2), 200}, // second resolution
+		{start.Add(10 * time.Second), 0},
+	} {
+		if have := counter.since(tt.since); have != tt.want {
+			t.Errorf("bytes since %v mismatch: have %d, want %d", tt.since.Sub(start), have, tt.want)
+		}
+	}
+	// Overflow the sample window and make sure old times are clamped to it
+	for i := 0; i < byteSampleCount; i++ {
+		counter.add(start.Add(time.Duration(10+i)*time.Second), 1)
+	}
+	if have := counter.since(start); have != byteSampleCount {
+		t.Fatalf("bytes mismatch beyond the window: have %d, want %d", have, byteSampleCount)
+	}
+	// Sync a chain and make sure all deliveries are accounted for
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+
+	begin := time.Now()
+	if err := tester.sync("peer", nil, SnapSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	total := tester.downloader.TotalBytesDownloaded()
+	if total == 0 {
+		t.Fatalf("no bytes downloaded")
+	}
+	if have := tester.downloader.TotalBytesDownloadedSince(begin); have != total {
+		t.Fatalf("bytes since sync start mismatch: have %d, want %d", have, total)
+	}
+	if have := tester.downloader.TotalBytesDownloadedSince(time.Now().Add(time.Second)); have != 0 {
+		t.Fatalf("bytes since the future mismatch: have %d, want 0", have)
+	}
+}
+
+// Benchmarks reading the total bytes downloaded, which should be a plain atomic
+// load.
+func BenchmarkTotalBytesDownloaded(b *testing.B) {
+	d := new(Downloader)
+	d.bytesDownloaded.add(time.Now(), 1)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d.TotalBytesDownloaded()
+	}
+}
+
+func TestExpectedBodiesPerSecond(t *testing.T) {
+	var (
+		rate  bodyRate
+		start = time.Unix(1000, 0)
+	)
+	if have := rate.rate(start); have != 0 {
+		t.Fatalf("rate mismatch with no deliveries: have %v, want 0", have)
+	}
+	// Deliver 10 bodies of 1000 bytes every second over the full window
+	for i := 0; i < bodyRateWindow; i++ {
+		rate.add(start.Add(time.Duration(i)*time.Second), 10, 10*1000)
+	}
+	now := start.Add((bodyRateWindow - 1) * time.Second)
+	if have := rate.rate(now); have != 10 {
+		t.Fatalf("rate mismatch: have %v, want 10", have)
+	}
+	// Deliveries older than the window must not be accounted for
+	now = now.Add(bodyRateWindow / 2 * time.Second)
+	if have := rate.rate(now); have != 5 {
+		t.Fatalf("rate mismatch after window slide: have %v, want 5", have)
+	}
+	if have := rate.rate(now.Add(bodyRateWindow * time.Second)); have != 0 {
+		t.Fatalf("rate mismatch after window passed: have %v, want 0", have)
+	}
+	// Sync a chain and make sure the rate is reported
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+	if have := tester.downloader.ExpectedBodiesPerSecond(); have != 0 {
+		t.Fatalf("rate mismatch before sync: have %v, want 0", have)
+	}
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if have := tester.downloader.ExpectedBodiesPerSecond(); have <= 0 {
+		t.Fatalf("rate mismatch after sync: have %v, want > 0", have)
+	}
+}
+
+// Tests that the pipeline latencies track the time blocks spend between the
+// stages of the download pipeline.
+func TestPipelineLatency(t *testing.T) {
+	var (
+		latency = newPipelineLatency()
+		start   = time.Unix(1000, 0)
+	)
+	// Advance 100 blocks through the pipeline, jittering the stage delays by 5%
+	// around 200ms to the body, 50ms to the insertion and 20ms to the commit
+	for i := 0; i < 100; i++ {
+		var (
+			result = &fetchResult{Header: &types.Header{Number: big.NewInt(int64(i))}}
+			hash   = result.Header.Hash()
+			jitter = 1.0 + 0.05*float64(i%3-1)
+			now    = start.Add(time.Duration(i) * time.Millisecond)
+		)
+		latency.schedule(now, hash)
+		now = now.Add(time.Duration(jitter * float64(200*time.Millisecond)))
+		latency.deliver(now, hash)
+		now = now.Add(time.Duration(jitter * float64(50*time.Millisecond)))
+		latency.insert(now, hash)
+		now = now.Add(time.Duration(jitter * float64(20*time.Millisecond)))
+		latency.commit(now, []*fetchResult{result})
+	}
+	have := latency.latencies()
+	for stage, want := range map[string]time.Duration{
+		"header_to_body":   200 * time.Millisecond,
+		"body_to_insert":   50 * time.Millisecond,
+		"insert_to_commit": 20 * time.Millisecond,
+	} {
+		if diff := have[stage] - want; diff < -want/10 || diff > want/10 {
+			t.Errorf("stage %s latency mismatch: have %v, want %v±10%%", stage, have[stage], want)
+		}
+	}
+	if len(latency.scheduled) != 0 || len(latency.delivered) != 0 || len(latency.inserting) != 0 {
+		t.Errorf("blocks left in flight: scheduled %d, delivered %d, inserting %d", len(latency.scheduled), len(latency.delivered), len(latency.inserting))
+	}
+	// Sync a chain and make sure the latencies are measured
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	for stage, have := range tester.downloader.PipelineLatency() {
+		if have <= 0 {
+			t.Errorf("stage %s latency mismatch after sync: have %v, want > 0", stage, have)
+		}
+	}
+}
+
+// Tests that the sync report gathers the status of a running sync, and that it
+// is reset once the sync is done.
+func TestSyncReport(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+	tester.peers["peer"].SimulateLatency(5 * time.Millisecond)
+
+	if report := tester.downloader.SyncReport(); report.Active || report.Mode != NoSync || !report.StartTime.IsZero() {
+		t.Fatalf("pristine report mismatch: %+v", report)
+	}
+	// Slow down the imports so downloaded blocks pile up in the queue
+	tester.downloader.chainInsertHook = func([]*fetchResult, chan struct{}) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tester.sync("peer", nil, SnapSync)
+	}()
+	var complete bool
+	for !complete {
+		select {
+		case err := <-errc:
+			t.Fatalf("sync finished without a complete report: %v", err)
+		case <-time.After(time.Millisecond):
+		}
+		report := tester.downloader.SyncReport()
+		complete = report.Mode == SnapSync && report.Active && report.Peer == "peer" &&
+			!report.StartTime.IsZero() && report.Duration > 0 &&
+			report.HeadersPerSec > 0 && report.BodiesPerSec > 0 && report.QueueDepth > 0 &&
+			report.Progress.CurrentBlock > 0 && report.Progress.HighestBlock > 0 &&
+			report.EstimatedCompletion > 0
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if report := tester.downloader.SyncReport(); report.Active || report.Mode != NoSync || !report.StartTime.IsZero() || report.Duration != 0 {
+		t.Fatalf("final report mismatch: %+v", report)
+	}
+}
+
+// Tests that a sync failing during a network partition resumes from where the
+// previous one left off and completes once the partition is over.
+func TestNetworkPartitionRecovery68Full(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(800 / 4)
+	half := chain.shorten(len(chain.blocks) / 2)
+
+	// Sync the first half of the chain
+	tester.newPeer("half", eth.ETH68, half.blocks[1:])
+	if err := tester.sync("half", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(half.blocks))
+
+	// Partition the network off and make sure syncing fails, not dropping the peer
+	tester.newPeer("full", eth.ETH68, chain.blocks[1:])
+	start := time.Now()
+	tester.simulateNetworkPartition(3 * time.Second)
+
+	head := tester.peers["full"].chain.CurrentBlock()
+	td := tester.peers["full"].chain.GetTd(head.Hash(), head.Number.Uint64())
+
+	err := tester.downloader.LegacySync("full", head.Hash(), "full", td, nil, FullSync)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("partitioned sync error mismatch: have %v, want %v", err, context.DeadlineExceeded)
+	}
+	if tester.downloader.peers.Peer("full") == nil {
+		t.Fatalf("peer dropped during network partition")
+	}
+	assertOwnChain(t, tester, len(half.blocks))
+
+	// Keep retrying until the partition recovers and the rest of the chain syncs
+	for {
+		if err = tester.sync("full", nil, FullSync); !errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("failed to synchronise blocks after recovery: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 3*time.Second {
+		t.Fatalf("sync completed during network partition: elapsed %v", elapsed)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+}
+
+// Tests that the snapshot generation priority is validated and retained for the
+// pivot commit.
+func TestSetSnapshotGenerationPriority(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	if have := snapshot.GenerationPriority(tester.downloader.snapGenPriority.Load()); have != snapshot.GenerationNormal {
+		t.Fatalf("default priority mismatch: have %d, want %d", have, snapshot.GenerationNormal)
+	}
+	for _, priority := range []int{0, 1, 2} {
+		if err := tester.downloader.SetSnapshotGenerationPriority(priority); err != nil {
+			t.Fatalf("priority %d: failed to set: %v", priority, err)
+		}
+		if have := int(tester.downloader.snapGenPriority.Load()); have != priority {
+			t.Fatalf("priority mismatch: have %d, want %d", have, priority)
+		}
+	}
+	for _, invalid := range []int{-1, 3} {
+		if err := tester.downloader.SetSnapshotGenerationPriority(invalid); !errors.Is(err, errInvalidSnapPriority) {
+			t.Fatalf("priority %d: error mismatch: have %v, want %v", invalid, err, errInvalidSnapPriority)
+		}
+	}
+	if have := int(tester.downloader.snapGenPriority.Load()); have != 2 {
+		t.Fatalf("priority changed by invalid value: have %d, want 2", have)
+	}
+}
+
+// Tests that a range sync overwrites the local chain with the blocks of the
+// peer within the requested range, bypassing the ancestor lookup.
+func TestDebugSync68Full(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chainA := testChainForkLightA.shorten(len(testChainBase.blocks) + 80)
+	chainB := testChainForkLightB.shorten(len(testChainBase.blocks) + 81)
+	tester.newPeer("fork A", eth.ETH68, chainA.blocks[1:])
+	tester.newPeer("fork B", eth.ETH68, chainB.blocks[1:])
+
+	// Sync to fork B, then overwrite the first half of the fork with fork A
+	if err := tester.sync("fork B", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chainB.blocks))
+
+	from, to := uint64(len(testChainBase.blocks)), uint64(len(testChainBase.blocks)+40)
+	if err := tester.downloader.DebugSync("fork A", from, to, FullSync); err != nil {
+		t.Fatalf("failed to synchronise range: %v", err)
+	}
+	assertOwnChain(t, tester, int(to)+1)
+
+	for number := from - 1; number <= to; number++ {
+		if have, want := tester.chain.GetCanonicalHash(number), chainA.blocks[number].Hash(); have != want {
+			t.Fatalf("block #%d mismatch: have %x, want %x", number, have, want)
+		}
+	}
+	// Ensure invalid ranges are rejected without touching the chain
+	for _, bounds := range [][2]uint64{{to, from}, {0, to}, {from, uint64(len(chainA.blocks))}} {
+		if err := tester.downloader.DebugSync("fork A", bounds[0], bounds[1], FullSync); !errors.Is(err, errOutOfRange) {
+			t.Fatalf("range [%d, %d]: error mismatch: have %v, want %v", bounds[0], bounds[1], err, errOutOfRange)
+		}
+	}
+	assertOwnChain(t, tester, int(to)+1)
+
+	if err := tester.downloader.DebugSync("fork A", from, to, SnapSync); !errors.Is(err, errUnsupportedRangeMode) {
+		t.Fatalf("snap range sync error mismatch: have %v, want %v", err, errUnsupportedRangeMode)
+	}
+	if err := tester.downloader.DebugSync("unknown", from, to, FullSync); !errors.Is(err, errUnknownPeer) {
+		t.Fatalf("unknown peer error mismatch: have %v, want %v", err, errUnknownPeer)
+	}
+}
+
+// Tests that snap sync writes the blocks below the configured freeze threshold
+// directly into the ancient store.
+func TestFreezeThreshold(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	if have := tester.downloader.FreezeThreshold(); have != FullMaxForkAncestry {
+		t.Fatalf("default threshold mismatch: have %d, want %d", have, FullMaxForkAncestry)
+	}
+	tester.downloader.SetFreezeThreshold(1)
+	if have := tester.downloader.FreezeThreshold(); have != FullMaxForkAncestry {
+		t.Fatalf("low threshold mismatch: have %d, want %d", have, FullMaxForkAncestry)
+	}
+	tester.downloader.SetFreezeThreshold(FullMaxForkAncestry + 1)
+	if have := tester.downloader.FreezeThreshold(); have != FullMaxForkAncestry+1 {
+		t.Fatalf("high threshold mismatch: have %d, want %d", have, FullMaxForkAncestry+1)
+	}
+	// Bypass the clamp to freeze a part of the short test chain
+	tester.downloader.freezeThreshold.Store(100)
+
+	chain := testChainBase.shorten(201)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+	if err := tester.sync("peer", nil, SnapSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	if frozen, err := tester.downloader.stateDB.Ancients(); err != nil || frozen != 100 {
+		t.Fatalf("ancient item count mismatch: have %d, want %d (err %v)", frozen, 100, err)
+	}
+	for i, block := range chain.blocks[:100] {
+		if hash := rawdb.ReadCanonicalHash(tester.downloader.stateDB, uint64(i)); hash != block.Hash() {
+			t.Fatalf("ancient block #%d mismatch: have %x, want %x", i, hash, block.Hash())
+		}
+	}
+}
+
+// Tests that the snap sync completion channel fires once the pivot state has
+// been committed, and that a new session hands out a fresh channel.
+func TestSnapSyncComplete(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	done := tester.downloader.SnapSyncComplete()
+	select {
+	case <-done:
+		t.Fatalf("completion signalled before syncing")
+	default:
+	}
+	chain := testChainBase.shorten(201)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+	if err := tester.sync("peer", nil, SnapSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("completion not signalled after snap sync")
+	}
+	// Starting a new session should replace the already closed channel
+	tester.downloader.resetSnapSyncComplete()
+	select {
+	case <-tester.downloader.SnapSyncComplete():
+		t.Fatalf("completion signalled for a new session")
+	default:
+	}
+}
+
+// Tests that the peer register hook can reject peers from joining the sync pool,
+// and that clearing it restores the default behaviour.
+func TestPeerRegisterHook(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	errUnauthorized := errors.New("unauthorized")
+	tester.downloader.SetPeerRegisterHook(func(id string, version uint) error {
+		if strings.HasPrefix(id, "bad") {
+			return errUnauthorized
+		}
+		return nil
+	})
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	tester.newPeer("good", eth.ETH68, chain.blocks[1:])
+
+	for _, id := range []string{"bad", "bad-peer"} {
+		if err := tester.downloader.RegisterPeer(id, eth.ETH68, &downloadTesterPeer{dl: tester, id: id}); !errors.Is(err, errUnauthorized) {
+			t.Errorf("peer %s: registration error mismatch: have %v, want %v", id, err, errUnauthorized)
+		}
+	}
+	if peers := tester.downloader.Peers(); len(peers) != 1 || peers[0] != "good" {
+		t.Fatalf("registered peers mismatch: have %v, want [good]", peers)
+	}
+	if err := tester.sync("good", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+
+	// Clearing the hook should let any peer in again
+	tester.downloader.SetPeerRegisterHook(nil)
+	if err := tester.downloader.RegisterPeer("bad", eth.ETH68, &downloadTesterPeer{dl: tester, id: "bad"}); err != nil {
+		t.Fatalf("failed to register peer without hook: %v", err)
+	}
+	if have := tester.downloader.PeerCount(); have != 2 {
+		t.Fatalf("peer count mismatch: have %d, want 2", have)
+	}
+}
+
+// testSidecarStore is a BlobSidecarStore keeping the sidecars in memory.
+type testSidecarStore struct {
+	sidecars map[common.Hash][]*types.BlobSidecar
+	fail     error // Error to fail the writes with, if set
+}
+
+func (s *testSidecarStore) Store(blockHash common.Hash, sidecars []*types.BlobSidecar) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.sidecars[blockHash] = sidecars
+	return nil
+}
+
+func (s *testSidecarStore) Get(blockHash common.Hash) ([]*types.BlobSidecar, error) {
+	return s.sidecars[blockHash], nil
+}
+
+// Tests that the blob sidecars of the downloaded blocks are written into the
+// registered sidecar store, skipping blocks without any.
+func TestBlobSidecarStore(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	store := &testSidecarStore{sidecars: make(map[common.Hash][]*types.BlobSidecar)}
+	tester.downloader.WithBlobSidecarStore(store)
+
+	// Sync a chain without blob transactions, nothing should be stored
+	chain := testChainBase.shorten(800 / 4)
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+	if len(store.sidecars) != 0 {
+		t.Fatalf("stored sidecar count mismatch: have %d, want 0", len(store.sidecars))
+	}
+	// Feed results with sidecars directly and ensure they're stored by block hash
+	var (
+		sidecar = &types.BlobSidecar{TxHash: common.Hash{0x01}}
+		blob    = &fetchResult{Header: chain.blocks[1].Header(), Sidecars: types.BlobSidecars{sidecar}}
+		plain   = &fetchResult{Header: chain.blocks[2].Header()}
+	)
+	if err := tester.downloader.storeSidecars([]*fetchResult{blob, plain}); err != nil {
+		t.Fatalf("failed to store sidecars: %v", err)
+	}
+	if have, _ := store.Get(blob.Header.Hash()); len(have) != 1 || have[0] != sidecar {
+		t.Fatalf("stored sidecars mismatch: have %v, want [%v]", have, sidecar)
+	}
+	if len(store.sidecars) != 1 {
+		t.Fatalf("stored sidecar count mismatch: have %d, want 1", len(store.sidecars))
+	}
+	// Ensure store failures are propagated
+	store.fail = errors.New("store failure")
+	if err := tester.downloader.storeSidecars([]*fetchResult{blob}); !errors.Is(err, store.fail) {
+		t.Fatalf("store error mismatch: have %v, want %v", err, store.fail)
+	}
+}
+
+// Tests that a reorg of the remote chain in the middle of a sync, after headers
+// only on the old fork were already fetched, fails the sync cleanly, leaving a
+// consistent local chain behind that a subsequent sync reorgs onto the new fork.
+func TestMidSyncReorg68Full(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	chainA := testChainForkLightA.shorten(len(testChainBase.blocks) + 80)
+	chainB := testChainForkLightB.shorten(len(testChainBase.blocks) + 81)
+
+	// Shrink the skeleton gaps so the first skeleton is filled with the headers
+	// of fork A up to 56 blocks past the fork point, and the reorg only hits the
+	// skeleton request after that
+	if err := tester.downloader.SetMaxHeadersPerRequest(32); err != nil {
+		t.Fatalf("failed to set header request size: %v", err)
+	}
+	peer := tester.newPeer("peer", eth.ETH68, chainA.blocks[1:])
+	peer.SimulateReorg(uint64(len(testChainBase.blocks)+40), newTestBlockchain(chainB.blocks[1:]))
+
+	if err := tester.sync("peer", nil, FullSync); err == nil {
+		t.Fatalf("sync succeeded across the reorg")
+	}
+	if !peer.reorged.Load() {
+		t.Fatalf("peer did not reorg mid-sync")
+	}
+	// The local chain must be a prefix of one of the forks
+	onFork := func(chain *testChain, number uint64, hash common.Hash) bool {
+		return number < uint64(len(chain.blocks)) && chain.blocks[number].Hash() == hash
+	}
+	for number := uint64(0); number <= tester.chain.CurrentBlock().Number.Uint64(); number++ {
+		hash := tester.chain.GetCanonicalHash(number)
+		if !onFork(chainA, number, hash) && !onFork(chainB, number, hash) {
+			t.Fatalf("block #%d [%x] on neither fork", number, hash)
+		}
+	}
+	// Sync again, which must end up on the reorged chain
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise after reorg: %v", err)
+	}
+	assertOwnChain(t, tester, len(chainB.blocks))
+	if have, want := tester.chain.CurrentBlock().Hash(), chainB.blocks[len(chainB.blocks)-1].Hash(); have != want {
+		t.Fatalf("head mismatch: have %x, want %x", have, want)
+	}
+}
+
+// Tests that header retrieval is held back while the body fetchers don't keep
+// up, so the pool of headers waiting for their bodies stops growing.
+func TestHeaderPoolBackpressure(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	// Shrink the skeleton so the chain spans a few of them
+	fetch := 32
+	if err := tester.downloader.SetMaxHeadersPerRequest(fetch); err != nil {
+		t.Fatalf("failed to set header request size: %v", err)
+	}
+	// Block the body fetcher on its first request until released
+	var (
+		release = make(chan struct{})
+		unblock = sync.OnceFunc(func() { close(release) })
+		once    sync.Once
+	)
+	defer unblock()
+
+	tester.downloader.bodyFetchHook = func([]*types.Header) {
+		once.Do(func() { <-release })
+	}
+	chain := testChainForkLightA
+	tester.newPeer("peer", eth.ETH68, chain.blocks[1:])
+
+	errc := make(chan error, 1)
+	go func() { errc <- tester.sync("peer", nil, FullSync) }()
+
+	// Wait for the header pool to settle and ensure it stopped short of the chain
+	var pool int
+	for start := time.Now(); ; {
+		time.Sleep(500 * time.Millisecond)
+		if next := tester.downloader.HeaderPool(); next == pool && pool > 0 {
+			break
+		} else {
+			pool = next
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("header pool did not settle, currently at %d", pool)
+		}
+	}
+	if limit := MaxSkeletonSize * fetch; pool > limit {
+		t.Fatalf("header pool too large: have %d, want at most %d", pool, limit)
+	}
+	// Release the body fetcher and ensure the sync completes
+	unblock()
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+	if pool := tester.downloader.HeaderPool(); pool != 0 {
+		t.Fatalf("header pool not drained: have %d, want 0", pool)
+	}
+}
+
+// Tests that in lazy body verification mode, bodies mismatching their headers
+// are accepted on delivery, but detected on import and their peer dropped.
+func TestLazyBodyVerification(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	tester.downloader.SetBodyVerificationMode(LazyVerification)
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+
+	attacker := tester.newPeer("attack", eth.ETH68, chain.blocks[1:])
+	attacker.corruptBodies = true
+
+	if err := tester.sync("attack", nil, FullSync); !errors.Is(err, errInvalidBody) {
+		t.Fatalf("sync error mismatch: have %v, want %v", err, errInvalidBody)
+	}
+	tester.lock.RLock()
+	_, ok := tester.peers["attack"]
+	tester.lock.RUnlock()
+	if ok {
+		t.Fatalf("peer delivering mismatching bodies not dropped")
+	}
+	// Ensure a valid peer can still be synced with lazily verified bodies
+	tester.newPeer("valid", eth.ETH68, chain.blocks[1:])
+	if err := tester.sync("valid", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, len(chain.blocks))
+}
@@ -0,0 +1,111 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DownloaderExtension is a set of callbacks invoked by the downloader at various
+// points of the synchronisation, allowing chain specific features (e.g. finality
+// tracking) to hook into the sync lifecycle without modifying the downloader.
+//
+// All callbacks are invoked synchronously from the sync goroutine, so they must
+// not block and must not call back into the downloader.
+type DownloaderExtension interface {
+	// OnBlockImported is called for every block fully imported into the local
+	// chain by the downloader.
+	OnBlockImported(block *types.Block)
+
+	// OnSyncStart is called when a sync cycle with a remote peer is started.
+	OnSyncStart(peer string, mode SyncMode)
+
+	// OnSyncComplete is called when a sync cycle terminates, with the error
+	// the cycle failed with or nil if it succeeded.
+	OnSyncComplete(peer string, err error)
+
+	// OnReorg is called when an import made the local chain head switch to a
+	// block which does not descend from the previous head.
+	OnReorg(oldHead, newHead *types.Header)
+}
+
+// NoopExtension is a DownloaderExtension ignoring all events. It can be embedded
+// into extensions only interested in a subset of the callbacks.
+type NoopExtension struct{}
+
+// OnBlockImported implements DownloaderExtension, ignoring the event.
+func (NoopExtension) OnBlockImported(block *types.Block) {}
+
+// OnSyncStart implements DownloaderExtension, ignoring the event.
+func (NoopExtension) OnSyncStart(peer string, mode SyncMode) {}
+
+// OnSyncComplete implements DownloaderExtension, ignoring the event.
+func (NoopExtension) OnSyncComplete(peer string, err error) {}
+
+// OnReorg implements DownloaderExtension, ignoring the event.
+func (NoopExtension) OnReorg(oldHead, newHead *types.Header) {}
+
+// RegisterExtension adds an extension to be notified of the sync events. All
+// extensions must be registered before the first synchronisation is started,
+// they are invoked in the order of registration.
+func (d *Downloader) RegisterExtension(ext DownloaderExtension) {
+	d.extensions = append(d.extensions, ext)
+}
+
+// notifyBlocksImported invokes the import callback of all extensions for each
+// of the given blocks.
+func (d *Downloader) notifyBlocksImported(blocks []*types.Block) {
+	for _, ext := range d.extensions {
+		for _, block := range blocks {
+			ext.OnBlockImported(block)
+		}
+	}
+}
+
+// notifySyncStart invokes the sync start callback of all extensions.
+func (d *Downloader) notifySyncStart(peer string, mode SyncMode) {
+	for _, ext := range d.extensions {
+		ext.OnSyncStart(peer, mode)
+	}
+}
+
+// notifySyncComplete invokes the sync completion callback of all extensions.
+func (d *Downloader) notifySyncComplete(peer string, err error) {
+	for _, ext := range d.extensions {
+		ext.OnSyncComplete(peer, err)
+	}
+}
+
+// notifyReorg checks whether the chain head moved from oldHead onto a different
+// branch and if so, invokes the reorg callback of all extensions.
+func (d *Downloader) notifyReorg(oldHead, newHead *types.Header) {
+	if len(d.extensions) == 0 || oldHead == nil || newHead == nil || oldHead.Hash() == newHead.Hash() {
+		return
+	}
+	// Walk the new head back to the height of the old one, if the old head is
+	// not an ancestor, the chain was reorganised
+	ancestor := newHead
+	for ancestor != nil && ancestor.Number.Cmp(oldHead.Number) > 0 {
+		ancestor = d.blockchain.GetHeaderByHash(ancestor.ParentHash)
+	}
+	if ancestor != nil && ancestor.Hash() == oldHead.Hash() {
+		return
+	}
+	for _, ext := range d.extensions {
+		ext.OnReorg(oldHead, newHead)
+	}
+}
@@ -39,7 +39,7 @@ func (d *Downloader) fetchHeadersByHash(p *peerConnection, hash common.Hash, amo
 	defer req.Close()
 
 	// Wait until the response arrives, the request is cancelled or times out
-	ttl := d.peers.rates.TargetTimeout()
+	ttl := max(d.peers.rates.TargetTimeout(), d.config.HeaderFetchTimeout)
 
 	timeoutTimer := time.NewTimer(ttl)
 	defer timeoutTimer.Stop()
@@ -52,13 +52,22 @@ func (d *Downloader) fetchHeadersByHash(p *peerConnection, hash common.Hash, amo
 		// Header retrieval timed out, update the metrics
 		p.log.Debug("Header request timed out", "elapsed", ttl)
 		headerTimeoutMeter.Mark(1)
+		p.markTimeout()
 
 		return nil, nil, errTimeout
 
 	case res := <-resCh:
+		// Reject malformed responses outright, the dispatcher will disconnect
+		// the peer on the returned error
+		if err := res.Validate(); err != nil {
+			res.Done <- err
+			return nil, nil, err
+		}
 		// Headers successfully retrieved, update the metrics
 		headerReqTimer.Update(time.Since(start))
 		headerInMeter.Mark(int64(len(*res.Res.(*eth.BlockHeadersRequest))))
+		p.updateHeaderFetchRate(len(*res.Res.(*eth.BlockHeadersRequest)), res.Time)
+		d.bytesDownloaded.add(time.Now(), uint64(headersSize(*res.Res.(*eth.BlockHeadersRequest))))
 
 		// Don't reject the packet even if it turns out to be bad, downloader will
 		// disconnect the peer on its own terms. Simply delivery the headers to
@@ -84,7 +93,7 @@ func (d *Downloader) fetchHeadersByNumber(p *peerConnection, number uint64, amou
 	defer req.Close()
 
 	// Wait until the response arrives, the request is cancelled or times out
-	ttl := d.peers.rates.TargetTimeout()
+	ttl := max(d.peers.rates.TargetTimeout(), d.config.HeaderFetchTimeout)
 
 	timeoutTimer := time.NewTimer(ttl)
 	defer timeoutTimer.Stop()
@@ -97,13 +106,22 @@ func (d *Downloader) fetchHeadersByNumber(p *peerConnection, number uint64, amou
 		// Header retrieval timed out, update the metrics
 		p.log.Debug("Header request timed out", "elapsed", ttl)
 		headerTimeoutMeter.Mark(1)
+		p.markTimeout()
 
 		return nil, nil, errTimeout
 
 	case res := <-resCh:
+		// Reject malformed responses outright, the dispatcher will disconnect
+		// the peer on the returned error
+		if err := res.Validate(); err != nil {
+			res.Done <- err
+			return nil, nil, err
+		}
 		// Headers successfully retrieved, update the metrics
 		headerReqTimer.Update(time.Since(start))
 		headerInMeter.Mark(int64(len(*res.Res.(*eth.BlockHeadersRequest))))
+		p.updateHeaderFetchRate(len(*res.Res.(*eth.BlockHeadersRequest)), res.Time)
+		d.bytesDownloaded.add(time.Now(), uint64(headersSize(*res.Res.(*eth.BlockHeadersRequest))))
 
 		// Don't reject the packet even if it turns out to be bad, downloader will
 		// disconnect the peer on its own terms. Simply delivery the headers to
@@ -45,6 +45,10 @@ type typedQueue interface {
 	// fetching by the concurrent downloader.
 	pending() int
 
+	// capabilities returns the peer capabilities preferred for retrieving items
+	// of the abstracted type, or nil if any peer is equally suitable.
+	capabilities() []string
+
 	// capacity is responsible for calculating how many items of the abstracted
 	// type a particular peer is estimated to be able to retrieve within the
 	// allotted round trip time.
@@ -75,8 +79,8 @@ type typedQueue interface {
 
 // concurrentFetch iteratively downloads scheduled block parts, taking available
 // peers, reserving a chunk of fetch requests for each and waiting for delivery
-// or timeouts.
-func (d *Downloader) concurrentFetch(queue typedQueue, beaconMode bool) error {
+// or timeouts. Requests are never timed out sooner than the given minimum.
+func (d *Downloader) concurrentFetch(queue typedQueue, minTimeout time.Duration, beaconMode bool) error {
 	// Create a delivery channel to accept responses from all peers
 	responses := make(chan *eth.Response)
 
@@ -156,11 +160,13 @@ func (d *Downloader) concurrentFetch(queue typedQueue, beaconMode bool) error {
 						// permitted it, consider the peer malicious attempting to
 						// stall the sync.
 						peer.log.Warn("Peer stalling, dropping", "waited", common.PrettyDuration(waited))
+						peer.markStall()
 						d.dropPeer(peer.id)
 					}
 				}
 			}
 			sort.Sort(&peerCapacitySort{idles, caps})
+			d.preferCapable(idles, caps, queue.capabilities())
 
 			var (
 				progressed bool
@@ -203,7 +209,7 @@ func (d *Downloader) concurrentFetch(queue typedQueue, beaconMode bool) error {
 				}
 				pending[peer.id] = req
 
-				ttl := d.peers.rates.TargetTimeout()
+				ttl := max(d.peers.rates.TargetTimeout(), minTimeout)
 				ordering[req] = timeouts.Size()
 
 				timeouts.Push(req, -time.Now().Add(ttl).UnixNano())
@@ -317,6 +323,7 @@ func (d *Downloader) concurrentFetch(queue typedQueue, beaconMode bool) error {
 				log.Error("Delivery timeout from unknown peer", "peer", req.Peer)
 				continue
 			}
+			peer.markTimeout()
 			if fails > 2 {
 				queue.updateCapacity(peer, 0, 0)
 			} else {
@@ -359,12 +366,21 @@ func (d *Downloader) concurrentFetch(queue typedQueue, beaconMode bool) error {
 
 			// Signal the dispatcher that the round trip is done. We'll drop the
 			// peer if the data turns out to be junk.
-			res.Done <- nil
+			err := res.Validate()
+			res.Done <- err
 			res.Req.Close()
 
 			// If the peer was previously banned and failed to deliver its pack
 			// in a reasonable time frame, ignore its message.
 			if peer := d.peers.Peer(res.Req.Peer); peer != nil {
+				// If the response is malformed, return the reserved tasks for
+				// other peers to pick up and drop the sender
+				if err != nil {
+					peer.log.Debug("Invalid response delivered", "err", err)
+					queue.unreserve(peer.id)
+					d.dropPeer(peer.id)
+					continue
+				}
 				// Deliver the received chunk of data and check chain validity
 				accepted, err := queue.deliver(peer, res)
 				if errors.Is(err, errInvalidChain) {
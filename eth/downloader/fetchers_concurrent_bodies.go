@@ -40,9 +40,18 @@ func (q *bodyQueue) pending() int {
 	return q.queue.PendingBodies()
 }
 
+// capabilities returns the peer capabilities preferred for retrieving bodies.
+func (q *bodyQueue) capabilities() []string {
+	return preferredFetchCapabilities
+}
+
 // capacity is responsible for calculating how many bodies a particular peer is
-// estimated to be able to retrieve within the allotted round trip time.
+// estimated to be able to retrieve within the allotted round trip time. Speeds
+// reported for the peer take precedence over the measured throughput.
 func (q *bodyQueue) capacity(peer *peerConnection, rtt time.Duration) int {
+	if cap := peer.reportedCapacity(q.queue.ResultSize(), rtt); cap > 0 {
+		return min(cap, MaxBlockFetch)
+	}
 	return peer.BodyCapacity(rtt)
 }
 
@@ -92,14 +101,30 @@ func (q *bodyQueue) deliver(peer *peerConnection, packet *eth.Response) (int, er
 	txs, uncles, withdrawals, sidecars := packet.Res.(*eth.BlockBodiesResponse).Unpack()
 	hashsets := packet.Meta.([][]common.Hash) // {txs hashes, uncle hashes, withdrawal hashes}
 
+	size := bodiesSize(txs, uncles, withdrawals, sidecars)
+	q.bytesDownloaded.add(time.Now(), uint64(size))
+
 	accepted, err := q.queue.DeliverBodies(peer.id, txs, hashsets[0], uncles, hashsets[1], withdrawals, hashsets[2], sidecars)
+	bodyDepthGauge.Update(int64(q.queue.BodyQueueDepth()))
+
 	switch {
 	case err == nil && len(txs) == 0:
 		peer.log.Trace("Requested bodies delivered")
 	case err == nil:
 		peer.log.Trace("Delivered new batch of bodies", "count", len(txs), "accepted", accepted)
+		q.bodyRate.add(time.Now(), len(txs), uint64(size))
 	default:
 		peer.log.Debug("Failed to deliver retrieved bodies", "err", err)
+
+		// Bodies failing validation are rescheduled for other peers, but a body
+		// exceeding the transaction cap can only be deliberate junk
+		for _, list := range txs {
+			if len(list) > maxBodyTransactions {
+				peer.log.Warn("Oversized block body delivered, dropping", "txs", len(list), "limit", maxBodyTransactions)
+				q.dropPeer(peer.id)
+				break
+			}
+		}
 	}
 	return accepted, err
 }
@@ -40,9 +40,18 @@ func (q *headerQueue) pending() int {
 	return q.queue.PendingHeaders()
 }
 
+// capabilities returns the peer capabilities preferred for retrieving headers.
+func (q *headerQueue) capabilities() []string {
+	return preferredFetchCapabilities
+}
+
 // capacity is responsible for calculating how many headers a particular peer is
-// estimated to be able to retrieve within the allotted round trip time.
+// estimated to be able to retrieve within the allotted round trip time. Speeds
+// reported for the peer take precedence over the measured throughput.
 func (q *headerQueue) capacity(peer *peerConnection, rtt time.Duration) int {
+	if cap := peer.reportedCapacity(estHeaderSize, rtt); cap > 0 {
+		return min(cap, MaxHeaderFetch)
+	}
 	return peer.HeaderCapacity(rtt)
 }
 
@@ -75,7 +84,7 @@ func (q *headerQueue) unreserve(peer string) int {
 // one and sending it to the remote peer for fulfillment.
 func (q *headerQueue) request(peer *peerConnection, req *fetchRequest, resCh chan *eth.Response) (*eth.Request, error) {
 	peer.log.Trace("Requesting new batch of headers", "from", req.From)
-	return peer.peer.RequestHeadersByNumber(req.From, MaxHeaderFetch, 0, false, resCh)
+	return peer.peer.RequestHeadersByNumber(req.From, q.maxHeadersPerRequest, 0, false, resCh)
 }
 
 // deliver is responsible for taking a generic response packet from the concurrent
@@ -83,6 +92,7 @@ func (q *headerQueue) request(peer *peerConnection, req *fetchRequest, resCh cha
 func (q *headerQueue) deliver(peer *peerConnection, packet *eth.Response) (int, error) {
 	headers := *packet.Res.(*eth.BlockHeadersRequest)
 	hashes := packet.Meta.([]common.Hash)
+	q.bytesDownloaded.add(time.Now(), uint64(headersSize(headers)))
 
 	accepted, err := q.queue.DeliverHeaders(peer.id, headers, hashes, q.headerProcCh)
 	switch {
@@ -40,9 +40,19 @@ func (q *receiptQueue) pending() int {
 	return q.queue.PendingReceipts()
 }
 
+// capabilities returns the peer capabilities preferred for retrieving receipts,
+// any peer is equally suitable for them.
+func (q *receiptQueue) capabilities() []string {
+	return nil
+}
+
 // capacity is responsible for calculating how many receipts a particular peer is
-// estimated to be able to retrieve within the allotted round trip time.
+// estimated to be able to retrieve within the allotted round trip time. Speeds
+// reported for the peer take precedence over the measured throughput.
 func (q *receiptQueue) capacity(peer *peerConnection, rtt time.Duration) int {
+	if cap := peer.reportedCapacity(q.queue.ResultSize(), rtt); cap > 0 {
+		return min(cap, MaxReceiptFetch)
+	}
 	return peer.ReceiptCapacity(rtt)
 }
 
@@ -91,7 +101,17 @@ func (q *receiptQueue) deliver(peer *peerConnection, packet *eth.Response) (int,
 	receipts := *packet.Res.(*eth.ReceiptsResponse)
 	hashes := packet.Meta.([]common.Hash) // {receipt hashes}
 
+	var size common.StorageSize
+	for _, list := range receipts {
+		for _, receipt := range list {
+			size += receipt.Size()
+		}
+	}
+	q.bytesDownloaded.add(time.Now(), uint64(size))
+
 	accepted, err := q.queue.DeliverReceipts(peer.id, receipts, hashes)
+	receiptDepthGauge.Update(int64(q.queue.ReceiptQueueDepth()))
+
 	switch {
 	case err == nil && len(receipts) == 0:
 		peer.log.Trace("Requested receipts delivered")
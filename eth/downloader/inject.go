@@ -0,0 +1,118 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var (
+	errUnknownParent = errors.New("unknown parent")
+	errReorgTooDeep  = errors.New("reorg too deep")
+)
+
+// InjectHeaders inserts a batch of headers obtained out of band (e.g. a trusted
+// bundle from a relay) directly into the local header chain, without negotiating
+// with any peer. The headers must be continuous and attach to a known header,
+// each of them is verified by the consensus engine upon insertion.
+//
+// Headers forking off the local chain deeper than FullMaxForkAncestry are always
+// rejected. The method fails with errBusy if a sync is in progress.
+func (d *Downloader) InjectHeaders(headers []*types.Header) error {
+	if !d.synchronising.CompareAndSwap(false, true) {
+		return errBusy
+	}
+	defer d.synchronising.Store(false)
+
+	// Skip any headers already known, nothing to do for them
+	for len(headers) > 0 && d.blockchain.HasHeader(headers[0].Hash(), headers[0].Number.Uint64()) {
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	// Ensure the headers are continuous and attach to the local chain
+	for i := 1; i < len(headers); i++ {
+		if headers[i].Number.Uint64() != headers[i-1].Number.Uint64()+1 || headers[i].ParentHash != headers[i-1].Hash() {
+			return fmt.Errorf("%w: #%d [%x] not child of #%d [%x]", errInvalidChain,
+				headers[i].Number, headers[i].Hash().Bytes()[:4], headers[i-1].Number, headers[i-1].Hash().Bytes()[:4])
+		}
+	}
+	first := headers[0]
+	parent := d.blockchain.GetHeaderByHash(first.ParentHash)
+	if parent == nil || parent.Number.Uint64()+1 != first.Number.Uint64() {
+		return fmt.Errorf("%w: #%d [%x]", errUnknownParent, first.Number, first.ParentHash.Bytes()[:4])
+	}
+	// Ensure the headers don't fork off the local chain too deep
+	if depth, ok := d.reorgDepth(parent); !ok {
+		return fmt.Errorf("%w: more than %d headers", errReorgTooDeep, FullMaxForkAncestry)
+	} else if depth > 0 {
+		log.Debug("Injecting headers on a side chain", "depth", depth, "parent", parent.Number)
+	}
+	if n, err := d.blockchain.InsertHeaderChain(headers); err != nil {
+		log.Warn("Invalid header injected", "number", headers[n].Number, "hash", headers[n].Hash(), "err", err)
+		return fmt.Errorf("%w: %v", errInvalidChain, err)
+	}
+	log.Info("Injected headers", "count", len(headers), "first", first.Number, "last", headers[len(headers)-1].Number)
+	return nil
+}
+
+// PreloadHeaders hands a batch of speculative headers, known before they are
+// finalised, to the download queue. Headers later delivered by the sync with a
+// matching hash reuse the preloaded instances, conflicting ones are discarded.
+func (d *Downloader) PreloadHeaders(headers []*types.Header) {
+	d.queue.Preload(headers)
+}
+
+// reorgDepth calculates the number of local headers that would be abandoned if
+// the chain switched to a side chain descending from the given header. False is
+// returned if the depth exceeds FullMaxForkAncestry.
+func (d *Downloader) reorgDepth(header *types.Header) (uint64, bool) {
+	head := d.blockchain.CurrentHeader()
+
+	// Rewind the head to the height of the header, then both of them together
+	// until the common ancestor is found
+	var depth uint64
+	for head.Number.Cmp(header.Number) > 0 {
+		if head = d.blockchain.GetHeaderByHash(head.ParentHash); head == nil {
+			return depth, false
+		}
+		if depth++; depth > FullMaxForkAncestry {
+			return depth, false
+		}
+	}
+	for head.Number.Cmp(header.Number) < 0 {
+		if header = d.blockchain.GetHeaderByHash(header.ParentHash); header == nil {
+			return depth, false
+		}
+	}
+	for head.Hash() != header.Hash() {
+		head = d.blockchain.GetHeaderByHash(head.ParentHash)
+		header = d.blockchain.GetHeaderByHash(header.ParentHash)
+		if head == nil || header == nil {
+			return depth, false
+		}
+		if depth++; depth > FullMaxForkAncestry {
+			return depth, false
+		}
+	}
+	return depth, true
+}
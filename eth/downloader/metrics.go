@@ -32,11 +32,15 @@ var (
 	bodyReqTimer     = metrics.NewRegisteredTimer("eth/downloader/bodies/req", nil)
 	bodyDropMeter    = metrics.NewRegisteredMeter("eth/downloader/bodies/drop", nil)
 	bodyTimeoutMeter = metrics.NewRegisteredMeter("eth/downloader/bodies/timeout", nil)
+	bodyDepthGauge   = metrics.NewRegisteredGauge("eth/downloader/bodies/depth", nil)
 
 	receiptInMeter      = metrics.NewRegisteredMeter("eth/downloader/receipts/in", nil)
 	receiptReqTimer     = metrics.NewRegisteredTimer("eth/downloader/receipts/req", nil)
 	receiptDropMeter    = metrics.NewRegisteredMeter("eth/downloader/receipts/drop", nil)
 	receiptTimeoutMeter = metrics.NewRegisteredMeter("eth/downloader/receipts/timeout", nil)
+	receiptDepthGauge   = metrics.NewRegisteredGauge("eth/downloader/receipts/depth", nil)
 
 	throttleCounter = metrics.NewRegisteredCounter("eth/downloader/throttle", nil)
+
+	ancestorSearchIterationsCounter = metrics.NewRegisteredCounter("eth/downloader/ancestor/iterations", nil)
 )
@@ -21,6 +21,7 @@ package downloader
 
 import (
 	"errors"
+	"math"
 	"math/big"
 	"sync"
 	"time"
@@ -34,6 +35,23 @@ import (
 
 const (
 	maxLackingHashes = 4096 // Maximum number of entries allowed on the list or lacking items
+
+	// headerRateWeight is the weight of a new measurement in the moving average
+	// of the header fetch rate of a peer.
+	headerRateWeight = 0.1
+
+	// speedReportDecay is the share of an externally reported peer speed that is
+	// lost every speedReportDecayPeriod without a new report.
+	speedReportDecay       = 0.1
+	speedReportDecayPeriod = 30 * time.Second
+
+	// speedReportMaxAge is the age after which an externally reported peer speed
+	// is ignored, falling back to the downloader's own throughput estimates.
+	speedReportMaxAge = 5 * time.Minute
+
+	// estHeaderSize is the approximate size of a header, used to convert reported
+	// peer speeds into header counts.
+	estHeaderSize = common.StorageSize(512)
 )
 
 var (
@@ -48,6 +66,14 @@ type peerConnection struct {
 	rates   *msgrate.Tracker         // Tracker to hone in on the number of items retrievable per second
 	lacking map[common.Hash]struct{} // Set of hashes not to request (didn't have previously)
 
+	headerRate     float64 // Moving average of the headers delivered per second
+	headerRateInit bool    // Whether the moving average was seeded by a measurement
+	timeouts       int     // Number of requests the peer failed to answer in time
+	stalls         int     // Number of requests the peer left unanswered past the grace period
+
+	reportedSpeed float64   // Bandwidth of the peer in bytes per second, as reported externally
+	reportedTime  time.Time // Time the bandwidth of the peer was last reported
+
 	peer Peer
 
 	version uint       // Eth protocol version number to switch strategies
@@ -89,6 +115,36 @@ func (p *peerConnection) Reset() {
 // the current measurement.
 func (p *peerConnection) UpdateHeaderRate(delivered int, elapsed time.Duration) {
 	p.rates.Update(eth.BlockHeadersMsg, elapsed, delivered)
+	p.updateHeaderFetchRate(delivered, elapsed)
+}
+
+// updateHeaderFetchRate folds a header delivery into the moving average of the
+// headers delivered per second. Failed requests count as zero throughput.
+func (p *peerConnection) updateHeaderFetchRate(delivered int, elapsed time.Duration) {
+	var rate float64
+	if delivered > 0 {
+		if elapsed <= 0 {
+			return
+		}
+		rate = float64(delivered) / elapsed.Seconds()
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.headerRateInit {
+		p.headerRate, p.headerRateInit = rate, true
+	} else {
+		p.headerRate = headerRateWeight*rate + (1-headerRateWeight)*p.headerRate
+	}
+}
+
+// HeaderFetchRate retrieves the moving average of the headers delivered by the
+// peer per second.
+func (p *peerConnection) HeaderFetchRate() float64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.headerRate
 }
 
 // UpdateBodyRate updates the peer's estimated body retrieval throughput with the
@@ -133,6 +189,42 @@ func (p *peerConnection) ReceiptCapacity(targetRTT time.Duration) int {
 	return cap
 }
 
+// ReportSpeed sets the bandwidth of the peer as measured outside the downloader.
+// As long as recent enough, it takes precedence over the downloader's own
+// throughput estimates when sizing requests.
+func (p *peerConnection) ReportSpeed(bytesPerSec uint64, now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.reportedSpeed, p.reportedTime = float64(bytesPerSec), now
+}
+
+// ReportedSpeed retrieves the externally reported bandwidth of the peer in bytes
+// per second, decayed for the time passed since it was reported. 0 is returned if
+// no bandwidth was reported, or if the report is older than speedReportMaxAge.
+func (p *peerConnection) ReportedSpeed(now time.Time) float64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.reportedSpeed == 0 || now.Sub(p.reportedTime) > speedReportMaxAge {
+		return 0
+	}
+	periods := float64(now.Sub(p.reportedTime)) / float64(speedReportDecayPeriod)
+	return p.reportedSpeed * math.Pow(1-speedReportDecay, max(periods, 0))
+}
+
+// reportedCapacity converts the externally reported bandwidth of the peer into
+// the number of items of the given size retrievable within the round trip time.
+// 0 is returned if no recent bandwidth was reported, the item size is unknown,
+// or the reported bandwidth doesn't cover a single item.
+func (p *peerConnection) reportedCapacity(itemSize common.StorageSize, targetRTT time.Duration) int {
+	speed := p.ReportedSpeed(time.Now())
+	if speed == 0 || itemSize == 0 {
+		return 0
+	}
+	return int(speed * targetRTT.Seconds() / float64(itemSize))
+}
+
 // MarkLacking appends a new entity to the set of items (blocks, receipts, states)
 // that a peer is known not to have (i.e. have been requested before). If the
 // set reaches its maximum allowed capacity, items are randomly dropped off.
@@ -166,6 +258,31 @@ type peeringEvent struct {
 	join bool
 }
 
+// markTimeout records a request the peer failed to answer in time.
+func (p *peerConnection) markTimeout() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.timeouts++
+}
+
+// markStall records a timed out request the peer still did not answer after
+// the grace period.
+func (p *peerConnection) markStall() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.stalls++
+}
+
+// failures returns the number of timeouts and stalls recorded for the peer.
+func (p *peerConnection) failures() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.timeouts + p.stalls
+}
+
 // peerSet represents the collection of active peer participating in the chain
 // download procedure.
 type peerSet struct {
@@ -258,6 +375,18 @@ func (ps *peerSet) Len() int {
 	return len(ps.peers)
 }
 
+// IDs retrieves the identifiers of all the peers within the set.
+func (ps *peerSet) IDs() []string {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	ids := make([]string, 0, len(ps.peers))
+	for id := range ps.peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 // AllPeers retrieves a flat list of all the peers within the set.
 func (ps *peerSet) AllPeers() []*peerConnection {
 	ps.lock.RLock()
@@ -270,6 +399,38 @@ func (ps *peerSet) AllPeers() []*peerConnection {
 	return list
 }
 
+// worstPeer retrieves the peer with the most failures recorded among the given
+// ones, or among all peers if none are given. Nil is returned if none of them
+// are registered. Ties are broken by the peer id to stay deterministic.
+func (ps *peerSet) worstPeer(ids ...string) (string, *peerConnection) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	candidates := ps.peers
+	if len(ids) > 0 {
+		candidates = make(map[string]*peerConnection, len(ids))
+		for _, id := range ids {
+			if p, ok := ps.peers[id]; ok {
+				candidates[id] = p
+			}
+		}
+	}
+	var (
+		worst *peerConnection
+		fails int
+	)
+	for id, p := range candidates {
+		have := p.failures()
+		if worst == nil || have > fails || (have == fails && id < worst.id) {
+			worst, fails = p, have
+		}
+	}
+	if worst == nil {
+		return "", nil
+	}
+	return worst.id, worst
+}
+
 // peerCapacitySort implements sort.Interface.
 // It sorts peer connections by capacity (descending).
 type peerCapacitySort struct {
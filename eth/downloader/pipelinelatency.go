@@ -0,0 +1,132 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// pipelineLatencyWeight is the weight of a newly measured block in the moving
+// averages of the pipeline stage latencies.
+const pipelineLatencyWeight = 0.1
+
+// pipelineLatency tracks how long blocks spend between the transitions of the
+// download pipeline: from the header being scheduled to its body arriving, from
+// the body arriving to the block being handed over for insertion, and from that
+// to the insertion completing.
+type pipelineLatency struct {
+	scheduled map[common.Hash]time.Time // Blocks with a scheduled header, awaiting their body
+	delivered map[common.Hash]time.Time // Blocks with a delivered body, awaiting insertion
+	inserting map[common.Hash]time.Time // Blocks handed over for insertion, awaiting completion
+
+	headerToBody   float64 // Moving average of the header to body latency, in nanoseconds
+	bodyToInsert   float64 // Moving average of the body to insertion latency, in nanoseconds
+	insertToCommit float64 // Moving average of the insertion latency, in nanoseconds
+
+	lock sync.Mutex
+}
+
+// newPipelineLatency creates a latency tracker with no blocks in flight.
+func newPipelineLatency() *pipelineLatency {
+	return &pipelineLatency{
+		scheduled: make(map[common.Hash]time.Time),
+		delivered: make(map[common.Hash]time.Time),
+		inserting: make(map[common.Hash]time.Time),
+	}
+}
+
+// schedule marks the header of a block as scheduled for body retrieval.
+func (l *pipelineLatency) schedule(now time.Time, hash common.Hash) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.scheduled[hash] = now
+}
+
+// deliver marks the body of a block as arrived.
+func (l *pipelineLatency) deliver(now time.Time, hash common.Hash) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if start, ok := l.scheduled[hash]; ok {
+		delete(l.scheduled, hash)
+		l.headerToBody = updateLatency(l.headerToBody, now.Sub(start))
+	}
+	l.delivered[hash] = now
+}
+
+// insert marks a block as handed over for chain insertion. Blocks without a
+// body are never delivered, so they only start being measured from here.
+func (l *pipelineLatency) insert(now time.Time, hash common.Hash) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.scheduled, hash)
+	if start, ok := l.delivered[hash]; ok {
+		delete(l.delivered, hash)
+		l.bodyToInsert = updateLatency(l.bodyToInsert, now.Sub(start))
+	}
+	l.inserting[hash] = now
+}
+
+// commit marks the chain insertion of a batch of blocks as completed.
+func (l *pipelineLatency) commit(now time.Time, results []*fetchResult) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, result := range results {
+		hash := result.Header.Hash()
+		if start, ok := l.inserting[hash]; ok {
+			delete(l.inserting, hash)
+			l.insertToCommit = updateLatency(l.insertToCommit, now.Sub(start))
+		}
+	}
+}
+
+// reset drops all the blocks in flight, retaining the measured averages.
+func (l *pipelineLatency) reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	clear(l.scheduled)
+	clear(l.delivered)
+	clear(l.inserting)
+}
+
+// latencies returns the moving averages of the stage latencies.
+func (l *pipelineLatency) latencies() map[string]time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return map[string]time.Duration{
+		"header_to_body":   time.Duration(l.headerToBody),
+		"body_to_insert":   time.Duration(l.bodyToInsert),
+		"insert_to_commit": time.Duration(l.insertToCommit),
+	}
+}
+
+// updateLatency folds a new latency sample into a moving average, seeding the
+// average with the first sample.
+func updateLatency(avg float64, sample time.Duration) float64 {
+	if avg == 0 {
+		return float64(sample)
+	}
+	return pipelineLatencyWeight*float64(sample) + (1-pipelineLatencyWeight)*avg
+}
@@ -22,6 +22,8 @@ package downloader
 import (
 	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,6 +33,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto/kzg4844"
 	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/eth/protocols/eth"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/params"
@@ -45,7 +48,10 @@ var (
 	blockCacheMaxItems     = 8192              // Maximum number of blocks to cache before throttling the download
 	blockCacheInitialItems = 2048              // Initial number of blocks to start fetching, before we know the sizes of the blocks
 	blockCacheMemory       = 256 * 1024 * 1024 // Maximum amount of memory to use for block caching
+	blockCacheMaxBytes     = 512 * 1024 * 1024 // Maximum amount of memory held by the cached results before throttling
 	blockCacheSizeWeight   = 0.1               // Multiplier to approximate the average block size based on past ones
+
+	tentativeHeadersLimit = 1024 // Maximum number of speculative headers to keep preloaded
 )
 
 var (
@@ -73,6 +79,8 @@ type fetchResult struct {
 	Receipts     types.Receipts
 	Withdrawals  types.Withdrawals
 	Sidecars     types.BlobSidecars
+
+	accounted int // Size of the result accounted for in the result store
 }
 
 func newFetchResult(header *types.Header, fastSync bool, pid string) *fetchResult {
@@ -91,6 +99,23 @@ func newFetchResult(header *types.Header, fastSync bool, pid string) *fetchResul
 	return item
 }
 
+// ByteSize approximates the memory held by the fetch result, summing up the
+// sizes of the header, the body and the receipts delivered so far.
+func (f *fetchResult) ByteSize() int {
+	size := f.Header.Size()
+	for _, uncle := range f.Uncles {
+		size += uncle.Size()
+	}
+	for _, receipt := range f.Receipts {
+		size += receipt.Size()
+	}
+	for _, tx := range f.Transactions {
+		size += common.StorageSize(tx.Size())
+	}
+	size += common.StorageSize(f.Withdrawals.Size())
+	return int(size)
+}
+
 // body returns a representation of the fetch result as a types.Body object.
 func (f *fetchResult) body() types.Body {
 	return types.Body{
@@ -137,9 +162,13 @@ type queue struct {
 	headerPendPool  map[string]*fetchRequest       // Currently pending header retrieval operations
 	headerResults   []*types.Header                // Result cache accumulating the completed headers
 	headerHashes    []common.Hash                  // Result cache accumulating the completed header hashes
+	headerPeers     []string                       // Peers that delivered the header batches of the result cache
 	headerProced    int                            // Number of headers already processed from the results
 	headerOffset    uint64                         // Number of the first header in the result cache
 	headerContCh    chan bool                      // Channel to notify when header download finishes
+	headerTentative map[uint64]*types.Header       // Speculative headers preloaded ahead of confirmation, mapping numbers to headers
+
+	headerPooled atomic.Int64 // Number of headers sent to the header processor, not yet scheduled for content retrieval
 
 	// All data retrievals below are based on an already assembles header chain
 	blockTaskPool  map[common.Hash]*types.Header      // Pending block (body) retrieval tasks, mapping hashes to headers
@@ -152,8 +181,15 @@ type queue struct {
 	receiptPendPool  map[string]*fetchRequest           // Currently pending receipt retrieval operations
 	receiptWakeCh    chan bool                          // Channel to notify when receipt fetcher of new tasks
 
-	resultCache *resultStore       // Downloaded but not yet delivered fetch results
-	resultSize  common.StorageSize // Approximate size of a block (exponential moving average)
+	resultCache  *resultStore       // Downloaded but not yet delivered fetch results
+	resultSize   common.StorageSize // Approximate size of a block (exponential moving average)
+	receiptLimit int                // Number of results ahead of the delivery offset to fetch receipts for
+	headerFetch  int                // Number of headers between two skeleton headers (i.e. per fill request)
+
+	latency *pipelineLatency // Tracker of the time blocks spend in each pipeline stage
+
+	bodyVerification BodyVerificationMode      // Whether bodies are matched to their headers on delivery or on import
+	lazyBodies       map[common.Hash]*lazyBody // Delivered bodies still awaiting lazy verification
 
 	lock   *sync.RWMutex
 	active *sync.Cond
@@ -167,10 +203,12 @@ func newQueue(blockCacheLimit int, thresholdInitialSize int) *queue {
 	lock := new(sync.RWMutex)
 	q := &queue{
 		headerContCh:     make(chan bool, 1),
+		headerTentative:  make(map[uint64]*types.Header),
 		blockTaskQueue:   prque.New[int64, *types.Header](nil),
 		blockWakeCh:      make(chan bool, 1),
 		receiptTaskQueue: prque.New[int64, *types.Header](nil),
 		receiptWakeCh:    make(chan bool, 1),
+		latency:          newPipelineLatency(),
 		active:           sync.NewCond(lock),
 		lock:             lock,
 	}
@@ -188,6 +226,7 @@ func (q *queue) Reset(blockCacheLimit int, thresholdInitialSize int) {
 
 	q.headerHead = common.Hash{}
 	q.headerPendPool = make(map[string]*fetchRequest)
+	q.headerPooled.Store(0)
 
 	q.blockTaskPool = make(map[common.Hash]*types.Header)
 	q.blockTaskQueue.Reset()
@@ -199,6 +238,38 @@ func (q *queue) Reset(blockCacheLimit int, thresholdInitialSize int) {
 
 	q.resultCache = newResultStore(blockCacheLimit)
 	q.resultCache.SetThrottleThreshold(uint64(thresholdInitialSize))
+	q.receiptLimit = blockCacheLimit
+	q.headerFetch = MaxHeaderFetch
+	q.lazyBodies = make(map[common.Hash]*lazyBody)
+	q.latency.reset()
+}
+
+// SetReceiptLimit caps the number of results ahead of the delivery offset to
+// retrieve receipts for, on top of the throttling of the result cache.
+func (q *queue) SetReceiptLimit(limit int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.receiptLimit = limit
+}
+
+// SetBodyVerification sets whether delivered block bodies are matched against
+// their headers right away, or only once they are imported.
+func (q *queue) SetBodyVerification(mode BodyVerificationMode) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.bodyVerification = mode
+}
+
+// SetHeaderFetch sets the number of headers each skeleton gap spans, which is
+// also the size of the header fill requests. It must not be changed while a
+// skeleton is being filled.
+func (q *queue) SetHeaderFetch(n int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.headerFetch = n
 }
 
 // Close marks the end of the sync, unblocking Results.
@@ -218,6 +289,13 @@ func (q *queue) PendingHeaders() int {
 	return q.headerTaskQueue.Size()
 }
 
+// HeaderPool retrieves the number of downloaded headers whose bodies were not
+// requested yet: the ones handed to the header processor and the scheduled ones
+// pending body retrieval.
+func (q *queue) HeaderPool() int {
+	return int(q.headerPooled.Load()) + q.PendingBodies()
+}
+
 // PendingBodies retrieves the number of block body requests pending for retrieval.
 func (q *queue) PendingBodies() int {
 	q.lock.Lock()
@@ -234,6 +312,36 @@ func (q *queue) PendingReceipts() int {
 	return q.receiptTaskQueue.Size()
 }
 
+// BodyQueueDepth retrieves the share of the result slots open to body retrievals
+// which are in use, as a percentage. At 100, no new bodies are requested until
+// some results are processed.
+func (q *queue) BodyQueueDepth() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	used, threshold := q.resultCache.usage()
+	return percentage(used, threshold)
+}
+
+// ReceiptQueueDepth retrieves the share of the result slots open to receipt
+// retrievals which are in use, as a percentage. At 100, no new receipts are
+// requested until some results are processed.
+func (q *queue) ReceiptQueueDepth() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	used, _ := q.resultCache.usage()
+	return percentage(used, q.receiptLimit)
+}
+
+// percentage returns n as a percentage of limit, capped at 100.
+func percentage(n, limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	return min(100*n/limit, 100)
+}
+
 // InFlightBlocks retrieves whether there are block fetch requests currently in
 // flight.
 func (q *queue) InFlightBlocks() bool {
@@ -263,6 +371,67 @@ func (q *queue) Idle() bool {
 	return (queued + pending) == 0
 }
 
+// ResultsPending retrieves the number of downloaded results ready for import.
+func (q *queue) ResultsPending() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.resultCache.CountCompleted()
+}
+
+// ResultSize retrieves the approximate size of a downloaded block, or 0 if no
+// blocks were downloaded yet.
+func (q *queue) ResultSize() common.StorageSize {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return q.resultSize
+}
+
+// Preload inserts a batch of speculative headers, known ahead of finalisation
+// (e.g. by a miner or validator), as tentative entries. Tentative headers are
+// retained across syncs until a skeleton or header delivery at the same height
+// either confirms them, promoting the preloaded header, or conflicts with them,
+// discarding it. Once the limit is reached, the lowest entries are dropped.
+func (q *queue) Preload(headers []*types.Header) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(headers) > tentativeHeadersLimit {
+		headers = headers[len(headers)-tentativeHeadersLimit:]
+	}
+	for _, header := range headers {
+		q.headerTentative[header.Number.Uint64()] = header
+	}
+	for len(q.headerTentative) > tentativeHeadersLimit {
+		lowest := uint64(math.MaxUint64)
+		for number := range q.headerTentative {
+			lowest = min(lowest, number)
+		}
+		delete(q.headerTentative, lowest)
+	}
+}
+
+// confirmTentative checks a header arriving from the network against the one
+// preloaded at the same height, returning the preloaded instance if it has the
+// same hash and the original header otherwise. The tentative entry is removed
+// either way. The caller must hold the lock.
+func (q *queue) confirmTentative(header *types.Header, hash common.Hash) *types.Header {
+	number := header.Number.Uint64()
+
+	tentative, ok := q.headerTentative[number]
+	if !ok {
+		return header
+	}
+	delete(q.headerTentative, number)
+
+	if tentative.Hash() != hash {
+		log.Trace("Discarded conflicting tentative header", "number", number, "hash", hash, "tentative", tentative.Hash())
+		return header
+	}
+	return tentative
+}
+
 // ScheduleSkeleton adds a batch of header retrieval tasks to the queue to fill
 // up an already retrieved header skeleton.
 func (q *queue) ScheduleSkeleton(from uint64, skeleton []*types.Header) {
@@ -277,30 +446,46 @@ func (q *queue) ScheduleSkeleton(from uint64, skeleton []*types.Header) {
 	q.headerTaskPool = make(map[uint64]*types.Header)
 	q.headerTaskQueue = prque.New[int64, uint64](nil)
 	q.headerPeerMiss = make(map[string]map[uint64]struct{}) // Reset availability to correct invalid chains
-	q.headerResults = make([]*types.Header, len(skeleton)*MaxHeaderFetch)
-	q.headerHashes = make([]common.Hash, len(skeleton)*MaxHeaderFetch)
+	q.headerResults = make([]*types.Header, len(skeleton)*q.headerFetch)
+	q.headerHashes = make([]common.Hash, len(skeleton)*q.headerFetch)
+	q.headerPeers = make([]string, len(skeleton))
 	q.headerProced = 0
 	q.headerOffset = from
 	q.headerContCh = make(chan bool, 1)
 
 	for i, header := range skeleton {
-		index := from + uint64(i*MaxHeaderFetch)
+		index := from + uint64(i*q.headerFetch)
 
-		q.headerTaskPool[index] = header
+		q.headerTaskPool[index] = q.confirmTentative(header, header.Hash())
 		q.headerTaskQueue.Push(index, -int64(index))
 	}
 }
 
 // RetrieveHeaders retrieves the header chain assemble based on the scheduled
-// skeleton.
-func (q *queue) RetrieveHeaders() ([]*types.Header, []common.Hash, int) {
+// skeleton, along with the peers that delivered the unprocessed headers.
+func (q *queue) RetrieveHeaders() ([]*types.Header, []common.Hash, []string, int) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
 	headers, hashes, proced := q.headerResults, q.headerHashes, q.headerProced
-	q.headerResults, q.headerHashes, q.headerProced = nil, nil, 0
+	peers := q.headerDeliverers(proced, len(headers))
+	q.headerResults, q.headerHashes, q.headerPeers, q.headerProced = nil, nil, nil, 0
 
-	return headers, hashes, proced
+	return headers, hashes, peers, proced
+}
+
+// headerDeliverers returns the distinct peers that delivered the header batches
+// in the given range of the result cache.
+//
+// Note, this method expects the queue lock to be already held.
+func (q *queue) headerDeliverers(from, to int) []string {
+	var peers []string
+	for i := from / q.headerFetch; i < len(q.headerPeers) && i*q.headerFetch < to; i++ {
+		if id := q.headerPeers[i]; id != "" && !slices.Contains(peers, id) {
+			peers = append(peers, id)
+		}
+	}
+	return peers
 }
 
 // Schedule adds a set of headers for the download queue for scheduling, returning
@@ -310,7 +495,10 @@ func (q *queue) Schedule(headers []*types.Header, hashes []common.Hash, from uin
 	defer q.lock.Unlock()
 
 	// Insert all the headers prioritised by the contained block number
-	inserts := make([]*types.Header, 0, len(headers))
+	var (
+		inserts = make([]*types.Header, 0, len(headers))
+		now     = time.Now()
+	)
 	for i, header := range headers {
 		// Make sure chain order is honoured and preserved throughout
 		hash := hashes[i]
@@ -341,6 +529,7 @@ func (q *queue) Schedule(headers []*types.Header, hashes []common.Hash, from uin
 			}
 		}
 		inserts = append(inserts, header)
+		q.latency.schedule(now, hash)
 		q.headerHead = hash
 		from++
 	}
@@ -377,19 +566,12 @@ func (q *queue) Results(block bool) []*fetchResult {
 	}
 	// Regardless if closed or not, we can still deliver whatever we have
 	results := q.resultCache.GetCompleted(maxResultsProcess)
+	now := time.Now()
 	for _, result := range results {
+		q.latency.insert(now, result.Header.Hash())
+
 		// Recalculate the result item weights to prevent memory exhaustion
-		size := result.Header.Size()
-		for _, uncle := range result.Uncles {
-			size += uncle.Size()
-		}
-		for _, receipt := range result.Receipts {
-			size += receipt.Size()
-		}
-		for _, tx := range result.Transactions {
-			size += common.StorageSize(tx.Size())
-		}
-		size += common.StorageSize(result.Withdrawals.Size())
+		size := common.StorageSize(result.ByteSize())
 		q.resultSize = common.StorageSize(blockCacheSizeWeight)*size +
 			(1-common.StorageSize(blockCacheSizeWeight))*q.resultSize
 	}
@@ -524,6 +706,11 @@ func (q *queue) reserveHeaders(p *peerConnection, count int, taskPool map[common
 		// is also the lowest block number.
 		header, _ := taskQueue.Peek()
 
+		// Receipts may be limited to a narrower window than the result cache
+		if kind == receiptType && q.resultCache.IsAhead(header.Number.Uint64(), q.receiptLimit) {
+			throttled = len(skip) == 0
+			break
+		}
 		// we can ask the resultcache if this header is within the
 		// "prioritized" segment of blocks. If it is not, we need to throttle
 
@@ -705,7 +892,7 @@ func (q *queue) DeliverHeaders(id string, headers []*types.Header, hashes []comm
 	// Ensure headers can be mapped onto the skeleton chain
 	target := q.headerTaskPool[request.From].Hash()
 
-	accepted := len(headers) == MaxHeaderFetch
+	accepted := len(headers) == q.headerFetch
 	if accepted {
 		if headers[0].Number.Uint64() != request.From {
 			logger.Trace("First header broke chain ordering", "number", headers[0].Number, "hash", hashes[0], "expected", request.From)
@@ -751,12 +938,20 @@ func (q *queue) DeliverHeaders(id string, headers []*types.Header, hashes []comm
 	// Clean up a successful fetch and try to deliver any sub-results
 	copy(q.headerResults[request.From-q.headerOffset:], headers)
 	copy(q.headerHashes[request.From-q.headerOffset:], hashes)
+	q.headerPeers[(request.From-q.headerOffset)/uint64(q.headerFetch)] = id
+
+	if len(q.headerTentative) > 0 {
+		results := q.headerResults[request.From-q.headerOffset:]
+		for i, header := range headers {
+			results[i] = q.confirmTentative(header, hashes[i])
+		}
+	}
 
 	delete(q.headerTaskPool, request.From)
 
 	ready := 0
 	for q.headerProced+ready < len(q.headerResults) && q.headerResults[q.headerProced+ready] != nil {
-		ready += MaxHeaderFetch
+		ready += q.headerFetch
 	}
 	if ready > 0 {
 		// Headers are ready for delivery, gather them and push forward (non blocking)
@@ -766,14 +961,17 @@ func (q *queue) DeliverHeaders(id string, headers []*types.Header, hashes []comm
 		processHashes := make([]common.Hash, ready)
 		copy(processHashes, q.headerHashes[q.headerProced:q.headerProced+ready])
 
+		q.headerPooled.Add(int64(ready))
 		select {
 		case headerProcCh <- &headerTask{
 			headers: processHeaders,
 			hashes:  processHashes,
+			peers:   q.headerDeliverers(q.headerProced, q.headerProced+ready),
 		}:
 			logger.Trace("Pre-scheduled new headers", "count", len(processHeaders), "from", processHeaders[0].Number)
 			q.headerProced += len(processHeaders)
 		default:
+			q.headerPooled.Add(-int64(ready))
 		}
 	}
 	// Check for termination and return
@@ -783,6 +981,15 @@ func (q *queue) DeliverHeaders(id string, headers []*types.Header, hashes []comm
 	return len(headers), nil
 }
 
+// lazyBody is a delivered block body awaiting verification against its header,
+// along with the hashes derived from it on arrival.
+type lazyBody struct {
+	peer            string       // Peer that delivered the body
+	txHash          common.Hash  // Root hash of the transactions in the body
+	uncleHash       common.Hash  // Hash of the uncles in the body
+	withdrawalsHash *common.Hash // Root hash of the withdrawals in the body, if any
+}
+
 // DeliverBodies injects a block body retrieval response into the results queue.
 // The method returns the number of blocks bodies accepted from the delivery and
 // also wakes any threads waiting for data delivery.
@@ -794,23 +1001,18 @@ func (q *queue) DeliverBodies(id string, txLists [][]*types.Transaction, txListH
 	defer q.lock.Unlock()
 
 	validate := func(index int, header *types.Header) error {
-		if txListHashes[index] != header.TxHash {
-			return errInvalidBody
-		}
-		if uncleListHashes[index] != header.UncleHash {
+		if len(txLists[index]) > maxBodyTransactions {
 			return errInvalidBody
 		}
-		if header.WithdrawalsHash == nil {
-			// nil hash means that withdrawals should not be present in body
+		// The hashes were derived on arrival, only compare them against the header,
+		// unless the comparison is deferred to the import
+		if q.bodyVerification != LazyVerification {
+			var withdrawalsHash *common.Hash
 			if withdrawalLists[index] != nil {
-				return errInvalidBody
-			}
-		} else { // non-nil hash: body must have withdrawals
-			if withdrawalLists[index] == nil {
-				return errInvalidBody
+				withdrawalsHash = &withdrawalListHashes[index]
 			}
-			if withdrawalListHashes[index] != *header.WithdrawalsHash {
-				return errInvalidBody
+			if err := eth.CheckBodyHashes(header, txListHashes[index], uncleListHashes[index], withdrawalsHash); err != nil {
+				return fmt.Errorf("%w: %w", errInvalidBody, err)
 			}
 		}
 		// Blocks must have a number of blobs corresponding to the header gas usage,
@@ -860,11 +1062,49 @@ func (q *queue) DeliverBodies(id string, txLists [][]*types.Transaction, txListH
 		result.Withdrawals = withdrawalLists[index]
 		result.Sidecars = sidecars[index]
 		result.SetBodyDone()
+		if q.bodyVerification == LazyVerification {
+			body := &lazyBody{
+				peer:      id,
+				txHash:    txListHashes[index],
+				uncleHash: uncleListHashes[index],
+			}
+			if withdrawalLists[index] != nil {
+				body.withdrawalsHash = &withdrawalListHashes[index]
+			}
+			q.lazyBodies[result.Header.Hash()] = body
+		}
+		q.latency.deliver(time.Now(), result.Header.Hash())
 	}
 	return q.deliver(id, q.blockTaskPool, q.blockTaskQueue, q.blockPendPool,
 		bodyReqTimer, bodyInMeter, bodyDropMeter, len(txLists), validate, reconstruct)
 }
 
+// verifyBodies matches the lazily verified bodies of a batch of results against
+// their headers, returning the peer that delivered the first mismatching one.
+// The body hashes derived on delivery are reused, bodies verified on delivery
+// are skipped.
+func (q *queue) verifyBodies(results []*fetchResult) (string, error) {
+	bodies := make([]*lazyBody, len(results))
+
+	q.lock.Lock()
+	for i, result := range results {
+		hash := result.Header.Hash()
+		bodies[i] = q.lazyBodies[hash]
+		delete(q.lazyBodies, hash)
+	}
+	q.lock.Unlock()
+
+	for i, body := range bodies {
+		if body == nil {
+			continue
+		}
+		if err := eth.CheckBodyHashes(results[i].Header, body.txHash, body.uncleHash, body.withdrawalsHash); err != nil {
+			return body.peer, fmt.Errorf("%w: %w", errInvalidBody, err)
+		}
+	}
+	return "", nil
+}
+
 // DeliverReceipts injects a receipt retrieval response into the results queue.
 // The method returns the number of transaction receipts accepted from the delivery
 // and also wakes any threads waiting for data delivery.
@@ -937,6 +1177,7 @@ func (q *queue) deliver(id string, taskPool map[common.Hash]*types.Header,
 	for _, header := range request.Headers[:i] {
 		if res, stale, err := q.resultCache.GetDeliverySlot(header.Number.Uint64()); err == nil && !stale {
 			reconstruct(accepted, res)
+			q.resultCache.trackBytes(res)
 		} else {
 			// else: between here and above, some other peer filled this result,
 			// or it was indeed a no-op. This should not happen, but if it does it's
@@ -22,6 +22,7 @@ import (
 	"math/big"
 	"math/rand"
 	"os"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -261,6 +262,41 @@ func TestEmptyBlocks(t *testing.T) {
 	}
 }
 
+// Tests that results delivered by the queue are not retained by the result
+// cache, so they can be garbage collected once the chain insertion is done.
+func TestResultsReleased(t *testing.T) {
+	q := newQueue(10, 10)
+	q.Prepare(1, SnapSync)
+
+	headers := emptyChain.headers()
+	hashes := make([]common.Hash, len(headers))
+	for i, header := range headers {
+		hashes[i] = header.Hash()
+	}
+	q.Schedule(headers, hashes, 1)
+
+	// Empty blocks are completed as soon as they are reserved
+	q.ReserveBodies(dummyPeer("peer-1"), 50)
+	q.ReserveReceipts(dummyPeer("peer-2"), 50)
+
+	results := q.Results(false)
+	if len(results) != 10 {
+		t.Fatalf("wrong result count, got %d, exp %d", len(results), 10)
+	}
+	delivered := make(map[*fetchResult]struct{})
+	for _, result := range results {
+		delivered[result] = struct{}{}
+	}
+	q.resultCache.lock.RLock()
+	defer q.resultCache.lock.RUnlock()
+
+	for i, item := range q.resultCache.items {
+		if _, ok := delivered[item]; ok {
+			t.Errorf("delivered result #%d retained in cache slot %d", item.Header.Number, i)
+		}
+	}
+}
+
 // XTestDelivery does some more extensive testing of events that happen,
 // blocks that become known and peers that make reservations and deliveries.
 // disabled since it's not really a unit-test, but can be executed to test
@@ -472,3 +508,204 @@ func (n *network) headers(from int) []*types.Header {
 	}
 	return hdrs
 }
+
+// Tests that preloaded tentative headers are promoted when confirmed by the
+// skeleton or the delivered headers, and discarded on conflicts.
+func TestPreloadHeaders(t *testing.T) {
+	blocks, _ := makeChain(MaxHeaderFetch, 0, testGenesis, true)
+
+	headers := make([]*types.Header, len(blocks))
+	hashes := make([]common.Hash, len(blocks))
+	for i, block := range blocks {
+		headers[i], hashes[i] = block.Header(), block.Hash()
+	}
+	// Preload copies of a few headers, along with a conflicting one
+	var (
+		middle   = types.CopyHeader(headers[10])
+		last     = types.CopyHeader(headers[len(headers)-1])
+		conflict = types.CopyHeader(headers[20])
+	)
+	conflict.Extra = []byte("conflict")
+
+	q := newQueue(10, 10)
+	q.Preload([]*types.Header{middle, conflict, last})
+
+	// Schedule the skeleton and ensure the matching tentative header is promoted
+	q.ScheduleSkeleton(1, []*types.Header{headers[len(headers)-1]})
+	if q.headerTaskPool[1] != last {
+		t.Fatalf("skeleton header not promoted from the tentative set")
+	}
+	if len(q.headerTentative) != 2 {
+		t.Fatalf("tentative header count mismatch: have %d, want 2", len(q.headerTentative))
+	}
+	// Deliver the filling headers and ensure the tentative set is resolved
+	if req := q.ReserveHeaders(dummyPeer("peer"), 1); req == nil || req.From != 1 {
+		t.Fatalf("failed to reserve header batch: %v", req)
+	}
+	if n, err := q.DeliverHeaders("peer", headers, hashes, make(chan *headerTask, 1)); err != nil || n != len(headers) {
+		t.Fatalf("failed to deliver headers: have %d, %v, want %d", n, err, len(headers))
+	}
+	if q.headerResults[10] != middle {
+		t.Errorf("delivered header not promoted from the tentative set")
+	}
+	if q.headerResults[20] != headers[20] {
+		t.Errorf("conflicting tentative header not discarded")
+	}
+	if len(q.headerTentative) != 0 {
+		t.Errorf("tentative headers left over: %d", len(q.headerTentative))
+	}
+	// Ensure the tentative set is capped, dropping the lowest headers
+	overflow := make([]*types.Header, tentativeHeadersLimit+1)
+	for i := range overflow {
+		overflow[i] = &types.Header{Number: big.NewInt(int64(i))}
+	}
+	q.Preload(overflow[:1])
+	q.Preload(overflow[1:])
+	if len(q.headerTentative) != tentativeHeadersLimit {
+		t.Fatalf("tentative header count mismatch: have %d, want %d", len(q.headerTentative), tentativeHeadersLimit)
+	}
+	if _, ok := q.headerTentative[0]; ok {
+		t.Errorf("lowest tentative header not dropped")
+	}
+}
+
+// Tests that the result store stops starting new results once the cached ones
+// hold too much memory, independently of the item count based throttling.
+func TestResultStoreByteThrottling(t *testing.T) {
+	store := newResultStore(16)
+	store.Prepare(1)
+
+	headers := make([]*types.Header, 4)
+	for i := range headers {
+		headers[i] = &types.Header{Number: big.NewInt(int64(i + 1)), Difficulty: common.Big1}
+	}
+	// Deliver a body into the first result, making the store exceed its limit
+	_, throttled, item, err := store.AddFetch(headers[0], false, "peer")
+	if err != nil || throttled {
+		t.Fatalf("first result not added: throttled %v, err %v", throttled, err)
+	}
+	for i := 0; i < 16; i++ {
+		item.Transactions = append(item.Transactions, types.NewTransaction(uint64(i), common.Address{}, common.Big1, 21000, common.Big1, make([]byte, 1024)))
+	}
+	store.trackBytes(item)
+	item.SetBodyDone()
+
+	if have, want := store.totalBytes(), item.ByteSize(); have != want {
+		t.Fatalf("tracked bytes mismatch: have %d, want %d", have, want)
+	}
+	store.maxBytes = store.totalBytes() - 1
+
+	// New results should be throttled even though far from the item threshold,
+	// but results already being fetched must not be
+	if _, throttled, _, _ := store.AddFetch(headers[1], false, "peer"); !throttled {
+		t.Fatalf("new result not throttled above the byte limit")
+	}
+	if _, throttled, _, _ := store.AddFetch(headers[0], false, "peer"); throttled {
+		t.Fatalf("existing result throttled above the byte limit")
+	}
+	// Delivering the results should release the memory and lift the throttling
+	if results := store.GetCompleted(maxResultsProcess); len(results) != 1 {
+		t.Fatalf("completed result count mismatch: have %d, want 1", len(results))
+	}
+	if have := store.totalBytes(); have != 0 {
+		t.Fatalf("bytes not released: have %d", have)
+	}
+	if _, throttled, _, _ := store.AddFetch(headers[1], false, "peer"); throttled {
+		t.Fatalf("new result throttled below the byte limit")
+	}
+}
+
+// Tests that the queue depths report the share of the result slots in use,
+// measured against the limits of the body and receipt retrievals.
+func TestQueueDepth(t *testing.T) {
+	q := newQueue(16, 16)
+	q.Prepare(1, SnapSync)
+	q.SetReceiptLimit(8)
+
+	if body, receipt := q.BodyQueueDepth(), q.ReceiptQueueDepth(); body != 0 || receipt != 0 {
+		t.Fatalf("depths mismatch on empty queue: have %d/%d, want 0/0", body, receipt)
+	}
+	for i := 1; i <= 4; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i)), Difficulty: common.Big1}
+		if _, throttled, _, err := q.resultCache.AddFetch(header, true, "peer"); err != nil || throttled {
+			t.Fatalf("result %d not added: throttled %v, err %v", i, throttled, err)
+		}
+	}
+	if body, receipt := q.BodyQueueDepth(), q.ReceiptQueueDepth(); body != 25 || receipt != 50 {
+		t.Fatalf("depths mismatch: have %d/%d, want 25/50", body, receipt)
+	}
+	// Depths are capped to the limits, even if more slots are in use
+	q.SetReceiptLimit(2)
+	if receipt := q.ReceiptQueueDepth(); receipt != 100 {
+		t.Fatalf("receipt depth mismatch above the limit: have %d, want 100", receipt)
+	}
+}
+
+// Tests that only the results ready for import, up to the first incomplete one,
+// are reported as pending.
+func TestQueueResultsPending(t *testing.T) {
+	q := newQueue(16, 16)
+	q.Prepare(1, FullSync)
+
+	var items []*fetchResult
+	for i := 1; i <= 4; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i)), Difficulty: common.Big1}
+		_, _, item, err := q.resultCache.AddFetch(header, false, "peer")
+		if err != nil {
+			t.Fatalf("result %d not added: %v", i, err)
+		}
+		items = append(items, item)
+	}
+	if pending := q.ResultsPending(); pending != 0 {
+		t.Fatalf("pending results mismatch: have %d, want 0", pending)
+	}
+	items[0].SetBodyDone()
+	items[1].SetBodyDone()
+	items[3].SetBodyDone()
+	if pending := q.ResultsPending(); pending != 2 {
+		t.Fatalf("pending results mismatch: have %d, want 2", pending)
+	}
+}
+
+// Tests that the peers filling in a skeleton are tracked along the headers they
+// delivered, both when pushed to the processor and when retrieved after a fill.
+func TestHeaderDeliverers(t *testing.T) {
+	blocks, _ := makeChain(3*MaxHeaderFetch, 0, testGenesis, true)
+	headers := make([]*types.Header, len(blocks))
+	hashes := make([]common.Hash, len(blocks))
+	for i, block := range blocks {
+		headers[i], hashes[i] = block.Header(), block.Hash()
+	}
+	q := newQueue(10, 10)
+	q.ScheduleSkeleton(1, []*types.Header{
+		headers[MaxHeaderFetch-1], headers[2*MaxHeaderFetch-1], headers[3*MaxHeaderFetch-1],
+	})
+	deliver := func(id string, batch int, proc chan *headerTask) {
+		t.Helper()
+		if req := q.ReserveHeaders(dummyPeer(id), 1); req == nil || req.From != uint64(1+batch*MaxHeaderFetch) {
+			t.Fatalf("failed to reserve header batch %d: %v", batch, req)
+		}
+		from, to := batch*MaxHeaderFetch, (batch+1)*MaxHeaderFetch
+		if _, err := q.DeliverHeaders(id, headers[from:to], hashes[from:to], proc); err != nil {
+			t.Fatalf("failed to deliver header batch %d: %v", batch, err)
+		}
+	}
+	// Deliver the first two batches, the first pushed to the processor alone
+	proc := make(chan *headerTask, 1)
+	deliver("A", 0, proc)
+	deliver("B", 1, make(chan *headerTask)) // Processor busy, nothing pushed
+	task := <-proc
+	if !slices.Equal(task.peers, []string{"A"}) {
+		t.Errorf("processed deliverers mismatch: have %v, want [A]", task.peers)
+	}
+	// Deliver the last batch from the first peer again, the remaining headers
+	// must be attributed to both peers that delivered them
+	deliver("A", 2, make(chan *headerTask))
+	_, _, peers, proced := q.RetrieveHeaders()
+	if proced != MaxHeaderFetch {
+		t.Fatalf("processed header count mismatch: have %d, want %d", proced, MaxHeaderFetch)
+	}
+	if !slices.Equal(peers, []string{"B", "A"}) {
+		t.Errorf("remaining deliverers mismatch: have %v, want [B A]", peers)
+	}
+}
@@ -42,6 +42,12 @@ type resultStore struct {
 	// this index.
 	throttleThreshold uint64
 
+	// bytes is the memory held by the cached results, as accounted on their
+	// deliveries. Once above maxBytes, no new results are started, regardless
+	// of the throttle threshold.
+	bytes    int
+	maxBytes int
+
 	lock sync.RWMutex
 }
 
@@ -50,6 +56,7 @@ func newResultStore(size int) *resultStore {
 		resultOffset:      0,
 		items:             make([]*fetchResult, size),
 		throttleThreshold: uint64(size),
+		maxBytes:          blockCacheMaxBytes,
 	}
 }
 
@@ -82,16 +89,65 @@ func (r *resultStore) AddFetch(header *types.Header, fastSync bool, pid string)
 
 	var index int
 	item, index, stale, throttled, err = r.getFetchResult(header.Number.Uint64())
+	if err == nil && !stale && item == nil && index > 0 && r.bytes > r.maxBytes {
+		// Don't start new results while the cached ones hold too much memory,
+		// but never hold up the next result to be delivered
+		throttled = true
+	}
 	if err != nil || stale || throttled {
 		return stale, throttled, item, err
 	}
 	if item == nil {
 		item = newFetchResult(header, fastSync, pid)
 		r.items[index] = item
+
+		item.accounted = item.ByteSize()
+		r.bytes += item.accounted
 	}
 	return stale, throttled, item, err
 }
 
+// trackBytes updates the memory accounted for a result after a delivery into it.
+func (r *resultStore) trackBytes(item *fetchResult) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	size := item.ByteSize()
+	r.bytes += size - item.accounted
+	item.accounted = size
+}
+
+// totalBytes returns the memory held by the cached results.
+func (r *resultStore) totalBytes() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.bytes
+}
+
+// usage returns the number of result slots in use, counted from the delivery
+// offset up to the furthest one taken, along with the throttle threshold.
+func (r *resultStore) usage() (int, int) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for i := len(r.items); i > 0; i-- {
+		if r.items[i-1] != nil {
+			return i, int(r.throttleThreshold)
+		}
+	}
+	return 0, int(r.throttleThreshold)
+}
+
+// IsAhead reports whether the given header is at least limit items ahead of the
+// next result to be delivered.
+func (r *resultStore) IsAhead(headerNumber uint64, limit int) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return int64(headerNumber)-int64(r.resultOffset) >= int64(limit)
+}
+
 // GetDeliverySlot returns the fetchResult for the given header. If the 'stale' flag
 // is true, that means the header has already been delivered 'upstream'. This method
 // does not bubble up the 'throttle' flag, since it's moot at the point in time when
@@ -139,6 +195,14 @@ func (r *resultStore) HasCompletedItems() bool {
 	return false
 }
 
+// CountCompleted returns the number of items ready for delivery.
+func (r *resultStore) CountCompleted() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.countCompleted()
+}
+
 // countCompleted returns the number of items ready for delivery, stopping at
 // the first non-complete item.
 //
@@ -171,6 +235,9 @@ func (r *resultStore) GetCompleted(limit int) []*fetchResult {
 	}
 	results := make([]*fetchResult, limit)
 	copy(results, r.items[:limit])
+	for _, result := range results {
+		r.bytes -= result.accounted
+	}
 
 	// Delete the results from the cache and clear the tail.
 	copy(r.items, r.items[limit:])
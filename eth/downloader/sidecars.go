@@ -0,0 +1,60 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// BlobSidecarStore is an external storage for the blob sidecars of the blocks
+// retrieved during sync, e.g. a dedicated blob archive.
+type BlobSidecarStore interface {
+	// Store persists the blob sidecars of the block with the given hash.
+	Store(blockHash common.Hash, sidecars []*types.BlobSidecar) error
+
+	// Get retrieves the blob sidecars of the block with the given hash.
+	Get(blockHash common.Hash) ([]*types.BlobSidecar, error)
+}
+
+// WithBlobSidecarStore sets the store the blob sidecars of the downloaded blocks
+// are written into, before the blocks are imported into the local chain. The
+// sidecars are sent by the peers along with the block bodies. The store must be
+// set before the first synchronisation is started.
+func (d *Downloader) WithBlobSidecarStore(store BlobSidecarStore) {
+	d.sidecarStore = store
+}
+
+// storeSidecars writes the blob sidecars of the given results into the sidecar
+// store, if any is set. Blocks without blob transactions are skipped.
+func (d *Downloader) storeSidecars(results []*fetchResult) error {
+	if d.sidecarStore == nil {
+		return nil
+	}
+	for _, result := range results {
+		if len(result.Sidecars) == 0 {
+			continue
+		}
+		hash := result.Header.Hash()
+		if err := d.sidecarStore.Store(hash, result.Sidecars); err != nil {
+			return fmt.Errorf("failed to store blob sidecars of #%d [%x]: %w", result.Header.Number, hash.Bytes()[:4], err)
+		}
+	}
+	return nil
+}
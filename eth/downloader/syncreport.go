@@ -0,0 +1,75 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+)
+
+// SyncReport is a snapshot of the status of the downloader, gathering everything
+// needed to display the sync progress in a single place.
+type SyncReport struct {
+	Mode                SyncMode              // Sync mode of the running sync cycle, NoSync if idle
+	Active              bool                  // Whether a sync cycle is running
+	Peer                string                // Identifier of the peer being synced from
+	StartTime           time.Time             // Time the running sync cycle started at
+	Duration            time.Duration         // Time elapsed since the sync cycle started
+	HeadersPerSec       float64               // Headers delivered per second by all peers
+	BodiesPerSec        float64               // Bodies of average size delivered per second
+	QueueDepth          int                   // Downloaded blocks waiting to be imported
+	Progress            ethereum.SyncProgress // Chain and state sync progress
+	EstimatedCompletion time.Duration         // Estimated time left until the sync completes, -1 if unknown
+}
+
+// SyncReport gathers the current status of the downloader. The fields describing
+// the running sync cycle are zero if no sync is running.
+func (d *Downloader) SyncReport() SyncReport {
+	report := SyncReport{
+		Mode:                d.CurrentMode(),
+		Active:              d.synchronising.Load(),
+		Peer:                d.SyncPeer(),
+		BodiesPerSec:        d.ExpectedBodiesPerSecond(),
+		QueueDepth:          d.QueueResultsPending(),
+		Progress:            d.Progress(),
+		EstimatedCompletion: -1,
+	}
+	for _, p := range d.peers.AllPeers() {
+		report.HeadersPerSec += p.HeaderFetchRate()
+	}
+	if start := d.syncCycleStart.Load(); start != 0 {
+		report.StartTime = time.Unix(0, start)
+		report.Duration = time.Since(report.StartTime)
+	}
+	// Extrapolate the time needed for the remaining blocks from the blocks synced
+	// so far in this cycle. Snap sync also needs the state, so take the state ETA
+	// if that one takes longer.
+	var (
+		origin  = report.Progress.StartingBlock
+		current = report.Progress.CurrentBlock
+		highest = report.Progress.HighestBlock
+	)
+	if report.Duration > 0 && current > origin && highest >= current {
+		report.EstimatedCompletion = time.Duration(float64(report.Duration) * float64(highest-current) / float64(current-origin))
+	}
+	if report.Mode == ethconfig.SnapSync {
+		report.EstimatedCompletion = max(report.EstimatedCompletion, d.SnapSyncETA())
+	}
+	return report
+}
@@ -0,0 +1,101 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// SetSyncSource configures the peers to voluntarily prefer and avoid when picking
+// the peer to synchronise with. Preferred peers are used whenever they are at
+// least as far ahead as the suggested one, avoided peers are only used if there
+// is no other peer ahead of the local chain. Avoiding a peer is not a penalty,
+// it remains connected and keeps serving data during the sync.
+//
+// Each call replaces the previously configured sets.
+func (d *Downloader) SetSyncSource(prefer []string, avoid []string) {
+	preferred := make(map[string]struct{}, len(prefer))
+	for _, id := range prefer {
+		preferred[id] = struct{}{}
+	}
+	avoided := make(map[string]struct{}, len(avoid))
+	for _, id := range avoid {
+		avoided[id] = struct{}{}
+	}
+	d.syncSourceLock.Lock()
+	d.preferredPeers, d.avoidedPeers = preferred, avoided
+	d.syncSourceLock.Unlock()
+}
+
+// selectSyncPeer picks the peer to synchronise with based on the configured
+// preferences, given the one suggested by the caller. The returned values are
+// the identifier, head hash and total difficulty of the selected peer.
+func (d *Downloader) selectSyncPeer(id string, head common.Hash, td *big.Int, mode SyncMode) (string, common.Hash, *big.Int) {
+	d.syncSourceLock.RLock()
+	defer d.syncSourceLock.RUnlock()
+
+	if len(d.preferredPeers) == 0 && len(d.avoidedPeers) == 0 {
+		return id, head, td
+	}
+	if _, ok := d.preferredPeers[id]; ok {
+		return id, head, td
+	}
+	// Find the best preferred peer and the best not avoided peer as fallback
+	var (
+		prefID, altID     string
+		prefHead, altHead common.Hash
+		prefTD, altTD     *big.Int
+	)
+	for _, peer := range d.peers.AllPeers() {
+		if _, ok := d.avoidedPeers[peer.id]; ok {
+			continue
+		}
+		hash, ptd := peer.peer.Head()
+		if ptd == nil {
+			continue
+		}
+		if _, ok := d.preferredPeers[peer.id]; ok && (prefTD == nil || ptd.Cmp(prefTD) > 0) {
+			prefID, prefHead, prefTD = peer.id, hash, ptd
+		}
+		if altTD == nil || ptd.Cmp(altTD) > 0 {
+			altID, altHead, altTD = peer.id, hash, ptd
+		}
+	}
+	if prefTD != nil && prefTD.Cmp(td) >= 0 {
+		log.Debug("Synchronising with preferred peer", "suggested", id, "peer", prefID)
+		return prefID, prefHead, prefTD
+	}
+	if _, ok := d.avoidedPeers[id]; !ok {
+		return id, head, td
+	}
+	// The suggested peer is avoided, replace it if anyone else is ahead of us
+	local := d.blockchain.CurrentBlock()
+	if mode == ethconfig.SnapSync {
+		local = d.blockchain.CurrentSnapBlock()
+	}
+	if altTD != nil {
+		if ltd := d.blockchain.GetTd(local.Hash(), local.Number.Uint64()); ltd == nil || altTD.Cmp(ltd) > 0 {
+			log.Debug("Avoiding synchronisation with peer", "suggested", id, "peer", altID)
+			return altID, altHead, altTD
+		}
+	}
+	return id, head, td
+}
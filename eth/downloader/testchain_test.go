@@ -95,8 +95,12 @@ func init() {
 		testChainBase.shorten(800 / 6),
 		testChainBase.shorten(800 / 7),
 		testChainBase.shorten(800 / 8),
+		testChainBase.shorten(201),
 		testChainBase.shorten(3*fsHeaderSafetyNet + 256 + fsMinFullBlocks),
 		testChainBase.shorten(fsMinFullBlocks + 256 - 1),
+		testChainBase.shorten(reorgProtHeaderDelay),
+		testChainBase.shorten(reorgProtHeaderDelay + 1),
+		testChainBase.shorten(reorgProtHeaderDelay + 2),
 		testChainForkLightA.shorten(len(testChainBase.blocks) + 80),
 		testChainForkLightB.shorten(len(testChainBase.blocks) + 81),
 		testChainForkLightA.shorten(len(testChainBase.blocks) + MaxHeaderFetch),
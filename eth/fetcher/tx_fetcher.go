@@ -17,19 +17,28 @@
 package fetcher
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"math"
 	mrand "math/rand"
+	"slices"
 	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/gopool"
 	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/common/prque"
 	"github.com/ethereum/go-ethereum/core/txpool"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 )
@@ -60,9 +69,25 @@ const (
 	// re-request them.
 	maxTxUnderpricedSetSize = 32768
 
+	// maxTxReplaySetSize is the number of recently announced transactions tracked
+	// per peer to detect announcement replays.
+	maxTxReplaySetSize = 4096
+
+	// maxTxOriginSetSize is the number of transactions for which the peer that
+	// first announced them is remembered.
+	maxTxOriginSetSize = 65536
+
+	// txReplayWindow is the time within which a transaction re-announced by the
+	// same peer is considered a replay and ignored.
+	txReplayWindow = 60 * time.Second
+
 	// maxTxUnderpricedTimeout is the max time a transaction should be stuck in the underpriced set.
 	maxTxUnderpricedTimeout = 5 * time.Minute
 
+	// purgedTxUnderpricedTimeout is the time a purged transaction is tracked in
+	// the underpriced set to avoid an immediate re-announcement.
+	purgedTxUnderpricedTimeout = time.Minute
+
 	// txArriveTimeout is the time allowance before an announced transaction is
 	// explicitly requested.
 	txArriveTimeout = 500 * time.Millisecond
@@ -70,12 +95,32 @@ const (
 	// txGatherSlack is the interval used to collate almost-expired announces
 	// with network fetches.
 	txGatherSlack = 100 * time.Millisecond
+
+	// partitionWindow is the time window over which the delivered and timed out
+	// transaction retrievals are compared to detect a network partition.
+	partitionWindow = time.Minute
+
+	// partitionMinRetrievals is the number of retrievals that need to conclude
+	// within a window before the partition detector passes a verdict.
+	partitionMinRetrievals = 20
+
+	// partitionDeliveryRatio is the ratio of delivered to timed out retrievals,
+	// below which the node is suspected to be partitioned from the network.
+	partitionDeliveryRatio = 0.1
+
+	// txEventBuffer is the number of feed events buffered for delivery to the
+	// subscribers. Events posted while the buffer is full are dropped.
+	txEventBuffer = 1024
 )
 
 var (
 	// txFetchTimeout is the maximum allotted time to return an explicitly
 	// requested transaction.
 	txFetchTimeout = 5 * time.Second
+
+	// shutdownPollInterval is the frequency at which a graceful shutdown checks
+	// whether all the in-flight retrievals completed.
+	shutdownPollInterval = 10 * time.Millisecond
 )
 
 var (
@@ -83,6 +128,7 @@ var (
 	txAnnounceKnownMeter       = metrics.NewRegisteredMeter("eth/fetcher/transaction/announces/known", nil)
 	txAnnounceUnderpricedMeter = metrics.NewRegisteredMeter("eth/fetcher/transaction/announces/underpriced", nil)
 	txAnnounceDOSMeter         = metrics.NewRegisteredMeter("eth/fetcher/transaction/announces/dos", nil)
+	txAnnounceReplayMeter      = metrics.NewRegisteredMeter("eth/fetcher/transaction/announces/replay", nil)
 
 	txBroadcastInMeter          = metrics.NewRegisteredMeter("eth/fetcher/transaction/broadcasts/in", nil)
 	txBroadcastKnownMeter       = metrics.NewRegisteredMeter("eth/fetcher/transaction/broadcasts/known", nil)
@@ -103,11 +149,36 @@ var (
 	txFetcherWaitingHashes  = metrics.NewRegisteredGauge("eth/fetcher/transaction/waiting/hashes", nil)
 	txFetcherQueueingPeers  = metrics.NewRegisteredGauge("eth/fetcher/transaction/queueing/peers", nil)
 	txFetcherQueueingHashes = metrics.NewRegisteredGauge("eth/fetcher/transaction/queueing/hashes", nil)
+	txFetcherQueueAge       = metrics.NewRegisteredResettingTimer("eth/fetcher/transaction/queueing/age", nil)
 	txFetcherFetchingPeers  = metrics.NewRegisteredGauge("eth/fetcher/transaction/fetching/peers", nil)
 	txFetcherFetchingHashes = metrics.NewRegisteredGauge("eth/fetcher/transaction/fetching/hashes", nil)
+
+	txFetcherEventDropMeter = metrics.NewRegisteredMeter("eth/fetcher/transaction/events/drop", nil)
 )
 
-var errTerminated = errors.New("terminated")
+var (
+	errTerminated = errors.New("terminated")
+
+	// ErrShutdownTimeout is returned if a graceful shutdown could not wait for
+	// all the in-flight retrievals to complete.
+	ErrShutdownTimeout = errors.New("shutdown timed out")
+)
+
+// ShutdownTimeoutError is returned by Shutdown if the context expired before all
+// the in-flight retrievals completed, carrying the hashes still being fetched.
+type ShutdownTimeoutError struct {
+	InflightHashes []common.Hash
+}
+
+// Error implements error, reporting the number of retrievals left.
+func (e *ShutdownTimeoutError) Error() string {
+	return fmt.Sprintf("%v: %d transactions in flight", ErrShutdownTimeout, len(e.InflightHashes))
+}
+
+// Unwrap returns ErrShutdownTimeout for errors.Is checks.
+func (e *ShutdownTimeoutError) Unwrap() error {
+	return ErrShutdownTimeout
+}
 
 // txAnnounce is the notification of the availability of a batch
 // of new transactions in the network.
@@ -117,6 +188,15 @@ type txAnnounce struct {
 	metas  []txMetadata  // Batch of metadata associated with the hashes
 }
 
+// TxAnnounce is a single peer's announcement of transactions, as passed to
+// NotifyBatch. The fields mirror the arguments of Notify.
+type TxAnnounce struct {
+	Peer   string        // Identifier of the peer originating the announcement
+	Types  []byte        // Consensus types of the announced transactions
+	Sizes  []uint32      // Sizes of the announced transactions in bytes
+	Hashes []common.Hash // Hashes of the announced transactions
+}
+
 // txMetadata provides the extra data transmitted along with the announcement
 // for better fetch scheduling.
 type txMetadata struct {
@@ -150,7 +230,107 @@ type txDelivery struct {
 
 // txDrop is the notification that a peer has disconnected.
 type txDrop struct {
-	peer string
+	peer  string
+	drain bool               // Whether the peer is removed gracefully and lost hashes should be reported
+	lost  chan []common.Hash // Channel to report hashes with no alternate origin (drain only)
+}
+
+// txPurge is a request to remove stale transactions from the waitlist and the
+// retrieval queue.
+type txPurge struct {
+	maxAge  time.Duration // Maximum time a transaction may wait before being purged
+	removed chan int      // Channel to report the number of purged transactions
+}
+
+// txFlush is a request to finalise all the transactions not being retrieved.
+type txFlush struct {
+	fetched int           // Number of flushed transactions already in the pool
+	dropped int           // Number of flushed transactions dropped
+	done    chan struct{} // Channel to signal the flush completed
+}
+
+// txStats is a request to inspect the internal state of the fetcher. The query
+// is executed on the event loop to avoid racing with it.
+type txStats struct {
+	query func()        // Inspection to run on the event loop
+	done  chan struct{} // Channel to signal the inspection completed
+}
+
+// PartitionEvent is posted when most transaction retrievals time out within the
+// detection window, hinting that the node is partitioned from the network.
+type PartitionEvent struct {
+	Delivered int // Number of transactions delivered within the window
+	TimedOut  int // Number of transaction retrievals timed out within the window
+}
+
+// partitionDetector tracks the delivered and timed out transaction retrievals in
+// fixed time windows to detect if the node is cut off from the network.
+type partitionDetector struct {
+	start     mclock.AbsTime // Start of the current detection window
+	delivered int            // Number of transactions delivered in the window
+	timedOut  int            // Number of transaction retrievals timed out in the window
+	alerted   bool           // Whether a partition was already reported in the window
+}
+
+// track accounts the delivered and timed out retrievals, rolling over to a new
+// window if the current one expired. It returns whether a partition should be
+// reported, which happens at most once per window.
+func (d *partitionDetector) track(now mclock.AbsTime, delivered, timedOut int) bool {
+	if time.Duration(now-d.start) > partitionWindow {
+		*d = partitionDetector{start: now}
+	}
+	d.delivered += delivered
+	d.timedOut += timedOut
+
+	if d.alerted || d.delivered+d.timedOut < partitionMinRetrievals || d.timedOut == 0 {
+		return false
+	}
+	if float64(d.delivered)/float64(d.timedOut) >= partitionDeliveryRatio {
+		return false
+	}
+	d.alerted = true
+	return true
+}
+
+// TxFetcherStage is a stage of the transaction fetcher's state machine a tracked
+// transaction hash may be in.
+type TxFetcherStage int
+
+const (
+	EventNone      TxFetcherStage = iota // Hash not tracked by the fetcher
+	EventWaiting                         // Hash waiting for a potential broadcast
+	EventQueued                          // Hash queued for retrieval
+	EventFetching                        // Hash being retrieved from a peer
+	EventDelivered                       // Transaction delivered, hash no longer tracked
+	EventDropped                         // Hash dropped without delivery, no longer tracked
+)
+
+// String implements fmt.Stringer.
+func (stage TxFetcherStage) String() string {
+	switch stage {
+	case EventNone:
+		return "none"
+	case EventWaiting:
+		return "waiting"
+	case EventQueued:
+		return "queued"
+	case EventFetching:
+		return "fetching"
+	case EventDelivered:
+		return "delivered"
+	case EventDropped:
+		return "dropped"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(stage))
+	}
+}
+
+// TxFetcherStateEvent is posted when a transaction hash transitions between two
+// stages of the fetcher.
+type TxFetcherStateEvent struct {
+	Hash common.Hash
+	Old  TxFetcherStage
+	New  TxFetcherStage
 }
 
 // TxFetcher is responsible for retrieving new transaction based on announcements.
@@ -171,14 +351,30 @@ type txDrop struct {
 //     only ever one concurrently. This ensures we can immediately know what is
 //     missing from a reply and reschedule it.
 type TxFetcher struct {
-	notify  chan *txAnnounce
-	cleanup chan *txDelivery
-	drop    chan *txDrop
-	quit    chan struct{}
+	notify      chan *txAnnounce
+	notifyBatch chan []*txAnnounce
+	directFetch chan *txAnnounce
+	cleanup     chan *txDelivery
+	drop        chan *txDrop
+	purge       chan *txPurge
+	flush       chan *txFlush
+	stats       chan *txStats
+	quit        chan struct{}
+	stop        sync.Once
 
 	txSeq       uint64                             // Unique transaction sequence number
 	underpriced *lru.Cache[common.Hash, time.Time] // Transactions discarded as too cheap (don't re-fetch)
 
+	recentAnnounces map[string]*lru.Cache[common.Hash, mclock.AbsTime] // Recently announced transactions, grouped by peer (loop owned)
+	replayed        int                                                // Number of re-announced transactions ignored (loop owned)
+
+	directPeers map[string]struct{} // Peers whose announcements are fetched right away, skipping the wait and queue stages
+	directLock  sync.RWMutex        // Protects the set of direct peers
+
+	firstAnnouncer map[common.Hash]string // Peers that first announced a transaction, for gossip analysis
+	announceOrder  []common.Hash          // Ring of hashes in firstAnnouncer, in insertion order (FIFO eviction)
+	announceNext   int                    // Position of the oldest hash in the announce order ring once full
+
 	// Stage 1: Waiting lists for newly discovered transactions that might be
 	// broadcast without needing explicit request/reply round trips.
 	waitlist  map[common.Hash]map[string]struct{}           // Transactions waiting for an potential broadcast
@@ -189,6 +385,7 @@ type TxFetcher struct {
 	// to be retrieved directly.
 	announces map[string]map[common.Hash]*txMetadataWithSeq // Set of announced transactions, grouped by origin peer
 	announced map[common.Hash]map[string]struct{}           // Set of download locations, grouped by transaction hash
+	queuetime map[common.Hash]mclock.AbsTime                // Timestamps when transactions were queued for retrieval
 
 	// Stage 3: Set of transactions currently being retrieved, some which may be
 	// fulfilled and some rescheduled. Note, this step shares 'announces' from the
@@ -202,16 +399,107 @@ type TxFetcher struct {
 	addTxs   func(string, []*types.Transaction) []error // Insert a batch of transactions into local txpool
 	fetchTxs func(string, []common.Hash) error          // Retrieves a set of txs from a remote peer
 	dropPeer func(string)                               // Drops a peer in case of announcement violation
+	ackTxs   func(string, []common.Hash)                // Acknowledges transactions successfully added to the txpool (optional)
+
+	maxAnnounces int           // Maximum number of unique transactions a peer can announce
+	replayWindow time.Duration // Time within which re-announcements from the same peer are ignored
+
+	events      event.Feed               // Feed of hash state transitions (TxFetcherStateEvent)
+	stateEvents chan TxFetcherStateEvent // Buffer of state transitions pending delivery to the feed
+
+	partition       partitionDetector   // Detector of network partitions based on retrieval timeouts
+	partitionFeed   event.Feed          // Feed of suspected network partitions (PartitionEvent)
+	partitionEvents chan PartitionEvent // Buffer of suspected partitions pending delivery to the feed
 
 	step  chan struct{} // Notification channel when the fetcher loop iterates
 	clock mclock.Clock  // Time wrapper to simulate in tests
 	rand  *mrand.Rand   // Randomizer to use in tests instead of map range loops (soft-random)
 }
 
+// TxFetcherOption is a functional option to configure a TxFetcher.
+type TxFetcherOption func(*TxFetcher)
+
+// WithHasTx sets the callback used to check whether a transaction is already
+// known by the local txpool.
+func WithHasTx(hasTx func(common.Hash) bool) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.hasTx = hasTx
+	}
+}
+
+// WithAddTxs sets the callback used to insert a batch of transactions into the
+// local txpool.
+func WithAddTxs(addTxs func(string, []*types.Transaction) []error) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.addTxs = addTxs
+	}
+}
+
+// WithFetchTxs sets the callback used to retrieve a set of transactions from a
+// remote peer.
+func WithFetchTxs(fetchTxs func(string, []common.Hash) error) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.fetchTxs = fetchTxs
+	}
+}
+
+// WithDropPeer sets the callback used to drop a peer in case of announcement
+// violation.
+func WithDropPeer(dropPeer func(string)) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.dropPeer = dropPeer
+	}
+}
+
+// WithAckTxs sets the callback used to acknowledge the transactions delivered
+// by a peer that were successfully inserted into the local txpool.
+func WithAckTxs(ackTxs func(string, []common.Hash)) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.ackTxs = ackTxs
+	}
+}
+
+// WithClock overrides the realtime clock, mostly to use a simulated one in tests.
+func WithClock(clock mclock.Clock) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.clock = clock
+	}
+}
+
+// WithRand sets a randomizer to iterate peers deterministically. It is only
+// meant to be used in tests, production code should leave it nil.
+func WithRand(rand *mrand.Rand) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.rand = rand
+	}
+}
+
+// WithMaxAnnounces overrides the maximum number of unique transactions a peer
+// can announce before further announcements get dropped.
+func WithMaxAnnounces(limit int) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.maxAnnounces = limit
+	}
+}
+
+// WithReplayWindow overrides the time within which a transaction re-announced
+// by the same peer is ignored. A non-positive window disables replay detection.
+func WithReplayWindow(window time.Duration) TxFetcherOption {
+	return func(f *TxFetcher) {
+		f.replayWindow = window
+	}
+}
+
 // NewTxFetcher creates a transaction fetcher to retrieve transaction
-// based on hash announcements.
+// based on hash announcements. It is kept for backwards compatibility, new
+// code should use NewTxFetcherWithOptions instead.
 func NewTxFetcher(hasTx func(common.Hash) bool, addTxs func(string, []*types.Transaction) []error, fetchTxs func(string, []common.Hash) error, dropPeer func(string)) *TxFetcher {
-	return NewTxFetcherForTests(hasTx, addTxs, fetchTxs, dropPeer, mclock.System{}, nil)
+	return NewTxFetcherWithOptions(
+		WithHasTx(hasTx),
+		WithAddTxs(addTxs),
+		WithFetchTxs(fetchTxs),
+		WithDropPeer(dropPeer),
+	)
 }
 
 // NewTxFetcherForTests is a testing method to mock out the realtime clock with
@@ -219,32 +507,156 @@ func NewTxFetcher(hasTx func(common.Hash) bool, addTxs func(string, []*types.Tra
 func NewTxFetcherForTests(
 	hasTx func(common.Hash) bool, addTxs func(string, []*types.Transaction) []error, fetchTxs func(string, []common.Hash) error, dropPeer func(string),
 	clock mclock.Clock, rand *mrand.Rand) *TxFetcher {
-	return &TxFetcher{
-		notify:      make(chan *txAnnounce),
-		cleanup:     make(chan *txDelivery),
-		drop:        make(chan *txDrop),
-		quit:        make(chan struct{}),
-		waitlist:    make(map[common.Hash]map[string]struct{}),
-		waittime:    make(map[common.Hash]mclock.AbsTime),
-		waitslots:   make(map[string]map[common.Hash]*txMetadataWithSeq),
-		announces:   make(map[string]map[common.Hash]*txMetadataWithSeq),
-		announced:   make(map[common.Hash]map[string]struct{}),
-		fetching:    make(map[common.Hash]string),
-		requests:    make(map[string]*txRequest),
-		alternates:  make(map[common.Hash]map[string]struct{}),
-		underpriced: lru.NewCache[common.Hash, time.Time](maxTxUnderpricedSetSize),
-		hasTx:       hasTx,
-		addTxs:      addTxs,
-		fetchTxs:    fetchTxs,
-		dropPeer:    dropPeer,
-		clock:       clock,
-		rand:        rand,
+	return NewTxFetcherWithOptions(
+		WithHasTx(hasTx),
+		WithAddTxs(addTxs),
+		WithFetchTxs(fetchTxs),
+		WithDropPeer(dropPeer),
+		WithClock(clock),
+		WithRand(rand),
+	)
+}
+
+// NewTxFetcherWithOptions creates a transaction fetcher to retrieve transaction
+// based on hash announcements, configured by the given options. Any option not
+// specified falls back to its production default (system clock, no test
+// randomizer and the standard announcement limit).
+func NewTxFetcherWithOptions(opts ...TxFetcherOption) *TxFetcher {
+	f := &TxFetcher{
+		notify:          make(chan *txAnnounce),
+		notifyBatch:     make(chan []*txAnnounce),
+		directFetch:     make(chan *txAnnounce),
+		cleanup:         make(chan *txDelivery),
+		drop:            make(chan *txDrop),
+		purge:           make(chan *txPurge),
+		flush:           make(chan *txFlush),
+		stats:           make(chan *txStats),
+		quit:            make(chan struct{}),
+		waitlist:        make(map[common.Hash]map[string]struct{}),
+		waittime:        make(map[common.Hash]mclock.AbsTime),
+		waitslots:       make(map[string]map[common.Hash]*txMetadataWithSeq),
+		announces:       make(map[string]map[common.Hash]*txMetadataWithSeq),
+		announced:       make(map[common.Hash]map[string]struct{}),
+		queuetime:       make(map[common.Hash]mclock.AbsTime),
+		fetching:        make(map[common.Hash]string),
+		requests:        make(map[string]*txRequest),
+		alternates:      make(map[common.Hash]map[string]struct{}),
+		underpriced:     lru.NewCache[common.Hash, time.Time](maxTxUnderpricedSetSize),
+		recentAnnounces: make(map[string]*lru.Cache[common.Hash, mclock.AbsTime]),
+		directPeers:     make(map[string]struct{}),
+		firstAnnouncer:  make(map[common.Hash]string),
+		stateEvents:     make(chan TxFetcherStateEvent, txEventBuffer),
+		partitionEvents: make(chan PartitionEvent, txEventBuffer),
+		maxAnnounces:    maxTxAnnounces,
+		replayWindow:    txReplayWindow,
+		clock:           mclock.System{},
+	}
+	for _, opt := range opts {
+		opt(f)
 	}
+	return f
 }
 
 // Notify announces the fetcher of the potential availability of a new batch of
 // transactions in the network.
 func (f *TxFetcher) Notify(peer string, types []byte, sizes []uint32, hashes []common.Hash) error {
+	return f.NotifyWithContext(context.Background(), peer, types, sizes, hashes)
+}
+
+// NotifyWithContext is Notify, but gives up waiting for the internal loop to
+// accept the announcement once the context is done, returning its error. An
+// announcement given up on leaves no trace, so it may be retried as is.
+func (f *TxFetcher) NotifyWithContext(ctx context.Context, peer string, types []byte, sizes []uint32, hashes []common.Hash) error {
+	announce := f.filterAnnounce(peer, hashes, func(i int) txMetadata {
+		return txMetadata{kind: types[i], size: sizes[i]}
+	})
+	// If anything's left to announce, push it into the internal loop
+	if announce == nil {
+		return nil
+	}
+	notify := f.notify
+	if f.isDirectPeer(peer) {
+		notify = f.directFetch
+	}
+	select {
+	case notify <- announce:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// NotifyBatch announces the fetcher of a batch of announcements, possibly from
+// many peers, handing them over to the internal loop in one go instead of one
+// by one as Notify would. The announcements are filtered the same way as with
+// Notify and the announcement limit of a peer applies across the batch.
+func (f *TxFetcher) NotifyBatch(announces []TxAnnounce) error {
+	batch := make([]*txAnnounce, 0, len(announces))
+	for _, ann := range announces {
+		announce := f.filterAnnounce(ann.Peer, ann.Hashes, func(i int) txMetadata {
+			return txMetadata{kind: ann.Types[i], size: ann.Sizes[i]}
+		})
+		if announce == nil {
+			continue
+		}
+		if f.isDirectPeer(ann.Peer) {
+			select {
+			case f.directFetch <- announce:
+			case <-f.quit:
+				return errTerminated
+			}
+			continue
+		}
+		batch = append(batch, announce)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	select {
+	case f.notifyBatch <- batch:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// SetDirectPeer marks a trusted peer, e.g. a co-located sequencer, as direct.
+// The transactions announced by a direct peer are still checked against the
+// known and underpriced ones, but are then requested from it straight away,
+// without waiting for broadcasts and without being tracked for retrieval from
+// other peers. Contrary to regular peers, a direct peer may be sent multiple
+// requests concurrently. The marking is retained if the peer is dropped.
+func (f *TxFetcher) SetDirectPeer(peer string) {
+	f.directLock.Lock()
+	defer f.directLock.Unlock()
+
+	f.directPeers[peer] = struct{}{}
+}
+
+// UnsetDirectPeer reverts a direct peer to the regular announcement handling.
+func (f *TxFetcher) UnsetDirectPeer(peer string) {
+	f.directLock.Lock()
+	defer f.directLock.Unlock()
+
+	delete(f.directPeers, peer)
+}
+
+// isDirectPeer reports whether the announcements of a peer are fetched directly.
+func (f *TxFetcher) isDirectPeer(peer string) bool {
+	f.directLock.RLock()
+	defer f.directLock.RUnlock()
+
+	_, ok := f.directPeers[peer]
+	return ok
+}
+
+// filterAnnounce drops the announced transactions which are already known or
+// were recently found underpriced, returning the announcement of the rest, or
+// nil if nothing's left. Replays are filtered by the internal loop, which owns
+// the recent announcements of the peers.
+func (f *TxFetcher) filterAnnounce(peer string, hashes []common.Hash, meta func(i int) txMetadata) *txAnnounce {
 	// Keep track of all the announced transactions
 	txAnnounceInMeter.Mark(int64(len(hashes)))
 
@@ -272,22 +684,58 @@ func (f *TxFetcher) Notify(peer string, types []byte, sizes []uint32, hashes []c
 			// Transaction metadata has been available since eth68, and all
 			// legacy eth protocols (prior to eth68) have been deprecated.
 			// Therefore, metadata is always expected in the announcement.
-			unknownMetas = append(unknownMetas, txMetadata{kind: types[i], size: sizes[i]})
+			unknownMetas = append(unknownMetas, meta(i))
 		}
 	}
 	txAnnounceKnownMeter.Mark(duplicate)
 	txAnnounceUnderpricedMeter.Mark(underpriced)
 
-	// If anything's left to announce, push it into the internal loop
 	if len(unknownHashes) == 0 {
 		return nil
 	}
-	announce := &txAnnounce{origin: peer, hashes: unknownHashes, metas: unknownMetas}
-	select {
-	case f.notify <- announce:
-		return nil
-	case <-f.quit:
-		return errTerminated
+	return &txAnnounce{origin: peer, hashes: unknownHashes, metas: unknownMetas}
+}
+
+// filterReplays drops the transactions of an announcement which were already
+// announced by the same peer within the replay window, marking the rest as
+// announced now. Entries older than the window are only cleaned up when looked
+// up again, or when the peer is dropped.
+func (f *TxFetcher) filterReplays(ann *txAnnounce) {
+	if f.replayWindow <= 0 {
+		return
+	}
+	recent := f.recentAnnounces[ann.origin]
+	if recent == nil {
+		recent = lru.NewCache[common.Hash, mclock.AbsTime](maxTxReplaySetSize)
+		f.recentAnnounces[ann.origin] = recent
+	}
+	var (
+		now      = f.clock.Now()
+		hashes   = ann.hashes[:0]
+		metas    = ann.metas[:0]
+		replayed int64
+	)
+	for i, hash := range ann.hashes {
+		if prev, ok := recent.Peek(hash); ok && time.Duration(now-prev) < f.replayWindow {
+			replayed++
+			continue
+		}
+		recent.Add(hash, now)
+		hashes = append(hashes, hash)
+		metas = append(metas, ann.metas[i])
+	}
+	ann.hashes, ann.metas = hashes, metas
+	f.replayed += int(replayed)
+	txAnnounceReplayMeter.Mark(replayed)
+}
+
+// forgetReplays drops a transaction from the recent announcements of its peers,
+// so that it's processed anew if re-announced.
+func (f *TxFetcher) forgetReplays(hash common.Hash, peers map[string]struct{}) {
+	for peer := range peers {
+		if recent := f.recentAnnounces[peer]; recent != nil {
+			recent.Remove(hash)
+		}
 	}
 }
 
@@ -331,6 +779,7 @@ func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction, direct bool)
 	var (
 		added = make([]common.Hash, 0, len(txs))
 		metas = make([]txMetadata, 0, len(txs))
+		acked []common.Hash
 	)
 	// proceed in batches
 	for i := 0; i < len(txs); i += 128 {
@@ -354,7 +803,11 @@ func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction, direct bool)
 			}
 			// Track a few interesting failure types
 			switch {
-			case err == nil: // Noop, but need to handle to not count these
+			case err == nil:
+				// Track successful insertions to acknowledge them to the origin
+				if f.ackTxs != nil {
+					acked = append(acked, batch[j].Hash())
+				}
 
 			case errors.Is(err, txpool.ErrAlreadyKnown):
 				duplicate++
@@ -381,6 +834,10 @@ func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction, direct bool)
 			log.Debug("Peer delivering stale transactions", "peer", peer, "rejected", otherreject)
 		}
 	}
+	// Let the origin know about the transactions that made it into the pool
+	if len(acked) > 0 {
+		f.ackTxs(peer, acked)
+	}
 	select {
 	case f.cleanup <- &txDelivery{origin: peer, hashes: added, metas: metas, direct: direct}:
 		return nil
@@ -400,16 +857,582 @@ func (f *TxFetcher) Drop(peer string) error {
 	}
 }
 
+// DrainPeer should be called when a peer is removed gracefully (i.e. not for
+// misbehaviour). Similar to Drop, it cleans up all the internal data structures
+// of the given node, leaving any transaction also announced by other peers to be
+// retrieved from them instead. The hashes which had no other origin to fall back
+// to are returned, allowing the caller to re-request them elsewhere.
+func (f *TxFetcher) DrainPeer(peer string) ([]common.Hash, error) {
+	drop := &txDrop{peer: peer, drain: true, lost: make(chan []common.Hash, 1)}
+	select {
+	case f.drop <- drop:
+	case <-f.quit:
+		return nil, errTerminated
+	}
+	select {
+	case lost := <-drop.lost:
+		return lost, nil
+	case <-f.quit:
+		return nil, errTerminated
+	}
+}
+
+// PurgeStale removes all the transactions which have been waiting longer than
+// maxAge in the waitlist or in the retrieval queue, returning the number of
+// removed hashes. The purged hashes are temporarily marked underpriced to avoid
+// them being re-announced straight away. Transactions being fetched are left
+// alone.
+func (f *TxFetcher) PurgeStale(maxAge time.Duration) int {
+	purge := &txPurge{maxAge: maxAge, removed: make(chan int, 1)}
+	select {
+	case f.purge <- purge:
+	case <-f.quit:
+		return 0
+	}
+	select {
+	case removed := <-purge.removed:
+		return removed
+	case <-f.quit:
+		return 0
+	}
+}
+
+// ForceFlush finalises all the transactions waiting or queued for retrieval,
+// without waiting for the timers to schedule them. Announcements carry hashes
+// only, so there's nothing to import, those the pool already has are counted
+// as fetched, the rest are dropped and will be fetched anew if re-announced.
+//
+// Transactions being retrieved are left alone, their requests complete normally.
+func (f *TxFetcher) ForceFlush() (fetched int, dropped int) {
+	fetched, dropped, _ = f.forceFlush(context.Background())
+	return fetched, dropped
+}
+
+// forceFlush is ForceFlush, but gives up waiting for the event loop once the
+// context is done, returning its error.
+func (f *TxFetcher) forceFlush(ctx context.Context) (int, int, error) {
+	flush := &txFlush{done: make(chan struct{})}
+	select {
+	case f.flush <- flush:
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	case <-f.quit:
+		return 0, 0, errTerminated
+	}
+	select {
+	case <-flush.done:
+		return flush.fetched, flush.dropped, nil
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	case <-f.quit:
+		return 0, 0, errTerminated
+	}
+}
+
+// WaitlistAge returns the time elapsed since the given transaction was inserted
+// into the waitlist, or false if it is not currently waiting.
+func (f *TxFetcher) WaitlistAge(hash common.Hash) (time.Duration, bool) {
+	var (
+		age    time.Duration
+		exists bool
+	)
+	err := f.inspect(func() {
+		var added mclock.AbsTime
+		if added, exists = f.waittime[hash]; exists {
+			age = time.Duration(f.clock.Now() - added)
+		}
+	})
+	if err != nil {
+		return 0, false
+	}
+	return age, exists
+}
+
+// AnnounceCount returns the number of transaction hashes tracked for each peer
+// that announced any, across all stages: waiting, queued and being fetched. The
+// fetched ones are still accounted in the queue stage, so they are not counted
+// twice.
+func (f *TxFetcher) AnnounceCount() map[string]int {
+	var counts map[string]int
+
+	err := f.inspect(func() {
+		counts = make(map[string]int, len(f.waitslots)+len(f.announces))
+		for peer, hashes := range f.waitslots {
+			counts[peer] += len(hashes)
+		}
+		for peer, hashes := range f.announces {
+			counts[peer] += len(hashes)
+		}
+	})
+	if err != nil {
+		return nil
+	}
+	return counts
+}
+
+// PeerCount returns the number of peers with transactions tracked in any stage:
+// waiting, queued or being fetched.
+func (f *TxFetcher) PeerCount() int {
+	var count int
+
+	err := f.inspect(func() {
+		peers := make(map[string]struct{}, len(f.waitslots)+len(f.announces))
+		for peer := range f.waitslots {
+			peers[peer] = struct{}{}
+		}
+		for peer := range f.announces {
+			peers[peer] = struct{}{}
+		}
+		for peer := range f.requests {
+			peers[peer] = struct{}{}
+		}
+		count = len(peers)
+	})
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// ActivePeerCount returns the number of peers with a transaction retrieval in
+// flight.
+func (f *TxFetcher) ActivePeerCount() int {
+	var count int
+
+	err := f.inspect(func() {
+		count = len(f.requests)
+	})
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// TopKQueued returns up to k transaction hashes that have been queued for
+// retrieval the longest, oldest first. Transactions still waiting for a
+// broadcast or already being fetched are not considered.
+func (f *TxFetcher) TopKQueued(k int) []common.Hash {
+	if k <= 0 {
+		return nil
+	}
+	var hashes []common.Hash
+
+	err := f.inspect(func() {
+		// Keep the k oldest hashes in a bounded queue, evicting the newest one
+		// on overflow, so the selection runs in O(n log k)
+		oldest := prque.New[mclock.AbsTime, common.Hash](nil)
+		for hash, queued := range f.queuetime {
+			oldest.Push(hash, queued)
+			if oldest.Size() > k {
+				oldest.Pop()
+			}
+		}
+		hashes = make([]common.Hash, oldest.Size())
+		for i := len(hashes) - 1; i >= 0; i-- {
+			hashes[i] = oldest.PopItem()
+		}
+	})
+	if err != nil {
+		return nil
+	}
+	return hashes
+}
+
+// GossipOrigin returns the peer that first announced a transaction, if it is
+// still remembered. Only the most recent maxTxOriginSetSize transactions are
+// tracked.
+func (f *TxFetcher) GossipOrigin(hash common.Hash) (string, bool) {
+	var (
+		peer string
+		ok   bool
+	)
+	err := f.inspect(func() {
+		peer, ok = f.firstAnnouncer[hash]
+	})
+	if err != nil {
+		return "", false
+	}
+	return peer, ok
+}
+
+// trackOrigin remembers the peer that first announced a transaction, evicting
+// the oldest tracked transaction if the set is full.
+func (f *TxFetcher) trackOrigin(hash common.Hash, peer string) {
+	if _, ok := f.firstAnnouncer[hash]; ok {
+		return
+	}
+	if len(f.announceOrder) < maxTxOriginSetSize {
+		f.announceOrder = append(f.announceOrder, hash)
+	} else {
+		delete(f.firstAnnouncer, f.announceOrder[f.announceNext])
+		f.announceOrder[f.announceNext] = hash
+		f.announceNext = (f.announceNext + 1) % maxTxOriginSetSize
+	}
+	f.firstAnnouncer[hash] = peer
+}
+
+// DumpState returns a human readable snapshot of the fetcher internals for
+// debugging purposes: the waiting transactions (oldest first), the queued ones,
+// the ones being fetched along with the time elapsed since they were requested,
+// and a summary of the in-flight requests per peer.
+func (f *TxFetcher) DumpState() string {
+	var (
+		buf strings.Builder
+		w   = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	)
+	err := f.inspect(func() {
+		now := f.clock.Now()
+
+		// peerList joins the sorted identifiers of a set of peers
+		peerList := func(peers map[string]struct{}) string {
+			return strings.Join(slices.Sorted(maps.Keys(peers)), ",")
+		}
+		waiting := slices.SortedFunc(maps.Keys(f.waitlist), func(a, b common.Hash) int {
+			return cmp.Or(cmp.Compare(f.waittime[a], f.waittime[b]), a.Cmp(b))
+		})
+		fmt.Fprintf(w, "WAITING (%d)\tPEERS\tAGE\n", len(waiting))
+		for _, hash := range waiting {
+			fmt.Fprintf(w, "%s\t%s\t%v\n", hash.Hex(), peerList(f.waitlist[hash]), time.Duration(now-f.waittime[hash]))
+		}
+		queued := slices.SortedFunc(maps.Keys(f.announced), common.Hash.Cmp)
+		fmt.Fprintf(w, "\nQUEUED (%d)\tPEERS\t\n", len(queued))
+		for _, hash := range queued {
+			fmt.Fprintf(w, "%s\t%s\t\n", hash.Hex(), peerList(f.announced[hash]))
+		}
+		fetching := slices.SortedFunc(maps.Keys(f.fetching), common.Hash.Cmp)
+		fmt.Fprintf(w, "\nFETCHING (%d)\tPEER\tELAPSED\n", len(fetching))
+		for _, hash := range fetching {
+			peer := f.fetching[hash]
+			fmt.Fprintf(w, "%s\t%s\t%v\n", hash.Hex(), peer, time.Duration(now-f.requests[peer].time))
+		}
+		fmt.Fprintf(w, "\nREQUESTS (%d)\tHASHES\tSTOLEN\tELAPSED\n", len(f.requests))
+		for _, peer := range slices.Sorted(maps.Keys(f.requests)) {
+			req := f.requests[peer]
+			fmt.Fprintf(w, "%s\t%d\t%d\t%v\n", peer, len(req.hashes), len(req.stolen), time.Duration(now-req.time))
+		}
+		w.Flush()
+	})
+	if err != nil {
+		return err.Error()
+	}
+	return buf.String()
+}
+
+// inspect runs the given query on the event loop and waits for it to finish.
+func (f *TxFetcher) inspect(query func()) error {
+	return f.inspectContext(context.Background(), query)
+}
+
+// inspectContext is inspect, but gives up waiting for the event loop once the
+// context is done, returning its error. The query may still run afterwards, so
+// its results must not be used if an error is returned.
+func (f *TxFetcher) inspectContext(ctx context.Context, query func()) error {
+	req := &txStats{query: query, done: make(chan struct{})}
+	select {
+	case f.stats <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.quit:
+		return errTerminated
+	}
+	select {
+	case <-req.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Events returns the feed on which the fetcher posts a TxFetcherStateEvent every
+// time a tracked transaction hash transitions between stages. The events are
+// buffered and delivered in order from a separate goroutine, so a slow subscriber
+// does not stall the fetcher. If it falls more than txEventBuffer events behind,
+// the new events are dropped until it catches up.
+func (f *TxFetcher) Events() *event.Feed {
+	return &f.events
+}
+
+// PartitionEvents returns the feed on which the fetcher posts a PartitionEvent if
+// most transaction retrievals time out, hinting at a network partition. The
+// events are delivered the same way as the ones on the Events feed.
+func (f *TxFetcher) PartitionEvents() *event.Feed {
+	return &f.partitionFeed
+}
+
+// trackPartition feeds the outcome of transaction retrievals into the partition
+// detector, reporting if the node seems to be cut off from the network.
+func (f *TxFetcher) trackPartition(delivered, timedOut int) {
+	if !f.partition.track(f.clock.Now(), delivered, timedOut) {
+		return
+	}
+	log.Warn("Possible network partition detected", "delivered", f.partition.delivered, "timedout", f.partition.timedOut, "window", partitionWindow)
+	select {
+	case f.partitionEvents <- PartitionEvent{Delivered: f.partition.delivered, TimedOut: f.partition.timedOut}:
+	default:
+		txFetcherEventDropMeter.Mark(1)
+	}
+}
+
+// stageEvent posts a state transition of a hash on the event feed, also tracking
+// the time the hash spends in the queueing stage.
+func (f *TxFetcher) stageEvent(hash common.Hash, old, new TxFetcherStage) {
+	if old == EventQueued {
+		if new == EventFetching {
+			txFetcherQueueAge.Update(time.Duration(f.clock.Now() - f.queuetime[hash]))
+		}
+		delete(f.queuetime, hash)
+	}
+	if new == EventQueued {
+		f.queuetime[hash] = f.clock.Now()
+	}
+	select {
+	case f.stateEvents <- TxFetcherStateEvent{Hash: hash, Old: old, New: new}:
+	default:
+		txFetcherEventDropMeter.Mark(1)
+	}
+}
+
+// deliverEvents forwards the events posted by the fetcher loop to the feed
+// subscribers until termination is requested.
+func (f *TxFetcher) deliverEvents() {
+	for {
+		select {
+		case event := <-f.stateEvents:
+			f.events.Send(event)
+		case event := <-f.partitionEvents:
+			f.partitionFeed.Send(event)
+		case <-f.quit:
+			return
+		}
+	}
+}
+
 // Start boots up the announcement based synchroniser, accepting and processing
 // hash notifications and block fetches until termination requested.
 func (f *TxFetcher) Start() {
 	go f.loop()
+	go f.deliverEvents()
 }
 
 // Stop terminates the announcement based synchroniser, canceling all pending
 // operations.
 func (f *TxFetcher) Stop() {
-	close(f.quit)
+	f.stop.Do(func() { close(f.quit) })
+}
+
+// Shutdown gracefully terminates the fetcher. The transactions waiting or queued
+// for retrieval are flushed, after which the in-flight retrievals are given time
+// to complete until the context expires. In that case, a *ShutdownTimeoutError
+// is returned with the hashes still being fetched, allowing the caller to persist
+// them for later. If the event loop does not respond before the context expires,
+// the context's error is returned instead.
+func (f *TxFetcher) Shutdown(ctx context.Context) error {
+	defer f.Stop()
+
+	if _, _, err := f.forceFlush(ctx); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	var inflight []common.Hash
+	for {
+		latest, err := f.inflightHashes(ctx)
+		switch {
+		case errors.Is(err, errTerminated):
+			return err
+		case err != nil:
+			// The loop stopped responding in time, report what was last seen
+			if inflight == nil {
+				return err
+			}
+			return &ShutdownTimeoutError{InflightHashes: inflight}
+		}
+		if inflight = latest; len(inflight) == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// Requests might have finished since the last check, gather them anew,
+			// but don't wait for the loop longer than a poll interval
+			recheck, cancel := context.WithTimeout(context.Background(), shutdownPollInterval)
+			latest, err := f.inflightHashes(recheck)
+			cancel()
+
+			if errors.Is(err, errTerminated) {
+				return err
+			}
+			if err == nil {
+				if inflight = latest; len(inflight) == 0 {
+					return nil
+				}
+			}
+			return &ShutdownTimeoutError{InflightHashes: inflight}
+		}
+	}
+}
+
+// inflightHashes returns the hashes of all the transactions currently being
+// retrieved, skipping those already delivered by someone else. It gives up once
+// the context is done.
+func (f *TxFetcher) inflightHashes(ctx context.Context) ([]common.Hash, error) {
+	var hashes []common.Hash
+	err := f.inspectContext(ctx, func() {
+		for _, req := range f.requests {
+			for _, hash := range req.hashes {
+				if _, ok := req.stolen[hash]; !ok {
+					hashes = append(hashes, hash)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// scheduleAnnounce inserts the transactions of an announcement into the waiting
+// list, or tracks the peer as an alternate source if they are already known. It
+// reports whether any blob transaction (skipping the wait) was announced and
+// whether the peer is new and announced something already queued.
+func (f *TxFetcher) scheduleAnnounce(ann *txAnnounce) (hasBlob bool, fetch bool) {
+	// Drop part of the new announcements if there are too many accumulated.
+	// Note, we could but do not filter already known transactions here as
+	// the probability of something arriving between this call and the pre-
+	// filter outside is essentially zero.
+	used := len(f.waitslots[ann.origin]) + len(f.announces[ann.origin])
+	if used >= f.maxAnnounces {
+		// This can happen if a set of transactions are requested but not
+		// all fulfilled, so the remainder are rescheduled without the cap
+		// check. Should be fine as the limit is in the thousands and the
+		// request size in the hundreds.
+		txAnnounceDOSMeter.Mark(int64(len(ann.hashes)))
+		return false, false
+	}
+	want := used + len(ann.hashes)
+	if want > f.maxAnnounces {
+		txAnnounceDOSMeter.Mark(int64(want - f.maxAnnounces))
+
+		ann.hashes = ann.hashes[:want-f.maxAnnounces]
+		ann.metas = ann.metas[:want-f.maxAnnounces]
+	}
+	// All is well, schedule the remainder of the transactions
+	var (
+		_, oldPeer = f.announces[ann.origin]
+
+		// nextSeq returns the next available sequence number for tagging
+		// transaction announcement and also bump it internally.
+		nextSeq = func() uint64 {
+			seq := f.txSeq
+			f.txSeq++
+			return seq
+		}
+	)
+	for i, hash := range ann.hashes {
+		// If the transaction is already downloading, add it to the list
+		// of possible alternates (in case the current retrieval fails) and
+		// also account it for the peer.
+		if f.alternates[hash] != nil {
+			f.alternates[hash][ann.origin] = struct{}{}
+
+			// Stage 2 and 3 share the set of origins per tx
+			if announces := f.announces[ann.origin]; announces != nil {
+				announces[hash] = &txMetadataWithSeq{
+					txMetadata: ann.metas[i],
+					seq:        nextSeq(),
+				}
+			} else {
+				f.announces[ann.origin] = map[common.Hash]*txMetadataWithSeq{
+					hash: {
+						txMetadata: ann.metas[i],
+						seq:        nextSeq(),
+					},
+				}
+			}
+			continue
+		}
+		// If the transaction is not downloading, but is already queued
+		// from a different peer, track it for the new peer too.
+		if f.announced[hash] != nil {
+			f.announced[hash][ann.origin] = struct{}{}
+
+			// Stage 2 and 3 share the set of origins per tx
+			if announces := f.announces[ann.origin]; announces != nil {
+				announces[hash] = &txMetadataWithSeq{
+					txMetadata: ann.metas[i],
+					seq:        nextSeq(),
+				}
+			} else {
+				f.announces[ann.origin] = map[common.Hash]*txMetadataWithSeq{
+					hash: {
+						txMetadata: ann.metas[i],
+						seq:        nextSeq(),
+					},
+				}
+			}
+			continue
+		}
+		// If the transaction is already known to the fetcher, but not
+		// yet downloading, add the peer as an alternate origin in the
+		// waiting list.
+		if f.waitlist[hash] != nil {
+			// Ignore double announcements from the same peer. This is
+			// especially important if metadata is also passed along to
+			// prevent malicious peers flip-flopping good/bad values.
+			if _, ok := f.waitlist[hash][ann.origin]; ok {
+				continue
+			}
+			f.waitlist[hash][ann.origin] = struct{}{}
+
+			if waitslots := f.waitslots[ann.origin]; waitslots != nil {
+				waitslots[hash] = &txMetadataWithSeq{
+					txMetadata: ann.metas[i],
+					seq:        nextSeq(),
+				}
+			} else {
+				f.waitslots[ann.origin] = map[common.Hash]*txMetadataWithSeq{
+					hash: {
+						txMetadata: ann.metas[i],
+						seq:        nextSeq(),
+					},
+				}
+			}
+			continue
+		}
+		// Transaction unknown to the fetcher, insert it into the waiting list
+		f.waitlist[hash] = map[string]struct{}{ann.origin: {}}
+		f.trackOrigin(hash, ann.origin)
+		f.stageEvent(hash, EventNone, EventWaiting)
+
+		// Assign the current timestamp as the wait time, but for blob transactions,
+		// skip the wait time since they are only announced.
+		if ann.metas[i].kind != types.BlobTxType {
+			f.waittime[hash] = f.clock.Now()
+		} else {
+			hasBlob = true
+			f.waittime[hash] = f.clock.Now() - mclock.AbsTime(txArriveTimeout)
+		}
+		if waitslots := f.waitslots[ann.origin]; waitslots != nil {
+			waitslots[hash] = &txMetadataWithSeq{
+				txMetadata: ann.metas[i],
+				seq:        nextSeq(),
+			}
+		} else {
+			f.waitslots[ann.origin] = map[common.Hash]*txMetadataWithSeq{
+				hash: {
+					txMetadata: ann.metas[i],
+					seq:        nextSeq(),
+				},
+			}
+		}
+	}
+	// If this peer is new and announced something already queued, maybe
+	// request transactions from them
+	return hasBlob, !oldPeer && len(f.announces[ann.origin]) > 0
 }
 
 func (f *TxFetcher) loop() {
@@ -423,144 +1446,70 @@ func (f *TxFetcher) loop() {
 	for {
 		select {
 		case ann := <-f.notify:
-			// Drop part of the new announcements if there are too many accumulated.
-			// Note, we could but do not filter already known transactions here as
-			// the probability of something arriving between this call and the pre-
-			// filter outside is essentially zero.
-			used := len(f.waitslots[ann.origin]) + len(f.announces[ann.origin])
-			if used >= maxTxAnnounces {
-				// This can happen if a set of transactions are requested but not
-				// all fulfilled, so the remainder are rescheduled without the cap
-				// check. Should be fine as the limit is in the thousands and the
-				// request size in the hundreds.
-				txAnnounceDOSMeter.Mark(int64(len(ann.hashes)))
+			if f.filterReplays(ann); len(ann.hashes) == 0 {
 				break
 			}
-			want := used + len(ann.hashes)
-			if want > maxTxAnnounces {
-				txAnnounceDOSMeter.Mark(int64(want - maxTxAnnounces))
+			idleWait := len(f.waittime) == 0
+			hasBlob, fetch := f.scheduleAnnounce(ann)
 
-				ann.hashes = ann.hashes[:want-maxTxAnnounces]
-				ann.metas = ann.metas[:want-maxTxAnnounces]
+			// If a new item was added to the waitlist, schedule it into the fetcher
+			if hasBlob || (idleWait && len(f.waittime) > 0) {
+				f.rescheduleWait(waitTimer, waitTrigger)
 			}
-			// All is well, schedule the remainder of the transactions
+			if fetch {
+				f.scheduleFetches(timeoutTimer, timeoutTrigger, map[string]struct{}{ann.origin: {}})
+			}
+
+		case anns := <-f.notifyBatch:
+			// Insert the whole batch before rescheduling, so the timers and the
+			// fetches are only updated once. The announcement limit of a peer is
+			// accounted across all its announcements in the batch.
 			var (
-				idleWait   = len(f.waittime) == 0
-				_, oldPeer = f.announces[ann.origin]
-				hasBlob    bool
-
-				// nextSeq returns the next available sequence number for tagging
-				// transaction announcement and also bump it internally.
-				nextSeq = func() uint64 {
-					seq := f.txSeq
-					f.txSeq++
-					return seq
-				}
+				idleWait = len(f.waittime) == 0
+				hasBlob  bool
+				peers    = make(map[string]struct{})
 			)
-			for i, hash := range ann.hashes {
-				// If the transaction is already downloading, add it to the list
-				// of possible alternates (in case the current retrieval fails) and
-				// also account it for the peer.
-				if f.alternates[hash] != nil {
-					f.alternates[hash][ann.origin] = struct{}{}
-
-					// Stage 2 and 3 share the set of origins per tx
-					if announces := f.announces[ann.origin]; announces != nil {
-						announces[hash] = &txMetadataWithSeq{
-							txMetadata: ann.metas[i],
-							seq:        nextSeq(),
-						}
-					} else {
-						f.announces[ann.origin] = map[common.Hash]*txMetadataWithSeq{
-							hash: {
-								txMetadata: ann.metas[i],
-								seq:        nextSeq(),
-							},
-						}
-					}
+			for _, ann := range anns {
+				if f.filterReplays(ann); len(ann.hashes) == 0 {
 					continue
 				}
-				// If the transaction is not downloading, but is already queued
-				// from a different peer, track it for the new peer too.
-				if f.announced[hash] != nil {
-					f.announced[hash][ann.origin] = struct{}{}
-
-					// Stage 2 and 3 share the set of origins per tx
-					if announces := f.announces[ann.origin]; announces != nil {
-						announces[hash] = &txMetadataWithSeq{
-							txMetadata: ann.metas[i],
-							seq:        nextSeq(),
-						}
-					} else {
-						f.announces[ann.origin] = map[common.Hash]*txMetadataWithSeq{
-							hash: {
-								txMetadata: ann.metas[i],
-								seq:        nextSeq(),
-							},
-						}
-					}
-					continue
-				}
-				// If the transaction is already known to the fetcher, but not
-				// yet downloading, add the peer as an alternate origin in the
-				// waiting list.
-				if f.waitlist[hash] != nil {
-					// Ignore double announcements from the same peer. This is
-					// especially important if metadata is also passed along to
-					// prevent malicious peers flip-flopping good/bad values.
-					if _, ok := f.waitlist[hash][ann.origin]; ok {
-						continue
-					}
-					f.waitlist[hash][ann.origin] = struct{}{}
-
-					if waitslots := f.waitslots[ann.origin]; waitslots != nil {
-						waitslots[hash] = &txMetadataWithSeq{
-							txMetadata: ann.metas[i],
-							seq:        nextSeq(),
-						}
-					} else {
-						f.waitslots[ann.origin] = map[common.Hash]*txMetadataWithSeq{
-							hash: {
-								txMetadata: ann.metas[i],
-								seq:        nextSeq(),
-							},
-						}
-					}
-					continue
-				}
-				// Transaction unknown to the fetcher, insert it into the waiting list
-				f.waitlist[hash] = map[string]struct{}{ann.origin: {}}
-
-				// Assign the current timestamp as the wait time, but for blob transactions,
-				// skip the wait time since they are only announced.
-				if ann.metas[i].kind != types.BlobTxType {
-					f.waittime[hash] = f.clock.Now()
-				} else {
-					hasBlob = true
-					f.waittime[hash] = f.clock.Now() - mclock.AbsTime(txArriveTimeout)
-				}
-				if waitslots := f.waitslots[ann.origin]; waitslots != nil {
-					waitslots[hash] = &txMetadataWithSeq{
-						txMetadata: ann.metas[i],
-						seq:        nextSeq(),
-					}
-				} else {
-					f.waitslots[ann.origin] = map[common.Hash]*txMetadataWithSeq{
-						hash: {
-							txMetadata: ann.metas[i],
-							seq:        nextSeq(),
-						},
-					}
+				blob, fetch := f.scheduleAnnounce(ann)
+				hasBlob = hasBlob || blob
+				if fetch {
+					peers[ann.origin] = struct{}{}
 				}
 			}
-			// If a new item was added to the waitlist, schedule it into the fetcher
 			if hasBlob || (idleWait && len(f.waittime) > 0) {
 				f.rescheduleWait(waitTimer, waitTrigger)
 			}
-			// If this peer is new and announced something already queued, maybe
-			// request transactions from them
-			if !oldPeer && len(f.announces[ann.origin]) > 0 {
-				f.scheduleFetches(timeoutTimer, timeoutTrigger, map[string]struct{}{ann.origin: {}})
+			if len(peers) > 0 {
+				f.scheduleFetches(timeoutTimer, timeoutTrigger, peers)
+			}
+
+		case ann := <-f.directFetch:
+			// A direct peer announced transactions, request them right away in
+			// batches within the retrieval limits. They are not tracked, their
+			// delivery cleans up any other peer's announcement of them.
+			if f.filterReplays(ann); len(ann.hashes) == 0 {
+				break
+			}
+			var (
+				from  int
+				bytes uint64
+			)
+			for i, meta := range ann.metas {
+				bytes += uint64(meta.size)
+				if i+1 == len(ann.hashes) || i+1-from >= maxTxRetrievals || bytes >= maxTxRetrievalSize {
+					peer, hashes := ann.origin, ann.hashes[from:i+1]
+					txRequestOutMeter.Mark(int64(len(hashes)))
+					gopool.Submit(func() {
+						if err := f.fetchTxs(peer, hashes); err != nil {
+							txRequestFailMeter.Mark(int64(len(hashes)))
+							f.Drop(peer)
+						}
+					})
+					from, bytes = i+1, 0
+				}
 			}
 
 		case <-waitTrigger:
@@ -588,6 +1537,7 @@ func (f *TxFetcher) loop() {
 					}
 					delete(f.waittime, hash)
 					delete(f.waitlist, hash)
+					f.stageEvent(hash, EventWaiting, EventQueued)
 				}
 			}
 			// If transactions are still waiting for propagation, reschedule the wait timer
@@ -607,6 +1557,7 @@ func (f *TxFetcher) loop() {
 			for peer, req := range f.requests {
 				if time.Duration(f.clock.Now()-req.time)+txGatherSlack > txFetchTimeout {
 					txRequestTimeoutMeter.Mark(int64(len(req.hashes)))
+					f.trackPartition(0, len(req.hashes))
 
 					// Reschedule all the not-yet-delivered fetches to alternate peers
 					for _, hash := range req.hashes {
@@ -626,6 +1577,9 @@ func (f *TxFetcher) loop() {
 						delete(f.announced[hash], peer)
 						if len(f.announced[hash]) == 0 {
 							delete(f.announced, hash)
+							f.stageEvent(hash, EventFetching, EventDropped)
+						} else {
+							f.stageEvent(hash, EventFetching, EventQueued)
 						}
 						delete(f.announces[peer], hash)
 						delete(f.alternates, hash)
@@ -676,7 +1630,14 @@ func (f *TxFetcher) loop() {
 					}
 					delete(f.waitlist, hash)
 					delete(f.waittime, hash)
+					f.stageEvent(hash, EventWaiting, EventDelivered)
 				} else {
+					stage := EventNone
+					if _, ok := f.fetching[hash]; ok {
+						stage = EventFetching
+					} else if _, ok := f.announced[hash]; ok {
+						stage = EventQueued
+					}
 					for peer, txset := range f.announces {
 						if meta := txset[hash]; meta != nil {
 							if delivery.metas[i].kind != meta.kind {
@@ -715,6 +1676,9 @@ func (f *TxFetcher) loop() {
 						stolen[hash] = struct{}{}
 					}
 					delete(f.fetching, hash)
+					if stage != EventNone {
+						f.stageEvent(hash, stage, EventDelivered)
+					}
 				}
 			}
 			// In case of a direct delivery, also reschedule anything missing
@@ -722,11 +1686,14 @@ func (f *TxFetcher) loop() {
 			if delivery.direct {
 				// Mark the requesting successful (independent of individual status)
 				txRequestDoneMeter.Mark(int64(len(delivery.hashes)))
+				f.trackPartition(len(delivery.hashes), 0)
 
 				// Make sure something was pending, nuke it
 				req := f.requests[delivery.origin]
 				if req == nil {
-					log.Warn("Unexpected transaction delivery", "peer", delivery.origin)
+					if !f.isDirectPeer(delivery.origin) {
+						log.Warn("Unexpected transaction delivery", "peer", delivery.origin)
+					}
 					break
 				}
 				delete(f.requests, delivery.origin)
@@ -764,6 +1731,9 @@ func (f *TxFetcher) loop() {
 								panic(fmt.Sprintf("announced tracker already contains alternate item: %v", f.announced[hash]))
 							}
 							f.announced[hash] = f.alternates[hash]
+							f.stageEvent(hash, EventFetching, EventQueued)
+						} else {
+							f.stageEvent(hash, EventFetching, EventDropped)
 						}
 					}
 					delete(f.alternates, hash)
@@ -774,13 +1744,36 @@ func (f *TxFetcher) loop() {
 			}
 
 		case drop := <-f.drop:
+			// If the peer is being drained, gather all the hashes that have no
+			// other origin before the trackers are cleaned up
+			var lost []common.Hash
+			if drop.drain {
+				for hash := range f.waitslots[drop.peer] {
+					if len(f.waitlist[hash]) == 1 {
+						lost = append(lost, hash)
+					}
+				}
+				for hash := range f.announces[drop.peer] {
+					if origins := f.announced[hash]; origins != nil {
+						if len(origins) == 1 {
+							lost = append(lost, hash) // Queued, but only from this peer
+						}
+					} else if origins := f.alternates[hash]; origins != nil {
+						if len(origins) == 1 {
+							lost = append(lost, hash) // Fetching, but no alternates
+						}
+					}
+				}
+			}
 			// A peer was dropped, remove all traces of it
+			delete(f.recentAnnounces, drop.peer)
 			if _, ok := f.waitslots[drop.peer]; ok {
 				for hash := range f.waitslots[drop.peer] {
 					delete(f.waitlist[hash], drop.peer)
 					if len(f.waitlist[hash]) == 0 {
 						delete(f.waitlist, hash)
 						delete(f.waittime, hash)
+						f.stageEvent(hash, EventWaiting, EventDropped)
 					}
 				}
 				delete(f.waitslots, drop.peer)
@@ -802,9 +1795,11 @@ func (f *TxFetcher) loop() {
 					delete(f.alternates[hash], drop.peer)
 					if len(f.alternates[hash]) == 0 {
 						delete(f.alternates, hash)
+						f.stageEvent(hash, EventFetching, EventDropped)
 					} else {
 						f.announced[hash] = f.alternates[hash]
 						delete(f.alternates, hash)
+						f.stageEvent(hash, EventFetching, EventQueued)
 					}
 					delete(f.fetching, hash)
 				}
@@ -813,9 +1808,16 @@ func (f *TxFetcher) loop() {
 			// Clean up general announcement tracking
 			if _, ok := f.announces[drop.peer]; ok {
 				for hash := range f.announces[drop.peer] {
-					delete(f.announced[hash], drop.peer)
-					if len(f.announced[hash]) == 0 {
-						delete(f.announced, hash)
+					if origins, ok := f.announced[hash]; ok {
+						delete(origins, drop.peer)
+						if len(origins) == 0 {
+							delete(f.announced, hash)
+							f.stageEvent(hash, EventQueued, EventDropped)
+						}
+					}
+					delete(f.alternates[hash], drop.peer)
+					if len(f.alternates[hash]) == 0 {
+						delete(f.alternates, hash)
 					}
 				}
 				delete(f.announces, drop.peer)
@@ -825,6 +1827,93 @@ func (f *TxFetcher) loop() {
 				f.scheduleFetches(timeoutTimer, timeoutTrigger, nil)
 				f.rescheduleTimeout(timeoutTimer, timeoutTrigger)
 			}
+			if drop.drain {
+				drop.lost <- lost
+			}
+
+		case purge := <-f.purge:
+			var (
+				now     = f.clock.Now()
+				removed int
+			)
+			for hash, instance := range f.waittime {
+				if time.Duration(now-instance) <= purge.maxAge {
+					continue
+				}
+				for peer := range f.waitlist[hash] {
+					delete(f.waitslots[peer], hash)
+					if len(f.waitslots[peer]) == 0 {
+						delete(f.waitslots, peer)
+					}
+				}
+				delete(f.waitlist, hash)
+				delete(f.waittime, hash)
+				f.stageEvent(hash, EventWaiting, EventDropped)
+
+				// Backdate the underpriced marker so it expires sooner than usual
+				f.underpriced.Add(hash, time.Now().Add(purgedTxUnderpricedTimeout-maxTxUnderpricedTimeout))
+				removed++
+			}
+			for hash, instance := range f.queuetime {
+				if time.Duration(now-instance) <= purge.maxAge {
+					continue
+				}
+				for peer := range f.announced[hash] {
+					delete(f.announces[peer], hash)
+					if len(f.announces[peer]) == 0 {
+						delete(f.announces, peer)
+					}
+				}
+				delete(f.announced, hash)
+				f.stageEvent(hash, EventQueued, EventDropped)
+
+				f.underpriced.Add(hash, time.Now().Add(purgedTxUnderpricedTimeout-maxTxUnderpricedTimeout))
+				removed++
+			}
+			purge.removed <- removed
+
+		case flush := <-f.flush:
+			// finalise counts a flushed transaction based on whether the pool has it
+			finalise := func(hash common.Hash, stage TxFetcherStage) {
+				if f.hasTx(hash) {
+					f.stageEvent(hash, stage, EventDelivered)
+					flush.fetched++
+				} else {
+					f.stageEvent(hash, stage, EventDropped)
+					flush.dropped++
+				}
+			}
+			for hash, peers := range f.waitlist {
+				for peer := range peers {
+					delete(f.waitslots[peer], hash)
+					if len(f.waitslots[peer]) == 0 {
+						delete(f.waitslots, peer)
+					}
+				}
+				f.forgetReplays(hash, peers)
+				delete(f.waitlist, hash)
+				delete(f.waittime, hash)
+				finalise(hash, EventWaiting)
+			}
+			for hash, peers := range f.announced {
+				for peer := range peers {
+					delete(f.announces[peer], hash)
+					if len(f.announces[peer]) == 0 {
+						delete(f.announces, peer)
+					}
+				}
+				f.forgetReplays(hash, peers)
+				delete(f.announced, hash)
+				finalise(hash, EventQueued)
+			}
+			close(flush.done)
+
+		case req := <-f.stats:
+			// Someone is inspecting the internals, nothing changed, so skip the
+			// metrics update and step notification
+			req.query()
+			close(req.done)
+			continue
 
 		case <-f.quit:
 			return
@@ -948,6 +2037,7 @@ func (f *TxFetcher) scheduleFetches(timer *mclock.Timer, timeout chan struct{},
 			}
 			f.alternates[hash] = f.announced[hash]
 			delete(f.announced, hash)
+			f.stageEvent(hash, EventQueued, EventFetching)
 
 			// Accumulate the hash and stop if the limit was reached
 			hashes = append(hashes, hash)
@@ -17,10 +17,15 @@
 package fetcher
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"math/big"
 	"math/rand"
 	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -28,6 +33,8 @@ import (
 	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/core/txpool"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -55,6 +62,9 @@ type doTxNotify struct {
 	types  []byte
 	sizes  []uint32
 }
+type doTxReplay doTxNotify
+type doTxNotifyBatch []doTxNotify
+
 type doTxEnqueue struct {
 	peer   string
 	txs    []*types.Transaction
@@ -65,6 +75,22 @@ type doWait struct {
 	step bool
 }
 type doDrop string
+type doDrain struct {
+	peer string
+	lost []common.Hash
+}
+type doPurge struct {
+	maxAge  time.Duration
+	removed int
+}
+type doFlush struct {
+	fetched int
+	dropped int
+}
+type doShutdown struct {
+	timeout  time.Duration
+	inflight []common.Hash // Hashes expected to be reported in flight, nil for a clean shutdown
+}
 type doFunc func()
 
 type isWaiting map[string][]announce
@@ -1498,6 +1524,231 @@ func TestTransactionFetcherDrop(t *testing.T) {
 	})
 }
 
+// Tests that the waitlist age of a transaction tracks the time it spent in the
+// waitlist, and that it's reported as missing once it leaves.
+func TestTransactionFetcherWaitlistAge(t *testing.T) {
+	var fetcher *TxFetcher
+
+	checkAge := func(hash common.Hash, want time.Duration, exists bool) doFunc {
+		return func() {
+			age, ok := fetcher.WaitlistAge(hash)
+			if ok != exists {
+				t.Errorf("waitlist presence mismatch for %x: have %v, want %v", hash, ok, exists)
+			}
+			if age != want {
+				t.Errorf("waitlist age mismatch for %x: have %v, want %v", hash, age, want)
+			}
+		}
+	}
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcher(
+				func(common.Hash) bool { return false },
+				nil,
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			return fetcher
+		},
+		steps: []interface{}{
+			// Unknown transactions should not be reported
+			checkAge(common.Hash{0x01}, 0, false),
+
+			// Announce a transaction and ensure its age is tracked
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x01}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+			checkAge(common.Hash{0x01}, 0, true),
+			doWait{time: txArriveTimeout / 2, step: false},
+			checkAge(common.Hash{0x01}, txArriveTimeout/2, true),
+
+			// Announce a second transaction and ensure ages are tracked independently
+			doTxNotify{peer: "B", hashes: []common.Hash{{0x02}}, types: []byte{types.LegacyTxType}, sizes: []uint32{222}},
+			doWait{time: txArriveTimeout / 4, step: false},
+			checkAge(common.Hash{0x01}, 3*txArriveTimeout/4, true),
+			checkAge(common.Hash{0x02}, txArriveTimeout/4, true),
+
+			// Move the first transaction out of the waitlist and ensure it's gone
+			doWait{time: txArriveTimeout / 4, step: true},
+			checkAge(common.Hash{0x01}, 0, false),
+			checkAge(common.Hash{0x02}, txArriveTimeout/2, true),
+		},
+	})
+}
+
+// Tests that purging the waitlist removes the transactions waiting longer than
+// the requested age, retains the newer ones and prevents re-announcing them.
+func TestTransactionFetcherPurgeStale(t *testing.T) {
+	var fetcher *TxFetcher
+
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcher(
+				func(common.Hash) bool { return false },
+				nil,
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			return fetcher
+		},
+		steps: []interface{}{
+			// Announce a few transactions at different times
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x01}, {0x02}}, types: []byte{types.LegacyTxType, types.LegacyTxType}, sizes: []uint32{111, 222}},
+			doTxNotify{peer: "B", hashes: []common.Hash{{0x02}}, types: []byte{types.LegacyTxType}, sizes: []uint32{222}},
+			doWait{time: txArriveTimeout / 2, step: false},
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x03}}, types: []byte{types.LegacyTxType}, sizes: []uint32{333}},
+			doTxNotify{peer: "B", hashes: []common.Hash{{0x04}}, types: []byte{types.LegacyTxType}, sizes: []uint32{444}},
+			doWait{time: txArriveTimeout / 4, step: false},
+
+			// Purge with a limit above every age and ensure nothing's removed
+			doPurge{maxAge: txArriveTimeout, removed: 0},
+			isUnderpriced(0),
+
+			// Purge the old announcements and ensure only the new ones remain
+			doPurge{maxAge: txArriveTimeout / 2, removed: 2},
+			isWaiting(map[string][]announce{
+				"A": {{common.Hash{0x03}, types.LegacyTxType, 333}},
+				"B": {{common.Hash{0x04}, types.LegacyTxType, 444}},
+			}),
+			isScheduled{nil, nil, nil},
+			isUnderpriced(2),
+
+			// Re-announce a purged transaction and ensure it's ignored (the announce
+			// is filtered before it reaches the loop, so there's no step to wait for)
+			doFunc(func() {
+				if err := fetcher.Notify("C", []byte{types.LegacyTxType}, []uint32{111}, []common.Hash{{0x01}}); err != nil {
+					t.Errorf("failed to announce transaction: %v", err)
+				}
+			}),
+			isWaiting(map[string][]announce{
+				"A": {{common.Hash{0x03}, types.LegacyTxType, 333}},
+				"B": {{common.Hash{0x04}, types.LegacyTxType, 444}},
+			}),
+		},
+	})
+}
+
+// Tests that purging also removes the transactions queued for retrieval longer
+// than the requested age, leaving the ones being fetched alone.
+func TestTransactionFetcherPurgeStaleQueued(t *testing.T) {
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			return NewTxFetcher(
+				func(common.Hash) bool { return false },
+				nil,
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+		},
+		steps: []interface{}{
+			// Keep the peer busy fetching a transaction, queueing up the later ones
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x01}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x02}}, types: []byte{types.LegacyTxType}, sizes: []uint32{222}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x03}}, types: []byte{types.LegacyTxType}, sizes: []uint32{333}},
+			doWait{time: txArriveTimeout, step: true},
+			doWait{time: txArriveTimeout / 2, step: false},
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {
+						{common.Hash{0x01}, types.LegacyTxType, 111},
+						{common.Hash{0x02}, types.LegacyTxType, 222},
+						{common.Hash{0x03}, types.LegacyTxType, 333},
+					},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {{0x01}},
+				},
+			},
+			// Purge the older queued announcement and ensure the fetch is kept
+			doPurge{maxAge: txArriveTimeout, removed: 1},
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {
+						{common.Hash{0x01}, types.LegacyTxType, 111},
+						{common.Hash{0x03}, types.LegacyTxType, 333},
+					},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {{0x01}},
+				},
+			},
+			isUnderpriced(1),
+		},
+	})
+}
+
+// Tests that draining a peer cleans out all its traces, leaving announcements
+// from other peers intact and reporting the hashes without any other origin.
+func TestTransactionFetcherDrain(t *testing.T) {
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			return NewTxFetcher(
+				func(common.Hash) bool { return false },
+				func(peer string, txs []*types.Transaction) []error {
+					return make([]error, len(txs))
+				},
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+		},
+		steps: []interface{}{
+			// Set up a few hashes into various stages, some shared with other peers
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x01}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x02}, {0x03}}, types: []byte{types.LegacyTxType, types.LegacyTxType}, sizes: []uint32{222, 333}},
+			doTxNotify{peer: "B", hashes: []common.Hash{{0x02}}, types: []byte{types.LegacyTxType}, sizes: []uint32{222}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x04}, {0x05}}, types: []byte{types.LegacyTxType, types.LegacyTxType}, sizes: []uint32{444, 555}},
+			doTxNotify{peer: "C", hashes: []common.Hash{{0x04}}, types: []byte{types.LegacyTxType}, sizes: []uint32{444}},
+
+			isWaiting(map[string][]announce{
+				"A": {
+					{common.Hash{0x04}, types.LegacyTxType, 444},
+					{common.Hash{0x05}, types.LegacyTxType, 555},
+				},
+				"C": {
+					{common.Hash{0x04}, types.LegacyTxType, 444},
+				},
+			}),
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {
+						{common.Hash{0x01}, types.LegacyTxType, 111},
+						{common.Hash{0x02}, types.LegacyTxType, 222},
+						{common.Hash{0x03}, types.LegacyTxType, 333},
+					},
+					"B": {
+						{common.Hash{0x02}, types.LegacyTxType, 222},
+					},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {{0x01}},
+					"B": {{0x02}},
+				},
+			},
+			// Drain the peer and ensure only the hashes with no fallback are lost
+			doDrain{peer: "A", lost: []common.Hash{{0x01}, {0x03}, {0x05}}},
+			isWaiting(map[string][]announce{
+				"C": {
+					{common.Hash{0x04}, types.LegacyTxType, 444},
+				},
+			}),
+			isScheduled{
+				tracking: map[string][]announce{
+					"B": {
+						{common.Hash{0x02}, types.LegacyTxType, 222},
+					},
+				},
+				fetching: map[string][]common.Hash{
+					"B": {{0x02}},
+				},
+			},
+			// Draining an unknown peer should be a noop
+			doDrain{peer: "D", lost: nil},
+		},
+	})
+}
+
 // Tests that dropping a peer instantly reschedules failed announcements to any
 // available peer.
 func TestTransactionFetcherDropRescheduling(t *testing.T) {
@@ -1543,6 +1794,72 @@ func TestTransactionFetcherDropRescheduling(t *testing.T) {
 	})
 }
 
+// Tests that dropping a peer reschedules its in-flight transactions to other
+// peers announcing them, while new announcements from it are accepted again
+// straight away, so Drop also resets a peer whose state got out of sync.
+func TestTransactionFetcherDropReannounce(t *testing.T) {
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			return NewTxFetcher(
+				func(common.Hash) bool { return false },
+				func(peer string, txs []*types.Transaction) []error {
+					return make([]error, len(txs))
+				},
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+		},
+		steps: []interface{}{
+			// Set up a few hashes into various stages
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x01}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x02}}, types: []byte{types.LegacyTxType}, sizes: []uint32{222}},
+			doTxNotify{peer: "B", hashes: []common.Hash{{0x01}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+
+			isWaiting(map[string][]announce{
+				"A": {{common.Hash{0x02}, types.LegacyTxType, 222}},
+			}),
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {{common.Hash{0x01}, types.LegacyTxType, 111}},
+					"B": {{common.Hash{0x01}, types.LegacyTxType, 111}},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {{0x01}},
+				},
+			},
+			// Drop the peer and ensure its state was moved over to the other one
+			doDrop("A"),
+			isWaiting(nil),
+			isScheduled{
+				tracking: map[string][]announce{
+					"B": {{common.Hash{0x01}, types.LegacyTxType, 111}},
+				},
+				fetching: map[string][]common.Hash{
+					"B": {{0x01}},
+				},
+			},
+			// Ensure the peer can still announce transactions
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x03}}, types: []byte{types.LegacyTxType}, sizes: []uint32{333}},
+			isWaiting(map[string][]announce{
+				"A": {{common.Hash{0x03}, types.LegacyTxType, 333}},
+			}),
+			doWait{time: txArriveTimeout, step: true},
+			isWaiting(nil),
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {{common.Hash{0x03}, types.LegacyTxType, 333}},
+					"B": {{common.Hash{0x01}, types.LegacyTxType, 111}},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {{0x03}},
+					"B": {{0x01}},
+				},
+			},
+		},
+	})
+}
+
 // Tests that announced transactions with the wrong transaction type or size will
 // result in a dropped peer.
 func TestInvalidAnnounceMetadata(t *testing.T) {
@@ -1872,6 +2189,32 @@ func testTransactionFetcher(t *testing.T, tt txFetcherTest) {
 			case <-time.After(time.Millisecond):
 			}
 
+		case doTxNotifyBatch:
+			announces := make([]TxAnnounce, 0, len(step))
+			for _, ann := range step {
+				announces = append(announces, TxAnnounce{Peer: ann.peer, Types: ann.types, Sizes: ann.sizes, Hashes: ann.hashes})
+			}
+			if err := fetcher.NotifyBatch(announces); err != nil {
+				t.Errorf("step %d: %v", i, err)
+			}
+			<-wait // Fetcher needs to process the whole batch in one step
+			select {
+			case <-wait:
+				t.Errorf("step %d: batch processed in multiple steps", i)
+			case <-time.After(time.Millisecond):
+			}
+
+		case doTxReplay:
+			// Replays are filtered by the loop, which must not track anything new
+			tracked := fetcher.AnnounceCount()[step.peer]
+			if err := fetcher.Notify(step.peer, step.types, step.sizes, step.hashes); err != nil {
+				t.Errorf("step %d: %v", i, err)
+			}
+			<-wait // Fetcher needs to process this, wait until it's done
+			if have := fetcher.AnnounceCount()[step.peer]; have != tracked {
+				t.Errorf("step %d: replayed announcement tracked: have %d hashes, want %d", i, have, tracked)
+			}
+
 		case doTxEnqueue:
 			if err := fetcher.Enqueue(step.peer, step.txs, step.direct); err != nil {
 				t.Errorf("step %d: %v", i, err)
@@ -1890,6 +2233,60 @@ func testTransactionFetcher(t *testing.T, tt txFetcherTest) {
 			}
 			<-wait // Fetcher needs to process this, wait until it's done
 
+		case doDrain:
+			lost, err := fetcher.DrainPeer(step.peer)
+			if err != nil {
+				t.Errorf("step %d: %v", i, err)
+			}
+			<-wait // Fetcher needs to process this, wait until it's done
+
+			slices.SortFunc(lost, func(a, b common.Hash) int { return a.Cmp(b) })
+			if !slices.Equal(lost, step.lost) {
+				t.Errorf("step %d: lost hashes mismatch: have %x, want %x", i, lost, step.lost)
+			}
+
+		case doPurge:
+			if removed := fetcher.PurgeStale(step.maxAge); removed != step.removed {
+				t.Errorf("step %d: purged hash count mismatch: have %d, want %d", i, removed, step.removed)
+			}
+			<-wait // Fetcher needs to process this, wait until it's done
+
+		case doFlush:
+			if fetched, dropped := fetcher.ForceFlush(); fetched != step.fetched || dropped != step.dropped {
+				t.Errorf("step %d: flushed hash count mismatch: have %d fetched, %d dropped, want %d fetched, %d dropped", i, fetched, dropped, step.fetched, step.dropped)
+			}
+			<-wait // Fetcher needs to process this, wait until it's done
+
+		case doShutdown:
+			ctx, cancel := context.WithTimeout(context.Background(), step.timeout)
+			errc := make(chan error, 1)
+			go func() { errc <- fetcher.Shutdown(ctx) }()
+
+			var err error
+		drain: // The fetcher needs to process the flush, keep stepping it until done
+			for {
+				select {
+				case <-wait:
+				case err = <-errc:
+					break drain
+				}
+			}
+			cancel()
+
+			var inflight []common.Hash
+			if timeout := new(ShutdownTimeoutError); errors.As(err, &timeout) {
+				inflight = timeout.InflightHashes
+			} else if err != nil {
+				t.Errorf("step %d: shutdown failed: %v", i, err)
+			}
+			slices.SortFunc(inflight, func(a, b common.Hash) int { return a.Cmp(b) })
+			if !slices.Equal(inflight, step.inflight) {
+				t.Errorf("step %d: in-flight hashes mismatch: have %x, want %x", i, inflight, step.inflight)
+			}
+			if step.inflight != nil && !errors.Is(err, ErrShutdownTimeout) {
+				t.Errorf("step %d: shutdown error mismatch: have %v, want %v", i, err, ErrShutdownTimeout)
+			}
+
 		case doFunc:
 			step()
 
@@ -2184,3 +2581,1000 @@ func TestTransactionForgotten(t *testing.T) {
 		t.Fatal("transaction should be known underpriced")
 	}
 }
+
+// Tests that the optional fetcher settings fall back to their production
+// defaults if not explicitly configured, and are overridden otherwise.
+func TestTransactionFetcherOptions(t *testing.T) {
+	fetcher := NewTxFetcherWithOptions(
+		WithHasTx(func(common.Hash) bool { return false }),
+		WithFetchTxs(func(string, []common.Hash) error { return nil }),
+	)
+	if _, ok := fetcher.clock.(mclock.System); !ok {
+		t.Errorf("clock mismatch: have %T, want %T", fetcher.clock, mclock.System{})
+	}
+	if fetcher.rand != nil {
+		t.Errorf("randomizer set without being requested")
+	}
+	if fetcher.maxAnnounces != maxTxAnnounces {
+		t.Errorf("announce limit mismatch: have %d, want %d", fetcher.maxAnnounces, maxTxAnnounces)
+	}
+	if fetcher.hasTx == nil || fetcher.fetchTxs == nil {
+		t.Errorf("explicitly configured callbacks missing")
+	}
+	if fetcher.addTxs != nil || fetcher.dropPeer != nil {
+		t.Errorf("unconfigured callbacks set")
+	}
+	// Override all the optional settings and ensure they are applied
+	var (
+		clock = new(mclock.Simulated)
+		rand  = rand.New(rand.NewSource(0x3a29))
+	)
+	fetcher = NewTxFetcherWithOptions(WithClock(clock), WithRand(rand), WithMaxAnnounces(16))
+	if fetcher.clock != clock {
+		t.Errorf("clock mismatch: have %v, want %v", fetcher.clock, clock)
+	}
+	if fetcher.rand != rand {
+		t.Errorf("randomizer mismatch: have %v, want %v", fetcher.rand, rand)
+	}
+	if fetcher.maxAnnounces != 16 {
+		t.Errorf("announce limit mismatch: have %d, want %d", fetcher.maxAnnounces, 16)
+	}
+}
+
+// Tests that only the transactions successfully added to the pool are
+// acknowledged back to the delivering peer.
+func TestTransactionFetcherAckTxs(t *testing.T) {
+	var (
+		ackPeer string
+		acked   []common.Hash
+	)
+	fetcher := NewTxFetcherWithOptions(
+		WithHasTx(func(common.Hash) bool { return false }),
+		WithAddTxs(func(peer string, txs []*types.Transaction) []error {
+			return []error{nil, txpool.ErrAlreadyKnown, nil, txpool.ErrUnderpriced}
+		}),
+		WithFetchTxs(func(string, []common.Hash) error { return nil }),
+		WithAckTxs(func(peer string, hashes []common.Hash) {
+			ackPeer, acked = peer, hashes
+		}),
+	)
+	fetcher.Start()
+	defer fetcher.Stop()
+
+	if err := fetcher.Enqueue("A", testTxs, true); err != nil {
+		t.Fatal(err)
+	}
+	if ackPeer != "A" {
+		t.Errorf("ack peer mismatch: have %q, want %q", ackPeer, "A")
+	}
+	want := []common.Hash{testTxsHashes[0], testTxsHashes[2]}
+	if !slices.Equal(acked, want) {
+		t.Errorf("acked hashes mismatch: have %x, want %x", acked, want)
+	}
+}
+
+// Tests that the fetcher posts the stage transitions of a retrieved transaction
+// on its event feed, in the order of the state machine.
+func TestTransactionFetcherEvents(t *testing.T) {
+	var (
+		events = make(chan TxFetcherStateEvent, 16)
+		sub    event.Subscription
+	)
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher := NewTxFetcher(
+				func(common.Hash) bool { return false },
+				func(peer string, txs []*types.Transaction) []error {
+					return make([]error, len(txs))
+				},
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			sub = fetcher.Events().Subscribe(events)
+			return fetcher
+		},
+		steps: []interface{}{
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxEnqueue{peer: "A", txs: []*types.Transaction{testTxs[0]}, direct: true},
+			isScheduled{nil, nil, nil},
+			doFunc(func() {
+				defer sub.Unsubscribe()
+
+				want := []TxFetcherStateEvent{
+					{Hash: testTxsHashes[0], Old: EventNone, New: EventWaiting},
+					{Hash: testTxsHashes[0], Old: EventWaiting, New: EventQueued},
+					{Hash: testTxsHashes[0], Old: EventQueued, New: EventFetching},
+					{Hash: testTxsHashes[0], Old: EventFetching, New: EventDelivered},
+				}
+				for i, exp := range want {
+					select {
+					case have := <-events:
+						if have != exp {
+							t.Errorf("event %d mismatch: have %v -> %v, want %v -> %v", i, have.Old, have.New, exp.Old, exp.New)
+						}
+					case <-time.After(time.Second):
+						t.Fatalf("event %d missing: want %v -> %v", i, exp.Old, exp.New)
+					}
+				}
+				select {
+				case have := <-events:
+					t.Errorf("unexpected event: %v -> %v", have.Old, have.New)
+				case <-time.After(10 * time.Millisecond):
+				}
+			}),
+		},
+	})
+}
+
+// Tests that a subscriber not consuming its events does not stall the fetcher,
+// the events overflowing the buffer being dropped instead.
+func TestTransactionFetcherEventsBlockedSubscriber(t *testing.T) {
+	t.Parallel()
+
+	fetcher := NewTxFetcher(
+		func(common.Hash) bool { return false },
+		func(peer string, txs []*types.Transaction) []error {
+			return make([]error, len(txs))
+		},
+		func(string, []common.Hash) error { return nil },
+		nil,
+	)
+	sub := fetcher.Events().Subscribe(make(chan TxFetcherStateEvent))
+	defer sub.Unsubscribe()
+
+	fetcher.Start()
+	defer fetcher.Stop()
+
+	// Post a few times more events than can be buffered
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			hashes := make([]common.Hash, txEventBuffer)
+			for j := range hashes {
+				hashes[j] = common.Hash{byte(i), byte(j >> 8), byte(j)}
+			}
+			peer := fmt.Sprintf("peer-%d", i)
+			if err := fetcher.Notify(peer, make([]byte, len(hashes)), make([]uint32, len(hashes)), hashes); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- fetcher.Enqueue("peer-0", testTxs, false)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to feed the fetcher: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fetcher stalled by a blocked subscriber")
+	}
+}
+
+// Tests that if almost all transaction retrievals time out, the fetcher reports
+// a possible network partition.
+func TestTransactionFetcherPartitionDetection(t *testing.T) {
+	var (
+		partitions = make(chan PartitionEvent, 1)
+		sub        event.Subscription
+	)
+	hashes := make([]common.Hash, 20)
+	for i := range hashes {
+		hashes[i] = common.Hash{byte(i + 1)}
+	}
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher := NewTxFetcher(
+				func(common.Hash) bool { return false },
+				func(peer string, txs []*types.Transaction) []error {
+					return make([]error, len(txs))
+				},
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			sub = fetcher.PartitionEvents().Subscribe(partitions)
+			return fetcher
+		},
+		steps: []interface{}{
+			// Schedule a single transaction from one peer and many from another
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doTxNotify{peer: "B", hashes: hashes, types: make([]byte, len(hashes)), sizes: make([]uint32, len(hashes))},
+			doWait{time: txArriveTimeout, step: true},
+
+			// Deliver the first one, but let all the rest time out
+			doTxEnqueue{peer: "A", txs: []*types.Transaction{testTxs[0]}, direct: true},
+			doFunc(func() {
+				select {
+				case event := <-partitions:
+					t.Fatalf("partition reported prematurely: %+v", event)
+				default:
+				}
+			}),
+			doWait{time: txFetchTimeout, step: true},
+			doFunc(func() {
+				defer sub.Unsubscribe()
+
+				select {
+				case event := <-partitions:
+					if event.Delivered != 1 || event.TimedOut != len(hashes) {
+						t.Errorf("partition event mismatch: have %+v, want 1 delivered, %d timed out", event, len(hashes))
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("partition not reported")
+				}
+			}),
+		},
+	})
+}
+
+// Tests that the time transactions spend queued for retrieval is measured from
+// the moment they are queued until they are requested.
+func TestTransactionFetcherQueueAge(t *testing.T) {
+	metrics.Enable()
+	txFetcherQueueAge.Snapshot() // Drop any measurements of other tests
+
+	testTransactionFetcher(t, txFetcherTest{
+		init: func() *TxFetcher {
+			return NewTxFetcher(
+				func(common.Hash) bool { return false },
+				func(peer string, txs []*types.Transaction) []error {
+					return make([]error, len(txs))
+				},
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+		},
+		steps: []interface{}{
+			// Keep the peer busy with a request, so the next announcement gets queued
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[1]}, types: []byte{testTxs[1].Type()}, sizes: []uint32{uint32(testTxs[1].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {
+						{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())},
+						{testTxsHashes[1], testTxs[1].Type(), uint32(testTxs[1].Size())},
+					},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {testTxsHashes[0]},
+				},
+			},
+			// Free up the peer after a while and ensure the queueing time is tracked
+			doWait{time: 500 * time.Millisecond, step: false},
+			doTxEnqueue{peer: "A", txs: []*types.Transaction{testTxs[0]}, direct: true},
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {
+						{testTxsHashes[1], testTxs[1].Type(), uint32(testTxs[1].Size())},
+					},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {testTxsHashes[1]},
+				},
+			},
+			doFunc(func() {
+				snap := txFetcherQueueAge.Snapshot()
+				if snap.Count() != 2 {
+					t.Fatalf("queue age measurement count mismatch: have %d, want 2", snap.Count())
+				}
+				if age := time.Duration(snap.Min()); age != 0 {
+					t.Errorf("immediate retrieval queue age mismatch: have %v, want 0", age)
+				}
+				if age := time.Duration(snap.Max()); age != 500*time.Millisecond {
+					t.Errorf("queue age mismatch: have %v, want %v", age, 500*time.Millisecond)
+				}
+			}),
+		},
+	})
+}
+
+// Tests that force flushing the fetcher finalises all the waiting and queued
+// transactions, counting the ones already in the pool as fetched, while leaving
+// the in-flight requests alone.
+func TestTransactionFetcherForceFlush(t *testing.T) {
+	var known atomic.Bool // Whether the pool has the second transaction
+
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			return NewTxFetcher(
+				func(hash common.Hash) bool { return known.Load() && hash == testTxsHashes[1] },
+				func(peer string, txs []*types.Transaction) []error {
+					return make([]error, len(txs))
+				},
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+		},
+		steps: []interface{}{
+			// Keep peer A busy, queue up two more transactions behind it and make
+			// peer B announce a fourth one which stays in the waitlist
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[1], testTxsHashes[2]}, types: []byte{testTxs[1].Type(), testTxs[2].Type()}, sizes: []uint32{uint32(testTxs[1].Size()), uint32(testTxs[2].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "B", hashes: []common.Hash{testTxsHashes[3]}, types: []byte{testTxs[3].Type()}, sizes: []uint32{uint32(testTxs[3].Size())}},
+			isWaiting(map[string][]announce{
+				"B": {{testTxsHashes[3], testTxs[3].Type(), uint32(testTxs[3].Size())}},
+			}),
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {
+						{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())},
+						{testTxsHashes[1], testTxs[1].Type(), uint32(testTxs[1].Size())},
+						{testTxsHashes[2], testTxs[2].Type(), uint32(testTxs[2].Size())},
+					},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {testTxsHashes[0]},
+				},
+			},
+			// Let the second transaction arrive through some other path and flush
+			doFunc(func() { known.Store(true) }),
+			doFlush{fetched: 1, dropped: 2},
+			isWaiting(nil),
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())}},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {testTxsHashes[0]},
+				},
+			},
+			// Ensure the in-flight request still completes normally
+			doTxEnqueue{peer: "A", txs: []*types.Transaction{testTxs[0]}, direct: true},
+			isScheduled{nil, nil, nil},
+		},
+	})
+}
+
+// Tests that a graceful shutdown flushes the transactions not yet being fetched,
+// and reports the ones still in flight if the deadline expires.
+func TestTransactionFetcherShutdownTimeout(t *testing.T) {
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			return NewTxFetcher(
+				func(common.Hash) bool { return false },
+				func(peer string, txs []*types.Transaction) []error {
+					return make([]error, len(txs))
+				},
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+		},
+		steps: []interface{}{
+			// Keep peer A busy with a retrieval and queue up another one behind it
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[1]}, types: []byte{testTxs[1].Type()}, sizes: []uint32{uint32(testTxs[1].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {
+						{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())},
+						{testTxsHashes[1], testTxs[1].Type(), uint32(testTxs[1].Size())},
+					},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {testTxsHashes[0]},
+				},
+			},
+			// Shut down without peer A ever answering and ensure the in-flight
+			// transaction is reported while the queued one is flushed
+			doShutdown{timeout: 50 * time.Millisecond, inflight: []common.Hash{testTxsHashes[0]}},
+			isScheduled{
+				tracking: map[string][]announce{
+					"A": {{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())}},
+				},
+				fetching: map[string][]common.Hash{
+					"A": {testTxsHashes[0]},
+				},
+			},
+		},
+	})
+}
+
+// Tests that a fetcher with nothing in flight shuts down cleanly.
+func TestTransactionFetcherShutdown(t *testing.T) {
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			return NewTxFetcher(
+				func(common.Hash) bool { return false },
+				nil,
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+		},
+		steps: []interface{}{
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			isWaiting(map[string][]announce{
+				"A": {{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())}},
+			}),
+			doShutdown{timeout: time.Second},
+			isWaiting(nil),
+			isScheduled{nil, nil, nil},
+		},
+	})
+}
+
+// Tests that a graceful shutdown respects its deadline even if the event loop
+// never responds.
+func TestTransactionFetcherShutdownUnresponsive(t *testing.T) {
+	t.Parallel()
+
+	// The loop is never started, so nothing answers the flush
+	fetcher := NewTxFetcher(
+		func(common.Hash) bool { return false },
+		nil,
+		func(string, []common.Hash) error { return nil },
+		nil,
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- fetcher.Shutdown(ctx) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("shutdown error mismatch: have %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("shutdown ignored its deadline")
+	}
+}
+
+// Tests that transactions re-announced by the same peer within the replay window
+// are ignored, while announcements from other peers or after the window expires
+// are still processed.
+func TestTransactionFetcherAnnounceReplay(t *testing.T) {
+	var fetcher *TxFetcher
+
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcherWithOptions(
+				WithHasTx(func(common.Hash) bool { return false }),
+				WithFetchTxs(func(string, []common.Hash) error { return nil }),
+				WithReplayWindow(txArriveTimeout/2),
+			)
+			return fetcher
+		},
+		steps: []interface{}{
+			// Announce a transaction and replay it from the same peer
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doTxReplay{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doFunc(func() {
+				var replayed int
+				fetcher.inspect(func() { replayed = fetcher.replayed })
+				if replayed != 1 {
+					t.Errorf("replayed announcement count mismatch: have %d, want 1", replayed)
+				}
+			}),
+			// Ensure other peers may still announce the same transaction
+			doTxNotify{peer: "B", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			isWaiting(map[string][]announce{
+				"A": {{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())}},
+				"B": {{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())}},
+			}),
+			// Wait for the replay window to expire and ensure the re-announcement
+			// is processed again
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+		},
+	})
+}
+
+// Tests that the recent announcements are forgotten when they are flushed or
+// their peer is dropped, so the transactions are processed anew if announced
+// again by the same peer.
+func TestTransactionFetcherAnnounceReplayCleanup(t *testing.T) {
+	var fetcher *TxFetcher
+
+	notify := doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}}
+	waiting := isWaiting(map[string][]announce{
+		"A": {{testTxsHashes[0], testTxs[0].Type(), uint32(testTxs[0].Size())}},
+	})
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcherWithOptions(
+				WithHasTx(func(common.Hash) bool { return false }),
+				WithFetchTxs(func(string, []common.Hash) error { return nil }),
+				WithReplayWindow(time.Minute),
+			)
+			return fetcher
+		},
+		steps: []interface{}{
+			// Flush a waiting transaction and ensure it's accepted again
+			notify,
+			doFlush{dropped: 1},
+			notify,
+			waiting,
+
+			// Drop the peer and ensure its recent announcements are gone
+			doDrop("A"),
+			doFunc(func() {
+				var tracked int
+				fetcher.inspect(func() { tracked = len(fetcher.recentAnnounces) })
+				if tracked != 0 {
+					t.Errorf("recent announcement caches mismatch: have %d, want 0", tracked)
+				}
+			}),
+			notify,
+			waiting,
+		},
+	})
+}
+
+// Tests that the state dump lists the tracked transactions and requests in the
+// sections of their respective stages.
+func TestTransactionFetcherDumpState(t *testing.T) {
+	var fetcher *TxFetcher
+
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcher(
+				func(common.Hash) bool { return false },
+				nil,
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			return fetcher
+		},
+		steps: []interface{}{
+			// Keep peer A busy, queue up another transaction behind it and make
+			// peer B announce a third one which stays in the waitlist
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{testTxs[0].Type()}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[1]}, types: []byte{testTxs[1].Type()}, sizes: []uint32{uint32(testTxs[1].Size())}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "B", hashes: []common.Hash{testTxsHashes[2]}, types: []byte{testTxs[2].Type()}, sizes: []uint32{uint32(testTxs[2].Size())}},
+			doFunc(func() {
+				dump := fetcher.DumpState()
+
+				// Split the dump into its sections and check each one
+				sections := strings.Split(dump, "\n\n")
+				if len(sections) != 4 {
+					t.Fatalf("section count mismatch: have %d, want 4\n%s", len(sections), dump)
+				}
+				for i, want := range [][]string{
+					{"WAITING (1)", testTxsHashes[2].Hex(), "B"},
+					{"QUEUED (1)", testTxsHashes[1].Hex(), "A"},
+					{"FETCHING (1)", testTxsHashes[0].Hex(), "A", "500ms"},
+					{"REQUESTS (1)", "A"},
+				} {
+					for _, s := range want {
+						if !strings.Contains(sections[i], s) {
+							t.Errorf("section %d: missing %q\n%s", i, s, sections[i])
+						}
+					}
+				}
+			}),
+		},
+	})
+}
+
+// Tests that the announcement counts of the peers cover all the stages their
+// announced hashes are in.
+func TestTransactionFetcherAnnounceCount(t *testing.T) {
+	var fetcher *TxFetcher
+
+	// announce creates a notification of count unique hashes tagged by the peer
+	announce := func(peer string, tag byte, count int) doTxNotify {
+		ann := doTxNotify{peer: peer}
+		for i := 0; i < count; i++ {
+			ann.hashes = append(ann.hashes, common.Hash{tag, byte(i)})
+			ann.types = append(ann.types, types.LegacyTxType)
+			ann.sizes = append(ann.sizes, 111)
+		}
+		return ann
+	}
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcher(
+				func(common.Hash) bool { return false },
+				nil,
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			return fetcher
+		},
+		steps: []interface{}{
+			// Move the announcements of peer A into the queue and fetching stages,
+			// keeping the ones of peer B in the waitlist
+			doFunc(func() {
+				if counts := fetcher.AnnounceCount(); len(counts) != 0 {
+					t.Errorf("counts mismatch: have %v, want none", counts)
+				}
+			}),
+			announce("A", 0xa, 100),
+			doWait{time: txArriveTimeout, step: true},
+			announce("B", 0xb, 50),
+			doFunc(func() {
+				counts := fetcher.AnnounceCount()
+				if len(counts) != 2 || counts["A"] != 100 || counts["B"] != 50 {
+					t.Errorf("counts mismatch: have %v, want map[A:100 B:50]", counts)
+				}
+			}),
+		},
+	})
+}
+
+// Tests that the peers are counted across all stages of their announcements,
+// and that only the ones with in-flight retrievals are considered active.
+func TestTransactionFetcherPeerCount(t *testing.T) {
+	var fetcher *TxFetcher
+
+	checkCounts := func(peers, active int) doFunc {
+		return func() {
+			if have := fetcher.PeerCount(); have != peers {
+				t.Errorf("peer count mismatch: have %d, want %d", have, peers)
+			}
+			if have := fetcher.ActivePeerCount(); have != active {
+				t.Errorf("active peer count mismatch: have %d, want %d", have, active)
+			}
+		}
+	}
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcher(
+				func(common.Hash) bool { return false },
+				nil,
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			return fetcher
+		},
+		steps: []interface{}{
+			checkCounts(0, 0),
+
+			// Peer B is fetching, peer C has the same transaction queued as an
+			// alternate and peer A has a transaction waiting
+			doTxNotify{peer: "B", hashes: []common.Hash{{0x01}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+			doWait{time: txArriveTimeout, step: true},
+			doTxNotify{peer: "C", hashes: []common.Hash{{0x01}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+			doTxNotify{peer: "A", hashes: []common.Hash{{0x02}}, types: []byte{types.LegacyTxType}, sizes: []uint32{222}},
+			isWaiting(map[string][]announce{
+				"A": {{common.Hash{0x02}, types.LegacyTxType, 222}},
+			}),
+			isScheduled{
+				tracking: map[string][]announce{
+					"B": {{common.Hash{0x01}, types.LegacyTxType, 111}},
+					"C": {{common.Hash{0x01}, types.LegacyTxType, 111}},
+				},
+				fetching: map[string][]common.Hash{
+					"B": {{0x01}},
+				},
+			},
+			checkCounts(3, 1),
+
+			// Drop the fetching peer, moving the retrieval over to peer C
+			doDrop("B"),
+			checkCounts(2, 1),
+		},
+	})
+}
+
+// Tests that the transactions queued the longest for retrieval are reported
+// oldest first, regardless of their hash ordering.
+func TestTransactionFetcherTopKQueued(t *testing.T) {
+	// Temporarily disable fetch timeouts as they massively mess up the simulated clock
+	defer func(timeout time.Duration) { txFetchTimeout = timeout }(txFetchTimeout)
+	txFetchTimeout = 24 * time.Hour
+
+	var fetcher *TxFetcher
+
+	// Keep the peer busy with a retrieval, so that none of its later
+	// announcements leave the queue
+	steps := []interface{}{
+		doTxNotify{peer: "A", hashes: []common.Hash{{0xff}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+		doWait{time: txArriveTimeout, step: true},
+	}
+	// Queue the hashes in descending order, one arrival timeout apart, so the
+	// oldest ones are the largest hashes
+	for i := 0; i < 100; i++ {
+		steps = append(steps,
+			doTxNotify{peer: "A", hashes: []common.Hash{{byte(99 - i)}}, types: []byte{types.LegacyTxType}, sizes: []uint32{111}},
+			doWait{time: txArriveTimeout, step: true},
+		)
+	}
+	steps = append(steps, doFunc(func() {
+		if hashes := fetcher.TopKQueued(0); len(hashes) != 0 {
+			t.Errorf("top 0 mismatch: have %v, want none", hashes)
+		}
+		want := []common.Hash{{99}, {98}, {97}, {96}, {95}}
+		if hashes := fetcher.TopKQueued(5); !slices.Equal(hashes, want) {
+			t.Errorf("top 5 mismatch: have %v, want %v", hashes, want)
+		}
+		if hashes := fetcher.TopKQueued(200); len(hashes) != 100 || hashes[0] != (common.Hash{99}) || hashes[99] != (common.Hash{0}) {
+			t.Errorf("top 200 mismatch: have %d hashes, want all 100 oldest first", len(hashes))
+		}
+	}))
+	testTransactionFetcher(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcher(
+				func(common.Hash) bool { return false },
+				nil,
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			return fetcher
+		},
+		steps: steps,
+	})
+}
+
+// Tests that the peer first announcing a transaction is remembered as its gossip
+// origin, even after other peers announce it too and it is retrieved.
+func TestTransactionFetcherGossipOrigin(t *testing.T) {
+	var fetcher *TxFetcher
+
+	checkOrigins := func(want map[common.Hash]string) doFunc {
+		return func() {
+			for hash, peer := range want {
+				if have, ok := fetcher.GossipOrigin(hash); !ok || have != peer {
+					t.Errorf("origin mismatch for %x: have %q/%v, want %q", hash, have, ok, peer)
+				}
+			}
+			if have, ok := fetcher.GossipOrigin(common.Hash{0xff}); ok {
+				t.Errorf("unknown hash has origin %q", have)
+			}
+		}
+	}
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			fetcher = NewTxFetcher(
+				func(common.Hash) bool { return false },
+				func(_ string, txs []*types.Transaction) []error {
+					return make([]error, len(txs))
+				},
+				func(string, []common.Hash) error { return nil },
+				nil,
+			)
+			return fetcher
+		},
+		steps: []interface{}{
+			doTxNotify{peer: "A", hashes: []common.Hash{testTxsHashes[0]}, types: []byte{types.LegacyTxType}, sizes: []uint32{uint32(testTxs[0].Size())}},
+			doTxNotify{peer: "B", hashes: []common.Hash{testTxsHashes[0], testTxsHashes[1]}, types: []byte{types.LegacyTxType, types.LegacyTxType}, sizes: []uint32{uint32(testTxs[0].Size()), uint32(testTxs[1].Size())}},
+			checkOrigins(map[common.Hash]string{testTxsHashes[0]: "A", testTxsHashes[1]: "B"}),
+
+			// Retrieve the transactions and ensure the origins are retained
+			doWait{time: txArriveTimeout, step: true},
+			doTxEnqueue{peer: "A", txs: []*types.Transaction{testTxs[0]}, direct: true},
+			doTxEnqueue{peer: "B", txs: []*types.Transaction{testTxs[1]}, direct: true},
+			isScheduled{nil, nil, nil},
+			checkOrigins(map[common.Hash]string{testTxsHashes[0]: "A", testTxsHashes[1]: "B"}),
+		},
+	})
+}
+
+// Tests that the gossip origins are evicted oldest first once the tracked set
+// is full.
+func TestTransactionFetcherGossipOriginEviction(t *testing.T) {
+	fetcher := NewTxFetcher(nil, nil, nil, nil)
+	for i := 0; i < maxTxOriginSetSize+10; i++ {
+		var hash common.Hash
+		binary.BigEndian.PutUint64(hash[:], uint64(i))
+		fetcher.trackOrigin(hash, fmt.Sprintf("peer-%d", i))
+	}
+	if len(fetcher.firstAnnouncer) != maxTxOriginSetSize {
+		t.Fatalf("tracked origin count mismatch: have %d, want %d", len(fetcher.firstAnnouncer), maxTxOriginSetSize)
+	}
+	for i := 0; i < maxTxOriginSetSize+10; i++ {
+		var hash common.Hash
+		binary.BigEndian.PutUint64(hash[:], uint64(i))
+
+		peer, ok := fetcher.firstAnnouncer[hash]
+		if i < 10 {
+			if ok {
+				t.Errorf("origin %d not evicted", i)
+			}
+			continue
+		}
+		if want := fmt.Sprintf("peer-%d", i); !ok || peer != want {
+			t.Errorf("origin %d mismatch: have %q/%v, want %q", i, peer, ok, want)
+		}
+	}
+}
+
+// Tests that a batch of announcements is processed in one go, with the same
+// outcome as announcing them one by one, including the announcement limit of a
+// peer being accounted across the batch.
+func TestTransactionFetcherNotifyBatch(t *testing.T) {
+	testTransactionFetcherParallel(t, txFetcherTest{
+		init: func() *TxFetcher {
+			return NewTxFetcherWithOptions(
+				WithHasTx(func(common.Hash) bool { return false }),
+				WithFetchTxs(func(string, []common.Hash) error { return nil }),
+				WithMaxAnnounces(3),
+			)
+		},
+		steps: []interface{}{
+			doTxNotify{peer: "C", hashes: []common.Hash{{0x05}}, types: []byte{types.LegacyTxType}, sizes: []uint32{555}},
+			doTxNotifyBatch{
+				{peer: "A", hashes: []common.Hash{{0x01}, {0x02}}, types: []byte{types.LegacyTxType, types.LegacyTxType}, sizes: []uint32{111, 222}},
+				{peer: "B", hashes: []common.Hash{{0x01}, {0x03}}, types: []byte{types.LegacyTxType, types.LegacyTxType}, sizes: []uint32{111, 333}},
+				{peer: "A", hashes: []common.Hash{{0x03}, {0x04}}, types: []byte{types.LegacyTxType, types.LegacyTxType}, sizes: []uint32{333, 444}},
+			},
+			isWaiting(map[string][]announce{
+				"A": {
+					{common.Hash{0x01}, types.LegacyTxType, 111},
+					{common.Hash{0x02}, types.LegacyTxType, 222},
+					{common.Hash{0x03}, types.LegacyTxType, 333},
+				},
+				"B": {
+					{common.Hash{0x01}, types.LegacyTxType, 111},
+					{common.Hash{0x03}, types.LegacyTxType, 333},
+				},
+				"C": {
+					{common.Hash{0x05}, types.LegacyTxType, 555},
+				},
+			}),
+		},
+	})
+}
+
+// Benchmarks handing the announcements of 10 peers, 256 transactions each, over
+// to the fetcher one by one and in a single batch.
+func BenchmarkTransactionFetcherNotify(b *testing.B) {
+	const peers, hashes = 10, 256
+
+	announces := make([]TxAnnounce, peers)
+	for i := range announces {
+		ann := TxAnnounce{Peer: string(rune('A' + i))}
+		for j := 0; j < hashes; j++ {
+			ann.Types = append(ann.Types, types.LegacyTxType)
+			ann.Sizes = append(ann.Sizes, 111)
+			ann.Hashes = append(ann.Hashes, common.Hash{byte(i), byte(j >> 8), byte(j)})
+		}
+		announces[i] = ann
+	}
+	// newFetcher creates a fetcher not doing any retrievals, with the duplicate
+	// announcements ignored by the replay filter disabled
+	newFetcher := func() *TxFetcher {
+		f := NewTxFetcherWithOptions(
+			WithHasTx(func(common.Hash) bool { return false }),
+			WithFetchTxs(func(string, []common.Hash) error { return nil }),
+			WithReplayWindow(0),
+		)
+		f.Start()
+		return f
+	}
+	b.Run("single", func(b *testing.B) {
+		f := newFetcher()
+		defer f.Stop()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, ann := range announces {
+				f.Notify(ann.Peer, ann.Types, ann.Sizes, ann.Hashes)
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		f := newFetcher()
+		defer f.Stop()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			f.NotifyBatch(announces)
+		}
+	})
+}
+
+// Tests that the announcements of a direct peer are requested right away,
+// skipping the wait for broadcasts, split into batches within the retrieval
+// limits, and that unmarking the peer reverts to the regular handling.
+func TestTransactionFetcherDirectPeer(t *testing.T) {
+	var (
+		clock   = new(mclock.Simulated)
+		fetched = make(chan []common.Hash, 4)
+	)
+	fetcher := NewTxFetcherWithOptions(
+		WithHasTx(func(hash common.Hash) bool { return hash == common.Hash{0xff} }),
+		WithAddTxs(func(peer string, txs []*types.Transaction) []error {
+			return make([]error, len(txs))
+		}),
+		WithFetchTxs(func(peer string, hashes []common.Hash) error {
+			fetched <- hashes
+			return nil
+		}),
+		WithClock(clock),
+	)
+	fetcher.Start()
+	defer fetcher.Stop()
+
+	fetcher.SetDirectPeer("A")
+
+	// Announce an unknown and a known transaction, only the former should be
+	// requested, without the (simulated) clock moving forward
+	start := time.Now()
+	if err := fetcher.Notify("A", []byte{types.LegacyTxType, types.LegacyTxType}, []uint32{111, 222}, []common.Hash{{0x01}, {0xff}}); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+	select {
+	case hashes := <-fetched:
+		if len(hashes) != 1 || hashes[0] != (common.Hash{0x01}) {
+			t.Fatalf("fetched hashes mismatch: have %v, want %v", hashes, []common.Hash{{0x01}})
+		}
+		t.Logf("direct announcement scheduled in %v", time.Since(start))
+	case <-time.After(time.Second):
+		t.Fatalf("direct announcement not fetched")
+	}
+	// Announce more transactions than fit a single request
+	var (
+		hashes = make([]common.Hash, maxTxRetrievals+10)
+		kinds  = make([]byte, len(hashes))
+		sizes  = make([]uint32, len(hashes))
+	)
+	for i := range hashes {
+		hashes[i] = common.Hash{0x02, byte(i >> 8), byte(i)}
+		sizes[i] = 100
+	}
+	if err := fetcher.Notify("A", kinds, sizes, hashes); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+	var batches []int
+	for len(batches) < 2 {
+		select {
+		case hashes := <-fetched:
+			batches = append(batches, len(hashes))
+		case <-time.After(time.Second):
+			t.Fatalf("direct announcements not fetched, batches so far: %v", batches)
+		}
+	}
+	if batches[0]+batches[1] != len(hashes) || max(batches[0], batches[1]) != maxTxRetrievals {
+		t.Fatalf("batch sizes mismatch: have %v, want %d and %d", batches, maxTxRetrievals, 10)
+	}
+	// Revert the peer to the regular handling, announcements should wait
+	fetcher.UnsetDirectPeer("A")
+	if err := fetcher.Notify("A", []byte{types.LegacyTxType}, []uint32{111}, []common.Hash{{0x03}}); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+	select {
+	case hashes := <-fetched:
+		t.Fatalf("regular announcement fetched without waiting: %v", hashes)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Tests that announcing with a context gives up once the context expires if
+// the fetcher is not processing announcements, and succeeds once it is.
+func TestTransactionFetcherNotifyWithContext(t *testing.T) {
+	fetcher := NewTxFetcher(
+		func(common.Hash) bool { return false },
+		func(peer string, txs []*types.Transaction) []error {
+			return make([]error, len(txs))
+		},
+		func(string, []common.Hash) error { return nil },
+		nil,
+	)
+	// The loop is not running yet, nothing picks the announcement up
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := fetcher.NotifyWithContext(ctx, "A", []byte{types.LegacyTxType}, []uint32{111}, []common.Hash{{0x01}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("blocked notify error mismatch: have %v, want %v", err, context.DeadlineExceeded)
+	}
+	fetcher.Start()
+	defer fetcher.Stop()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := fetcher.NotifyWithContext(ctx, "A", []byte{types.LegacyTxType}, []uint32{222}, []common.Hash{{0x02}}); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+	// Ensure the announcement given up on is not treated as a replay on retry
+	if err := fetcher.NotifyWithContext(ctx, "A", []byte{types.LegacyTxType}, []uint32{111}, []common.Hash{{0x01}}); err != nil {
+		t.Fatalf("failed to retry notify: %v", err)
+	}
+	if count := fetcher.AnnounceCount()["A"]; count != 2 {
+		t.Fatalf("announced transaction count mismatch: have %d, want 2", count)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/consensus/beacon"
 	"github.com/ethereum/go-ethereum/consensus/parlia"
 	"github.com/ethereum/go-ethereum/core"
@@ -47,6 +48,7 @@ import (
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 const (
@@ -130,6 +132,7 @@ type handlerConfig struct {
 	EnableEVNFeatures         bool
 	EVNNodeIdsWhitelist       []enode.ID
 	ProxyedValidatorAddresses []common.Address
+	DownloaderConfig          *downloader.DownloaderConfig // Downloader fetch timeouts (nil = defaults)
 }
 
 type handler struct {
@@ -151,6 +154,8 @@ type handler struct {
 	votepool             votePool
 	maliciousVoteMonitor *monitor.MaliciousVoteMonitor
 	chain                *core.BlockChain
+	receiptRootCache     *lru.Cache[common.Hash, rlp.RawValue] // Encoded receipts served to remote peers
+	bodyCache            *lru.Cache[common.Hash, rlp.RawValue] // Encoded bodies served to remote peers
 	maxPeers             int
 	maxPeersPerIP        int
 	peersPerIP           map[string]int
@@ -205,6 +210,8 @@ func newHandler(config *handlerConfig) (*handler, error) {
 		txpool:                     config.TxPool,
 		votepool:                   config.VotePool,
 		chain:                      config.Chain,
+		receiptRootCache:           lru.NewCache[common.Hash, rlp.RawValue](eth.ReceiptCacheSize),
+		bodyCache:                  lru.NewCache[common.Hash, rlp.RawValue](eth.BodyCacheSize),
 		peers:                      config.PeerSet,
 		peersPerIP:                 make(map[string]int),
 		requiredBlocks:             config.RequiredBlocks,
@@ -256,7 +263,11 @@ func newHandler(config *handlerConfig) (*handler, error) {
 		return nil, errors.New("snap sync not supported with snapshots disabled")
 	}
 	// Construct the downloader (long sync)
-	h.downloader = downloader.New(config.Database, h.eventMux, h.chain, h.removePeer, nil)
+	var err error
+	h.downloader, err = downloader.New(config.Database, h.eventMux, h.chain, h.removePeer, nil, config.DownloaderConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	// Construct the fetcher (short sync)
 	validator := func(header *types.Header) error {
@@ -397,7 +408,12 @@ func newHandler(config *handlerConfig) (*handler, error) {
 		}
 		return errors
 	}
-	h.txFetcher = fetcher.NewTxFetcher(h.txpool.Has, addTxs, fetchTx, h.removePeer)
+	h.txFetcher = fetcher.NewTxFetcherWithOptions(
+		fetcher.WithHasTx(h.txpool.Has),
+		fetcher.WithAddTxs(addTxs),
+		fetcher.WithFetchTxs(fetchTx),
+		fetcher.WithDropPeer(h.removePeer),
+	)
 	h.chainSync = newChainSyncer(h)
 	return h, nil
 }
@@ -554,6 +570,12 @@ func (h *handler) runEthPeer(peer *eth.Peer, handler eth.Handler) error {
 		peer.Log().Error("Failed to register peer in eth syncer", "err", err)
 		return err
 	}
+	caps := make(map[string]bool)
+	for _, cap := range peer.Caps() {
+		caps[cap.String()] = true
+	}
+	h.downloader.RegisterPeerCapabilities(peer.ID(), caps)
+
 	if snap != nil {
 		if err := h.downloader.SnapSyncer.Register(snap); err != nil {
 			peer.Log().Error("Failed to register peer in snap syncer", "err", err)
@@ -23,11 +23,13 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth/protocols/eth"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // ethHandler implements the eth.Backend interface to handle the various network
@@ -37,6 +39,16 @@ type ethHandler handler
 func (h *ethHandler) Chain() *core.BlockChain { return h.chain }
 func (h *ethHandler) TxPool() eth.TxPool      { return h.txpool }
 
+// ReceiptCache retrieves the cache of encoded receipts served to remote peers.
+func (h *ethHandler) ReceiptCache() *lru.Cache[common.Hash, rlp.RawValue] {
+	return h.receiptRootCache
+}
+
+// BodyCache retrieves the cache of encoded block bodies served to remote peers.
+func (h *ethHandler) BodyCache() *lru.Cache[common.Hash, rlp.RawValue] {
+	return h.bodyCache
+}
+
 // RunPeer is invoked when a peer joins on the `eth` protocol.
 func (h *ethHandler) RunPeer(peer *eth.Peer, hand eth.Handler) error {
 	return (*handler)(h).runEthPeer(peer, hand)
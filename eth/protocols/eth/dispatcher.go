@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/p2p"
 )
 
@@ -36,6 +37,22 @@ var (
 	// errMismatchingResponseType is returned if the remote peer sent a different
 	// packet type as a response to a request than what the local node expected.
 	errMismatchingResponseType = errors.New("mismatching response type")
+
+	// errMissingRequest is returned when validating a response that is not tied
+	// to any originating request.
+	errMissingRequest = errors.New("response without request")
+
+	// errMissingResponse is returned when validating a response that carries no
+	// remote payload at all.
+	errMissingResponse = errors.New("missing response payload")
+
+	// errUnknownResponse is returned when validating a response that carries a
+	// payload type which is not a reply to any tracked request.
+	errUnknownResponse = errors.New("unknown response payload")
+
+	// errInvalidMetadata is returned when validating a response whose locally
+	// generated metadata does not match the type or size of the payload.
+	errInvalidMetadata = errors.New("invalid response metadata")
 )
 
 // Request is a pending request to allow tracking it and delivering a response
@@ -107,6 +124,73 @@ type Response struct {
 	Done chan error    // Channel to signal message handling to the reader
 }
 
+// Validate checks that the response carries a payload and metadata of the types
+// expected for the request it answers, so consumers can type assert them safely.
+// Empty payloads are valid, the remote peer might simply not have the data.
+func (r *Response) Validate() error {
+	if r.Req == nil {
+		return errMissingRequest
+	}
+	var code uint64
+	switch res := r.Res.(type) {
+	case nil:
+		return errMissingResponse
+
+	case *BlockHeadersRequest:
+		if res == nil {
+			return fmt.Errorf("%w: nil %T", errMissingResponse, res)
+		}
+		hashes, ok := r.Meta.([]common.Hash)
+		if !ok {
+			return fmt.Errorf("%w: have %T, want []common.Hash", errInvalidMetadata, r.Meta)
+		}
+		if len(hashes) != len(*res) {
+			return fmt.Errorf("%w: %d hashes for %d headers", errInvalidMetadata, len(hashes), len(*res))
+		}
+		code = BlockHeadersMsg
+
+	case *BlockBodiesResponse:
+		if res == nil {
+			return fmt.Errorf("%w: nil %T", errMissingResponse, res)
+		}
+		hashsets, ok := r.Meta.([][]common.Hash)
+		if !ok {
+			return fmt.Errorf("%w: have %T, want [][]common.Hash", errInvalidMetadata, r.Meta)
+		}
+		if len(hashsets) != 3 {
+			return fmt.Errorf("%w: %d hash sets, want 3", errInvalidMetadata, len(hashsets))
+		}
+		for _, hashes := range hashsets {
+			if len(hashes) != len(*res) {
+				return fmt.Errorf("%w: %d hashes for %d bodies", errInvalidMetadata, len(hashes), len(*res))
+			}
+		}
+		code = BlockBodiesMsg
+
+	case *ReceiptsResponse:
+		if res == nil {
+			return fmt.Errorf("%w: nil %T", errMissingResponse, res)
+		}
+		hashes, ok := r.Meta.([]common.Hash)
+		if !ok {
+			return fmt.Errorf("%w: have %T, want []common.Hash", errInvalidMetadata, r.Meta)
+		}
+		if len(hashes) != len(*res) {
+			return fmt.Errorf("%w: %d hashes for %d receipt sets", errInvalidMetadata, len(hashes), len(*res))
+		}
+		code = ReceiptsMsg
+
+	default:
+		return fmt.Errorf("%w: %T", errUnknownResponse, r.Res)
+	}
+	// Requests created outside of the dispatcher (e.g. in tests) don't track the
+	// expected reply code, only cross check the ones that do
+	if r.Req.want != 0 && r.Req.want != code {
+		return fmt.Errorf("%w: have %d, want %d", errMismatchingResponseType, code, r.Req.want)
+	}
+	return nil
+}
+
 // response is a wrapper around a remote Response that has an error channel to
 // signal on if processing the response failed.
 type response struct {
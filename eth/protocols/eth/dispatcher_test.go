@@ -0,0 +1,106 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Tests that responses are accepted only if their payload and metadata match
+// each other and the originating request.
+func TestResponseValidate(t *testing.T) {
+	headers := BlockHeadersRequest{new(types.Header), new(types.Header)}
+	bodies := BlockBodiesResponse{new(BlockBody)}
+
+	tests := []struct {
+		res  *Response
+		fail bool
+	}{
+		{&Response{Res: &headers, Meta: make([]common.Hash, 2)}, true},
+		{&Response{Req: &Request{}, Meta: make([]common.Hash, 2)}, true},
+		{&Response{Req: &Request{}, Res: (*BlockHeadersRequest)(nil)}, true},
+		{&Response{Req: &Request{}, Res: headers, Meta: make([]common.Hash, 2)}, true},
+		{&Response{Req: &Request{}, Res: &headers, Meta: make([]common.Hash, 1)}, true},
+		{&Response{Req: &Request{}, Res: &headers, Meta: [][]common.Hash{}}, true},
+		{&Response{Req: &Request{}, Res: &headers, Meta: make([]common.Hash, 2)}, false},
+		{&Response{Req: &Request{want: BlockHeadersMsg}, Res: &headers, Meta: make([]common.Hash, 2)}, false},
+		{&Response{Req: &Request{want: ReceiptsMsg}, Res: &headers, Meta: make([]common.Hash, 2)}, true},
+		{&Response{Req: &Request{}, Res: &bodies, Meta: [][]common.Hash{make([]common.Hash, 1), make([]common.Hash, 1)}}, true},
+		{&Response{Req: &Request{}, Res: &bodies, Meta: [][]common.Hash{make([]common.Hash, 1), make([]common.Hash, 1), nil}}, true},
+		{&Response{Req: &Request{}, Res: &bodies, Meta: [][]common.Hash{make([]common.Hash, 1), make([]common.Hash, 1), make([]common.Hash, 1)}}, false},
+		{&Response{Req: &Request{}, Res: new(ReceiptsResponse), Meta: []common.Hash{}}, false},
+	}
+	for i, tt := range tests {
+		if err := tt.res.Validate(); (err != nil) != tt.fail {
+			t.Errorf("test %d: validation mismatch: have %v, want failure %v", i, err, tt.fail)
+		}
+	}
+}
+
+// FuzzResponseValidate checks that validating arbitrary combinations of payload
+// and metadata types never panics.
+func FuzzResponseValidate(f *testing.F) {
+	f.Add(byte(1), byte(1), uint8(2), uint8(2), byte(0))
+	f.Add(byte(2), byte(2), uint8(1), uint8(3), byte(1))
+	f.Add(byte(3), byte(1), uint8(0), uint8(0), byte(2))
+
+	f.Fuzz(func(t *testing.T, resKind, metaKind byte, items, hashes uint8, want byte) {
+		var res interface{}
+		switch resKind % 8 {
+		case 1:
+			headers := make(BlockHeadersRequest, items)
+			res = &headers
+		case 2:
+			bodies := make(BlockBodiesResponse, items)
+			res = &bodies
+		case 3:
+			receipts := make(ReceiptsResponse, items)
+			res = &receipts
+		case 4:
+			res = (*BlockHeadersRequest)(nil)
+		case 5:
+			res = (*BlockBodiesResponse)(nil)
+		case 6:
+			res = (*ReceiptsResponse)(nil)
+		case 7:
+			res = make(BlockHeadersRequest, items)
+		}
+		var meta interface{}
+		switch metaKind % 5 {
+		case 1:
+			meta = make([]common.Hash, hashes)
+		case 2:
+			sets := make([][]common.Hash, hashes%5)
+			for i := range sets {
+				sets[i] = make([]common.Hash, items)
+			}
+			meta = sets
+		case 3:
+			meta = make([][]common.Hash, hashes)
+		case 4:
+			meta = hashes
+		}
+		var req *Request
+		if want != 0xff {
+			req = &Request{want: []uint64{0, BlockHeadersMsg, BlockBodiesMsg, ReceiptsMsg}[want%4]}
+		}
+		(&Response{Req: req, Res: res, Meta: meta}).Validate()
+	})
+}
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -29,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 const (
@@ -49,6 +51,19 @@ const (
 	// containing 200+ transactions nowadays, the practical limit will always
 	// be softResponseLimit.
 	maxReceiptsServe = 1024
+
+	// maxReceiptsPerResponse is the maximum number of individual receipts to serve
+	// in a single response, summed across all the requested blocks. It caps the
+	// work a peer can request with blocks containing many transactions.
+	maxReceiptsPerResponse = 10000
+
+	// ReceiptCacheSize is the number of blocks for which the RLP encoded receipts
+	// are cached to avoid re-encoding them on every remote request.
+	ReceiptCacheSize = 4096
+
+	// BodyCacheSize is the number of blocks for which the RLP encoded bodies are
+	// cached, as the bodies of the most recent blocks are requested by many peers.
+	BodyCacheSize = 512
 )
 
 // Handler is a callback to invoke from an outside runner after the boilerplate
@@ -64,6 +79,14 @@ type Backend interface {
 	// TxPool retrieves the transaction pool object to serve data.
 	TxPool() TxPool
 
+	// ReceiptCache retrieves the cache of RLP encoded block receipts to serve
+	// remote requests from. It may return nil to disable caching.
+	ReceiptCache() *lru.Cache[common.Hash, rlp.RawValue]
+
+	// BodyCache retrieves the cache of RLP encoded block bodies to serve remote
+	// requests from. It may return nil to disable caching.
+	BodyCache() *lru.Cache[common.Hash, rlp.RawValue]
+
 	// AcceptTxs retrieves whether transaction processing is enabled on the node
 	// or if inbound transactions should simply be dropped.
 	AcceptTxs() bool
@@ -19,6 +19,7 @@ package eth
 import (
 	"bytes"
 	rand2 "crypto/rand"
+	"fmt"
 	"io"
 	"math"
 	"math/big"
@@ -27,6 +28,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/beacon"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
@@ -61,9 +63,11 @@ func u64(val uint64) *uint64 { return &val }
 // purpose is to allow testing the request/reply workflows and wire serialization
 // in the `eth` protocol without actually doing any data processing.
 type testBackend struct {
-	db     ethdb.Database
-	chain  *core.BlockChain
-	txpool *txpool.TxPool
+	db       ethdb.Database
+	chain    *core.BlockChain
+	txpool   *txpool.TxPool
+	receipts *lru.Cache[common.Hash, rlp.RawValue]
+	bodies   *lru.Cache[common.Hash, rlp.RawValue]
 }
 
 // newTestBackend creates an empty chain and wraps it into a mock backend.
@@ -126,9 +130,11 @@ func newTestBackendWithGenerator(blocks int, shanghai bool, generator func(int,
 	txpool, _ := txpool.New(txconfig.PriceLimit, chain, []txpool.SubPool{pool})
 
 	return &testBackend{
-		db:     db,
-		chain:  chain,
-		txpool: txpool,
+		db:       db,
+		chain:    chain,
+		txpool:   txpool,
+		receipts: lru.NewCache[common.Hash, rlp.RawValue](ReceiptCacheSize),
+		bodies:   lru.NewCache[common.Hash, rlp.RawValue](BodyCacheSize),
 	}
 }
 
@@ -141,6 +147,9 @@ func (b *testBackend) close() {
 func (b *testBackend) Chain() *core.BlockChain { return b.chain }
 func (b *testBackend) TxPool() TxPool          { return b.txpool }
 
+func (b *testBackend) ReceiptCache() *lru.Cache[common.Hash, rlp.RawValue] { return b.receipts }
+func (b *testBackend) BodyCache() *lru.Cache[common.Hash, rlp.RawValue]    { return b.bodies }
+
 func (b *testBackend) RunPeer(peer *Peer, handler Handler) error {
 	// Normally the backend would do peer maintenance and handshakes. All that
 	// is omitted, and we will just give control back to the handler.
@@ -371,6 +380,41 @@ func testGetBlockHeaders(t *testing.T, protocol uint) {
 }
 
 // Tests that block contents can be retrieved from a remote chain based on their hashes.
+// Tests that reverse header queries by hash stay contiguous if a header of the
+// canonical chain is missing, serving the ancestors only down to the gap.
+func TestGetBlockHeadersReverseGap(t *testing.T) {
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		engine = ethash.NewFaker()
+		gspec  = &core.Genesis{Config: params.TestChainConfig}
+	)
+	_, blocks, _ := core.GenerateChainWithGenesis(gspec, engine, 200, nil)
+	chain, _ := core.NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	// Punch a hole into the headers and reopen the chain to drop its caches
+	rawdb.DeleteHeader(db, blocks[149].Hash(), 150)
+	chain, _ = core.NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	defer chain.Stop()
+
+	headers := ServiceGetBlockHeadersQuery(chain, &GetBlockHeadersRequest{Origin: HashOrNumber{Hash: blocks[199].Hash()}, Amount: 100, Reverse: true}, nil)
+	if len(headers) != 50 {
+		t.Fatalf("header count mismatch: have %d, want 50", len(headers))
+	}
+	for i, blob := range headers {
+		var header types.Header
+		if err := rlp.DecodeBytes(blob, &header); err != nil {
+			t.Fatalf("header %d: failed to decode: %v", i, err)
+		}
+		if want := blocks[199-i].Hash(); header.Hash() != want {
+			t.Errorf("header %d: hash mismatch: have %x, want %x", i, header.Hash(), want)
+		}
+	}
+}
+
 func TestGetBlockBodies68(t *testing.T) { testGetBlockBodies(t, ETH68) }
 
 func testGetBlockBodies(t *testing.T, protocol uint) {
@@ -523,16 +567,186 @@ func testGetBlockReceipts(t *testing.T, protocol uint) {
 		hashes = append(hashes, block.Hash())
 		receipts = append(receipts, backend.chain.GetReceiptsByHash(block.Hash()))
 	}
-	// Send the hash request and verify the response
-	p2p.Send(peer.app, GetReceiptsMsg, &GetReceiptsPacket{
-		RequestId:          123,
-		GetReceiptsRequest: hashes,
+	// Send the hash request and verify the response, twice to also cover the
+	// serving of the cached receipts
+	for i := 0; i < 2; i++ {
+		p2p.Send(peer.app, GetReceiptsMsg, &GetReceiptsPacket{
+			RequestId:          123,
+			GetReceiptsRequest: hashes,
+		})
+		if err := p2p.ExpectMsg(peer.app, ReceiptsMsg, &ReceiptsPacket{
+			RequestId:        123,
+			ReceiptsResponse: receipts,
+		}); err != nil {
+			t.Errorf("attempt %d: receipts mismatch: %v", i, err)
+		}
+	}
+}
+
+// Tests that the number of receipts served in a single response is capped, the
+// receipts of a block only being included if they fit entirely.
+func TestGetBlockReceiptsCountLimit(t *testing.T) {
+	t.Parallel()
+
+	// makeReceipts creates the encoding of a block with n (invalid, but tiny)
+	// receipts, the serving only counts them
+	makeReceipts := func(n int) rlp.RawValue {
+		blob, err := rlp.EncodeToBytes(make([][]byte, n))
+		if err != nil {
+			t.Fatalf("failed to encode receipts: %v", err)
+		}
+		return blob
+	}
+	tests := []struct {
+		counts   []int // Number of receipts in the requested blocks
+		serviced int   // Number of blocks expected to be included
+	}{
+		// Receipts below and exactly at the limit are served entirely
+		{counts: []int{1, 2, 3}, serviced: 3},
+		{counts: []int{maxReceiptsPerResponse - 1, 1}, serviced: 2},
+		{counts: []int{maxReceiptsPerResponse - 1, 1, 1}, serviced: 2},
+
+		// Blocks crossing the limit are left out, apart from the first one
+		{counts: []int{maxReceiptsPerResponse - 1, 2, 1}, serviced: 1},
+		{counts: []int{maxReceiptsPerResponse + 1, 1}, serviced: 1},
+	}
+	for i, tt := range tests {
+		var (
+			cache = lru.NewCache[common.Hash, rlp.RawValue](ReceiptCacheSize)
+			query GetReceiptsRequest
+		)
+		for j, count := range tt.counts {
+			hash := common.Hash{byte(i), byte(j)}
+			cache.Add(hash, makeReceipts(count))
+			query = append(query, hash)
+		}
+		blobs, serviced := serviceGetReceiptsQuery(nil, cache, query)
+		if serviced != tt.serviced || len(blobs) != tt.serviced {
+			t.Errorf("test %d: serviced blocks mismatch: have %d (%d blobs), want %d", i, serviced, len(blobs), tt.serviced)
+		}
+	}
+}
+
+// Benchmarks serving the receipts of a block with 100 transactions, both with
+// re-encoding them on every request and serving them from the receipt cache.
+func BenchmarkGetBlockReceipts(b *testing.B) {
+	signer := types.HomesteadSigner{}
+	generator := func(i int, block *core.BlockGen) {
+		for j := 0; j < 100; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(testAddr), common.Address{byte(j)}, big.NewInt(1), params.TxGas, block.BaseFee(), nil), signer, testKey)
+			block.AddTx(tx)
+		}
+	}
+	backend := newTestBackendWithGenerator(1, false, generator)
+	defer backend.close()
+
+	query := GetReceiptsRequest{backend.chain.CurrentBlock().Hash()}
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			serviceGetReceiptsQuery(backend.chain, nil, query)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		cache := lru.NewCache[common.Hash, rlp.RawValue](ReceiptCacheSize)
+		serviceGetReceiptsQuery(backend.chain, cache, query) // Warm up the cache
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			serviceGetReceiptsQuery(backend.chain, cache, query)
+		}
+	})
+}
+
+// Benchmarks serving the body of a block with 100 transactions, both with
+// re-encoding it on every request and serving it from the body cache.
+func BenchmarkGetBlockBodies(b *testing.B) {
+	signer := types.HomesteadSigner{}
+	generator := func(i int, block *core.BlockGen) {
+		for j := 0; j < 100; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(testAddr), common.Address{byte(j)}, big.NewInt(1), params.TxGas, block.BaseFee(), nil), signer, testKey)
+			block.AddTx(tx)
+		}
+	}
+	backend := newTestBackendWithGenerator(1, false, generator)
+	defer backend.close()
+
+	query := GetBlockBodiesRequest{backend.chain.CurrentBlock().Hash()}
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			serviceGetBlockBodiesQuery(backend.chain, nil, query)
+		}
 	})
-	if err := p2p.ExpectMsg(peer.app, ReceiptsMsg, &ReceiptsPacket{
-		RequestId:        123,
-		ReceiptsResponse: receipts,
-	}); err != nil {
-		t.Errorf("receipts mismatch: %v", err)
+	b.Run("cached", func(b *testing.B) {
+		cache := lru.NewCache[common.Hash, rlp.RawValue](BodyCacheSize)
+		serviceGetBlockBodiesQuery(backend.chain, cache, query) // Warm up the cache
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			serviceGetBlockBodiesQuery(backend.chain, cache, query)
+		}
+	})
+}
+
+// Benchmarks serving header queries with headers of various sizes, ranging from
+// plain Ethereum ones to Parlia ones carrying validator sets and attestations.
+// The number mode serves the stored RLP straight from the database, the hash
+// mode decodes and re-encodes every header, and the encode mode measures the
+// cost of the RLP encoding alone.
+func BenchmarkServiceGetBlockHeadersQuery(b *testing.B) {
+	for _, size := range []int{500, 2048, 8192} {
+		var (
+			db     = rawdb.NewMemoryDatabase()
+			engine = ethash.NewFullFaker() // Accept the oversized extra-data
+			gspec  = &core.Genesis{Config: params.TestChainConfig}
+		)
+		// Pad the extra-data of the headers to approximate the requested size
+		_, base, _ := core.GenerateChainWithGenesis(gspec, engine, 1, nil)
+		enc, _ := rlp.EncodeToBytes(base[0].Header())
+		extra := max(size-len(enc), 0)
+
+		_, blocks, _ := core.GenerateChainWithGenesis(gspec, engine, maxHeadersServe, func(i int, block *core.BlockGen) {
+			block.SetExtra(make([]byte, extra))
+		})
+		chain, _ := core.NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, nil)
+		if _, err := chain.InsertChain(blocks); err != nil {
+			b.Fatalf("failed to insert chain: %v", err)
+		}
+		head := chain.CurrentBlock()
+
+		for _, amount := range []uint64{12, 64, 192} {
+			b.Run(fmt.Sprintf("size=%d/amount=%d/number", size, amount), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					ServiceGetBlockHeadersQuery(chain, &GetBlockHeadersRequest{Origin: HashOrNumber{Number: 1}, Amount: amount}, nil)
+				}
+			})
+			b.Run(fmt.Sprintf("size=%d/amount=%d/hash", size, amount), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					ServiceGetBlockHeadersQuery(chain, &GetBlockHeadersRequest{Origin: HashOrNumber{Hash: head.Hash()}, Amount: amount, Reverse: true}, nil)
+				}
+			})
+			b.Run(fmt.Sprintf("size=%d/amount=%d/encode", size, amount), func(b *testing.B) {
+				headers := make([]*types.Header, amount)
+				for i := range headers {
+					headers[i] = blocks[i].Header()
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					for _, header := range headers {
+						rlp.EncodeToBytes(header)
+					}
+				}
+			})
+		}
+		chain.Stop()
 	}
 }
 
@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
@@ -199,13 +200,26 @@ func serviceContiguousBlockHeaderQuery(chain *core.BlockChain, query *GetBlockHe
 		return headers
 	}
 	{ // Last mode: deliver ancestors of H
-		for i := uint64(1); i < count; i++ {
-			header = chain.GetHeaderByHash(header.ParentHash)
+		parent := header.ParentHash
+		if canonHash := chain.GetCanonicalHash(num); canonHash == hash && count > 1 && num > 0 {
+			// H is canon, so are its ancestors: read the encoded segment below
+			// it in one sweep instead of resolving the parents one by one. The
+			// read stops at the first missing header, in which case the rest
+			// is attempted through the parents.
+			ancestors := chain.GetHeadersFrom(num-1, count-1)
+			headers = append(headers, ancestors...)
+			if len(ancestors) > 0 {
+				parent = types.HeaderParentHashFromRLP(ancestors[len(ancestors)-1])
+			}
+		}
+		for uint64(len(headers)) < count {
+			header = chain.GetHeaderByHash(parent)
 			if header == nil {
 				break
 			}
 			rlpData, _ := rlp.EncodeToBytes(header)
 			headers = append(headers, rlpData)
+			parent = header.ParentHash
 		}
 		return headers
 	}
@@ -217,13 +231,25 @@ func handleGetBlockBodies(backend Backend, msg Decoder, peer *Peer) error {
 	if err := msg.Decode(&query); err != nil {
 		return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
 	}
-	response := ServiceGetBlockBodiesQuery(backend.Chain(), query.GetBlockBodiesRequest)
+	response := serviceGetBlockBodiesQuery(backend.Chain(), backend.BodyCache(), query.GetBlockBodiesRequest)
 	return peer.ReplyBlockBodiesRLP(query.RequestId, response)
 }
 
 // ServiceGetBlockBodiesQuery assembles the response to a body query. It is
 // exposed to allow external packages to test protocol behavior.
 func ServiceGetBlockBodiesQuery(chain *core.BlockChain, query GetBlockBodiesRequest) []rlp.RawValue {
+	return serviceGetBlockBodiesQuery(chain, nil, query)
+}
+
+// serviceGetBlockBodiesQuery assembles the response to a body query, serving
+// the encoded bodies from the given cache if available (nil disables it).
+//
+// The cache is keyed by block hash, which commits to the transactions, uncles
+// and withdrawals of the body, so a reorg can't make an entry stale. The blob
+// sidecars are however stored apart from the body and may be missing, e.g. if
+// not yet received or already pruned, so bodies lacking the sidecars of their
+// blob transactions aren't cached, to be served with them once available.
+func serviceGetBlockBodiesQuery(chain *core.BlockChain, cache *lru.Cache[common.Hash, rlp.RawValue], query GetBlockBodiesRequest) []rlp.RawValue {
 	// Gather blocks until the fetch or network limits is reached
 	var (
 		bytes  int
@@ -234,6 +260,14 @@ func ServiceGetBlockBodiesQuery(chain *core.BlockChain, query GetBlockBodiesRequ
 			lookups >= 2*maxBodiesServe {
 			break
 		}
+		// If the body was already encoded for a previous request, reuse it
+		if cache != nil {
+			if enc, ok := cache.Get(hash); ok {
+				bodies = append(bodies, enc)
+				bytes += len(enc)
+				continue
+			}
+		}
 		body := chain.GetBody(hash)
 		if body == nil {
 			continue
@@ -250,35 +284,91 @@ func ServiceGetBlockBodiesQuery(chain *core.BlockChain, query GetBlockBodiesRequ
 			log.Error("block body encode err", "hash", hash, "err", err)
 			continue
 		}
+		if cache != nil && len(sidecars) == countBlobTxs(body.Transactions) {
+			cache.Add(hash, enc)
+		}
 		bodies = append(bodies, enc)
 		bytes += len(enc)
 	}
 	return bodies
 }
 
+// countBlobTxs returns the number of blob transactions in the given list, each
+// of which is expected to have a sidecar.
+func countBlobTxs(txs []*types.Transaction) int {
+	var n int
+	for _, tx := range txs {
+		if tx.Type() == types.BlobTxType {
+			n++
+		}
+	}
+	return n
+}
+
 func handleGetReceipts(backend Backend, msg Decoder, peer *Peer) error {
 	// Decode the block receipts retrieval message
 	var query GetReceiptsPacket
 	if err := msg.Decode(&query); err != nil {
 		return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
 	}
-	response := ServiceGetReceiptsQuery(backend.Chain(), query.GetReceiptsRequest)
+	response, _ := serviceGetReceiptsQuery(backend.Chain(), backend.ReceiptCache(), query.GetReceiptsRequest)
 	return peer.ReplyReceiptsRLP(query.RequestId, response)
 }
 
 // ServiceGetReceiptsQuery assembles the response to a receipt query. It is
-// exposed to allow external packages to test protocol behavior.
-func ServiceGetReceiptsQuery(chain *core.BlockChain, query GetReceiptsRequest) []rlp.RawValue {
+// exposed to allow external packages to test protocol behavior. Besides the
+// encoded receipts, the number of blocks included in the response is returned.
+func ServiceGetReceiptsQuery(chain *core.BlockChain, query GetReceiptsRequest) ([]rlp.RawValue, int) {
+	return serviceGetReceiptsQuery(chain, nil, query)
+}
+
+// serviceGetReceiptsQuery assembles the response to a receipt query, serving
+// the encoded receipts from the given cache if available (nil disables it).
+//
+// Apart from the first block, the receipts of a block are only included if they
+// fit into maxReceiptsPerResponse together with the ones already included.
+func serviceGetReceiptsQuery(chain *core.BlockChain, cache *lru.Cache[common.Hash, rlp.RawValue], query GetReceiptsRequest) ([]rlp.RawValue, int) {
 	// Gather state data until the fetch or network limits is reached
 	var (
 		bytes    int
+		count    int
 		receipts []rlp.RawValue
 	)
+	// include appends the encoded receipts of a block to the response, unless they
+	// would push the receipt count over the limit
+	include := func(encoded rlp.RawValue) bool {
+		content, _, err := rlp.SplitList(encoded)
+		if err != nil {
+			log.Error("Failed to split encoded receipts", "err", err)
+			return true
+		}
+		n, err := rlp.CountValues(content)
+		if err != nil {
+			log.Error("Failed to count encoded receipts", "err", err)
+			return true
+		}
+		if len(receipts) > 0 && count+n > maxReceiptsPerResponse {
+			return false
+		}
+		receipts = append(receipts, encoded)
+		bytes += len(encoded)
+		count += n
+		return true
+	}
 	for lookups, hash := range query {
 		if bytes >= softResponseLimit || len(receipts) >= maxReceiptsServe ||
 			lookups >= 2*maxReceiptsServe {
 			break
 		}
+		// If the receipts were already encoded for a previous request, reuse them
+		if cache != nil {
+			if encoded, ok := cache.Get(hash); ok {
+				if !include(encoded) {
+					break
+				}
+				continue
+			}
+		}
 		// Retrieve the requested block's receipts
 		results := chain.GetReceiptsByHash(hash)
 		if results == nil {
@@ -290,11 +380,15 @@ func ServiceGetReceiptsQuery(chain *core.BlockChain, query GetReceiptsRequest) [
 		if encoded, err := rlp.EncodeToBytes(results); err != nil {
 			log.Error("Failed to encode receipt", "err", err)
 		} else {
-			receipts = append(receipts, encoded)
-			bytes += len(encoded)
+			if cache != nil {
+				cache.Add(hash, encoded)
+			}
+			if !include(encoded) {
+				break
+			}
 		}
 	}
-	return receipts
+	return receipts, len(receipts)
 }
 
 func handleNewBlockhashes(backend Backend, msg Decoder, peer *Peer) error {
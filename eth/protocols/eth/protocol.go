@@ -26,6 +26,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/forkid"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // Constants to match up protocol versions and messages
@@ -76,6 +77,21 @@ var (
 	errForkIDRejected          = errors.New("fork ID rejected")
 )
 
+var (
+	// ErrTxHashMismatch is returned if the transactions of a block body do not
+	// match the transaction root of the header.
+	ErrTxHashMismatch = errors.New("transaction root mismatch")
+
+	// ErrUncleHashMismatch is returned if the uncles of a block body do not match
+	// the uncle hash of the header.
+	ErrUncleHashMismatch = errors.New("uncle hash mismatch")
+
+	// ErrWithdrawalHashMismatch is returned if the withdrawals of a block body do
+	// not match the withdrawals root of the header, or are present (or missing)
+	// contrary to the header.
+	ErrWithdrawalHashMismatch = errors.New("withdrawals root mismatch")
+)
+
 // Packet represents a p2p message in the `eth` protocol.
 type Packet interface {
 	Name() string // Name returns a string corresponding to the message type.
@@ -280,6 +296,40 @@ type BlockBody struct {
 	Sidecars     types.BlobSidecars   `rlp:"optional"` // Sidecars contained within a block
 }
 
+// SanityCheck validates the body against the header of its block, without any
+// execution: the transaction root, uncle hash and withdrawals root must match.
+func (b *BlockBody) SanityCheck(header *types.Header) error {
+	hasher := trie.NewStackTrie(nil)
+
+	var withdrawalsHash *common.Hash
+	if b.Withdrawals != nil {
+		hash := types.DeriveSha(types.Withdrawals(b.Withdrawals), hasher)
+		withdrawalsHash = &hash
+	}
+	return CheckBodyHashes(header, types.DeriveSha(types.Transactions(b.Transactions), hasher), types.CalcUncleHash(b.Uncles), withdrawalsHash)
+}
+
+// CheckBodyHashes validates the already derived hashes of a block body against
+// the header of its block. The withdrawals hash must be nil if the body has no
+// withdrawals.
+func CheckBodyHashes(header *types.Header, txHash common.Hash, uncleHash common.Hash, withdrawalsHash *common.Hash) error {
+	if txHash != header.TxHash {
+		return fmt.Errorf("%w: have %x, want %x", ErrTxHashMismatch, txHash, header.TxHash)
+	}
+	if uncleHash != header.UncleHash {
+		return fmt.Errorf("%w: have %x, want %x", ErrUncleHashMismatch, uncleHash, header.UncleHash)
+	}
+	switch {
+	case header.WithdrawalsHash == nil && withdrawalsHash != nil:
+		return fmt.Errorf("%w: withdrawals present before Shanghai", ErrWithdrawalHashMismatch)
+	case header.WithdrawalsHash != nil && withdrawalsHash == nil:
+		return fmt.Errorf("%w: withdrawals missing after Shanghai", ErrWithdrawalHashMismatch)
+	case header.WithdrawalsHash != nil && *withdrawalsHash != *header.WithdrawalsHash:
+		return fmt.Errorf("%w: have %x, want %x", ErrWithdrawalHashMismatch, *withdrawalsHash, *header.WithdrawalsHash)
+	}
+	return nil
+}
+
 // Unpack retrieves the transactions and uncles from the range packet and returns
 // them in a split flat format that's more consistent with the internal data structures.
 func (p *BlockBodiesResponse) Unpack() ([][]*types.Transaction, [][]*types.Header, [][]*types.Withdrawal, []types.BlobSidecars) {
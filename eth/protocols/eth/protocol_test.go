@@ -18,12 +18,14 @@ package eth
 
 import (
 	"bytes"
+	"errors"
 	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // Tests that the custom union field encoder and decoder works correctly.
@@ -70,6 +72,50 @@ func TestGetBlockHeadersDataEncodeDecode(t *testing.T) {
 	}
 }
 
+// Benchmarks encoding a typical header query, as sent for every header request.
+func BenchmarkEncodeGetBlockHeadersRequest(b *testing.B) {
+	for _, origin := range []HashOrNumber{{Number: 314}, {Hash: common.Hash{0x01}}} {
+		name := "number"
+		if origin.Hash != (common.Hash{}) {
+			name = "hash"
+		}
+		b.Run(name, func(b *testing.B) {
+			packet := &GetBlockHeadersPacket{
+				RequestId:              1,
+				GetBlockHeadersRequest: &GetBlockHeadersRequest{Origin: origin, Amount: 192},
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := rlp.EncodeToBytes(packet); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Benchmarks decoding a typical header query, as received for every header request.
+func BenchmarkDecodeGetBlockHeadersRequest(b *testing.B) {
+	for _, origin := range []HashOrNumber{{Number: 314}, {Hash: common.Hash{0x01}}} {
+		name := "number"
+		if origin.Hash != (common.Hash{}) {
+			name = "hash"
+		}
+		b.Run(name, func(b *testing.B) {
+			blob, _ := rlp.EncodeToBytes(&GetBlockHeadersPacket{
+				RequestId:              1,
+				GetBlockHeadersRequest: &GetBlockHeadersRequest{Origin: origin, Amount: 192},
+			})
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := rlp.DecodeBytes(blob, new(GetBlockHeadersPacket)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 // TestEmptyMessages tests encoding of empty messages.
 func TestEmptyMessages(t *testing.T) {
 	// All empty messages encodes to the same format
@@ -246,3 +292,68 @@ func TestMessages(t *testing.T) {
 		}
 	}
 }
+
+// Tests that block bodies are validated against the roots of their header.
+func TestBlockBodySanityCheck(t *testing.T) {
+	var (
+		txs         = []*types.Transaction{types.NewTransaction(1, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil)}
+		uncles      = []*types.Header{{Number: big.NewInt(1)}}
+		withdrawals = []*types.Withdrawal{{Index: 1, Amount: 1}}
+
+		txHash          = types.DeriveSha(types.Transactions(txs), trie.NewStackTrie(nil))
+		uncleHash       = types.CalcUncleHash(uncles)
+		withdrawalsHash = types.DeriveSha(types.Withdrawals(withdrawals), trie.NewStackTrie(nil))
+		emptyHash       = types.EmptyWithdrawalsHash
+	)
+	tests := []struct {
+		body   *BlockBody
+		header *types.Header
+		err    error
+	}{
+		// Pre-Shanghai body without withdrawals
+		{
+			body:   &BlockBody{Transactions: txs, Uncles: uncles},
+			header: &types.Header{TxHash: txHash, UncleHash: uncleHash},
+		},
+		// Post-Shanghai body with withdrawals
+		{
+			body:   &BlockBody{Transactions: txs, Uncles: uncles, Withdrawals: withdrawals},
+			header: &types.Header{TxHash: txHash, UncleHash: uncleHash, WithdrawalsHash: &withdrawalsHash},
+		},
+		// Transactions not matching the header
+		{
+			body:   &BlockBody{Uncles: uncles},
+			header: &types.Header{TxHash: txHash, UncleHash: uncleHash},
+			err:    ErrTxHashMismatch,
+		},
+		// Uncles not matching the header
+		{
+			body:   &BlockBody{Transactions: txs},
+			header: &types.Header{TxHash: txHash, UncleHash: uncleHash},
+			err:    ErrUncleHashMismatch,
+		},
+		// Withdrawals present before Shanghai
+		{
+			body:   &BlockBody{Transactions: txs, Uncles: uncles, Withdrawals: withdrawals},
+			header: &types.Header{TxHash: txHash, UncleHash: uncleHash},
+			err:    ErrWithdrawalHashMismatch,
+		},
+		// Withdrawals missing after Shanghai
+		{
+			body:   &BlockBody{Transactions: txs, Uncles: uncles},
+			header: &types.Header{TxHash: txHash, UncleHash: uncleHash, WithdrawalsHash: &emptyHash},
+			err:    ErrWithdrawalHashMismatch,
+		},
+		// Withdrawals not matching the header
+		{
+			body:   &BlockBody{Transactions: txs, Uncles: uncles, Withdrawals: withdrawals},
+			header: &types.Header{TxHash: txHash, UncleHash: uncleHash, WithdrawalsHash: &emptyHash},
+			err:    ErrWithdrawalHashMismatch,
+		},
+	}
+	for i, tt := range tests {
+		if err := tt.body.SanityCheck(tt.header); !errors.Is(err, tt.err) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
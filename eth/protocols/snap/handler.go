@@ -50,6 +50,17 @@ const (
 	// number is there to limit the number of disk lookups.
 	maxTrieNodeLookups = 1024
 
+	// maxAllowedResponseSize is the maximum response size a remote peer may ask
+	// for in a single storage range query. Requests above this are considered
+	// malicious as no sane client would request this much at once.
+	maxAllowedResponseSize = 8 * 1024 * 1024
+
+	// maxStorageAccountsPerRequest is the maximum number of accounts a remote
+	// peer may request storage slots for in a single query. Note, our own syncer
+	// bundles at most maxRequestSize/1024 accounts into a request, the limit is
+	// set well above that to avoid disconnecting honest peers.
+	maxStorageAccountsPerRequest = 1024
+
 	// maxTrieNodeTimeSpent is the maximum time we should spend on looking up trie nodes.
 	// If we spend too much time, then it's a fairly high chance of timing out
 	// at the remote side, which means all the work is in vain.
@@ -183,6 +194,10 @@ func HandleMessage(backend Backend, peer *Peer) error {
 		if err := msg.Decode(&req); err != nil {
 			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
 		}
+		// Reject over-budget requests, tearing down the connection to the peer
+		if err := ValidateGetStorageRangesPacket(&req); err != nil {
+			return err
+		}
 		// Service the request, potentially returning nothing in case of errors
 		slots, proofs := ServiceGetStorageRangesQuery(backend.Chain(), &req)
 
@@ -326,6 +341,20 @@ func ServiceGetAccountRangeQuery(chain *core.BlockChain, req *GetAccountRangePac
 	return accounts, proof.List()
 }
 
+// ValidateGetStorageRangesPacket checks that a storage range query stays within
+// the limits any sane client would request, returning errInvalidRequest if not.
+func ValidateGetStorageRangesPacket(req *GetStorageRangesPacket) error {
+	if req.Bytes > maxAllowedResponseSize {
+		return fmt.Errorf("%w: response limit %d > %d", errInvalidRequest, req.Bytes, maxAllowedResponseSize)
+	}
+	if len(req.Accounts) > maxStorageAccountsPerRequest {
+		return fmt.Errorf("%w: requested accounts %d > %d", errInvalidRequest, len(req.Accounts), maxStorageAccountsPerRequest)
+	}
+	return nil
+}
+
+// ServiceGetStorageRangesQuery assembles the response to a storage ranges query.
+// It is exposed to allow external packages to test protocol behavior.
 func ServiceGetStorageRangesQuery(chain *core.BlockChain, req *GetStorageRangesPacket) ([][]*StorageData, [][]byte) {
 	if req.Bytes > softResponseLimit {
 		req.Bytes = softResponseLimit
@@ -337,6 +366,11 @@ func ServiceGetStorageRangesQuery(chain *core.BlockChain, req *GetStorageRangesP
 	// Calculate the hard limit at which to abort, even if mid storage trie
 	hardLimit := uint64(float64(req.Bytes) * (1 + stateLookupSlack))
 
+	// Requesting the entire storage of a single account is the most common query
+	// during sync, serve it without the range bookkeeping of the general path
+	if len(req.Accounts) == 1 && len(req.Origin) == 0 && len(req.Limit) == 0 {
+		return serviceFullStorageQuery(chain, req.Root, req.Accounts[0], hardLimit)
+	}
 	// Retrieve storage ranges until the packet limit is reached
 	var (
 		slots  [][]*StorageData
@@ -401,31 +435,11 @@ func ServiceGetStorageRangesQuery(chain *core.BlockChain, req *GetStorageRangesP
 		if origin != (common.Hash{}) || (abort && len(storage) > 0) {
 			// Request started at a non-zero hash or was capped prematurely, add
 			// the endpoint Merkle proofs
-			accTrie, err := trie.NewStateTrie(trie.StateTrieID(req.Root), chain.TrieDB())
-			if err != nil {
-				return nil, nil
-			}
-			acc, err := accTrie.GetAccountByHash(account)
-			if err != nil || acc == nil {
-				return nil, nil
-			}
-			id := trie.StorageTrieID(req.Root, account, acc.Root)
-			stTrie, err := trie.NewStateTrie(id, chain.TrieDB())
+			proof, err := proveStorageRange(chain, req.Root, account, origin, last)
 			if err != nil {
 				return nil, nil
 			}
-			proof := trienode.NewProofSet()
-			if err := stTrie.Prove(origin[:], proof); err != nil {
-				log.Warn("Failed to prove storage range", "origin", req.Origin, "err", err)
-				return nil, nil
-			}
-			if last != (common.Hash{}) {
-				if err := stTrie.Prove(last[:], proof); err != nil {
-					log.Warn("Failed to prove storage range", "last", last, "err", err)
-					return nil, nil
-				}
-			}
-			proofs = append(proofs, proof.List()...)
+			proofs = append(proofs, proof...)
 			// Proof terminates the reply as proofs are only added if a node
 			// refuses to serve more data (exception when a contract fetch is
 			// finishing, but that's that).
@@ -435,8 +449,106 @@ func ServiceGetStorageRangesQuery(chain *core.BlockChain, req *GetStorageRangesP
 	return slots, proofs
 }
 
+const (
+	// storageArenaSize is the size of the memory chunks the slots of a full
+	// storage query are copied into, to avoid allocating every slot separately.
+	storageArenaSize = 64 * 1024
+
+	// storageItemsChunk is the number of reply items of a full storage query
+	// allocated at once, to avoid allocating every item separately.
+	storageItemsChunk = 256
+)
+
+// serviceFullStorageQuery assembles the response to a storage query requesting
+// the entire storage of a single account. Contrary to the general path, no range
+// boundaries need to be tracked, so the slots are simply piled up until the trie
+// is exhausted or the hard limit is reached, in which case the range is proven.
+func serviceFullStorageQuery(chain *core.BlockChain, root common.Hash, account common.Hash, hardLimit uint64) ([][]*StorageData, [][]byte) {
+	it, err := chain.Snapshots().StorageIterator(root, account, common.Hash{})
+	if err != nil {
+		return nil, nil
+	}
+	defer it.Release()
+
+	var (
+		storage []*StorageData
+		items   []StorageData
+		arena   []byte
+		size    uint64
+		abort   bool
+	)
+	for it.Next() {
+		if size >= hardLimit {
+			abort = true
+			break
+		}
+		slot := it.Slot()
+		if len(arena)+len(slot) > cap(arena) {
+			arena = make([]byte, 0, max(storageArenaSize, len(slot)))
+		}
+		arena = append(arena, slot...)
+
+		if len(items) == cap(items) {
+			items = make([]StorageData, 0, storageItemsChunk)
+		}
+		size += uint64(common.HashLength + len(slot))
+		items = append(items, StorageData{
+			Hash: it.Hash(),
+			Body: arena[len(arena)-len(slot) : len(arena) : len(arena)],
+		})
+		storage = append(storage, &items[len(items)-1])
+	}
+	if len(storage) == 0 {
+		return nil, nil
+	}
+	if !abort {
+		return [][]*StorageData{storage}, nil
+	}
+	proof, err := proveStorageRange(chain, root, account, common.Hash{}, storage[len(storage)-1].Hash)
+	if err != nil {
+		return nil, nil
+	}
+	return [][]*StorageData{storage}, proof
+}
+
+// proveStorageRange generates the Merkle proofs for the boundaries of a storage
+// range of an account, omitting the last one if it is the zero hash.
+func proveStorageRange(chain *core.BlockChain, root common.Hash, account common.Hash, origin, last common.Hash) ([][]byte, error) {
+	accTrie, err := trie.NewStateTrie(trie.StateTrieID(root), chain.TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	acc, err := accTrie.GetAccountByHash(account)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, fmt.Errorf("account %x not found", account)
+	}
+	id := trie.StorageTrieID(root, account, acc.Root)
+	stTrie, err := trie.NewStateTrie(id, chain.TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	proof := trienode.NewProofSet()
+	if err := stTrie.Prove(origin[:], proof); err != nil {
+		log.Warn("Failed to prove storage range", "origin", origin, "err", err)
+		return nil, err
+	}
+	if last != (common.Hash{}) {
+		if err := stTrie.Prove(last[:], proof); err != nil {
+			log.Warn("Failed to prove storage range", "last", last, "err", err)
+			return nil, err
+		}
+	}
+	return proof.List(), nil
+}
+
 // ServiceGetByteCodesQuery assembles the response to a byte codes query.
 // It is exposed to allow external packages to test protocol behavior.
+//
+// Codes requested multiple times are only returned once, so the response may be
+// shorter than the request even if all the codes are available.
 func ServiceGetByteCodesQuery(chain *core.BlockChain, req *GetByteCodesPacket) [][]byte {
 	if req.Bytes > softResponseLimit {
 		req.Bytes = softResponseLimit
@@ -448,8 +560,15 @@ func ServiceGetByteCodesQuery(chain *core.BlockChain, req *GetByteCodesPacket) [
 	var (
 		codes [][]byte
 		bytes uint64
+		seen  = make(map[common.Hash]struct{})
 	)
 	for _, hash := range req.Hashes {
+		// Skip duplicate requests, they would only inflate the response
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		seen[hash] = struct{}{}
+
 		if hash == types.EmptyCodeHash {
 			// Peers should not request the empty code, but if they do, at
 			// least sent them back a correct response without db lookups
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"math/big"
 	"testing"
 	"time"
@@ -30,6 +31,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/params"
@@ -61,6 +63,70 @@ func FuzzTrieNodes(f *testing.F) {
 	})
 }
 
+// FuzzStorageRangesLimits throws extreme storage range query parameters at the
+// request validator and the serving code, checking that anything let through is
+// served without crashing or allocating beyond the response limits.
+func FuzzStorageRangesLimits(f *testing.F) {
+	f.Add(uint64(0), uint16(0), []byte{}, []byte{})
+	f.Add(uint64(softResponseLimit), uint16(1), []byte{}, []byte{})
+	f.Add(uint64(maxAllowedResponseSize), uint16(maxStorageAccountsPerRequest), []byte{0x01}, []byte{0xff})
+	f.Add(uint64(maxAllowedResponseSize+1), uint16(1), []byte{}, []byte{})
+	f.Add(uint64(math.MaxUint64), uint16(math.MaxUint16), bytes.Repeat([]byte{0xff}, 64), []byte{0x00})
+
+	bc := getChain()
+	defer bc.Stop()
+
+	// Gather the accounts with storage to query them alongside junk ones
+	var (
+		addr     = make([]byte, 20)
+		accounts []common.Hash
+	)
+	for i := 0; i < 1000; i += 2 {
+		binary.LittleEndian.PutUint64(addr, uint64(i+1+0xff))
+		accounts = append(accounts, crypto.Keccak256Hash(addr))
+		accounts = append(accounts, common.Hash{byte(i)})
+	}
+	f.Fuzz(func(t *testing.T, limit uint64, count uint16, origin, end []byte) {
+		req := &GetStorageRangesPacket{
+			Root:     trieRoot,
+			Accounts: make([]common.Hash, count),
+			Origin:   origin,
+			Limit:    end,
+			Bytes:    limit,
+		}
+		for i := range req.Accounts {
+			req.Accounts[i] = accounts[i%len(accounts)]
+		}
+		err := ValidateGetStorageRangesPacket(req)
+		if invalid := limit > maxAllowedResponseSize || int(count) > maxStorageAccountsPerRequest; invalid != (err != nil) {
+			t.Fatalf("validation mismatch: bytes %d, accounts %d, err %v", limit, count, err)
+		}
+		if err != nil {
+			return
+		}
+		slots, proofs := ServiceGetStorageRangesQuery(bc, req)
+
+		var size uint64
+		for _, set := range slots {
+			for _, slot := range set {
+				size += uint64(common.HashLength + len(slot.Body))
+			}
+		}
+		if len(slots) > int(count) {
+			t.Fatalf("too many storage sets: have %d, requested %d", len(slots), count)
+		}
+		// Allow a single slot of overshoot on top of the hard limit
+		slack := stateLookupSlack
+		hardLimit := uint64(float64(softResponseLimit)*(1+slack)) + 2*common.HashLength
+		if size > hardLimit {
+			t.Fatalf("response too large: have %d, limit %d", size, hardLimit)
+		}
+		if len(proofs) > 0 && len(slots) == 0 && len(origin) == 0 {
+			t.Fatalf("proofs returned for empty response: %d", len(proofs))
+		}
+	})
+}
+
 func doFuzz(input []byte, obj interface{}, code int) {
 	bc := getChain()
 	defer bc.Stop()
@@ -0,0 +1,166 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/pebble"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that codes requested multiple times in a byte codes query are only served
+// once, to avoid peers inflating the responses.
+func TestServiceGetByteCodesQueryDuplicates(t *testing.T) {
+	codes := [][]byte{{0x60, 0x01}, {0x60, 0x02}}
+	gspec := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: types.GenesisAlloc{
+			common.Address{0x01}: {Balance: big.NewInt(0), Code: codes[0]},
+			common.Address{0x02}: {Balance: big.NewInt(0), Code: codes[1]},
+		},
+	}
+	chain, err := core.NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	var (
+		hashA = crypto.Keccak256Hash(codes[0])
+		hashB = crypto.Keccak256Hash(codes[1])
+	)
+	res := ServiceGetByteCodesQuery(chain, &GetByteCodesPacket{
+		Hashes: []common.Hash{hashA, hashA, hashB, hashA, hashB},
+		Bytes:  softResponseLimit,
+	})
+	if len(res) != len(codes) {
+		t.Fatalf("response length mismatch: have %d, want %d", len(res), len(codes))
+	}
+	for i, code := range codes {
+		if !bytes.Equal(res[i], code) {
+			t.Errorf("code %d mismatch: have %x, want %x", i, res[i], code)
+		}
+	}
+}
+
+// newStorageTestChain creates a chain with a single contract holding the given
+// number of storage slots, returning it along with the hashed contract address.
+// The chain is backed by a disk database, as iterating the memory database sorts
+// all its keys every time, dwarfing the cost of serving the storage itself.
+func newStorageTestChain(t testing.TB, slots int) (*core.BlockChain, common.Hash) {
+	storage := make(map[common.Hash]common.Hash, slots)
+	for i := 0; i < slots; i++ {
+		storage[common.BigToHash(big.NewInt(int64(i)))] = common.BigToHash(big.NewInt(int64(i + 1)))
+	}
+	addr := common.Address{0x01}
+	gspec := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: types.GenesisAlloc{
+			addr: {Balance: big.NewInt(0), Code: []byte{0x00}, Storage: storage},
+		},
+	}
+	cacheConf := &core.CacheConfig{
+		TrieCleanLimit: 256,
+		TrieDirtyLimit: 256,
+		TrieTimeLimit:  5 * time.Minute,
+		SnapshotLimit:  256,
+		SnapshotWait:   true,
+	}
+	kv, err := pebble.New(t.TempDir(), 16, 16, "", false)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { kv.Close() })
+
+	chain, err := core.NewBlockChain(rawdb.NewDatabase(kv), cacheConf, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return chain, crypto.Keccak256Hash(addr.Bytes())
+}
+
+// Tests that queries for the entire storage of a single account are served the
+// same as an explicit full range, both when the storage fits into the response
+// and when it is capped and needs to be proven.
+func TestServiceGetStorageRangesQueryFull(t *testing.T) {
+	chain, account := newStorageTestChain(t, 1000)
+	defer chain.Stop()
+
+	root := chain.CurrentBlock().Root
+	for _, limit := range []uint64{softResponseLimit, 1000} {
+		full, fullProofs := ServiceGetStorageRangesQuery(chain, &GetStorageRangesPacket{
+			Root:     root,
+			Accounts: []common.Hash{account},
+			Bytes:    limit,
+		})
+		ranged, rangedProofs := ServiceGetStorageRangesQuery(chain, &GetStorageRangesPacket{
+			Root:     root,
+			Accounts: []common.Hash{account},
+			Limit:    common.MaxHash[:],
+			Bytes:    limit,
+		})
+		if len(full) != 1 || len(ranged) != 1 {
+			t.Fatalf("limit %d: account count mismatch: have %d, want %d", limit, len(full), len(ranged))
+		}
+		if !reflect.DeepEqual(full, ranged) {
+			t.Errorf("limit %d: slots mismatch: have %d, want %d", limit, len(full[0]), len(ranged[0]))
+		}
+		if !reflect.DeepEqual(fullProofs, rangedProofs) {
+			t.Errorf("limit %d: proofs mismatch: have %d nodes, want %d", limit, len(fullProofs), len(rangedProofs))
+		}
+		if capped := len(full[0]) < 1000; capped != (len(fullProofs) > 0) {
+			t.Errorf("limit %d: proof presence mismatch: %d slots, %d proof nodes", limit, len(full[0]), len(fullProofs))
+		}
+	}
+}
+
+// Benchmarks serving the entire storage of a single contract, comparing the fast
+// path of full storage queries with the general path of an explicit full range.
+func BenchmarkServiceGetStorageRangesQuery(b *testing.B) {
+	chain, account := newStorageTestChain(b, 10000)
+	defer chain.Stop()
+
+	root := chain.CurrentBlock().Root
+	bench := func(b *testing.B, limit []byte) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			slots, proofs := ServiceGetStorageRangesQuery(chain, &GetStorageRangesPacket{
+				Root:     root,
+				Accounts: []common.Hash{account},
+				Limit:    limit,
+				Bytes:    softResponseLimit,
+			})
+			if len(slots) != 1 || len(slots[0]) != 10000 || len(proofs) != 0 {
+				b.Fatalf("response mismatch: have %d accounts, %d proofs", len(slots), len(proofs))
+			}
+		}
+	}
+	b.Run("full", func(b *testing.B) { bench(b, nil) })
+	b.Run("range", func(b *testing.B) { bench(b, common.MaxHash[:]) })
+}
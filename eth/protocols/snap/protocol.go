@@ -17,14 +17,22 @@
 package snap
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
+//go:generate go run github.com/fjl/gencodec -type AccountRangePacket -field-override accountRangePacketMarshaling -out gen_accountrangepacket_json.go
+//go:generate go run github.com/fjl/gencodec -type AccountData -field-override accountDataMarshaling -out gen_accountdata_json.go
+//go:generate go run github.com/fjl/gencodec -type StorageRangesPacket -field-override storageRangesPacketMarshaling -out gen_storagerangespacket_json.go
+//go:generate go run github.com/fjl/gencodec -type StorageData -field-override storageDataMarshaling -out gen_storagedata_json.go
+//go:generate go run github.com/fjl/gencodec -type ByteCodesPacket -field-override byteCodesPacketMarshaling -out gen_bytecodespacket_json.go
+
 // Constants to match up protocol versions and messages
 const (
 	SNAP1 = 1
@@ -61,6 +69,7 @@ var (
 	errDecode         = errors.New("invalid message")
 	errInvalidMsgCode = errors.New("invalid message code")
 	errBadRequest     = errors.New("bad request")
+	errInvalidRequest = errors.New("invalid request")
 )
 
 // Packet represents a p2p message in the `snap` protocol.
@@ -79,16 +88,33 @@ type GetAccountRangePacket struct {
 }
 
 // AccountRangePacket represents an account query response.
+//
+// Besides RLP on the wire, the packet can be encoded as JSON for debugging tools,
+// with the ID as a hex quantity and the proof nodes as hex strings:
+//
+//	{"id": "0x1", "accounts": [{"hash": "0x...", "body": "0x..."}], "proof": ["0x..."]}
 type AccountRangePacket struct {
-	ID       uint64         // ID of the request this is a response for
-	Accounts []*AccountData // List of consecutive accounts from the trie
-	Proof    [][]byte       // List of trie nodes proving the account range
+	ID       uint64         `json:"id"`       // ID of the request this is a response for
+	Accounts []*AccountData `json:"accounts"` // List of consecutive accounts from the trie
+	Proof    [][]byte       `json:"proof"`    // List of trie nodes proving the account range
+}
+
+type accountRangePacketMarshaling struct {
+	ID    hexutil.Uint64
+	Proof []hexutil.Bytes
 }
 
 // AccountData represents a single account in a query response.
+//
+// In JSON, the account is encoded as {"hash": "0x...", "body": "0x..."}, where
+// the body is the hex encoded slim RLP account.
 type AccountData struct {
-	Hash common.Hash  // Hash of the account
-	Body rlp.RawValue // Account body in slim format
+	Hash common.Hash  `json:"hash"` // Hash of the account
+	Body rlp.RawValue `json:"body"` // Account body in slim format
+}
+
+type accountDataMarshaling struct {
+	Body hexutil.Bytes
 }
 
 // Unpack retrieves the accounts from the range packet and converts from slim
@@ -113,6 +139,28 @@ func (p *AccountRangePacket) Unpack() ([]common.Hash, [][]byte, error) {
 	return hashes, accounts, nil
 }
 
+// Validate checks that the range response is consistent with the request bounds.
+// All accounts must start at or after the requested origin and, apart from the
+// last one (which may overshoot to prove there are no more accounts until the
+// limit), must not exceed the requested limit. A response not starting from the
+// beginning of the trie must also carry a proof for its edges.
+//
+// Note, the proof itself is not verified here, that needs the state root.
+func (p *AccountRangePacket) Validate(req *GetAccountRangePacket) error {
+	for i, acc := range p.Accounts {
+		if bytes.Compare(acc.Hash[:], req.Origin[:]) < 0 {
+			return fmt.Errorf("account #%d [%x] before requested origin [%x]", i, acc.Hash[:], req.Origin[:])
+		}
+		if i < len(p.Accounts)-1 && bytes.Compare(acc.Hash[:], req.Limit[:]) > 0 {
+			return fmt.Errorf("account #%d [%x] after requested limit [%x]", i, acc.Hash[:], req.Limit[:])
+		}
+	}
+	if len(p.Proof) == 0 && req.Origin != (common.Hash{}) {
+		return fmt.Errorf("missing edge proof for range starting at [%x]", req.Origin[:])
+	}
+	return nil
+}
+
 // GetStorageRangesPacket represents an storage slot query.
 type GetStorageRangesPacket struct {
 	ID       uint64        // Request ID to match up responses with
@@ -124,16 +172,33 @@ type GetStorageRangesPacket struct {
 }
 
 // StorageRangesPacket represents a storage slot query response.
+//
+// In JSON, the ID is a hex quantity, the slots a list of slot lists, one for
+// each requested account, and the proof nodes hex strings:
+//
+//	{"id": "0x1", "slots": [[{"hash": "0x...", "body": "0x..."}]], "proof": ["0x..."]}
 type StorageRangesPacket struct {
-	ID    uint64           // ID of the request this is a response for
-	Slots [][]*StorageData // Lists of consecutive storage slots for the requested accounts
-	Proof [][]byte         // Merkle proofs for the *last* slot range, if it's incomplete
+	ID    uint64           `json:"id"`    // ID of the request this is a response for
+	Slots [][]*StorageData `json:"slots"` // Lists of consecutive storage slots for the requested accounts
+	Proof [][]byte         `json:"proof"` // Merkle proofs for the *last* slot range, if it's incomplete
+}
+
+type storageRangesPacketMarshaling struct {
+	ID    hexutil.Uint64
+	Proof []hexutil.Bytes
 }
 
 // StorageData represents a single storage slot in a query response.
+//
+// In JSON, the slot is encoded as {"hash": "0x...", "body": "0x..."}, where the
+// body is the hex encoded slot content.
 type StorageData struct {
-	Hash common.Hash // Hash of the storage slot
-	Body []byte      // Data content of the slot
+	Hash common.Hash `json:"hash"` // Hash of the storage slot
+	Body []byte      `json:"body"` // Data content of the slot
+}
+
+type storageDataMarshaling struct {
+	Body hexutil.Bytes
 }
 
 // Unpack retrieves the storage slots from the range packet and returns them in
@@ -154,6 +219,40 @@ func (p *StorageRangesPacket) Unpack() ([][]common.Hash, [][][]byte) {
 	return hashset, slotset
 }
 
+// ErrInvalidProof is returned if a storage range response does not prove against
+// the storage root of the account it was requested for.
+var ErrInvalidProof = errors.New("invalid storage range proof")
+
+// Validate checks that the storage ranges in the response are part of the storage
+// tries of the requested accounts, given their storage roots. Every range apart
+// from the last one must cover an entire storage trie, the last one may instead
+// be partial, proven by the attached edge proof. Requests without any accounts
+// have no valid response.
+func (p *StorageRangesPacket) Validate(req *GetStorageRangesPacket, accountStorageRoots map[common.Hash]common.Hash) error {
+	if len(req.Accounts) == 0 {
+		return errors.New("no accounts requested")
+	}
+	if len(p.Slots) > len(req.Accounts) {
+		return fmt.Errorf("slot sets %d > requested accounts %d", len(p.Slots), len(req.Accounts))
+	}
+	roots := make([]common.Hash, len(req.Accounts))
+	for i, account := range req.Accounts {
+		root, ok := accountStorageRoots[account]
+		if !ok {
+			return fmt.Errorf("unknown storage root for account %x", account)
+		}
+		roots[i] = root
+	}
+	hashes, slots := p.Unpack()
+
+	// An empty response with a proof attached proves the requested range empty
+	if len(hashes) == 0 && len(p.Proof) > 0 {
+		hashes, slots = [][]common.Hash{{}}, [][][]byte{{}}
+	}
+	_, err := verifyStorageRanges(roots, common.BytesToHash(req.Origin), hashes, slots, p.Proof)
+	return err
+}
+
 // GetByteCodesPacket represents a contract bytecode query.
 type GetByteCodesPacket struct {
 	ID     uint64        // Request ID to match up responses with
@@ -162,9 +261,18 @@ type GetByteCodesPacket struct {
 }
 
 // ByteCodesPacket represents a contract bytecode query response.
+//
+// In JSON, the ID is a hex quantity and the bytecodes hex strings:
+//
+//	{"id": "0x1", "codes": ["0x..."]}
 type ByteCodesPacket struct {
-	ID    uint64   // ID of the request this is a response for
-	Codes [][]byte // Requested contract bytecodes
+	ID    uint64   `json:"id"`    // ID of the request this is a response for
+	Codes [][]byte `json:"codes"` // Requested contract bytecodes
+}
+
+type byteCodesPacketMarshaling struct {
+	ID    hexutil.Uint64
+	Codes []hexutil.Bytes
 }
 
 // GetTrieNodesPacket represents a state trie node query.
@@ -0,0 +1,73 @@
+// Copyright 2025 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// testJSONRoundTrip decodes the given JSON into a fresh packet, encodes it back
+// and checks that the output is identical to the input.
+func testJSONRoundTrip[T any](t *testing.T, input string) {
+	t.Helper()
+
+	var packet T
+	if err := json.Unmarshal([]byte(input), &packet); err != nil {
+		t.Fatalf("failed to decode %T: %v", packet, err)
+	}
+	output, err := json.Marshal(&packet)
+	if err != nil {
+		t.Fatalf("failed to encode %T: %v", packet, err)
+	}
+	if string(output) != input {
+		t.Fatalf("%T round trip mismatch:\nhave %s\nwant %s", packet, output, input)
+	}
+}
+
+// Tests that account range responses survive a JSON round trip.
+func TestAccountRangePacketJSON(t *testing.T) {
+	for _, input := range []string{
+		`{"id":"0x0","accounts":null,"proof":null}`,
+		`{"id":"0x2a","accounts":[],"proof":[]}`,
+		`{"id":"0xffffffffffffffff","accounts":[{"hash":"0x0100000000000000000000000000000000000000000000000000000000000002","body":"0xc480808080"},{"hash":"0xff00000000000000000000000000000000000000000000000000000000000000","body":"0x"}],"proof":["0xc0","0x"]}`,
+	} {
+		testJSONRoundTrip[AccountRangePacket](t, input)
+	}
+}
+
+// Tests that storage range responses survive a JSON round trip.
+func TestStorageRangesPacketJSON(t *testing.T) {
+	for _, input := range []string{
+		`{"id":"0x0","slots":null,"proof":null}`,
+		`{"id":"0x2a","slots":[[],null],"proof":[]}`,
+		`{"id":"0x7","slots":[[{"hash":"0x0100000000000000000000000000000000000000000000000000000000000002","body":"0x2a"}],[{"hash":"0xff00000000000000000000000000000000000000000000000000000000000000","body":"0x"}]],"proof":["0xc0"]}`,
+	} {
+		testJSONRoundTrip[StorageRangesPacket](t, input)
+	}
+}
+
+// Tests that bytecode responses survive a JSON round trip.
+func TestByteCodesPacketJSON(t *testing.T) {
+	for _, input := range []string{
+		`{"id":"0x0","codes":null}`,
+		`{"id":"0x2a","codes":[]}`,
+		`{"id":"0x7","codes":["0x6080604052","0x"]}`,
+	} {
+		testJSONRoundTrip[ByteCodesPacket](t, input)
+	}
+}
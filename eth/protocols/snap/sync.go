@@ -32,6 +32,8 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/gopool"
 	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/common/prque"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -97,6 +99,23 @@ const (
 
 	// batchSizeThreshold is the maximum size allowed for gentrie batch.
 	batchSizeThreshold = 8 * 1024 * 1024
+
+	// defaultPeerUtilisationCap is the default maximum share of the account range
+	// requests of a sync cycle a single peer is allowed to serve.
+	defaultPeerUtilisationCap = 0.8
+
+	// peerUtilisationMinRequests is the number of account range requests to
+	// issue in a sync cycle before the peer utilisation cap is enforced, to
+	// avoid throttling peers based on a meaningless sample.
+	peerUtilisationMinRequests = 10
+
+	// peerThrottleTime is the duration for which a peer exceeding the utilisation
+	// cap is not assigned new account range requests.
+	peerThrottleTime = 30 * time.Second
+
+	// accountRateWindow is the period over which the account sync throughput is
+	// averaged to estimate the remaining time of the sync.
+	accountRateWindow = 30 * time.Second
 )
 
 var (
@@ -374,6 +393,25 @@ type healTask struct {
 
 	trieTasks map[string]common.Hash   // Set of trie node tasks currently queued for retrieval, indexed by node path
 	codeTasks map[common.Hash]struct{} // Set of byte code tasks currently queued for retrieval, indexed by code hash
+
+	cutoff   int                                    // Maximum trie depth healed in the current pass (0 = unlimited)
+	deferred *prque.Prque[int64, *deferredTrieNode] // Trie nodes beyond the cutoff, popped shallowest first
+}
+
+// deferredTrieNode is a trie node scheduled for healing, but postponed until a
+// later pass due to being deeper than the current depth cutoff.
+type deferredTrieNode struct {
+	path string      // Path of the trie node, as scheduled by the state sync scheduler
+	hash common.Hash // Hash of the trie node to retrieve
+}
+
+// healDepth returns the depth of a scheduled trie node within its own trie. The
+// path of storage trie nodes is prefixed with the 64 nibbles of the account hash.
+func healDepth(path string) int {
+	if len(path) < 64 {
+		return len(path)
+	}
+	return len(path) - 64
 }
 
 // SyncProgress is a database entry to allow suspending and resuming a snapshot state
@@ -445,6 +483,7 @@ type SyncPeer interface {
 type Syncer struct {
 	db     ethdb.Database // Database to store the trie nodes into (and dedup)
 	scheme string         // Node scheme used in node database
+	config SyncConfig     // Tunable parameters of the syncer
 
 	root    common.Hash    // Current state trie root being synced
 	tasks   []*accountTask // Current account task set being synced
@@ -467,6 +506,10 @@ type Syncer struct {
 	bytecodeReqs map[uint64]*bytecodeRequest // Bytecode requests currently running
 	storageReqs  map[uint64]*storageRequest  // Storage requests currently running
 
+	accountServed    map[string]int       // Number of account requests assigned to each peer in this cycle
+	accountRequests  int                  // Number of account requests assigned in this cycle
+	accountThrottled map[string]time.Time // Peers exceeding the utilisation cap, until when
+
 	accountSynced  uint64             // Number of accounts downloaded
 	accountBytes   common.StorageSize // Number of account trie bytes persisted to disk
 	bytecodeSynced uint64             // Number of bytecodes downloaded
@@ -476,6 +519,19 @@ type Syncer struct {
 
 	extProgress *SyncProgress // progress that can be exposed to external caller.
 
+	accountRates    []accountRateSample // Synced account counts measured within the rate window
+	accountEstimate uint64              // Total number of accounts, extrapolated from the covered hash space
+
+	accountDelivered atomic.Uint64 // Number of accounts delivered in the current sync cycle (live counter)
+	slotDelivered    atomic.Uint64 // Number of storage slots delivered in the current sync cycle (live counter)
+
+	// Aggregated sync metrics across all the sync cycles, exposed via Stats
+	statAccounts  atomic.Uint64 // Number of accounts processed
+	statSlots     atomic.Uint64 // Number of storage slots processed
+	statBytecodes atomic.Uint64 // Number of bytecodes processed in the syncing phase
+	statHealed    atomic.Uint64 // Number of trie nodes healed
+	statBytes     atomic.Uint64 // Number of bytes delivered by the remote peers
+
 	// Request tracking during healing phase
 	trienodeHealIdlers map[string]struct{} // Peers that aren't serving trie node requests
 	bytecodeHealIdlers map[string]struct{} // Peers that aren't serving bytecode requests
@@ -510,12 +566,81 @@ type Syncer struct {
 	lock sync.RWMutex   // Protects fields that can change outside of sync (peers, reqs, root)
 }
 
+// accountRateSample is a measurement of the number of accounts synced so far.
+type accountRateSample struct {
+	time   time.Time
+	synced uint64
+}
+
+// TrieHealStrategy is the order in which the trie nodes are retrieved during
+// the healing phase.
+type TrieHealStrategy int
+
+const (
+	// DepthFirst retrieves the deepest trie nodes first, completing sub-tries
+	// before moving on to their siblings. It bounds the number of pending nodes.
+	DepthFirst TrieHealStrategy = iota
+
+	// BreadthFirst retrieves all trie nodes of a level before any of the next.
+	// No sub-trie completes until the last level is reached, so it may hold a
+	// whole trie level in memory.
+	BreadthFirst
+)
+
+// SyncConfig contains the tunable parameters of the snapshot syncer.
+type SyncConfig struct {
+	// MaxHealingTrieDepth limits the depth of trie nodes healed in a single pass.
+	// Nodes deeper than that are deferred until the shallower ones are healed,
+	// after which the limit is raised by the same amount for the next pass. The
+	// zero value disables the limit.
+	MaxHealingTrieDepth int
+
+	// PeerUtilisationCap is the maximum share (0, 1] of the account range requests
+	// in a sync cycle a single peer may serve. Peers exceeding it are throttled
+	// for a while, routing requests to others. The zero value means the default.
+	PeerUtilisationCap float64
+
+	// MaxConcurrentRequests is the maximum number of requests of all kinds that
+	// may be in flight at the same time. Once reached, no new requests are sent
+	// until some are answered. The zero value means twice the number of peers.
+	MaxConcurrentRequests int
+
+	// TrieHealStrategy is the order in which trie nodes are healed. The zero
+	// value means depth-first.
+	TrieHealStrategy TrieHealStrategy
+
+	// BytecodeJobBatchSize is the maximum number of bytecodes requested in a
+	// single query, both during the snap and the healing phase. Larger batches
+	// save round trips if the contracts are small, at the cost of more data in
+	// flight per request. Non-positive values mean maxCodeRequestCount.
+	BytecodeJobBatchSize int
+
+	// AccountConcurrency is the number of chunks the account range of a fresh
+	// sync is split into, each being retrieved in parallel from its own peer.
+	// Resumed syncs keep the chunks they were started with. The zero value
+	// means accountConcurrency.
+	AccountConcurrency int
+}
+
 // NewSyncer creates a new snapshot syncer to download the Ethereum state over the
 // snap protocol.
 func NewSyncer(db ethdb.Database, scheme string) *Syncer {
+	return NewSyncerWithConfig(db, scheme, SyncConfig{})
+}
+
+// NewSyncerWithConfig creates a new snapshot syncer to download the Ethereum state
+// over the snap protocol, using the given tunable parameters.
+func NewSyncerWithConfig(db ethdb.Database, scheme string, config SyncConfig) *Syncer {
+	if config.PeerUtilisationCap == 0 {
+		config.PeerUtilisationCap = defaultPeerUtilisationCap
+	}
+	if config.BytecodeJobBatchSize <= 0 {
+		config.BytecodeJobBatchSize = maxCodeRequestCount
+	}
 	return &Syncer{
 		db:     db,
 		scheme: scheme,
+		config: config,
 
 		peers:    make(map[string]SyncPeer),
 		peerJoin: new(event.Feed),
@@ -543,6 +668,16 @@ func NewSyncer(db ethdb.Database, scheme string) *Syncer {
 	}
 }
 
+// SetAccountConcurrency sets the number of chunks the account range is split
+// into for parallel retrieval, taking effect from the next fresh sync. A
+// non-positive number restores the default.
+func (s *Syncer) SetAccountConcurrency(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.config.AccountConcurrency = n
+}
+
 // Register injects a new data source into the syncer's peerset.
 func (s *Syncer) Register(peer SyncPeer) error {
 	// Make sure the peer is not registered yet
@@ -586,6 +721,7 @@ func (s *Syncer) Unregister(id string) error {
 
 	// Remove status markers, even if no sync is running
 	delete(s.statelessPeers, id)
+	delete(s.accountThrottled, id)
 
 	delete(s.accountIdlers, id)
 	delete(s.storageIdlers, id)
@@ -599,6 +735,46 @@ func (s *Syncer) Unregister(id string) error {
 	return nil
 }
 
+// PreloadGenesisState writes the contract codes allocated in the genesis into the
+// local database, so they are not retrieved from remote peers during sync. BSC
+// deploys its system contracts in the genesis, so that's a fair chunk of data.
+//
+// Only the codes are preloaded, as they are content addressed and thus valid in
+// any state. The genesis accounts and storage slots have most probably changed
+// since, so they are still synced and proven against the requested state root.
+func (s *Syncer) PreloadGenesisState(gspec *core.Genesis) error {
+	if gspec == nil {
+		return errors.New("no genesis specification")
+	}
+	var (
+		batch = s.db.NewBatch()
+		codes int
+	)
+	for _, account := range gspec.Alloc {
+		if len(account.Code) == 0 {
+			continue
+		}
+		hash := crypto.Keccak256Hash(account.Code)
+		if rawdb.HasCodeWithPrefix(s.db, hash) {
+			continue
+		}
+		rawdb.WriteCode(batch, hash, account.Code)
+		codes++
+
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Debug("Preloaded genesis contract codes", "codes", codes)
+	return nil
+}
+
 // Sync starts (or resumes a previous) sync cycle to iterate over a state trie
 // with the given root and reconstruct the nodes based on the snapshot leaves.
 // Previously downloaded segments will not be redownloaded of fixed, rather any
@@ -612,13 +788,22 @@ func (s *Syncer) Sync(root common.Hash, cancel chan struct{}) error {
 		scheduler: state.NewStateSync(root, s.db, s.onHealState, s.scheme),
 		trieTasks: make(map[string]common.Hash),
 		codeTasks: make(map[common.Hash]struct{}),
+		cutoff:    s.config.MaxHealingTrieDepth,
+		deferred:  prque.New[int64, *deferredTrieNode](nil),
 	}
+	s.healer.scheduler.SetBreadthFirst(s.config.TrieHealStrategy == BreadthFirst)
 	s.statelessPeers = make(map[string]struct{})
-	s.lock.Unlock()
-
+	s.accountServed = make(map[string]int)
+	s.accountRequests = 0
+	s.accountThrottled = make(map[string]time.Time)
 	if s.startTime == (time.Time{}) {
 		s.startTime = time.Now()
 	}
+	s.lock.Unlock()
+
+	s.accountDelivered.Store(0)
+	s.slotDelivered.Store(0)
+
 	// Retrieve the previous sync status from LevelDB and abort if already synced
 	s.loadSyncStatus()
 	if len(s.tasks) == 0 && s.healer.scheduler.Pending() == 0 {
@@ -833,16 +1018,22 @@ func (s *Syncer) loadSyncStatus() {
 	s.trienodeHealSynced, s.trienodeHealBytes = 0, 0
 	s.bytecodeHealSynced, s.bytecodeHealBytes = 0, 0
 
+	s.lock.RLock()
+	chunks := s.config.AccountConcurrency
+	s.lock.RUnlock()
+	if chunks <= 0 {
+		chunks = accountConcurrency
+	}
 	var next common.Hash
 	step := new(big.Int).Sub(
 		new(big.Int).Div(
 			new(big.Int).Exp(common.Big2, common.Big256, nil),
-			big.NewInt(int64(accountConcurrency)),
+			big.NewInt(int64(chunks)),
 		), common.Big1,
 	)
-	for i := 0; i < accountConcurrency; i++ {
+	for i := 0; i < chunks; i++ {
 		last := common.BigToHash(new(big.Int).Add(next.Big(), step))
-		if i == accountConcurrency-1 {
+		if i == chunks-1 {
 			// Make sure we don't overflow if the step is not a proper divisor
 			last = common.MaxHash
 		}
@@ -935,6 +1126,123 @@ func (s *Syncer) Progress() (*SyncProgress, *SyncPending) {
 	return s.extProgress, pending
 }
 
+// SyncStat is a snapshot of the aggregated snap sync metrics, accumulated over
+// all the sync cycles run by a syncer.
+type SyncStat struct {
+	AccountsProcessed     uint64        // Number of accounts processed
+	StorageSlotsProcessed uint64        // Number of storage slots processed
+	BytecodesProcessed    uint64        // Number of bytecodes processed in the syncing phase
+	TrieNodesHealed       uint64        // Number of trie nodes healed
+	BytesDownloaded       uint64        // Number of bytes delivered by the remote peers
+	StartTime             time.Time     // Time the first sync cycle started, zero if none did
+	ElapsedTime           time.Duration // Time passed since StartTime
+}
+
+// Stats returns the aggregated metrics of the snap sync. Contrary to Progress,
+// the counters are updated as soon as the responses are delivered or processed.
+func (s *Syncer) Stats() SyncStat {
+	s.lock.RLock()
+	start := s.startTime
+	s.lock.RUnlock()
+
+	stat := SyncStat{
+		AccountsProcessed:     s.statAccounts.Load(),
+		StorageSlotsProcessed: s.statSlots.Load(),
+		BytecodesProcessed:    s.statBytecodes.Load(),
+		TrieNodesHealed:       s.statHealed.Load(),
+		BytesDownloaded:       s.statBytes.Load(),
+		StartTime:             start,
+	}
+	if !start.IsZero() {
+		stat.ElapsedTime = time.Since(start)
+	}
+	return stat
+}
+
+// AccountCount returns the number of accounts delivered during the current sync
+// cycle, excluding any overflowing into subsequent tasks. Contrary to the
+// periodic progress reports, it is updated as soon as a response is processed.
+func (s *Syncer) AccountCount() uint64 {
+	return s.accountDelivered.Load()
+}
+
+// StorageSlotCount returns the number of storage slots delivered during the
+// current sync cycle, updated as soon as a response is processed.
+func (s *Syncer) StorageSlotCount() uint64 {
+	return s.slotDelivered.Load()
+}
+
+// AccountThroughput returns the number of accounts synced per second, averaged
+// over the last accountRateWindow.
+func (s *Syncer) AccountThroughput() float64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.accountThroughput(time.Now())
+}
+
+// AccountETA estimates the time needed to sync the remaining accounts at the
+// current throughput, or returns -1 if no accounts are being synced.
+func (s *Syncer) AccountETA() time.Duration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	rate := s.accountThroughput(time.Now())
+	if rate == 0 {
+		return -1
+	}
+	var remaining uint64
+	if n := len(s.accountRates); s.accountEstimate > s.accountRates[n-1].synced {
+		remaining = s.accountEstimate - s.accountRates[n-1].synced
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second))
+}
+
+// accountThroughput calculates the account sync rate from the samples measured
+// within the rate window ending at the given time. The caller must hold the lock.
+func (s *Syncer) accountThroughput(now time.Time) float64 {
+	var first *accountRateSample
+	for i := range s.accountRates {
+		if now.Sub(s.accountRates[i].time) <= accountRateWindow {
+			first = &s.accountRates[i]
+			break
+		}
+	}
+	if first == nil {
+		return 0
+	}
+	last := s.accountRates[len(s.accountRates)-1]
+	if elapsed := last.time.Sub(first.time); elapsed > 0 {
+		return float64(last.synced-first.synced) / elapsed.Seconds()
+	}
+	return 0
+}
+
+// trackAccountRate records the number of accounts synced at the given time and
+// extrapolates the total number of accounts from the hash space covered by the
+// account tasks.
+func (s *Syncer) trackAccountRate(now time.Time) {
+	gaps := new(big.Int)
+	for _, task := range s.tasks {
+		gaps.Add(gaps, new(big.Int).Sub(task.Last.Big(), task.Next.Big()))
+	}
+	var estimate uint64
+	if fills := new(big.Int).Sub(hashSpace, gaps); fills.Sign() > 0 {
+		estimate = new(big.Int).Div(new(big.Int).Mul(new(big.Int).SetUint64(s.accountSynced), hashSpace), fills).Uint64()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.accountEstimate = estimate
+	if n := len(s.accountRates); n > 0 && s.accountRates[n-1].synced > s.accountSynced {
+		s.accountRates = s.accountRates[:0] // Sync restarted from scratch
+	}
+	s.accountRates = append(s.accountRates, accountRateSample{time: now, synced: s.accountSynced})
+	for len(s.accountRates) > 1 && now.Sub(s.accountRates[0].time) > accountRateWindow {
+		s.accountRates = s.accountRates[1:]
+	}
+}
+
 // cleanAccountTasks removes account range retrieval tasks that have already been
 // completed.
 func (s *Syncer) cleanAccountTasks() {
@@ -997,6 +1305,52 @@ func (s *Syncer) cleanStorageTasks() {
 	}
 }
 
+// accountPeerThrottled returns whether a peer is currently barred from serving
+// account range requests due to exceeding the utilisation cap.
+//
+// Note, this method assumes the syncer lock is held.
+func (s *Syncer) accountPeerThrottled(id string) bool {
+	until, ok := s.accountThrottled[id]
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	delete(s.accountThrottled, id)
+	return false
+}
+
+// trackAccountPeer accounts an account range request assigned to a peer, and
+// throttles the peer if it served more than its allowed share of the requests.
+//
+// Note, this method assumes the syncer lock is held.
+func (s *Syncer) trackAccountPeer(id string) {
+	s.accountServed[id]++
+	s.accountRequests++
+
+	if len(s.peers) < 2 || s.accountRequests < peerUtilisationMinRequests {
+		return
+	}
+	if share := float64(s.accountServed[id]) / float64(s.accountRequests); share > s.config.PeerUtilisationCap {
+		log.Debug("Throttling overutilised snap peer", "peer", id, "share", share, "cap", s.config.PeerUtilisationCap)
+		s.accountThrottled[id] = time.Now().Add(peerThrottleTime)
+	}
+}
+
+// requestsCapped reports whether the number of in-flight requests across all the
+// retrieval types reached the aggregate concurrency cap.
+//
+// Note, this method assumes the syncer lock is held.
+func (s *Syncer) requestsCapped() bool {
+	limit := s.config.MaxConcurrentRequests
+	if limit == 0 {
+		limit = 2 * len(s.peers)
+	}
+	pending := len(s.accountReqs) + len(s.storageReqs) + len(s.bytecodeReqs) + len(s.trienodeHealReqs) + len(s.bytecodeHealReqs)
+	return pending >= limit
+}
+
 // assignAccountTasks attempts to match idle peers to pending account range
 // retrievals.
 func (s *Syncer) assignAccountTasks(success chan *accountResponse, fail chan *accountRequest, cancel chan struct{}) {
@@ -1008,14 +1362,29 @@ func (s *Syncer) assignAccountTasks(success chan *accountResponse, fail chan *ac
 		ids:  make([]string, 0, len(s.accountIdlers)),
 		caps: make([]int, 0, len(s.accountIdlers)),
 	}
-	targetTTL := s.rates.TargetTimeout()
+	var (
+		targetTTL = s.rates.TargetTimeout()
+		throttled []string
+	)
 	for id := range s.accountIdlers {
 		if _, ok := s.statelessPeers[id]; ok {
 			continue
 		}
+		if s.accountPeerThrottled(id) {
+			throttled = append(throttled, id)
+			continue
+		}
 		idlers.ids = append(idlers.ids, id)
 		idlers.caps = append(idlers.caps, s.rates.Capacity(id, AccountRangeMsg, targetTTL))
 	}
+	// If only throttled peers are available and nothing is in flight to retrigger
+	// the assignment, fall back to them instead of stalling the sync
+	if len(idlers.ids) == 0 && len(s.accountReqs) == 0 {
+		for _, id := range throttled {
+			idlers.ids = append(idlers.ids, id)
+			idlers.caps = append(idlers.caps, s.rates.Capacity(id, AccountRangeMsg, targetTTL))
+		}
+	}
 	if len(idlers.ids) == 0 {
 		return
 	}
@@ -1029,8 +1398,9 @@ func (s *Syncer) assignAccountTasks(success chan *accountResponse, fail chan *ac
 		}
 		// Task pending retrieval, try to find an idle peer. If no such peer
 		// exists, we probably assigned tasks for all (or they are stateless).
-		// Abort the entire assignment mechanism.
-		if len(idlers.ids) == 0 {
+		// Abort the entire assignment mechanism, same if too many requests are
+		// already in flight.
+		if len(idlers.ids) == 0 || s.requestsCapped() {
 			return
 		}
 		var (
@@ -1072,6 +1442,7 @@ func (s *Syncer) assignAccountTasks(success chan *accountResponse, fail chan *ac
 		})
 		s.accountReqs[reqid] = req
 		delete(s.accountIdlers, idle)
+		s.trackAccountPeer(idle)
 
 		s.pend.Add(1)
 		root := s.root
@@ -1131,8 +1502,9 @@ func (s *Syncer) assignBytecodeTasks(success chan *bytecodeResponse, fail chan *
 		}
 		// Task pending retrieval, try to find an idle peer. If no such peer
 		// exists, we probably assigned tasks for all (or they are stateless).
-		// Abort the entire assignment mechanism.
-		if len(idlers.ids) == 0 {
+		// Abort the entire assignment mechanism, same if too many requests are
+		// already in flight.
+		if len(idlers.ids) == 0 || s.requestsCapped() {
 			return
 		}
 		var (
@@ -1155,8 +1527,8 @@ func (s *Syncer) assignBytecodeTasks(success chan *bytecodeResponse, fail chan *
 			break
 		}
 		// Generate the network query and send it to the peer
-		if cap > maxCodeRequestCount {
-			cap = maxCodeRequestCount
+		if cap > s.config.BytecodeJobBatchSize {
+			cap = s.config.BytecodeJobBatchSize
 		}
 		hashes := make([]common.Hash, 0, cap)
 		for hash := range task.codeTasks {
@@ -1235,8 +1607,9 @@ func (s *Syncer) assignStorageTasks(success chan *storageResponse, fail chan *st
 		}
 		// Task pending retrieval, try to find an idle peer. If no such peer
 		// exists, we probably assigned tasks for all (or they are stateless).
-		// Abort the entire assignment mechanism.
-		if len(idlers.ids) == 0 {
+		// Abort the entire assignment mechanism, same if too many requests are
+		// already in flight.
+		if len(idlers.ids) == 0 || s.requestsCapped() {
 			return
 		}
 		var (
@@ -1356,6 +1729,40 @@ func (s *Syncer) assignStorageTasks(success chan *storageResponse, fail chan *st
 	}
 }
 
+// fillHealTasks retrieves up to max missing trie nodes and bytecodes from the
+// state sync scheduler and queues them up for retrieval. Trie nodes deeper than
+// the current healing pass allows are deferred until a later pass.
+func (s *Syncer) fillHealTasks(max int) {
+	paths, hashes, codes := s.healer.scheduler.Missing(max)
+	for i, path := range paths {
+		if depth := healDepth(path); s.healer.cutoff > 0 && depth > s.healer.cutoff {
+			s.healer.deferred.Push(&deferredTrieNode{path: path, hash: hashes[i]}, -int64(depth))
+			continue
+		}
+		s.healer.trieTasks[path] = hashes[i]
+	}
+	for _, hash := range codes {
+		s.healer.codeTasks[hash] = struct{}{}
+	}
+}
+
+// startHealPass raises the depth cutoff of trie healing by the configured limit
+// and queues up all the deferred trie nodes that fall within the new cutoff.
+func (s *Syncer) startHealPass() {
+	for len(s.healer.trieTasks) == 0 && !s.healer.deferred.Empty() {
+		s.healer.cutoff += s.config.MaxHealingTrieDepth
+		for !s.healer.deferred.Empty() {
+			node, prio := s.healer.deferred.Peek()
+			if int(-prio) > s.healer.cutoff {
+				break
+			}
+			s.healer.deferred.Pop()
+			s.healer.trieTasks[node.path] = node.hash
+		}
+	}
+	log.Debug("Starting next trie healing pass", "cutoff", s.healer.cutoff, "scheduled", len(s.healer.trieTasks), "deferred", s.healer.deferred.Size())
+}
+
 // assignTrienodeHealTasks attempts to match idle peers to trie node requests to
 // heal any trie errors caused by the snap sync's chunked retrieval model.
 func (s *Syncer) assignTrienodeHealTasks(success chan *trienodeHealResponse, fail chan *trienodeHealRequest, cancel chan struct{}) {
@@ -1390,13 +1797,12 @@ func (s *Syncer) assignTrienodeHealTasks(success chan *trienodeHealResponse, fai
 			want = maxTrieRequestCount + maxCodeRequestCount
 		)
 		if have < want {
-			paths, hashes, codes := s.healer.scheduler.Missing(want - have)
-			for i, path := range paths {
-				s.healer.trieTasks[path] = hashes[i]
-			}
-			for _, hash := range codes {
-				s.healer.codeTasks[hash] = struct{}{}
-			}
+			s.fillHealTasks(want - have)
+		}
+		// If the current pass is done (nothing queued or in flight), raise the
+		// depth cutoff and schedule the deferred nodes for the next pass
+		if len(s.healer.trieTasks) == 0 && len(s.trienodeHealReqs) == 0 && !s.healer.deferred.Empty() {
+			s.startHealPass()
 		}
 		// If all the heal tasks are bytecodes or already downloading, bail
 		if len(s.healer.trieTasks) == 0 {
@@ -1404,8 +1810,9 @@ func (s *Syncer) assignTrienodeHealTasks(success chan *trienodeHealResponse, fai
 		}
 		// Task pending retrieval, try to find an idle peer. If no such peer
 		// exists, we probably assigned tasks for all (or they are stateless).
-		// Abort the entire assignment mechanism.
-		if len(idlers.ids) == 0 {
+		// Abort the entire assignment mechanism, same if too many requests are
+		// already in flight.
+		if len(idlers.ids) == 0 || s.requestsCapped() {
 			return
 		}
 		var (
@@ -1519,13 +1926,7 @@ func (s *Syncer) assignBytecodeHealTasks(success chan *bytecodeHealResponse, fai
 			want = maxTrieRequestCount + maxCodeRequestCount
 		)
 		if have < want {
-			paths, hashes, codes := s.healer.scheduler.Missing(want - have)
-			for i, path := range paths {
-				s.healer.trieTasks[path] = hashes[i]
-			}
-			for _, hash := range codes {
-				s.healer.codeTasks[hash] = struct{}{}
-			}
+			s.fillHealTasks(want - have)
 		}
 		// If all the heal tasks are trienodes or already downloading, bail
 		if len(s.healer.codeTasks) == 0 {
@@ -1533,8 +1934,9 @@ func (s *Syncer) assignBytecodeHealTasks(success chan *bytecodeHealResponse, fai
 		}
 		// Task pending retrieval, try to find an idle peer. If no such peer
 		// exists, we probably assigned tasks for all (or they are stateless).
-		// Abort the entire assignment mechanism.
-		if len(idlers.ids) == 0 {
+		// Abort the entire assignment mechanism, same if too many requests are
+		// already in flight.
+		if len(idlers.ids) == 0 || s.requestsCapped() {
 			return
 		}
 		var (
@@ -1557,8 +1959,8 @@ func (s *Syncer) assignBytecodeHealTasks(success chan *bytecodeHealResponse, fai
 			break
 		}
 		// Generate the network query and send it to the peer
-		if cap > maxCodeRequestCount {
-			cap = maxCodeRequestCount
+		if cap > s.config.BytecodeJobBatchSize {
+			cap = s.config.BytecodeJobBatchSize
 		}
 		hashes := make([]common.Hash, 0, cap)
 		for hash := range s.healer.codeTasks {
@@ -1890,6 +2292,9 @@ func (s *Syncer) processAccountResponse(res *accountResponse) {
 			break
 		}
 	}
+	s.accountDelivered.Add(uint64(len(res.hashes)))
+	s.statAccounts.Add(uint64(len(res.hashes)))
+
 	// Iterate over all the accounts and assemble which ones need further sub-
 	// filling before the entire account range can be persisted.
 	res.task.needCode = make([]bool, len(res.accounts))
@@ -2020,6 +2425,7 @@ func (s *Syncer) processBytecodeResponse(res *bytecodeResponse) {
 	}
 	s.bytecodeSynced += codes
 	s.bytecodeBytes += bytes
+	s.statBytecodes.Add(codes)
 
 	log.Debug("Persisted set of bytecodes", "count", codes, "bytes", bytes)
 
@@ -2281,6 +2687,8 @@ func (s *Syncer) processStorageResponse(res *storageResponse) {
 		}
 	}
 	s.storageSynced += uint64(slots)
+	s.slotDelivered.Add(uint64(slots))
+	s.statSlots.Add(uint64(slots))
 
 	log.Debug("Persisted set of storage slots", "accounts", len(res.hashes), "slots", slots, "bytes", s.storageBytes-oldStorageBytes)
 
@@ -2314,6 +2722,7 @@ func (s *Syncer) processTrienodeHealResponse(res *trienodeHealResponse) {
 		// Push the trie node into the state syncer
 		s.trienodeHealSynced++
 		s.trienodeHealBytes += common.StorageSize(len(node))
+		s.statHealed.Add(1)
 
 		err := s.healer.scheduler.ProcessNode(trie.NodeSyncResult{Path: res.paths[i], Data: node})
 		switch err {
@@ -2480,6 +2889,7 @@ func (s *Syncer) forwardAccountTask(task *accountTask) {
 		log.Crit("Failed to persist accounts", "err", err)
 	}
 	s.accountSynced += uint64(len(res.accounts))
+	s.trackAccountRate(time.Now())
 
 	// Task filling persisted, push it the chunk marker forward to the first
 	// account still missing data.
@@ -2530,6 +2940,8 @@ func (s *Syncer) OnAccounts(peer SyncPeer, id uint64, hashes []common.Hash, acco
 	for _, node := range proof {
 		size += common.StorageSize(len(node))
 	}
+	s.statBytes.Add(uint64(size))
+
 	logger := peer.Log().New("reqid", id)
 	logger.Trace("Delivering range of accounts", "hashes", len(hashes), "accounts", len(accounts), "proofs", len(proof), "bytes", size)
 
@@ -2582,7 +2994,19 @@ func (s *Syncer) OnAccounts(peer SyncPeer, id uint64, hashes []common.Hash, acco
 	root := s.root
 	s.lock.Unlock()
 
-	// Reconstruct a partial trie from the response and verify it
+	// Ensure the delivered accounts are within the requested bounds
+	packet := &AccountRangePacket{ID: id, Accounts: make([]*AccountData, len(hashes)), Proof: proof}
+	for i, hash := range hashes {
+		packet.Accounts[i] = &AccountData{Hash: hash}
+	}
+	if err := packet.Validate(&GetAccountRangePacket{ID: id, Root: root, Origin: req.origin, Limit: req.limit}); err != nil {
+		logger.Warn("Account range out of bounds", "err", err)
+		// Signal this request as failed, and ready for rescheduling
+		s.scheduleRevertAccountRequest(req)
+		return err
+	}
+	// Reconstruct a partial trie from the response and verify it. An empty range
+	// must come with a proof that no more accounts follow the origin
 	keys := make([][]byte, len(hashes))
 	for i, key := range hashes {
 		keys[i] = common.CopyBytes(key[:])
@@ -2640,6 +3064,8 @@ func (s *Syncer) onByteCodes(peer SyncPeer, id uint64, bytecodes [][]byte) error
 	for _, code := range bytecodes {
 		size += common.StorageSize(len(code))
 	}
+	s.statBytes.Add(uint64(size))
+
 	logger := peer.Log().New("reqid", id)
 	logger.Trace("Delivering set of bytecodes", "bytecodes", len(bytecodes), "bytes", size)
 
@@ -2753,6 +3179,8 @@ func (s *Syncer) OnStorage(peer SyncPeer, id uint64, hashes [][]common.Hash, slo
 	for _, node := range proof {
 		size += common.StorageSize(len(node))
 	}
+	s.statBytes.Add(uint64(size))
+
 	logger := peer.Log().New("reqid", id)
 	logger.Trace("Delivering ranges of storage slots", "accounts", len(hashes), "hashes", hashCount, "slots", slotCount, "proofs", len(proof), "size", size)
 
@@ -2818,8 +3246,6 @@ func (s *Syncer) OnStorage(peer SyncPeer, id uint64, hashes [][]common.Hash, slo
 	s.lock.Unlock()
 
 	// Reconstruct the partial tries from the response and verify them
-	var cont bool
-
 	// If a proof was attached while the response is empty, it indicates that the
 	// requested range specified with 'origin' is empty. Construct an empty state
 	// response locally to finalize the range.
@@ -2827,6 +3253,37 @@ func (s *Syncer) OnStorage(peer SyncPeer, id uint64, hashes [][]common.Hash, slo
 		hashes = append(hashes, []common.Hash{})
 		slots = append(slots, [][]byte{})
 	}
+	cont, err := verifyStorageRanges(req.roots, req.origin, hashes, slots, proof)
+	if err != nil {
+		s.scheduleRevertStorageRequest(req) // reschedule request
+		logger.Warn("Storage slots failed proof", "err", err)
+		return err
+	}
+	// Partial tries reconstructed, send them to the scheduler for storage filling
+	response := &storageResponse{
+		mainTask: req.mainTask,
+		subTask:  req.subTask,
+		accounts: req.accounts,
+		roots:    req.roots,
+		hashes:   hashes,
+		slots:    slots,
+		cont:     cont,
+	}
+	select {
+	case req.deliver <- response:
+	case <-req.cancel:
+	case <-req.stale:
+	}
+	return nil
+}
+
+// verifyStorageRanges reconstructs the partial storage tries from the ranges of a
+// storage response and verifies them against the storage roots of the requested
+// accounts. Only the last range may be partial, in which case it starts at the
+// given origin and is proven by the attached edge proof. The returned flag tells
+// whether there are more slots after the last range.
+func verifyStorageRanges(roots []common.Hash, origin common.Hash, hashes [][]common.Hash, slots [][][]byte, proof [][]byte) (bool, error) {
+	var cont bool
 	for i := 0; i < len(hashes); i++ {
 		// Convert the keys and proofs into an internal format
 		keys := make([][]byte, len(hashes[i]))
@@ -2843,41 +3300,18 @@ func (s *Syncer) OnStorage(peer SyncPeer, id uint64, hashes [][]common.Hash, slo
 		if len(nodes) == 0 {
 			// No proof has been attached, the response must cover the entire key
 			// space and hash to the origin root.
-			_, err = trie.VerifyRangeProof(req.roots[i], nil, keys, slots[i], nil)
-			if err != nil {
-				s.scheduleRevertStorageRequest(req) // reschedule request
-				logger.Warn("Storage slots failed proof", "err", err)
-				return err
+			if _, err = trie.VerifyRangeProof(roots[i], nil, keys, slots[i], nil); err != nil {
+				return false, fmt.Errorf("%w: account #%d: %v", ErrInvalidProof, i, err)
 			}
 		} else {
 			// A proof was attached, the response is only partial, check that the
 			// returned data is indeed part of the storage trie
-			proofdb := nodes.Set()
-
-			cont, err = trie.VerifyRangeProof(req.roots[i], req.origin[:], keys, slots[i], proofdb)
-			if err != nil {
-				s.scheduleRevertStorageRequest(req) // reschedule request
-				logger.Warn("Storage range failed proof", "err", err)
-				return err
+			if cont, err = trie.VerifyRangeProof(roots[i], origin[:], keys, slots[i], nodes.Set()); err != nil {
+				return false, fmt.Errorf("%w: account #%d range: %v", ErrInvalidProof, i, err)
 			}
 		}
 	}
-	// Partial tries reconstructed, send them to the scheduler for storage filling
-	response := &storageResponse{
-		mainTask: req.mainTask,
-		subTask:  req.subTask,
-		accounts: req.accounts,
-		roots:    req.roots,
-		hashes:   hashes,
-		slots:    slots,
-		cont:     cont,
-	}
-	select {
-	case req.deliver <- response:
-	case <-req.cancel:
-	case <-req.stale:
-	}
-	return nil
+	return cont, nil
 }
 
 // OnTrieNodes is a callback method to invoke when a batch of trie nodes
@@ -2887,6 +3321,8 @@ func (s *Syncer) OnTrieNodes(peer SyncPeer, id uint64, trienodes [][]byte) error
 	for _, node := range trienodes {
 		size += common.StorageSize(len(node))
 	}
+	s.statBytes.Add(uint64(size))
+
 	logger := peer.Log().New("reqid", id)
 	logger.Trace("Delivering set of healing trienodes", "trienodes", len(trienodes), "bytes", size)
 
@@ -2994,6 +3430,8 @@ func (s *Syncer) onHealByteCodes(peer SyncPeer, id uint64, bytecodes [][]byte) e
 	for _, code := range bytecodes {
 		size += common.StorageSize(len(code))
 	}
+	s.statBytes.Add(uint64(size))
+
 	logger := peer.Log().New("reqid", id)
 	logger.Trace("Delivering set of healing bytecodes", "bytecodes", len(bytecodes), "bytes", size)
 
@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	mrand "math/rand"
@@ -29,6 +31,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -127,7 +130,7 @@ type (
 
 type testPeer struct {
 	id            string
-	test          *testing.T
+	test          testing.TB
 	remote        *Syncer
 	logger        log.Logger
 	accountTrie   *trie.Trie
@@ -148,7 +151,7 @@ type testPeer struct {
 	nTrienodeRequests int
 }
 
-func newTestPeer(id string, t *testing.T, term func()) *testPeer {
+func newTestPeer(id string, t testing.TB, term func()) *testPeer {
 	peer := &testPeer{
 		id:                    id,
 		test:                  t,
@@ -528,6 +531,34 @@ func corruptAccountRequestHandler(t *testPeer, requestId uint64, root common.Has
 	return nil
 }
 
+// emptyRangeAccountRequestHandler is a handler which claims that there are no
+// accounts in the requested range, but backs it up with an edge proof of the
+// origin only, which does not prove the absence of the remaining accounts.
+func emptyRangeAccountRequestHandler(t *testPeer, requestId uint64, root common.Hash, origin common.Hash, limit common.Hash, cap uint64) error {
+	proof := trienode.NewProofSet()
+	if err := t.accountTrie.Prove(origin[:], proof); err != nil {
+		t.logger.Error("Could not prove origin", "origin", origin, "error", err)
+	}
+	if err := t.remote.OnAccounts(t, requestId, nil, nil, proof.List()); err != nil {
+		t.logger.Info("remote error on delivery (as expected)", "error", err)
+		// Mimic the real-life handler, which drops a peer on errors
+		t.remote.Unregister(t.id)
+	}
+	return nil
+}
+
+// overreachingAccountRequestHandler ignores the requested limit, delivering a
+// properly proven range extending until the end of the trie
+func overreachingAccountRequestHandler(t *testPeer, requestId uint64, root common.Hash, origin common.Hash, limit common.Hash, cap uint64) error {
+	hashes, accounts, proofs := createAccountRequestResponse(t, root, origin, common.MaxHash, cap)
+	if err := t.remote.OnAccounts(t, requestId, hashes, accounts, proofs); err != nil {
+		t.logger.Info("remote error on delivery (as expected)", "error", err)
+		// Mimic the real-life handler, which drops a peer on errors
+		t.remote.Unregister(t.id)
+	}
+	return nil
+}
+
 // corruptStorageRequestHandler doesn't provide good proofs
 func corruptStorageRequestHandler(t *testPeer, requestId uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, max uint64) error {
 	hashes, slots, proofs := createStorageRequestResponse(t, root, accounts, origin, limit, max)
@@ -774,6 +805,71 @@ func testSyncWithStorage(t *testing.T, scheme string) {
 	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
 }
 
+// TestSyncWithGenesisPreload tests that contract codes preloaded from the genesis
+// are not retrieved from the remote peers.
+func TestSyncWithGenesisPreload(t *testing.T) {
+	t.Parallel()
+
+	testSyncWithGenesisPreload(t, rawdb.HashScheme)
+	testSyncWithGenesisPreload(t, rawdb.PathScheme)
+}
+
+func testSyncWithGenesisPreload(t *testing.T, scheme string) {
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	sourceAccountTrie, elems, storageTries, storageElems := makeAccountTrieWithStorage(scheme, 10, 100, true, false, false)
+
+	// Allocate all the contract codes used by the source state in the genesis
+	gspec := &core.Genesis{Alloc: make(types.GenesisAlloc)}
+	for i, hash := range codehashes {
+		gspec.Alloc[common.BytesToAddress([]byte{byte(i + 1)})] = types.Account{
+			Code:    getCodeByHash(hash),
+			Balance: big.NewInt(0),
+		}
+	}
+	var (
+		lock    sync.Mutex
+		fetched []common.Hash
+	)
+	source := newTestPeer("sourceA", t, term)
+	source.accountTrie = sourceAccountTrie.Copy()
+	source.accountValues = elems
+	source.setStorageTries(storageTries)
+	source.storageValues = storageElems
+	source.codeRequestHandler = func(t *testPeer, id uint64, hashes []common.Hash, max uint64) error {
+		lock.Lock()
+		fetched = append(fetched, hashes...)
+		lock.Unlock()
+		return defaultCodeRequestHandler(t, id, hashes, max)
+	}
+	syncer := setupSyncer(scheme, source)
+	if err := syncer.PreloadGenesisState(gspec); err != nil {
+		t.Fatalf("failed to preload genesis: %v", err)
+	}
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
+
+	if len(fetched) != 0 {
+		t.Errorf("preloaded codes retrieved from peer: %v", fetched)
+	}
+	for _, hash := range codehashes {
+		if !rawdb.HasCodeWithPrefix(syncer.db, hash) {
+			t.Errorf("genesis code %x missing", hash)
+		}
+	}
+}
+
 // TestMultiSyncManyUseless contains one good peer, and many which doesn't return anything valuable at all
 func TestMultiSyncManyUseless(t *testing.T) {
 	t.Parallel()
@@ -1133,6 +1229,103 @@ func testSyncNoStorageAndOneAccountCorruptPeer(t *testing.T, scheme string) {
 	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
 }
 
+// TestSyncNoStorageAndOneOverreachingPeer has one peer which delivers accounts
+// beyond the requested range, which must be detected and the peer dropped
+func TestSyncNoStorageAndOneOverreachingPeer(t *testing.T) {
+	t.Parallel()
+
+	testSyncNoStorageAndOneOverreachingPeer(t, rawdb.HashScheme)
+	testSyncNoStorageAndOneOverreachingPeer(t, rawdb.PathScheme)
+}
+
+func testSyncNoStorageAndOneOverreachingPeer(t *testing.T, scheme string) {
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	nodeScheme, sourceAccountTrie, elems := makeAccountTrieNoStorage(3000, scheme)
+
+	mkSource := func(name string, accFn accountHandlerFunc) *testPeer {
+		source := newTestPeer(name, t, term)
+		source.accountTrie = sourceAccountTrie.Copy()
+		source.accountValues = elems
+		source.accountRequestHandler = accFn
+		return source
+	}
+	syncer := setupSyncer(
+		nodeScheme,
+		mkSource("nice", defaultAccountRequestHandler),
+		mkSource("overreach", overreachingAccountRequestHandler),
+	)
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
+
+	syncer.lock.RLock()
+	_, ok := syncer.peers["overreach"]
+	syncer.lock.RUnlock()
+	if ok {
+		t.Fatalf("peer delivering out of range accounts not dropped")
+	}
+}
+
+// TestSyncNoStorageAndOneEmptyRangePeer tests sync using accounts and no storage,
+// where one peer claims account ranges are empty, with a proof that doesn't
+// back the claim up
+func TestSyncNoStorageAndOneEmptyRangePeer(t *testing.T) {
+	t.Parallel()
+
+	testSyncNoStorageAndOneEmptyRangePeer(t, rawdb.HashScheme)
+	testSyncNoStorageAndOneEmptyRangePeer(t, rawdb.PathScheme)
+}
+
+func testSyncNoStorageAndOneEmptyRangePeer(t *testing.T, scheme string) {
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	nodeScheme, sourceAccountTrie, elems := makeAccountTrieNoStorage(3000, scheme)
+
+	mkSource := func(name string, accFn accountHandlerFunc) *testPeer {
+		source := newTestPeer(name, t, term)
+		source.accountTrie = sourceAccountTrie.Copy()
+		source.accountValues = elems
+		source.accountRequestHandler = accFn
+		return source
+	}
+	syncer := setupSyncer(
+		nodeScheme,
+		mkSource("nice", defaultAccountRequestHandler),
+		mkSource("empty", emptyRangeAccountRequestHandler),
+	)
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
+
+	syncer.lock.RLock()
+	_, ok := syncer.peers["empty"]
+	syncer.lock.RUnlock()
+	if ok {
+		t.Fatalf("peer delivering unproven empty range not dropped")
+	}
+}
+
 // TestSyncNoStorageAndOneCodeCappedPeer has one peer which delivers code hashes
 // one by one
 func TestSyncNoStorageAndOneCodeCappedPeer(t *testing.T) {
@@ -1958,9 +2151,777 @@ func TestSlotEstimation(t *testing.T) {
 	}
 }
 
+// TestSyncPeerUtilisationCap tests that a peer much faster than the others does
+// not end up serving the majority of the account range requests.
+func TestSyncPeerUtilisationCap(t *testing.T) {
+	t.Parallel()
+
+	testSyncPeerUtilisationCap(t, rawdb.HashScheme)
+	testSyncPeerUtilisationCap(t, rawdb.PathScheme)
+}
+
+func testSyncPeerUtilisationCap(t *testing.T, scheme string) {
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	nodeScheme, sourceAccountTrie, elems := makeAccountTrieNoStorage(500, scheme)
+
+	mkSource := func(name string, delay time.Duration) *testPeer {
+		source := newTestPeer(name, t, term)
+		source.accountTrie = sourceAccountTrie.Copy()
+		source.accountValues = elems
+		source.accountRequestHandler = func(t *testPeer, id uint64, root common.Hash, origin common.Hash, limit common.Hash, cap uint64) error {
+			time.Sleep(delay)
+			return starvingAccountRequestHandler(t, id, root, origin, limit, cap)
+		}
+		return source
+	}
+	peers := []*testPeer{
+		mkSource("fast", 0),
+		mkSource("slow-a", 20*time.Millisecond),
+		mkSource("slow-b", 20*time.Millisecond),
+	}
+	syncer := NewSyncerWithConfig(rawdb.NewMemoryDatabase(), nodeScheme, SyncConfig{PeerUtilisationCap: 0.5})
+	for _, peer := range peers {
+		syncer.Register(peer)
+		peer.remote = syncer
+	}
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
+
+	var total int
+	for _, peer := range peers {
+		total += peer.nAccountRequests
+	}
+	if total < 100 {
+		t.Fatalf("too few account requests to check utilisation: %d", total)
+	}
+	for _, peer := range peers {
+		if share := float64(peer.nAccountRequests) / float64(total); share > 0.6 {
+			t.Errorf("peer %s served too many requests: %d/%d", peer.id, peer.nAccountRequests, total)
+		}
+	}
+}
+
+// TestSyncMaxConcurrentRequests tests that the number of requests in flight across
+// all the peers and retrieval types never exceeds the configured cap.
+func TestSyncMaxConcurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	testSyncMaxConcurrentRequests(t, rawdb.HashScheme)
+	testSyncMaxConcurrentRequests(t, rawdb.PathScheme)
+}
+
+func testSyncMaxConcurrentRequests(t *testing.T, scheme string) {
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	sourceAccountTrie, elems, storageTries, storageElems := makeAccountTrieWithStorage(scheme, 50, 100, true, false, false)
+
+	// Track the number of requests in flight from the syncer's point of view,
+	// whenever a peer is about to serve one
+	var (
+		lock    sync.Mutex
+		maxSeen int
+	)
+	track := func(t *testPeer) {
+		t.remote.lock.RLock()
+		pending := len(t.remote.accountReqs) + len(t.remote.storageReqs) + len(t.remote.bytecodeReqs) + len(t.remote.trienodeHealReqs) + len(t.remote.bytecodeHealReqs)
+		t.remote.lock.RUnlock()
+
+		lock.Lock()
+		maxSeen = max(maxSeen, pending)
+		lock.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+	}
+	mkSource := func(name string) *testPeer {
+		source := newTestPeer(name, t, term)
+		source.accountTrie = sourceAccountTrie.Copy()
+		source.accountValues = elems
+		source.setStorageTries(storageTries)
+		source.storageValues = storageElems
+		source.accountRequestHandler = func(t *testPeer, id uint64, root common.Hash, origin common.Hash, limit common.Hash, cap uint64) error {
+			track(t)
+			return defaultAccountRequestHandler(t, id, root, origin, limit, cap)
+		}
+		source.storageRequestHandler = func(t *testPeer, id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, max uint64) error {
+			track(t)
+			return defaultStorageRequestHandler(t, id, root, accounts, origin, limit, max)
+		}
+		source.codeRequestHandler = func(t *testPeer, id uint64, hashes []common.Hash, max uint64) error {
+			track(t)
+			return defaultCodeRequestHandler(t, id, hashes, max)
+		}
+		source.trieRequestHandler = func(t *testPeer, id uint64, root common.Hash, paths []TrieNodePathSet, cap uint64) error {
+			track(t)
+			return defaultTrieRequestHandler(t, id, root, paths, cap)
+		}
+		return source
+	}
+	syncer := NewSyncerWithConfig(rawdb.NewMemoryDatabase(), scheme, SyncConfig{MaxConcurrentRequests: 2})
+	for _, name := range []string{"peer-a", "peer-b", "peer-c", "peer-d"} {
+		peer := mkSource(name)
+		syncer.Register(peer)
+		peer.remote = syncer
+	}
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
+
+	if maxSeen == 0 {
+		t.Fatalf("no requests tracked")
+	}
+	if maxSeen > 2 {
+		t.Errorf("too many requests in flight: have %d, want at most %d", maxSeen, 2)
+	}
+}
+
+// TestSyncHealingDepthLimit tests that healing with a depth limit defers deeper
+// trie nodes to later passes, but still converges to the complete trie.
+func TestSyncHealingDepthLimit(t *testing.T) {
+	t.Parallel()
+
+	testSyncHealingDepthLimit(t, rawdb.HashScheme)
+	testSyncHealingDepthLimit(t, rawdb.PathScheme)
+}
+
+func testSyncHealingDepthLimit(t *testing.T, scheme string) {
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	// Create an account trie with hashed keys, deep enough to need multiple passes
+	var (
+		db      = triedb.NewDatabase(rawdb.NewMemoryDatabase(), newDbConfig(scheme))
+		accTrie = trie.NewEmpty(db)
+	)
+	for i := uint64(1); i <= 2000; i++ {
+		value, _ := rlp.EncodeToBytes(&types.StateAccount{
+			Nonce:    i,
+			Balance:  uint256.NewInt(i),
+			Root:     types.EmptyRootHash,
+			CodeHash: types.EmptyCodeHash[:],
+		})
+		accTrie.MustUpdate(crypto.Keccak256(key32(i)), value)
+	}
+	root, nodes := accTrie.Commit(false)
+	db.Update(root, types.EmptyRootHash, 0, trienode.NewWithNodeSet(nodes), triedb.NewStateSet())
+	accTrie, _ = trie.New(trie.StateTrieID(root), db)
+
+	var depth int
+	for it := accTrie.MustNodeIterator(nil); it.Next(true); {
+		if it.Hash() != (common.Hash{}) {
+			depth = max(depth, len(it.Path()))
+		}
+	}
+	if depth < 5 {
+		t.Fatalf("test trie too shallow: have %d, want at least 5", depth)
+	}
+	// Track the depths of the requested trie nodes, ensuring no node is requested
+	// deeper than the limit until all the shallower ones were requested
+	var (
+		lock      sync.Mutex
+		deepest   int
+		violation bool
+	)
+	source := newTestPeer("source", t, term)
+	source.accountTrie = accTrie.Copy()
+	source.trieRequestHandler = func(t *testPeer, requestId uint64, root common.Hash, paths []TrieNodePathSet, cap uint64) error {
+		lock.Lock()
+		for _, pathset := range paths {
+			compact := pathset[0]
+			depth := 2*len(compact) - 2
+			if len(compact) > 0 && compact[0]&0x10 != 0 {
+				depth++ // Odd length path
+			}
+			if deepest > 3 && depth <= 3 {
+				violation = true
+			}
+			deepest = max(deepest, depth)
+		}
+		lock.Unlock()
+		return defaultTrieRequestHandler(t, requestId, root, paths, cap)
+	}
+	// Skip the snap phase altogether, forcing the entire trie to be healed
+	stateDb := rawdb.NewMemoryDatabase()
+	status, _ := json.Marshal(new(SyncProgress))
+	rawdb.WriteSnapshotSyncStatus(stateDb, status)
+
+	syncer := NewSyncerWithConfig(stateDb, scheme, SyncConfig{MaxHealingTrieDepth: 3})
+	syncer.Register(source)
+	source.remote = syncer
+
+	done := checkStall(t, term)
+	if err := syncer.Sync(root, cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+	verifyTrie(scheme, syncer.db, root, t)
+
+	if violation {
+		t.Errorf("shallow trie node requested after deeper pass started")
+	}
+	if deepest != depth {
+		t.Errorf("deepest healed node mismatch: have %d, want %d", deepest, depth)
+	}
+}
+
+// TestSyncHealingBreadthFirst tests that healing the trie level by level still
+// converges to the complete trie.
+func TestSyncHealingBreadthFirst(t *testing.T) {
+	t.Parallel()
+
+	testSyncHealingBreadthFirst(t, rawdb.HashScheme)
+	testSyncHealingBreadthFirst(t, rawdb.PathScheme)
+}
+
+func testSyncHealingBreadthFirst(t *testing.T, scheme string) {
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	nodeScheme, sourceAccountTrie, elems := makeAccountTrieNoStorage(2000, scheme)
+
+	source := newTestPeer("source", t, term)
+	source.accountTrie = sourceAccountTrie.Copy()
+	source.accountValues = elems
+
+	// Skip the snap phase altogether, forcing the entire trie to be healed
+	stateDb := rawdb.NewMemoryDatabase()
+	status, _ := json.Marshal(new(SyncProgress))
+	rawdb.WriteSnapshotSyncStatus(stateDb, status)
+
+	syncer := NewSyncerWithConfig(stateDb, nodeScheme, SyncConfig{TrieHealStrategy: BreadthFirst})
+	syncer.Register(source)
+	source.remote = syncer
+
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
+}
+
 func newDbConfig(scheme string) *triedb.Config {
 	if scheme == rawdb.HashScheme {
 		return &triedb.Config{}
 	}
 	return &triedb.Config{PathDB: pathdb.Defaults}
 }
+
+// Tests that storage range responses are validated against the storage roots of
+// the requested accounts, both when covering entire tries and partial ones.
+func TestStorageRangesPacketValidate(t *testing.T) {
+	t.Parallel()
+
+	_, _, storageTries, storageEntries := makeAccountTrieWithStorage(rawdb.HashScheme, 3, 20, false, false, false)
+
+	var (
+		req   = &GetStorageRangesPacket{Accounts: []common.Hash{common.BytesToHash(key32(1)), common.BytesToHash(key32(2)), common.BytesToHash(key32(3))}}
+		roots = make(map[common.Hash]common.Hash)
+	)
+	for _, account := range req.Accounts {
+		roots[account] = storageTries[account].Hash()
+	}
+	makePacket := func() *StorageRangesPacket {
+		packet := &StorageRangesPacket{Slots: make([][]*StorageData, len(req.Accounts))}
+		for i, account := range req.Accounts {
+			entries := storageEntries[account]
+			if i == len(req.Accounts)-1 {
+				entries = entries[:len(entries)/2] // Last range is partial
+			}
+			for _, entry := range entries {
+				packet.Slots[i] = append(packet.Slots[i], &StorageData{Hash: common.BytesToHash(entry.k), Body: common.CopyBytes(entry.v)})
+			}
+		}
+		last := req.Accounts[len(req.Accounts)-1]
+		proof := trienode.NewProofSet()
+		if err := storageTries[last].Prove(common.Hash{}.Bytes(), proof); err != nil {
+			t.Fatalf("failed to prove origin: %v", err)
+		}
+		slots := packet.Slots[len(packet.Slots)-1]
+		if err := storageTries[last].Prove(slots[len(slots)-1].Hash[:], proof); err != nil {
+			t.Fatalf("failed to prove last slot: %v", err)
+		}
+		packet.Proof = proof.List()
+		return packet
+	}
+	if err := makePacket().Validate(req, roots); err != nil {
+		t.Fatalf("valid response rejected: %v", err)
+	}
+	// Tamper with a slot of a complete and of the partial range
+	for _, account := range []int{0, len(req.Accounts) - 1} {
+		packet := makePacket()
+		packet.Slots[account][1].Body = []byte{0xde, 0xad}
+		if err := packet.Validate(req, roots); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("account #%d: tampered response error mismatch: have %v, want %v", account, err, ErrInvalidProof)
+		}
+	}
+	// Ensure a proof delivered for a request without accounts is rejected
+	if err := makePacket().Validate(&GetStorageRangesPacket{}, roots); err == nil {
+		t.Errorf("response to empty request accepted")
+	}
+	if err := (&StorageRangesPacket{Proof: makePacket().Proof}).Validate(&GetStorageRangesPacket{}, roots); err == nil {
+		t.Errorf("proof for empty request accepted")
+	}
+}
+
+// Tests that the account sync throughput is averaged over the rate window and
+// that the remaining time is extrapolated from the covered account hash space.
+func TestSyncAccountThroughput(t *testing.T) {
+	t.Parallel()
+
+	syncer := NewSyncer(rawdb.NewMemoryDatabase(), rawdb.HashScheme)
+	if eta := syncer.AccountETA(); eta != -1 {
+		t.Fatalf("pristine ETA mismatch: have %v, want -1", eta)
+	}
+	// Sync the first quarter of the account hash space slowly, outside the rate
+	// window, and then half of it within the window
+	var (
+		now     = time.Now()
+		quarter = new(big.Int).Rsh(hashSpace, 2)
+	)
+	task := &accountTask{Next: common.BigToHash(quarter), Last: common.MaxHash}
+	syncer.tasks = []*accountTask{task}
+
+	syncer.accountSynced = 50
+	syncer.trackAccountRate(now.Add(-2 * accountRateWindow))
+
+	syncer.accountSynced = 100
+	syncer.trackAccountRate(now.Add(-10 * time.Second))
+
+	task.Next = common.BigToHash(new(big.Int).Mul(quarter, big.NewInt(3)))
+	syncer.accountSynced = 150
+	syncer.trackAccountRate(now)
+
+	if rate := syncer.AccountThroughput(); rate != 5 {
+		t.Errorf("throughput mismatch: have %v, want 5", rate)
+	}
+	// A quarter of the hash space is left, estimated at 50 accounts at 5/sec
+	if eta := syncer.AccountETA(); eta < 9*time.Second || eta > 10*time.Second {
+		t.Errorf("ETA mismatch: have %v, want ~10s", eta)
+	}
+}
+
+// Tests that the delivered account and storage slot counters track the processed
+// responses of the current sync cycle, and reset when a new one starts.
+func TestSyncDeliveredCounts(t *testing.T) {
+	t.Parallel()
+
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	sourceAccountTrie, elems, storageTries, storageElems := makeAccountTrieWithStorage(rawdb.HashScheme, 100, 10, false, false, false)
+
+	source := newTestPeer("source", t, term)
+	source.accountTrie = sourceAccountTrie.Copy()
+	source.accountValues = elems
+	source.setStorageTries(storageTries)
+	source.storageValues = storageElems
+
+	syncer := setupSyncer(rawdb.HashScheme, source)
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+
+	if have := syncer.AccountCount(); have != 100 {
+		t.Errorf("account count mismatch: have %d, want %d", have, 100)
+	}
+	if have := syncer.StorageSlotCount(); have != 100*10 {
+		t.Errorf("storage slot count mismatch: have %d, want %d", have, 100*10)
+	}
+	// Ensure a new cycle resets the counters, the state being already synced
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("resync failed: %v", err)
+	}
+	if have := syncer.AccountCount(); have != 0 {
+		t.Errorf("account count not reset: have %d", have)
+	}
+	if have := syncer.StorageSlotCount(); have != 0 {
+		t.Errorf("storage slot count not reset: have %d", have)
+	}
+}
+
+// Tests that the aggregated sync metrics account for everything processed and
+// downloaded, and that they are kept across sync cycles.
+func TestSyncStats(t *testing.T) {
+	t.Parallel()
+
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+	)
+	sourceAccountTrie, elems, storageTries, storageElems := makeAccountTrieWithStorage(rawdb.HashScheme, 100, 10, true, false, false)
+
+	source := newTestPeer("source", t, term)
+	source.accountTrie = sourceAccountTrie.Copy()
+	source.accountValues = elems
+	source.setStorageTries(storageTries)
+	source.storageValues = storageElems
+
+	syncer := setupSyncer(rawdb.HashScheme, source)
+	if stat := syncer.Stats(); stat != (SyncStat{}) {
+		t.Fatalf("stats not empty before sync: %+v", stat)
+	}
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+
+	stat := syncer.Stats()
+	if stat.AccountsProcessed != 100 {
+		t.Errorf("processed account mismatch: have %d, want %d", stat.AccountsProcessed, 100)
+	}
+	if stat.StorageSlotsProcessed != 100*10 {
+		t.Errorf("processed storage slot mismatch: have %d, want %d", stat.StorageSlotsProcessed, 100*10)
+	}
+	if stat.BytecodesProcessed == 0 {
+		t.Error("no bytecodes processed")
+	}
+	if stat.BytesDownloaded == 0 {
+		t.Error("no bytes downloaded")
+	}
+	if stat.StartTime.IsZero() || stat.ElapsedTime <= 0 {
+		t.Errorf("sync timing not tracked: start %v, elapsed %v", stat.StartTime, stat.ElapsedTime)
+	}
+	// Ensure a new cycle keeps the aggregated metrics, the state being already synced
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("resync failed: %v", err)
+	}
+	if have := syncer.Stats(); have.AccountsProcessed != stat.AccountsProcessed || have.StartTime != stat.StartTime {
+		t.Errorf("stats not kept across cycles: have %+v, want %+v", have, stat)
+	}
+}
+
+// makeAccountTrieWithCodes constructs an account trie with a contract for each
+// of the given bytecodes.
+func makeAccountTrieWithCodes(scheme string, codes [][]byte) (string, *trie.Trie, []*kv) {
+	var (
+		db      = triedb.NewDatabase(rawdb.NewMemoryDatabase(), newDbConfig(scheme))
+		accTrie = trie.NewEmpty(db)
+		entries []*kv
+	)
+	for i, code := range codes {
+		value, _ := rlp.EncodeToBytes(&types.StateAccount{
+			Nonce:    uint64(i),
+			Balance:  uint256.NewInt(uint64(i)),
+			Root:     types.EmptyRootHash,
+			CodeHash: crypto.Keccak256(code),
+		})
+		elem := &kv{key32(uint64(i + 1)), value}
+		accTrie.MustUpdate(elem.k, elem.v)
+		entries = append(entries, elem)
+	}
+	slices.SortFunc(entries, (*kv).cmp)
+
+	root, nodes := accTrie.Commit(false)
+	db.Update(root, types.EmptyRootHash, 0, trienode.NewWithNodeSet(nodes), triedb.NewStateSet())
+
+	accTrie, _ = trie.New(trie.StateTrieID(root), db)
+	return db.Scheme(), accTrie, entries
+}
+
+// makeContractCodes generates n unique bytecodes, sized like the contracts seen
+// on BSC: mostly small proxies and tokens, some larger dapps and a few close to
+// the size limit.
+func makeContractCodes(n int) [][]byte {
+	rng := mrand.New(mrand.NewSource(1))
+
+	codes := make([][]byte, n)
+	for i := range codes {
+		var size int
+		switch p := rng.Intn(100); {
+		case p < 60:
+			size = 100 + rng.Intn(1900)
+		case p < 90:
+			size = 2048 + rng.Intn(6*1024)
+		default:
+			size = 16*1024 + rng.Intn(8*1024)
+		}
+		codes[i] = make([]byte, size)
+		binary.BigEndian.PutUint64(codes[i], uint64(i))
+	}
+	return codes
+}
+
+// codeServer creates a bytecode request handler serving the given codes up to
+// the requested byte limit, after sleeping for the given latency.
+func codeServer(codes [][]byte, latency time.Duration, track func(hashes []common.Hash)) codeHandlerFunc {
+	lookup := make(map[common.Hash][]byte, len(codes))
+	for _, code := range codes {
+		lookup[crypto.Keccak256Hash(code)] = code
+	}
+	return func(t *testPeer, id uint64, hashes []common.Hash, max uint64) error {
+		if track != nil {
+			track(hashes)
+		}
+		time.Sleep(latency)
+
+		var (
+			served [][]byte
+			bytes  uint64
+		)
+		for _, hash := range hashes {
+			served = append(served, lookup[hash])
+			if bytes += uint64(len(lookup[hash])); bytes >= max {
+				break
+			}
+		}
+		if err := t.remote.OnByteCodes(t, id, served); err != nil {
+			t.test.Errorf("Remote side rejected our delivery: %v", err)
+			t.term()
+		}
+		return nil
+	}
+}
+
+// TestSyncBytecodeJobBatchSize tests that bytecode requests never bundle more
+// hashes than the configured batch size.
+func TestSyncBytecodeJobBatchSize(t *testing.T) {
+	t.Parallel()
+
+	testSyncBytecodeJobBatchSize(t, rawdb.HashScheme)
+	testSyncBytecodeJobBatchSize(t, rawdb.PathScheme)
+}
+
+func testSyncBytecodeJobBatchSize(t *testing.T, scheme string) {
+	var (
+		once   sync.Once
+		cancel = make(chan struct{})
+		term   = func() {
+			once.Do(func() {
+				close(cancel)
+			})
+		}
+		codes = makeContractCodes(200)
+
+		lock     sync.Mutex
+		requests int
+		maxSeen  int
+	)
+	nodeScheme, sourceAccountTrie, elems := makeAccountTrieWithCodes(scheme, codes)
+
+	track := func(hashes []common.Hash) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		requests++
+		maxSeen = max(maxSeen, len(hashes))
+	}
+	syncer := NewSyncerWithConfig(rawdb.NewMemoryDatabase(), nodeScheme, SyncConfig{BytecodeJobBatchSize: 8})
+	for _, name := range []string{"peer-a", "peer-b"} {
+		source := newTestPeer(name, t, term)
+		source.accountTrie = sourceAccountTrie.Copy()
+		source.accountValues = elems
+		source.codeRequestHandler = codeServer(codes, 0, track)
+
+		syncer.Register(source)
+		source.remote = syncer
+	}
+	done := checkStall(t, term)
+	if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	close(done)
+	verifyTrie(scheme, syncer.db, sourceAccountTrie.Hash(), t)
+
+	if requests < len(codes)/8 {
+		t.Errorf("too few bytecode requests: have %d, want at least %d", requests, len(codes)/8)
+	}
+	if maxSeen > 8 {
+		t.Errorf("bytecode request too large: have %d hashes, want at most %d", maxSeen, 8)
+	}
+}
+
+// Tests that non-positive bytecode batch sizes fall back to the default instead
+// of producing invalid requests.
+func TestSyncBytecodeJobBatchSizeDefault(t *testing.T) {
+	for _, size := range []int{0, -1, -100} {
+		syncer := NewSyncerWithConfig(rawdb.NewMemoryDatabase(), rawdb.HashScheme, SyncConfig{BytecodeJobBatchSize: size})
+		if have := syncer.config.BytecodeJobBatchSize; have != maxCodeRequestCount {
+			t.Errorf("batch size %d: have %d, want %d", size, have, maxCodeRequestCount)
+		}
+	}
+}
+
+// BenchmarkSyncBytecodeJobBatchSize measures the time needed to snap sync a
+// state of contracts sized like on BSC, using different bytecode batch sizes
+// and a 10ms round trip to the peers.
+func BenchmarkSyncBytecodeJobBatchSize(b *testing.B) {
+	codes := makeContractCodes(4000)
+	nodeScheme, sourceAccountTrie, elems := makeAccountTrieWithCodes(rawdb.HashScheme, codes)
+
+	for _, size := range []int{16, 32, 64, maxCodeRequestCount, 128, 256} {
+		b.Run(fmt.Sprintf("batch-%d", size), func(b *testing.B) {
+			var (
+				lock     sync.Mutex
+				requests int
+			)
+			track := func([]common.Hash) {
+				lock.Lock()
+				requests++
+				lock.Unlock()
+			}
+			for i := 0; i < b.N; i++ {
+				var (
+					once   sync.Once
+					cancel = make(chan struct{})
+					term   = func() {
+						once.Do(func() {
+							close(cancel)
+						})
+					}
+				)
+				syncer := NewSyncerWithConfig(rawdb.NewMemoryDatabase(), nodeScheme, SyncConfig{BytecodeJobBatchSize: size})
+				for _, name := range []string{"peer-a", "peer-b", "peer-c", "peer-d"} {
+					source := newTestPeer(name, b, term)
+					source.accountTrie = sourceAccountTrie.Copy()
+					source.accountValues = elems
+					source.codeRequestHandler = codeServer(codes, 10*time.Millisecond, track)
+
+					syncer.Register(source)
+					source.remote = syncer
+				}
+				if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+					b.Fatalf("sync failed: %v", err)
+				}
+			}
+			b.ReportMetric(float64(requests)/float64(b.N), "requests/op")
+		})
+	}
+}
+
+// Tests that the account range is split into the configured number of chunks,
+// and that a sync still completes correctly with any of them.
+func TestSyncAccountConcurrency(t *testing.T) {
+	t.Parallel()
+
+	nodeScheme, sourceAccountTrie, elems := makeAccountTrieNoStorage(1000, rawdb.HashScheme)
+
+	for _, n := range []int{1, 3, 16, 17} {
+		// Ensure the chunks are contiguous and cover the entire account range
+		syncer := setupSyncer(nodeScheme)
+		syncer.SetAccountConcurrency(n)
+		syncer.loadSyncStatus()
+
+		if len(syncer.tasks) != n {
+			t.Fatalf("chunks %d: task count mismatch: have %d", n, len(syncer.tasks))
+		}
+		var next common.Hash
+		for i, task := range syncer.tasks {
+			if task.Next != next {
+				t.Errorf("chunks %d: task %d origin mismatch: have %x, want %x", n, i, task.Next, next)
+			}
+			next = common.BigToHash(new(big.Int).Add(task.Last.Big(), common.Big1))
+		}
+		if last := syncer.tasks[n-1].Last; last != common.MaxHash {
+			t.Errorf("chunks %d: range limit mismatch: have %x, want %x", n, last, common.MaxHash)
+		}
+		// Ensure the state is synced correctly from multiple peers
+		var (
+			once   sync.Once
+			cancel = make(chan struct{})
+			term   = func() {
+				once.Do(func() {
+					close(cancel)
+				})
+			}
+		)
+		syncer = NewSyncerWithConfig(rawdb.NewMemoryDatabase(), nodeScheme, SyncConfig{AccountConcurrency: n})
+		for _, name := range []string{"peer-a", "peer-b", "peer-c"} {
+			source := newTestPeer(name, t, term)
+			source.accountTrie = sourceAccountTrie.Copy()
+			source.accountValues = elems
+
+			syncer.Register(source)
+			source.remote = syncer
+		}
+		if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+			t.Fatalf("chunks %d: sync failed: %v", n, err)
+		}
+		verifyTrie(nodeScheme, syncer.db, sourceAccountTrie.Hash(), t)
+	}
+}
+
+// BenchmarkSyncAccountConcurrency measures the time needed to snap sync a state
+// of one million accounts from 8 peers with a 10ms round trip, splitting the
+// account range into different numbers of chunks.
+func BenchmarkSyncAccountConcurrency(b *testing.B) {
+	nodeScheme, sourceAccountTrie, elems := makeAccountTrieNoStorage(1_000_000, rawdb.HashScheme)
+
+	for _, n := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var (
+					once   sync.Once
+					cancel = make(chan struct{})
+					term   = func() {
+						once.Do(func() {
+							close(cancel)
+						})
+					}
+				)
+				syncer := NewSyncerWithConfig(rawdb.NewMemoryDatabase(), nodeScheme, SyncConfig{AccountConcurrency: n})
+				for j := 0; j < 8; j++ {
+					source := newTestPeer(fmt.Sprintf("peer-%d", j), b, term)
+					source.accountTrie = sourceAccountTrie.Copy()
+					source.accountValues = elems
+					source.accountRequestHandler = func(t *testPeer, id uint64, root common.Hash, origin common.Hash, limit common.Hash, cap uint64) error {
+						time.Sleep(10 * time.Millisecond)
+						return defaultAccountRequestHandler(t, id, root, origin, limit, cap)
+					}
+					syncer.Register(source)
+					source.remote = syncer
+				}
+				if err := syncer.Sync(sourceAccountTrie.Hash(), cancel); err != nil {
+					b.Fatalf("sync failed: %v", err)
+				}
+			}
+		})
+	}
+}
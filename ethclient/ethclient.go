@@ -892,6 +892,9 @@ type rpcProgress struct {
 	CurrentBlock  hexutil.Uint64
 	HighestBlock  hexutil.Uint64
 
+	BodyQueueDepth    hexutil.Uint64
+	ReceiptQueueDepth hexutil.Uint64
+
 	PulledStates hexutil.Uint64
 	KnownStates  hexutil.Uint64
 
@@ -919,6 +922,8 @@ func (p *rpcProgress) toSyncProgress() *ethereum.SyncProgress {
 		StartingBlock:          uint64(p.StartingBlock),
 		CurrentBlock:           uint64(p.CurrentBlock),
 		HighestBlock:           uint64(p.HighestBlock),
+		BodyQueueDepth:         uint64(p.BodyQueueDepth),
+		ReceiptQueueDepth:      uint64(p.ReceiptQueueDepth),
 		PulledStates:           uint64(p.PulledStates),
 		KnownStates:            uint64(p.KnownStates),
 		SyncedAccounts:         uint64(p.SyncedAccounts),
@@ -100,6 +100,11 @@ type SyncProgress struct {
 	CurrentBlock  uint64 // Current block number where sync is at
 	HighestBlock  uint64 // Highest alleged block number in the chain
 
+	// Download queue fields, in percent. At 100, the retrievals are held back
+	// until the downloaded blocks are imported.
+	BodyQueueDepth    uint64 // Share of the queue open to body retrievals in use
+	ReceiptQueueDepth uint64 // Share of the queue open to receipt retrievals in use
+
 	// "fast sync" fields. These used to be sent by geth, but are no longer used
 	// since version v1.10.
 	PulledStates uint64 // Number of state trie entries already downloaded
@@ -150,6 +150,7 @@ func (api *EthereumAPI) BlobBaseFee(ctx context.Context) *hexutil.Big {
 // - highestBlock:  block number of the highest block header this node has received from peers
 // - pulledStates:  number of state entries processed until now
 // - knownStates:   number of known state entries that still need to be pulled
+// - bodyQueueDepth, receiptQueueDepth: percentage of the download queue in use
 func (api *EthereumAPI) Syncing() (interface{}, error) {
 	progress := api.b.SyncProgress()
 
@@ -162,6 +163,8 @@ func (api *EthereumAPI) Syncing() (interface{}, error) {
 		"startingBlock":          hexutil.Uint64(progress.StartingBlock),
 		"currentBlock":           hexutil.Uint64(progress.CurrentBlock),
 		"highestBlock":           hexutil.Uint64(progress.HighestBlock),
+		"bodyQueueDepth":         hexutil.Uint64(progress.BodyQueueDepth),
+		"receiptQueueDepth":      hexutil.Uint64(progress.ReceiptQueueDepth),
 		"syncedAccounts":         hexutil.Uint64(progress.SyncedAccounts),
 		"syncedAccountBytes":     hexutil.Uint64(progress.SyncedAccountBytes),
 		"syncedBytecodes":        hexutil.Uint64(progress.SyncedBytecodes),
@@ -77,14 +77,14 @@ func fuzz(input []byte) int {
 	clock := new(mclock.Simulated)
 	rand := rand.New(rand.NewSource(0x3a29)) // Same used in package tests!!!
 
-	f := fetcher.NewTxFetcherForTests(
-		func(common.Hash) bool { return false },
-		func(peer string, txs []*types.Transaction) []error {
+	f := fetcher.NewTxFetcherWithOptions(
+		fetcher.WithHasTx(func(common.Hash) bool { return false }),
+		fetcher.WithAddTxs(func(peer string, txs []*types.Transaction) []error {
 			return make([]error, len(txs))
-		},
-		func(string, []common.Hash) error { return nil },
-		nil,
-		clock, rand,
+		}),
+		fetcher.WithFetchTxs(func(string, []common.Hash) error { return nil }),
+		fetcher.WithClock(clock),
+		fetcher.WithRand(rand),
 	)
 	f.Start()
 	defer f.Stop()
@@ -265,6 +265,8 @@ type Sync struct {
 	codeReqs map[common.Hash]*codeRequest // Pending requests pertaining to a code hash
 	queue    *prque.Prque[int64, any]     // Priority queue with the pending requests
 	fetches  map[int]int                  // Number of active fetches per trie node depth
+
+	breadthFirst bool // Whether shallower nodes are retrieved before deeper ones
 }
 
 // NewSync creates a new trie data download scheduler.
@@ -282,6 +284,17 @@ func NewSync(root common.Hash, database ethdb.Database, callback LeafCallback, s
 	return ts
 }
 
+// SetBreadthFirst switches the retrieval order of the trie nodes. By default the
+// deepest pending nodes are retrieved first, completing sub-tries one by one
+// before moving on to their siblings. In breadth-first mode all nodes of a trie
+// level are retrieved before any of the next level. As the nodes of a level are
+// only committed once all their descendants are, breadth-first mode does not
+// limit the pending nodes per depth, holding up to a whole level in memory. It
+// should be called before any node is retrieved.
+func (s *Sync) SetBreadthFirst(enabled bool) {
+	s.breadthFirst = enabled
+}
+
 // AddSubTrie registers a new trie to the sync code, rooted at the designated
 // parent for completion tracking. The given path is a unique node path in
 // hex format and contain all the parent path if it's layered trie node.
@@ -365,9 +378,14 @@ func (s *Sync) Missing(max int) ([]string, []common.Hash, []common.Hash) {
 		// Retrieve the next item in line
 		item, prio := s.queue.Peek()
 
-		// If we have too many already-pending tasks for this depth, throttle
+		// If we have too many already-pending tasks for this depth, throttle.
+		// Nodes only complete along with their sub-tries, so in breadth-first
+		// mode a throttled level could never make room for the next one.
 		depth := int(prio >> 56)
-		if s.fetches[depth] > maxFetchesPerDepth {
+		if s.breadthFirst {
+			depth = 127 - depth
+		}
+		if !s.breadthFirst && s.fetches[depth] > maxFetchesPerDepth {
 			break
 		}
 		// Item is allowed to be scheduled, add it to the task list
@@ -520,11 +538,7 @@ func (s *Sync) scheduleNodeRequest(req *nodeRequest) {
 
 	// Schedule the request for future retrieval. This queue is shared
 	// by both node requests and code requests.
-	prio := int64(len(req.path)) << 56 // depth >= 128 will never happen, storage leaves will be included in their parents
-	for i := 0; i < 14 && i < len(req.path); i++ {
-		prio |= int64(15-req.path[i]) << (52 - i*4) // 15-nibble => lexicographic order
-	}
-	s.queue.Push(string(req.path), prio)
+	s.queue.Push(string(req.path), s.priority(req.path))
 }
 
 // scheduleCodeRequest inserts a new state retrieval request into the fetch queue. If there
@@ -540,11 +554,21 @@ func (s *Sync) scheduleCodeRequest(req *codeRequest) {
 
 	// Schedule the request for future retrieval. This queue is shared
 	// by both node requests and code requests.
-	prio := int64(len(req.path)) << 56 // depth >= 128 will never happen, storage leaves will be included in their parents
-	for i := 0; i < 14 && i < len(req.path); i++ {
-		prio |= int64(15-req.path[i]) << (52 - i*4) // 15-nibble => lexicographic order
+	s.queue.Push(req.hash, s.priority(req.path))
+}
+
+// priority calculates the retrieval priority of the entry at the given path,
+// ordering the entries by depth first and lexicographically second.
+func (s *Sync) priority(path []byte) int64 {
+	depth := int64(len(path)) // depth >= 128 will never happen, storage leaves will be included in their parents
+	if s.breadthFirst {
+		depth = 127 - depth
+	}
+	prio := depth << 56
+	for i := 0; i < 14 && i < len(path); i++ {
+		prio |= int64(15-path[i]) << (52 - i*4) // 15-nibble => lexicographic order
 	}
-	s.queue.Push(req.hash, prio)
+	return prio
 }
 
 // children retrieves all the missing children of a state trie entry for future
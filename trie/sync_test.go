@@ -18,6 +18,7 @@ package trie
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"maps"
 	"math/rand"
@@ -684,6 +685,62 @@ func testSyncOrdering(t *testing.T, scheme string) {
 		}
 	}
 }
+
+// Tests that the trie scheduler requests all nodes of a trie level before any
+// of the next one in breadth-first mode.
+func TestSyncOrderingBreadthFirst(t *testing.T) {
+	testSyncOrderingBreadthFirst(t, rawdb.HashScheme)
+	testSyncOrderingBreadthFirst(t, rawdb.PathScheme)
+}
+
+func testSyncOrderingBreadthFirst(t *testing.T, scheme string) {
+	// Create a random trie to copy
+	_, srcDb, srcTrie, srcData := makeTestTrie(scheme)
+
+	// Create a destination trie and sync with the scheduler, tracking the requests
+	diskdb := rawdb.NewMemoryDatabase()
+	sched := NewSync(srcTrie.Hash(), diskdb, nil, srcDb.Scheme())
+	sched.SetBreadthFirst(true)
+
+	reader, err := srcDb.NodeReader(srcTrie.Hash())
+	if err != nil {
+		t.Fatalf("State is not available %x", srcTrie.Hash())
+	}
+	var depths []int
+	for {
+		paths, nodes, _ := sched.Missing(1)
+		if len(paths) == 0 {
+			break
+		}
+		for i, path := range paths {
+			depths = append(depths, len(path))
+
+			owner, inner := ResolvePath([]byte(path))
+			data, err := reader.Node(owner, inner, nodes[i])
+			if err != nil {
+				t.Fatalf("failed to retrieve node data for %x: %v", nodes[i], err)
+			}
+			if err := sched.ProcessNode(NodeSyncResult{path, data}); err != nil {
+				t.Fatalf("failed to process result %v", err)
+			}
+		}
+		batch := diskdb.NewBatch()
+		if err := sched.Commit(batch, nil); err != nil {
+			t.Fatalf("failed to commit data: %v", err)
+		}
+		batch.Write()
+	}
+	// Cross check that the two tries are in sync
+	checkTrieContents(t, diskdb, srcDb.Scheme(), srcTrie.Hash().Bytes(), srcData, false)
+
+	// Check that the trie nodes have been requested level by level
+	for i := 0; i < len(depths)-1; i++ {
+		if depths[i] > depths[i+1] {
+			t.Fatalf("Invalid request order: depth %d requested after depth %d", depths[i+1], depths[i])
+		}
+	}
+}
+
 func syncWith(t *testing.T, root common.Hash, db ethdb.Database, srcDb *testDb) {
 	syncWithHookWriter(t, root, db, srcDb, nil)
 }
@@ -999,3 +1056,71 @@ func testSyncAbort(t *testing.T, scheme string) {
 	syncWith(t, rootC, destDisk, srcTrieDB)
 	checkTrieContents(t, destDisk, scheme, srcTrie.Hash().Bytes(), stateC, true)
 }
+
+// Benchmarks reconstructing a trie of about a million nodes with the deepest and
+// shallowest node first retrieval orders, retrieving the nodes in batches as the
+// snap syncer does. Besides the time, the peak number of pending requests is
+// reported, which is what the trie node retrieval order mostly affects.
+func BenchmarkSyncStrategy(b *testing.B) {
+	// Create a random trie to copy
+	db := rawdb.NewMemoryDatabase()
+	srcDb := newTestDatabase(db, rawdb.HashScheme)
+	srcTrie, _ := NewStateTrie(TrieID(types.EmptyRootHash), srcDb)
+
+	for i := 0; i < 1<<19; i++ {
+		key := binary.BigEndian.AppendUint64(nil, uint64(i))
+		srcTrie.MustUpdate(crypto.Keccak256(key), key)
+	}
+	root, nodes := srcTrie.Commit(false)
+	if err := srcDb.Update(root, types.EmptyRootHash, trienode.NewWithNodeSet(nodes)); err != nil {
+		b.Fatalf("failed to commit db %v", err)
+	}
+	if err := srcDb.Commit(root); err != nil {
+		b.Fatal(err)
+	}
+	reader, err := srcDb.NodeReader(root)
+	if err != nil {
+		b.Fatalf("State is not available %x", root)
+	}
+	for _, bfs := range []bool{false, true} {
+		name := "depth-first"
+		if bfs {
+			name = "breadth-first"
+		}
+		b.Run(name, func(b *testing.B) {
+			var retrieved, pending int
+			for i := 0; i < b.N; i++ {
+				diskdb := rawdb.NewMemoryDatabase()
+				sched := NewSync(root, diskdb, nil, rawdb.HashScheme)
+				sched.SetBreadthFirst(bfs)
+
+				for {
+					paths, hashes, _ := sched.Missing(384)
+					if len(paths) == 0 {
+						break
+					}
+					retrieved += len(paths)
+					for j, path := range paths {
+						owner, inner := ResolvePath([]byte(path))
+						data, err := reader.Node(owner, inner, hashes[j])
+						if err != nil {
+							b.Fatalf("failed to retrieve node data for %x: %v", hashes[j], err)
+						}
+						if err := sched.ProcessNode(NodeSyncResult{path, data}); err != nil {
+							b.Fatalf("failed to process result %v", err)
+						}
+					}
+					pending = max(pending, sched.Pending())
+
+					batch := diskdb.NewBatch()
+					if err := sched.Commit(batch, nil); err != nil {
+						b.Fatalf("failed to commit data: %v", err)
+					}
+					batch.Write()
+				}
+			}
+			b.ReportMetric(float64(retrieved)/float64(b.N), "nodes/op")
+			b.ReportMetric(float64(pending), "pending/max")
+		})
+	}
+}